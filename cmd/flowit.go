@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/yamil-rivera/flowit/internal/audit"
 	"github.com/yamil-rivera/flowit/internal/command"
 	"github.com/yamil-rivera/flowit/internal/config"
 	"github.com/yamil-rivera/flowit/internal/fsm"
@@ -13,20 +14,26 @@ import (
 	"github.com/yamil-rivera/flowit/internal/workflow"
 )
 
-// TODO: Make flowit concurrent
 func main() {
 
 	// TODO: Get this from a default or from the env
 	workflowDefinition, err := config.Load(io.GetProjectRootDir() + "/samples/test.yaml")
 	optionalExit(err)
+	for _, warning := range workflowDefinition.Warnings {
+		// nolint: errcheck
+		io.Warn(warning)
+	}
 
-	repositoryService := repository.NewService()
+	repositoryService, err := repository.NewStore(workflowDefinition.Flowit.Config.Repository)
+	optionalExit(err)
 
 	workflowService := workflow.NewService()
 
 	fsmServiceFactory := fsm.NewServiceFactory()
 
-	runtimeService := runtime.NewService(repositoryService, fsmServiceFactory, workflowService)
+	auditLog := audit.NewLog(repositoryService, workflowDefinition.Flowit.Config.Audit.File)
+
+	runtimeService := runtime.NewService(repositoryService, fsmServiceFactory, workflowService, auditLog)
 
 	commandService := command.NewService(runtimeService, fsmServiceFactory, repositoryService, workflowDefinition)
 
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outcome tells whether or not the audited command succeeded
+type Outcome string
+
+// Supported outcomes
+const (
+	Succeeded Outcome = "succeeded"
+	Failed    Outcome = "failed"
+)
+
+// Supported actions
+const (
+	Run     = "run"
+	Cancel  = "cancel"
+	Alias   = "alias"
+	Unlock  = "unlock"
+	Upgrade = "upgrade"
+	Import  = "import"
+	Remove  = "remove"
+	Approve = "approve"
+)
+
+// Event records who changed a workflow instance, from where and with which result
+// Timestamps are Unix nanoseconds
+type Event struct {
+	User         string   `json:"user"`
+	Host         string   `json:"host"`
+	Directory    string   `json:"directory"`
+	GitHead      string   `json:"git-head,omitempty"`
+	CommandLine  []string `json:"command-line"`
+	Action       string   `json:"action"`
+	WorkflowName string   `json:"workflow"`
+	WorkflowID   string   `json:"workflow-id,omitempty"`
+	FromStage    string   `json:"from-stage,omitempty"`
+	ToStage      string   `json:"to-stage,omitempty"`
+	Outcome      Outcome  `json:"outcome"`
+	Error        string   `json:"error,omitempty"`
+	Started      uint64   `json:"started"`
+	Finished     uint64   `json:"finished"`
+}
+
+// Query describes the criteria the audit events returned by a Store must match
+// Zero valued fields match every event
+type Query struct {
+	// Since bounds the time the event started, in Unix nanoseconds
+	Since        uint64
+	WorkflowName string
+}
+
+// Store defines the methods that must be implemented in order for a struct to keep audit events
+// Audit events are never updated nor removed
+type Store interface {
+	PutAuditEvent(event Event) error
+}
+
+// Log records audit events in a Store and, optionally, in a JSON lines file
+type Log struct {
+	store Store
+	file  string
+}
+
+// NewLog returns a Log recording audit events in store. Events are also appended to file unless it is empty
+func NewLog(store Store, file string) *Log {
+	return &Log{store, file}
+}
+
+// NewEvent returns an event describing an action on a workflow instance started now by the current process
+func NewEvent(action, workflowName, workflowID string) Event {
+	host, _ := os.Hostname()
+	directory, _ := os.Getwd()
+	return Event{
+		User:         currentUser(),
+		Host:         host,
+		Directory:    directory,
+		GitHead:      gitHead(),
+		CommandLine:  os.Args,
+		Action:       action,
+		WorkflowName: workflowName,
+		WorkflowID:   workflowID,
+		Started:      uint64(time.Now().UnixNano()),
+	}
+}
+
+// Finish sets the event outcome based on the error the audited action returned
+func (e *Event) Finish(err error) {
+	e.Finished = uint64(time.Now().UnixNano())
+	e.Outcome = Succeeded
+	if err != nil {
+		e.Outcome = Failed
+		e.Error = err.Error()
+	}
+}
+
+// Matches returns whether or not the event meets every query criteria
+func (q Query) Matches(event Event) bool {
+	if q.Since > 0 && event.Started < q.Since {
+		return false
+	}
+	return q.WorkflowName == "" || event.WorkflowName == q.WorkflowName
+}
+
+// Record saves the event in the Store and appends it to the JSON lines file, if any
+func (l *Log) Record(event Event) error {
+	if err := l.store.PutAuditEvent(event); err != nil {
+		return errors.Wrap(err, "Error trying to save audit event")
+	}
+	if l.file == "" {
+		return nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.file), 0755); err != nil { // nolint:gosec
+		return errors.WithStack(err)
+	}
+	// Appends of a single line are atomic, so concurrent flowit invocations never interleave their events
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644) // nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "Error trying to open audit file")
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close() // nolint:errcheck,gosec
+		return errors.Wrap(err, "Error trying to write audit file")
+	}
+	return errors.WithStack(f.Close())
+}
+
+func currentUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// gitHead returns the commit checked out in the working directory, if it is within a git repository
+func gitHead() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,86 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/io"
+)
+
+func (s Service) generateAuditCommand() command {
+
+	var workflowName, since string
+	var asJSON bool
+	auditCommand := &cobra.Command{
+		Use:   "audit",
+		Short: "Show who changed which workflows, from where and with which result, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := audit.Query{WorkflowName: workflowName}
+			var err error
+			if query.Since, err = parseTime(since, time.Now()); err != nil {
+				return errors.WithStack(err)
+			}
+			events, err := s.repositoryService.GetAuditEvents(query)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if asJSON {
+				return writeAuditEventsJSON(events)
+			}
+			return writeAuditEvents(events)
+		},
+	}
+	flags := auditCommand.Flags()
+	flags.StringVar(&workflowName, "workflow", "", "Only show changes to workflows with this name")
+	flags.StringVar(&since, "since", "", "Only show changes made after this date (2006-01-02) or this long ago (168h)")
+	flags.BoolVar(&asJSON, "json", false,
+		"Write every event as a JSON line, including its directory, git HEAD and command line")
+	return command{cobra: auditCommand}
+
+}
+
+func writeAuditEvents(events []audit.Event) error {
+	if len(events) == 0 {
+		return io.Println("No audit events found")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tUSER\tHOST\tACTION\tWORKFLOW\tID\tSTAGES\tOUTCOME") // nolint:errcheck
+	for _, event := range events {
+		stages := event.FromStage
+		if event.ToStage != "" {
+			stages = strings.TrimPrefix(stages+" -> "+event.ToStage, " ")
+		}
+		if stages == "" {
+			stages = "-"
+		}
+		workflowID := event.WorkflowID
+		if workflowID == "" {
+			workflowID = "-"
+		}
+		outcome := string(event.Outcome)
+		if event.Error != "" {
+			outcome += ": " + event.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", // nolint:errcheck
+			formatTime(event.Started), event.User, event.Host, event.Action, event.WorkflowName, workflowID, stages, outcome)
+	}
+	return errors.WithStack(tw.Flush())
+}
+
+func writeAuditEventsJSON(events []audit.Event) error {
+	encoder := json.NewEncoder(os.Stdout)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package command
 
 import (
+	"os"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/yamil-rivera/flowit/internal/config"
 	"github.com/yamil-rivera/flowit/internal/fsm"
+	"github.com/yamil-rivera/flowit/internal/git"
 	"github.com/yamil-rivera/flowit/internal/io"
+	"github.com/yamil-rivera/flowit/internal/repository"
 	"github.com/yamil-rivera/flowit/internal/runtime"
 	"github.com/yamil-rivera/flowit/internal/utils"
 	w "github.com/yamil-rivera/flowit/internal/workflow"
@@ -13,17 +19,17 @@ import (
 
 // RuntimeService exposes useful methods for managing workflow executions
 type RuntimeService interface {
-	Run(optionalWorkflowID utils.OptionalString, args []string, workflowName, stageID string, workflowDefinition config.Flowit, executor runtime.Executor, writer runtime.Writer) error
+	Run(optionalWorkflowID utils.OptionalString, alias string, args []string, workflowName, stageID string,
+		workflowDefinition config.Flowit, executor runtime.Executor, writer runtime.Writer, prompter runtime.Prompter) error
+	Alias(workflowID, workflowName, alias string, workflowDefinition config.Flowit, writer runtime.Writer) error
 	Cancel(workflowID string, workflowName string, writer runtime.Writer) error
-}
-
-// RepositoryService exposes useful methods for persisting and retrieving workflows
-type RepositoryService interface {
-	GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error)
-	GetWorkflows(workflowName string, count int, excludeInactive bool) ([]w.Workflow, error)
-	GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error)
-	GetWorkflowFromPreffix(workflowName, workflowIDPreffix string) (w.OptionalWorkflow, error)
-	PutWorkflow(workflow w.Workflow) error
+	Approve(workflowID, workflowName string, writer runtime.Writer) error
+	Unlock(workflowID, workflowName string, force bool, writer runtime.Writer) error
+	Upgrade(workflowID, workflowName string, workflowDefinition config.Flowit, dryRun bool,
+		writer runtime.Writer, prompter runtime.Prompter) error
+	Import(workflows []w.Workflow, workflowDefinition config.Flowit, policy runtime.ConflictPolicy,
+		writer runtime.Writer) error
+	CollectGarbage(retention config.Retention, dryRun bool, writer runtime.Writer) error
 }
 
 // Service implements the command service interface
@@ -31,8 +37,10 @@ type Service struct {
 	rootCommand        *cobra.Command
 	runtimeService     RuntimeService
 	fsmServiceFactory  fsm.FsmServiceFactory
-	repositoryService  RepositoryService
+	repositoryService  repository.Store
 	workflowDefinition *config.WorkflowDefinition
+	// guardedWorkflows maps the commands of the workflow instances with guarded transitions to their workflow
+	guardedWorkflows map[*cobra.Command]w.Workflow
 }
 
 type command struct {
@@ -41,46 +49,29 @@ type command struct {
 }
 
 // NewService creates a new command service
-func NewService(run RuntimeService, fsf fsm.FsmServiceFactory, repo RepositoryService, wd *config.WorkflowDefinition) *Service {
-	return &Service{nil, run, fsf, repo, wd}
+func NewService(run RuntimeService, fsf fsm.FsmServiceFactory, repo repository.Store,
+	wd *config.WorkflowDefinition) *Service {
+	return &Service{nil, run, fsf, repo, wd, make(map[*cobra.Command]w.Workflow)}
 }
 
 // RegisterCommands registers all commands and subcommands based on the provided configuration
 // and previous active workflows
 func (s *Service) RegisterCommands(version string) error {
 
-	var mainCommands []command // nolint:prealloc
-	fsmService, err := s.fsmServiceFactory.NewFsmService(s.workflowDefinition.Flowit)
+	mainCommands, err := s.generateDefinitionCommands()
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	workflowDefinitions := s.workflowDefinition.Flowit.Workflows
-	for _, workflowDefinition := range workflowDefinitions {
-
-		workflowName := workflowDefinition.ID
-		stateMachine := workflowDefinition.StateMachine
-		cmd := command{}
-		cmd.cobra = newContainerCommand(workflowName)
-		initialStages, err := s.generateInitialCommands(fsmService, stateMachine, workflowName)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		cmd.subcommands = initialStages
-		mainCommands = append(mainCommands, cmd)
-	}
 
 	activeWorkflows, err := s.getAllActiveWorkflows()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	for _, workflow := range activeWorkflows {
-		childCmd := command{}
-		childCmd.cobra = newContainerCommand(workflow.Preffix)
-		stages, err := s.generatePossibleCommands(workflow)
+		childCmd, err := s.generateWorkflowCommand(workflow)
 		if err != nil {
-			return errors.Wrap(err, "Error generating possible commands")
+			return errors.WithStack(err)
 		}
-		childCmd.subcommands = stages
 
 		// Check if we already have a registered command for this workflow name
 		var cmd *command
@@ -101,10 +92,7 @@ func (s *Service) RegisterCommands(version string) error {
 		mainCommands = replaceCommand(mainCommands, *cmd)
 	}
 
-	// add version command
-	cmd := command{}
-	cmd.cobra = newPrintCommand("version", version)
-	mainCommands = append(mainCommands, cmd)
+	mainCommands = append(mainCommands, s.generateToolCommands(version)...)
 
 	// TODO: add update command
 
@@ -130,8 +118,86 @@ func (s *Service) RegisterCommands(version string) error {
 	return nil
 }
 
+// generateDefinitionCommands returns a command for every defined workflow holding the stages it can start at
+func (s *Service) generateDefinitionCommands() ([]command, error) {
+	var commands []command // nolint:prealloc
+	fsmService, err := s.fsmServiceFactory.NewFsmService(s.workflowDefinition.Flowit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	workflowDefinitions := s.workflowDefinition.Flowit.Workflows
+	for _, workflowDefinition := range workflowDefinitions {
+
+		workflowName := workflowDefinition.ID
+		stateMachine := workflowDefinition.StateMachine
+		cmd := command{}
+		cmd.cobra = newContainerCommand(workflowName)
+		initialStages, err := s.generateInitialCommands(fsmService, stateMachine, workflowName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		whereStages, err := s.generateWhereCommands(fsmService, stateMachine, workflowName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cmd.subcommands = append(initialStages, whereStages...)
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// generateWorkflowCommand returns the command holding the stages the active workflow can transition to
+func (s *Service) generateWorkflowCommand(workflow w.Workflow) (command, error) {
+	cmd := command{}
+	cmd.cobra = newContainerCommand(workflow.Preffix)
+	if workflow.Alias != "" {
+		cmd.cobra.Aliases = []string{workflow.Alias}
+	}
+	if workflow.IsDrifted(s.workflowDefinition.Hash) {
+		cmd.cobra.Short = "Workflow definition changed since this workflow was created, see 'upgrade'"
+	}
+	stages, guarded, err := s.generatePossibleCommands(workflow)
+	if err != nil {
+		return command{}, errors.Wrap(err, "Error generating possible commands")
+	}
+	cmd.subcommands = stages
+	if guarded {
+		// Guards are only evaluated when they are about to be shown since they might be slow
+		s.guardedWorkflows[cmd.cobra] = workflow
+		cmd.cobra.SetHelpFunc(s.helpWithBlockedStages)
+	}
+	return cmd, nil
+}
+
+// generateToolCommands returns the commands which are not specific to a workflow
+func (s *Service) generateToolCommands(version string) []command {
+	// add list command
+	commands := []command{s.generateListCommand()}
+
+	// add export and import commands
+	commands = append(commands, s.generateExportCommand(), s.generateImportCommand())
+
+	// add gc command
+	commands = append(commands, s.generateGCCommand())
+
+	// add audit command
+	commands = append(commands, s.generateAuditCommand())
+
+	// add version command
+	cmd := command{}
+	cmd.cobra = newPrintCommand("version", version)
+	commands = append(commands, cmd)
+
+	// add config command
+	cmd = command{}
+	cmd.cobra = newContainerCommand("config")
+	cmd.subcommands = []command{{cobra: newMigrateCommand()}}
+	return append(commands, cmd)
+}
+
 // Execute will kickstart the root command
 func (s Service) Execute() error {
+	s.annotateCompletion(os.Args[1:])
 	if err := s.rootCommand.Execute(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -157,13 +223,40 @@ func newPrintCommand(command string, out string) *cobra.Command {
 	}
 }
 
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate <workflow-definition-file>",
+		Short: "Rewrite a workflow definition into the latest version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fromVersion, toVersion, err := config.Migrate(args[0])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if fromVersion == toVersion {
+				return io.Printfln("Workflow definition is already on the latest version: %s", toVersion)
+			}
+			return io.Printfln("Workflow definition migrated from version %s to version %s", fromVersion, toVersion)
+		},
+	}
+}
+
 // TODO: Add arguments description to command help
 func newStageCommand(command string, args int, run func(cmd *cobra.Command, args []string) error) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:  command,
 		Args: cobra.ExactArgs(args),
 		RunE: run,
 	}
+	cmd.Flags().Bool("yes", false, "Confirm the stage and its actions without asking, e.g. when not run from a terminal")
+	return cmd
+}
+
+// prompter returns the prompter a command asks for confirmations with
+func prompter(cmd *cobra.Command) runtime.Prompter {
+	// The flag is defined for every stage command and for the upgrade command
+	yes, _ := cmd.Flags().GetBool("yes")
+	return io.NewConsolePrompter(yes)
 }
 
 func (s Service) generateCommandsFromStagesForWorkflow(workflow w.Workflow, stages []string) ([]command, error) {
@@ -177,7 +270,8 @@ func (s Service) generateCommandsFromStagesForWorkflow(workflow w.Workflow, stag
 				if err != nil {
 					return errors.WithStack(err)
 				}
-				err = s.runtimeService.Run(optionalWorkflowID, args, workflowName, stageID, s.workflowDefinition.Flowit, runtime.NewUnixShellExecutor(), io.NewConsoleWriter())
+				err = s.runtimeService.Run(optionalWorkflowID, "", args, workflowName, stageID, s.workflowDefinition.Flowit,
+					runtime.NewUnixShellExecutor(), io.NewConsoleWriter(), prompter(cmd))
 				return err
 			}
 
@@ -197,6 +291,7 @@ func (s Service) generateCommandsFromStages(workflowName string, stages []string
 	commands := make([]command, len(stages))
 	for i, stageID := range stages {
 
+		var alias string
 		runFunc := func(workflowName string, stageID string) func(cmd *cobra.Command, args []string) error {
 
 			return func(cmd *cobra.Command, args []string) error {
@@ -204,7 +299,8 @@ func (s Service) generateCommandsFromStages(workflowName string, stages []string
 				if err != nil {
 					return errors.WithStack(err)
 				}
-				err = s.runtimeService.Run(optionalWorkflowID, args, workflowName, stageID, s.workflowDefinition.Flowit, runtime.NewUnixShellExecutor(), io.NewConsoleWriter())
+				err = s.runtimeService.Run(optionalWorkflowID, alias, args, workflowName, stageID, s.workflowDefinition.Flowit,
+					runtime.NewUnixShellExecutor(), io.NewConsoleWriter(), prompter(cmd))
 				return err
 			}
 
@@ -215,15 +311,17 @@ func (s Service) generateCommandsFromStages(workflowName string, stages []string
 			return nil, errors.WithStack(err)
 		}
 		commands[i].cobra = newStageCommand(stage.ID, len(stage.Args), runFunc)
+		commands[i].cobra.Flags().StringVar(&alias, "alias", "",
+			"Name the new workflow can also be addressed with, e.g. its branch")
 
 	}
 	return commands, nil
 }
 
+// generateInitialCommands generates a command creating a new workflow for every stage the workflow can start from
 func (s Service) generateInitialCommands(fsmService fsm.Service, stateMachine, workflowName string) ([]command, error) {
 
-	initialEvent := fsmService.InitialState(stateMachine)
-	initialEvents := []string{initialEvent}
+	initialEvents := fsmService.InitialStates(stateMachine)
 	commands, err := s.generateCommandsFromStages(workflowName, initialEvents)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -232,26 +330,138 @@ func (s Service) generateInitialCommands(fsmService fsm.Service, stateMachine, w
 	return commands, nil
 }
 
-func (s Service) generatePossibleCommands(workflow w.Workflow) ([]command, error) {
-	fsmService, err := s.fsmServiceFactory.NewFsmService(workflow.State)
+// generateWhereCommands generates the non initial stage commands which run on the active workflow
+// selected by its variables values, or by the git branch checked out if none is given, instead of by its preffix
+func (s Service) generateWhereCommands(fsmService fsm.Service, stateMachine, workflowName string) ([]command, error) {
+
+	initialEvents := fsmService.InitialStates(stateMachine)
+	stages, err := s.workflowDefinition.Stages(workflowName)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	var availableStates []string
-	if workflow.LatestExecution.Checkpoint >= 0 {
-		availableStates = fsmService.AvailableStates(workflow.StateMachineID(), workflow.LatestExecution.FromStage)
-	} else {
-		availableStates = fsmService.AvailableStates(workflow.StateMachineID(), workflow.LatestExecution.Stage)
+	var commands []command // nolint:prealloc
+	for _, stage := range stages {
+		if utils.FindStringInArray(stage.ID, initialEvents) {
+			continue
+		}
+
+		var where []string
+		runFunc := func(workflowName string, stageID string) func(cmd *cobra.Command, args []string) error {
+
+			return func(cmd *cobra.Command, args []string) error {
+				var workflowID string
+				var err error
+				if len(where) > 0 {
+					workflowID, err = s.getWorkflowIDFromConditions(workflowName, where)
+				} else {
+					workflowID, err = s.getWorkflowIDFromBranch(workflowName)
+				}
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				return s.runtimeService.Run(utils.NewStringOptional(workflowID), "", args, workflowName, stageID,
+					s.workflowDefinition.Flowit, runtime.NewUnixShellExecutor(), io.NewConsoleWriter(), prompter(cmd))
+			}
+
+		}(workflowName, stage.ID)
+
+		cmd := newStageCommand(stage.ID, len(stage.Args), runFunc)
+		cmd.Short = "Run this stage on the active workflow selected with --where or by the git branch checked out"
+		cmd.Flags().StringArrayVar(&where, "where", nil,
+			"Select the workflow which variable holds a value, as variable=value")
+		commands = append(commands, command{cobra: cmd})
+	}
+	return commands, nil
+}
+
+// generatePossibleCommands generates the commands of a workflow instance
+// and reports whether or not any of its stages is reached through a guarded transition
+func (s Service) generatePossibleCommands(workflow w.Workflow) ([]command, bool, error) {
+	fsmService, err := s.fsmServiceFactory.NewFsmService(workflow.State)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
 	}
+	currentStage := currentStage(workflow)
+	availableStates := fsmService.AvailableStates(workflow.StateMachineID(), currentStage)
 
 	commands, err := s.generateCommandsFromStagesForWorkflow(workflow, availableStates)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, false, errors.WithStack(err)
+	}
+	guarded := false
+	for i, state := range availableStates {
+		if guards := fsmService.Guards(workflow.StateMachineID(), currentStage, state); len(guards) > 0 {
+			commands[i].cobra.Short = "Guarded by: " + strings.Join(guards, ", ")
+			guarded = true
+		}
+		if workflow.IsPendingApproval() {
+			commands[i].cobra.Short = "Waiting for approval"
+		}
 	}
 
-	commands = append(commands, s.generateCancelCommand(workflow.Name))
-	return commands, nil
+	if workflow.IsPendingApproval() {
+		commands = append(commands, s.generateApproveCommand(workflow.Name))
+	}
+	commands = append(commands, s.generateCancelCommand(workflow.Name), s.generateUpgradeCommand(workflow.Name),
+		s.generateStatusCommand(workflow.Name), s.generateUnlockCommand(workflow.Name), s.generateAliasCommand(workflow.Name))
+	return commands, guarded, nil
+
+}
+
+// currentStage returns the stage the next stage of the workflow transitions from
+// A failed execution is retried from the stage it started from
+func currentStage(workflow w.Workflow) string {
+	if workflow.LatestExecution.Checkpoint >= 0 {
+		return workflow.LatestExecution.FromStage
+	}
+	return workflow.LatestExecution.Stage
+}
+
+// helpWithBlockedStages shows the default help after pointing out the stages which guards currently block
+func (s Service) helpWithBlockedStages(cmd *cobra.Command, args []string) {
+	container := cmd
+	if _, ok := s.guardedWorkflows[cmd]; !ok && cmd.HasParent() {
+		container = cmd.Parent()
+	}
+	s.annotateBlockedStages(container)
+	cmd.Root().HelpFunc()(cmd, args)
+}
 
+// annotateCompletion points out the stages which guards currently block before completing a workflow instance command
+// Completion requests do not run the command being completed, so there is no other place to do it
+func (s Service) annotateCompletion(args []string) {
+	if len(args) < 2 || (args[0] != cobra.ShellCompRequestCmd && args[0] != cobra.ShellCompNoDescRequestCmd) {
+		return
+	}
+	if target, _, err := s.rootCommand.Find(args[1 : len(args)-1]); err == nil {
+		s.annotateBlockedStages(target)
+	}
+}
+
+// annotateBlockedStages evaluates the guards of the workflow instance command stages
+// and replaces the description of the blocked ones with the reason they are blocked
+func (s Service) annotateBlockedStages(container *cobra.Command) {
+	workflow, ok := s.guardedWorkflows[container]
+	if !ok {
+		return
+	}
+	fsmService, err := s.fsmServiceFactory.NewFsmService(workflow.State)
+	if err != nil {
+		return
+	}
+	executor := runtime.NewUnixShellExecutor()
+	executor.Config(runtime.NewExecutorConfig(workflow.State.Config))
+	evaluator := runtime.NewCommandGuardEvaluator(executor, workflow, workflow.LatestExecution)
+	for _, state := range fsmService.GuardedStates(workflow.StateMachineID(), currentStage(workflow), evaluator) {
+		if state.Blocked == "" {
+			continue
+		}
+		for _, cmd := range container.Commands() {
+			if cmd.Name() == state.ID {
+				cmd.Short = "Blocked: " + state.Blocked
+			}
+		}
+	}
 }
 
 func (s Service) generateCancelCommand(workflowName string) command {
@@ -278,6 +488,112 @@ func (s Service) generateCancelCommand(workflowName string) command {
 
 }
 
+func (s Service) generateApproveCommand(workflowName string) command {
+
+	return command{
+		cobra: &cobra.Command{
+			Use:   "approve",
+			Short: "Approve the approval stage the workflow is at so that it can leave it",
+			RunE: func(workflowName string) func(cmd *cobra.Command, args []string) error {
+
+				return func(cmd *cobra.Command, args []string) error {
+					optionalWorkflowID, err := s.getWorkflowIDFromCommand(cmd)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					// We are sure the optional is wrapping a workflow ID
+					workflowID, _ := optionalWorkflowID.Get()
+					return s.runtimeService.Approve(workflowID, workflowName, io.NewConsoleWriter())
+				}
+
+			}(workflowName),
+		},
+	}
+
+}
+
+func (s Service) generateUpgradeCommand(workflowName string) command {
+
+	var dryRun bool
+	upgradeCommand := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Start using the current workflow definition",
+		RunE: func(workflowName string) func(cmd *cobra.Command, args []string) error {
+
+			return func(cmd *cobra.Command, args []string) error {
+				optionalWorkflowID, err := s.getWorkflowIDFromCommand(cmd)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				// We are sure the optional is wrapping a workflow ID
+				workflowID, _ := optionalWorkflowID.Get()
+				return s.runtimeService.Upgrade(workflowID, workflowName, s.workflowDefinition.Flowit, dryRun,
+					io.NewConsoleWriter(), prompter(cmd))
+			}
+
+		}(workflowName),
+	}
+	upgradeCommand.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the changes the upgrade would introduce")
+	upgradeCommand.Flags().Bool("yes", false, "Apply the changes without asking, e.g. when not run from a terminal")
+	return command{cobra: upgradeCommand}
+
+}
+
+func (s Service) generateUnlockCommand(workflowName string) command {
+
+	var force bool
+	unlockCommand := &cobra.Command{
+		Use:   "unlock",
+		Short: "Remove the lock left by a flowit invocation that did not finish",
+		RunE: func(workflowName string) func(cmd *cobra.Command, args []string) error {
+
+			return func(cmd *cobra.Command, args []string) error {
+				optionalWorkflowID, err := s.getWorkflowIDFromCommand(cmd)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				// We are sure the optional is wrapping a workflow ID
+				workflowID, _ := optionalWorkflowID.Get()
+				return s.runtimeService.Unlock(workflowID, workflowName, force, io.NewConsoleWriter())
+			}
+
+		}(workflowName),
+	}
+	unlockCommand.Flags().BoolVar(&force, "force", false,
+		"Remove the lock even if the process holding it might still be running")
+	return command{cobra: unlockCommand}
+
+}
+
+func (s Service) generateAliasCommand(workflowName string) command {
+
+	return command{
+		cobra: &cobra.Command{
+			Use:   "alias [alias]",
+			Short: "Set the name the workflow can also be addressed with or remove it if no alias is provided",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(workflowName string) func(cmd *cobra.Command, args []string) error {
+
+				return func(cmd *cobra.Command, args []string) error {
+					optionalWorkflowID, err := s.getWorkflowIDFromCommand(cmd)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					// We are sure the optional is wrapping a workflow ID
+					workflowID, _ := optionalWorkflowID.Get()
+					alias := ""
+					if len(args) > 0 {
+						alias = args[0]
+					}
+					return s.runtimeService.Alias(workflowID, workflowName, alias, s.workflowDefinition.Flowit, io.NewConsoleWriter())
+				}
+
+			}(workflowName),
+		},
+	}
+
+}
+
 // cmd parent is either a workflow definition name or a workflow instance name
 func (s Service) getWorkflowIDFromCommand(cmd *cobra.Command) (utils.OptionalString, error) {
 
@@ -317,6 +633,65 @@ func (s Service) getWorkflowIDFromName(workflowName, workflowPreffix string) (st
 	return workflow.ID, nil
 }
 
+// getWorkflowIDFromConditions returns the ID of the only active workflow which variables hold the values
+// of the variable=value conditions
+func (s Service) getWorkflowIDFromConditions(workflowName string, conditions []string) (string, error) {
+
+	query, err := newQuery(workflowName, "", "", "", "", conditions, time.Now())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	workflows, err := s.repositoryService.QueryWorkflows(query)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	var candidates []w.Workflow
+	for _, workflow := range workflows {
+		if workflow.IsActive {
+			candidates = append(candidates, workflow)
+		}
+	}
+	reference := "Condition: " + strings.Join(conditions, ", ")
+	switch len(candidates) {
+	case 0:
+		return "", errors.New(reference + " does not match any active " + workflowName + " workflow")
+	case 1:
+		return candidates[0].ID, nil
+	default:
+		return "", errors.WithStack(repository.AmbiguousWorkflowError{Reference: reference, Candidates: candidates})
+	}
+}
+
+// getWorkflowIDFromBranch returns the ID of the only active workflow which alias is the git branch checked out
+// or which git actions create it
+func (s Service) getWorkflowIDFromBranch(workflowName string) (string, error) {
+
+	branch, err := git.NewRepository("").CurrentBranch()
+	if err != nil {
+		return "", errors.Wrap(err, "Select the workflow with --where")
+	}
+	workflows, err := s.repositoryService.QueryWorkflows(repository.Query{Name: workflowName})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	var candidates []w.Workflow
+	for _, workflow := range workflows {
+		if workflow.IsActive && (workflow.Alias == branch || utils.FindStringInArray(branch, workflow.Branches())) {
+			candidates = append(candidates, workflow)
+		}
+	}
+	reference := "Branch: " + branch
+	switch len(candidates) {
+	case 0:
+		return "", errors.New(reference + " does not match any active " + workflowName +
+			" workflow. Select the workflow with --where")
+	case 1:
+		return candidates[0].ID, nil
+	default:
+		return "", errors.WithStack(repository.AmbiguousWorkflowError{Reference: reference, Candidates: candidates})
+	}
+}
+
 func replaceCommand(cmds []command, cmd command) []command {
 	result := make([]command, len(cmds))
 	for i, c := range cmds {
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yamil-rivera/flowit/internal/io"
+)
+
+func (s Service) generateGCCommand() command {
+
+	var dryRun bool
+	var days, keep int
+	var archive string
+	gcCommand := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the finished and cancelled workflows the retention policy does not keep",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days < 0 || keep < 0 {
+				return errors.New("--days and --keep must not be negative")
+			}
+			retention := s.workflowDefinition.Flowit.Config.Retention
+			flags := cmd.Flags()
+			if flags.Changed("days") {
+				retention.Days = days
+			}
+			if flags.Changed("keep") {
+				retention.Keep = keep
+			}
+			if flags.Changed("archive") {
+				retention.Archive = archive
+			}
+			return s.runtimeService.CollectGarbage(retention, dryRun, io.NewConsoleWriter())
+		},
+	}
+	flags := gcCommand.Flags()
+	flags.BoolVar(&dryRun, "dry-run", false, "Only show the workflows that would be removed")
+	flags.IntVar(&days, "days", 0, "Keep workflows finished within this many days, overriding retention.days")
+	flags.IntVar(&keep, "keep", 0,
+		"Keep this many of the most recently finished workflows per workflow, overriding retention.keep")
+	flags.StringVar(&archive, "archive", "", "Archive removed workflows in this directory, overriding retention.archive")
+	return command{cobra: gcCommand}
+
+}
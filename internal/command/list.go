@@ -0,0 +1,260 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yamil-rivera/flowit/internal/io"
+	"github.com/yamil-rivera/flowit/internal/repository"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func (s Service) generateListCommand() command {
+
+	var workflowName, state, stage, since, until string
+	var where []string
+	listCommand := &cobra.Command{
+		Use:   "list",
+		Short: "List the workflows matching the provided filters, most recently updated first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query, err := newQuery(workflowName, state, stage, since, until, where, time.Now())
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			workflows, err := s.repositoryService.QueryWorkflows(query)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return writeWorkflows(workflows)
+		},
+	}
+	statuses := make([]string, len(w.Statuses()))
+	for i, status := range w.Statuses() {
+		statuses[i] = string(status)
+	}
+	flags := listCommand.Flags()
+	flags.StringVar(&workflowName, "workflow", "", "Only list workflows with this name")
+	flags.StringVar(&state, "state", "", "Only list workflows in this state: "+strings.Join(statuses, ", "))
+	flags.StringVar(&stage, "stage", "", "Only list workflows which latest execution ran, or failed to run, this stage")
+	flags.StringVar(&since, "since", "",
+		"Only list workflows updated after this date (2006-01-02) or this long ago (168h)")
+	flags.StringVar(&until, "until", "",
+		"Only list workflows updated before this date (2006-01-02) or this long ago (168h)")
+	flags.StringArrayVar(&where, "where", nil, "Only list workflows which variable holds a value, as variable=value")
+	return command{cobra: listCommand}
+
+}
+
+func (s Service) generateStatusCommand(workflowName string) command {
+
+	return command{
+		cobra: &cobra.Command{
+			Use:   "status",
+			Short: "Show the workflow state and its executions",
+			Args:  cobra.NoArgs,
+			RunE: func(workflowName string) func(cmd *cobra.Command, args []string) error {
+
+				return func(cmd *cobra.Command, args []string) error {
+					optionalWorkflowID, err := s.getWorkflowIDFromCommand(cmd)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					// We are sure the optional is wrapping a workflow ID
+					workflowID, _ := optionalWorkflowID.Get()
+					optionalWorkflow, err := s.repositoryService.GetWorkflow(workflowName, workflowID)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					workflow, err := optionalWorkflow.Get()
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					lease, err := s.repositoryService.GetLease(workflowName, workflowID)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					children, err := s.childrenTree(workflow, "  ")
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					return writeWorkflowStatus(workflow, lease, children)
+				}
+
+			}(workflowName),
+		},
+	}
+
+}
+
+func newQuery(workflowName, state, stage, since, until string, where []string,
+	now time.Time) (repository.Query, error) {
+	query := repository.Query{
+		Name:  workflowName,
+		Stage: stage,
+	}
+	if state != "" {
+		query.Status = w.Status(state)
+		valid := false
+		for _, status := range w.Statuses() {
+			valid = valid || status == query.Status
+		}
+		if !valid {
+			return query, errors.New("Invalid state: " + state)
+		}
+	}
+	var err error
+	if query.Since, err = parseTime(since, now); err != nil {
+		return query, errors.WithStack(err)
+	}
+	if query.Until, err = parseTime(until, now); err != nil {
+		return query, errors.WithStack(err)
+	}
+	for _, condition := range where {
+		pair := strings.SplitN(condition, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return query, errors.New("Invalid variable condition: " + condition + ". Expected variable=value")
+		}
+		if query.Variables == nil {
+			query.Variables = make(map[string]string)
+		}
+		query.Variables[pair[0]] = pair[1]
+	}
+	return query, nil
+}
+
+// parseTime converts a date, a RFC3339 timestamp or a duration relative to now into Unix nanoseconds
+// An empty value is converted into 0
+func parseTime(value string, now time.Time) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return uint64(now.Add(-duration).UnixNano()), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return uint64(t.UnixNano()), nil
+		}
+	}
+	return 0, errors.New("Invalid time: " + value +
+		". Expected a date (2006-01-02), a timestamp (RFC3339) or a duration (168h)")
+}
+
+func formatTime(timestamp uint64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.Unix(0, int64(timestamp)).Format(timeLayout)
+}
+
+func writeWorkflows(workflows []w.Workflow) error {
+	if len(workflows) == 0 {
+		return io.Println("No workflows found")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tALIAS\tWORKFLOW\tSTATE\tSTAGE\tUPDATED") // nolint:errcheck
+	for _, workflow := range workflows {
+		alias := workflow.Alias
+		if alias == "" {
+			alias = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", // nolint:errcheck
+			workflow.Preffix, alias, workflow.Name, workflow.Status(), workflow.LatestStage(),
+			formatTime(workflow.Metadata.Updated))
+	}
+	return errors.WithStack(tw.Flush())
+}
+
+// childrenTree returns one line per workflow spawned by the workflow, directly or not,
+// indenting each one below the workflow that spawned it
+func (s Service) childrenTree(workflow w.Workflow, indent string) ([]string, error) {
+	var lines []string
+	for _, child := range workflow.Children {
+		optionalChild, err := s.repositoryService.GetWorkflow(child.Name, child.ID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		childWorkflow, err := optionalChild.Get()
+		if err != nil {
+			lines = append(lines, indent+child.Name+" "+child.ID+" (deleted) spawned by "+child.Stage)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s %s at %s spawned by %s", indent, child.Name, childWorkflow.Preffix,
+			childWorkflow.Status(), childWorkflow.LatestStage(), child.Stage))
+		grandchildren, err := s.childrenTree(childWorkflow, indent+"  ")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		lines = append(lines, grandchildren...)
+	}
+	return lines, nil
+}
+
+func writeWorkflowStatus(workflow w.Workflow, lease *repository.Lease, children []string) error {
+	lines := []string{
+		"Workflow: " + workflow.Name + " " + workflow.ID,
+	}
+	if workflow.Alias != "" {
+		lines = append(lines, "Alias:    "+workflow.Alias)
+	}
+	lines = append(lines,
+		"State:    "+string(workflow.Status()),
+		"Stage:    "+workflow.LatestStage(),
+		"Started:  "+formatTime(workflow.Metadata.Started),
+		"Updated:  "+formatTime(workflow.Metadata.Updated),
+	)
+	if workflow.ParentID != "" {
+		lines = append(lines, "Parent:   "+workflow.ParentName+" "+workflow.ParentID)
+	}
+	if lease != nil {
+		locked := fmt.Sprintf("Locked:   by PID %d on %s %s since %s",
+			lease.PID, lease.Host, lease.Activity(), formatTime(lease.Acquired))
+		if lease.IsStale(time.Now()) {
+			locked += " (stale)"
+		}
+		lines = append(lines, locked)
+	}
+	if len(children) > 0 {
+		lines = append(lines, "Children:")
+		lines = append(lines, children...)
+	}
+	lines = append(lines, "Executions:")
+	for _, execution := range workflow.Executions {
+		stage := execution.Stage
+		if execution.Failed {
+			stage = execution.FailedStage + " (failed)"
+		}
+		lines = append(lines,
+			fmt.Sprintf("  %s %s -> %s", formatTime(execution.Metadata.Started), execution.FromStage, stage))
+		for _, result := range execution.Results {
+			status := "ok"
+			if result.Failed {
+				status = "failed"
+			}
+			if result.Skipped {
+				status = "skipped"
+			}
+			lines = append(lines, "    $ "+result.Command+" ("+status+")")
+			if result.Output != "" {
+				lines = append(lines, "      "+strings.ReplaceAll(result.Output, "\n", "\n      "))
+			}
+		}
+		if approval := execution.Approval; approval != nil {
+			if approval.ApprovedBy == "" {
+				lines = append(lines, "    Waiting for approval requested by "+approval.RequestedBy)
+			} else {
+				lines = append(lines, "    Approved by "+approval.ApprovedBy+" on "+formatTime(approval.Approved)+
+					", requested by "+approval.RequestedBy)
+			}
+		}
+	}
+	return io.Println(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yamil-rivera/flowit/internal/io"
+	"github.com/yamil-rivera/flowit/internal/repository"
+	"github.com/yamil-rivera/flowit/internal/runtime"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+func (s Service) generateExportCommand() command {
+
+	var workflowName string
+	var all bool
+	exportCommand := &cobra.Command{
+		Use:   "export",
+		Short: "Write the active workflows to the standard output so they can be imported somewhere else",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var workflows []w.Workflow
+			var err error
+			if workflowName != "" {
+				workflows, err = s.repositoryService.GetWorkflows(workflowName, 0, !all)
+			} else {
+				workflows, err = s.repositoryService.GetAllWorkflows(!all)
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return repository.WriteExport(os.Stdout, workflows)
+		},
+	}
+	flags := exportCommand.Flags()
+	flags.StringVar(&workflowName, "workflow", "", "Only export workflows with this name")
+	flags.BoolVar(&all, "all", false, "Export finished and cancelled workflows too")
+	return command{cobra: exportCommand}
+
+}
+
+func (s Service) generateImportCommand() command {
+
+	var onConflict string
+	policies := make([]string, len(runtime.ConflictPolicies()))
+	for i, policy := range runtime.ConflictPolicies() {
+		policies[i] = string(policy)
+	}
+	importCommand := &cobra.Command{
+		Use:   "import <export-file>",
+		Short: "Import the workflows written by export. Use - to read them from the standard input",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := runtime.ConflictPolicy(onConflict)
+			valid := false
+			for _, supported := range runtime.ConflictPolicies() {
+				valid = valid || supported == policy
+			}
+			if !valid {
+				return errors.New("Invalid conflict policy: " + onConflict + ". Expected one of: " + strings.Join(policies, ", "))
+			}
+			workflows, err := readExportFile(args[0])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return s.runtimeService.Import(workflows, s.workflowDefinition.Flowit, policy, io.NewConsoleWriter())
+		},
+	}
+	importCommand.Flags().StringVar(&onConflict, "on-conflict", string(runtime.SkipConflicts),
+		"What to do with workflows which ID already exists: "+strings.Join(policies, ", "))
+	return command{cobra: importCommand}
+
+}
+
+func readExportFile(file string) ([]w.Workflow, error) {
+	reader := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file) // nolint:gosec
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer f.Close() // nolint:errcheck
+		reader = f
+	}
+	workflows, err := repository.ReadExport(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error trying to read "+file)
+	}
+	return workflows, nil
+}
@@ -8,14 +8,13 @@ import (
 // Load reads, parses and validates the specified configuration file and returns consumable workflow definition
 func Load(fileLocation string) (*WorkflowDefinition, error) {
 
-	// TODO: Hash parsed and validated config and verify if it changed or not?
 	viper, err := readWorkflowDefinition(fileLocation)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	// TODO: Viper is allowing repeated keys...
-	rawWorkflowDefinition, err := unmarshallWorkflowDefinition(viper)
+	rawWorkflowDefinition, err := loadWorkflowDefinition(viper)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -33,5 +32,7 @@ func Load(fileLocation string) (*WorkflowDefinition, error) {
 	if err := utils.DeepCopy(rawWorkflowDefinition, &workflowDefinition); err != nil {
 		return nil, errors.WithStack(err)
 	}
+	workflowDefinition.Hash = workflowDefinition.Flowit.Hash()
+	workflowDefinition.Warnings = collectWarnings(workflowDefinition.Flowit)
 	return &workflowDefinition, nil
 }
@@ -19,10 +19,25 @@ var _ = Describe("Config", func() {
 				Expect(err).To(BeNil())
 				Expect(cs.Flowit.Version).To(Equal("0.1"))
 				Expect(cs.Flowit.Config.Shell).To(Equal("/usr/bin/env bash"))
+				Expect(cs.Flowit.Config.CheckpointExecution).To(BeTrue())
+				Expect(cs.Flowit.Config.VariableMode).To(Equal(config.SubstituteVariables))
+				Expect(cs.Warnings).To(BeEmpty())
 				/* #gomnd */
 				Expect(cs.Flowit.Variables["gerrit-port"]).To(Equal(float64(29418)))
 				Expect(cs.Flowit.Workflows[0].Stages[0].Actions[0]).
-					To(Equal("git checkout master"))
+					To(Equal(config.Command{Run: "git checkout master"}))
+			})
+
+		})
+
+		Context("Processing a configuration with env sections", func() {
+
+			It("should uppercase the environment variable names", func() {
+				cs, err := config.Load("./testdata/env.yaml")
+				Expect(err).To(BeNil())
+				Expect(cs.Flowit.Config.Env).To(Equal(map[string]string{"GOFLAGS": "-mod=vendor"}))
+				Expect(cs.Flowit.Workflows[0].Env).To(Equal(map[string]string{"JIRA_ISSUE": "$<jira-issue-id>"}))
+				Expect(cs.Flowit.Workflows[0].Stages[0].Env).To(Equal(map[string]string{"TARGET": "staging"}))
 			})
 
 		})
@@ -7,6 +7,9 @@ import (
 type defaults struct {
 	CheckpointExecution bool
 	Shell               string
+	VariableMode        string
+	RepositoryType      string
+	RepositoryLocations map[string]string
 	Stages              rawStages
 	Branches            []*string
 }
@@ -21,6 +24,14 @@ func generateDefaultValues() *defaults {
 
 	defaultValues.CheckpointExecution = true
 	defaultValues.Shell = generateDefaultShell()
+	defaultValues.VariableMode = SubstituteVariables
+	defaultValues.RepositoryType = BoltRepository
+	defaultValues.RepositoryLocations = map[string]string{
+		BoltRepository:   ".flowitDS",
+		MemoryRepository: "",
+		JSONRepository:   ".flowit",
+		SQLiteRepository: ".flowit.db",
+	}
 
 	return &defaultValues
 }
@@ -45,4 +56,18 @@ func setDefaultValues(workflowDefinition *rawWorkflowDefinition, defaultValues *
 	if workflowDefinition.Flowit.Config.Shell == nil {
 		workflowDefinition.Flowit.Config.Shell = &defaultValues.Shell
 	}
+	if workflowDefinition.Flowit.Config.VariableMode == nil {
+		workflowDefinition.Flowit.Config.VariableMode = &defaultValues.VariableMode
+	}
+	if workflowDefinition.Flowit.Config.Repository == nil {
+		workflowDefinition.Flowit.Config.Repository = &rawRepository{}
+	}
+	repository := workflowDefinition.Flowit.Config.Repository
+	if repository.Type == nil {
+		repository.Type = &defaultValues.RepositoryType
+	}
+	if repository.Location == nil {
+		location := defaultValues.RepositoryLocations[*repository.Type]
+		repository.Location = &location
+	}
 }
@@ -42,6 +42,27 @@ var _ = Describe("Config", func() {
 			Expect(*workflowDefinition.Flowit.Config.Shell).To(Equal(shell))
 		})
 
+		It("should default the repository location according to its type", func() {
+			workflowDefinition := rawWorkflowDefinition{
+				Flowit: &rawMainDefinition{},
+			}
+			setDefaults(&workflowDefinition)
+			Expect(*workflowDefinition.Flowit.Config.Repository.Type).To(Equal(BoltRepository))
+			Expect(*workflowDefinition.Flowit.Config.Repository.Location).To(Equal(".flowitDS"))
+
+			repositoryType := JSONRepository
+			workflowDefinition = rawWorkflowDefinition{
+				Flowit: &rawMainDefinition{
+					Config: &rawConfig{
+						Repository: &rawRepository{Type: &repositoryType},
+					},
+				},
+			}
+			setDefaults(&workflowDefinition)
+			Expect(*workflowDefinition.Flowit.Config.Repository.Type).To(Equal(JSONRepository))
+			Expect(*workflowDefinition.Flowit.Config.Repository.Location).To(Equal(".flowit"))
+		})
+
 	})
 
 })
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/utils"
+)
+
+// workflowScope gathers every definition section a single workflow depends on
+type workflowScope struct {
+	Config       Config
+	Variables    Variables
+	StateMachine StateMachine
+	Workflow     Workflow
+}
+
+// Diff returns a human readable list of the differences between two definitions as far as the
+// specified workflow is concerned. An empty list means both definitions behave the same for that workflow
+func Diff(from, to Flowit, workflowID string) ([]string, error) {
+	fromProperties, err := flattenWorkflowScope(from, workflowID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	toProperties, err := flattenWorkflowScope(to, workflowID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	paths := make([]string, 0, len(fromProperties)+len(toProperties))
+	for path := range fromProperties {
+		paths = append(paths, path)
+	}
+	for path := range toProperties {
+		if _, ok := fromProperties[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diff []string
+	for _, path := range paths {
+		fromValues, inFrom := fromProperties[path]
+		toValues, inTo := toProperties[path]
+		switch {
+		case !inTo:
+			diff = append(diff, "- "+path)
+		case !inFrom:
+			diff = append(diff, "+ "+path)
+		case utils.CompareSlices(fromValues, toValues):
+			continue
+		default:
+			diff = append(diff, "~ "+path)
+		}
+		for _, value := range fromValues {
+			diff = append(diff, "    - "+value)
+		}
+		for _, value := range toValues {
+			diff = append(diff, "    + "+value)
+		}
+	}
+	return diff, nil
+}
+
+func flattenWorkflowScope(definition Flowit, workflowID string) (map[string][]string, error) {
+	scope := workflowScope{
+		Config:    definition.Config,
+		Variables: definition.Variables,
+	}
+	for _, workflow := range definition.Workflows {
+		if workflow.ID == workflowID {
+			scope.Workflow = workflow
+		}
+	}
+	for _, stateMachine := range definition.StateMachines {
+		if stateMachine.ID == scope.Workflow.StateMachine {
+			scope.StateMachine = stateMachine
+		}
+	}
+
+	// Going through encoding/json gives us a generic representation of every field,
+	// including the ones added to the model in the future
+	var generic interface{}
+	if err := utils.DeepCopy(scope, &generic); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	properties := make(map[string][]string)
+	flatten("", generic, properties)
+	return properties, nil
+}
+
+// flatten walks a generic JSON value and stores every leaf or list of leaves under its dotted path
+// Lists of objects with an ID are keyed by that ID so reordering them is not reported as a change
+// Stage commands which are not parallel groups are leaves, as they are written as plain strings
+func flatten(path string, value interface{}, properties map[string][]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flatten(joinPath(path, key), child, properties)
+		}
+	case []interface{}:
+		if !containsObjects(value) {
+			values := make([]string, len(value))
+			for i, child := range value {
+				values[i] = leafString(child)
+			}
+			properties[path] = values
+			return
+		}
+		for i, child := range value {
+			key := strconv.Itoa(i)
+			if object, ok := child.(map[string]interface{}); ok {
+				if id, ok := object["ID"].(string); ok && id != "" {
+					key = id
+				}
+			}
+			flatten(joinPath(path, key), child, properties)
+		}
+	case nil:
+		return
+	default:
+		properties[path] = []string{leafString(value)}
+	}
+}
+
+func containsObjects(values []interface{}) bool {
+	for _, value := range values {
+		if _, ok := singleCommand(value); ok {
+			continue
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// singleCommand returns the command of a generic stage command which is not a parallel group
+func singleCommand(value interface{}) (string, bool) {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) != 1 {
+		return "", false
+	}
+	run, ok := object["Run"].(string)
+	return run, ok
+}
+
+func leafString(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	if run, ok := singleCommand(value); ok {
+		return run
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(bytes)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
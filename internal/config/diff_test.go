@@ -0,0 +1,70 @@
+package config_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/config"
+)
+
+var _ = Describe("Config", func() {
+
+	Describe("Comparing workflow definitions", func() {
+
+		It("should hash git commands by their fields", func() {
+			from, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+			to, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+
+			from.Flowit.Workflows[0].Stages[1].Actions = []config.Command{{Git: &config.GitCommand{Action: config.GitPush}}}
+			to.Flowit.Workflows[0].Stages[1].Actions = []config.Command{{Git: &config.GitCommand{Action: config.GitPush}}}
+			Expect(to.Flowit.Hash()).To(Equal(from.Flowit.Hash()))
+
+			to.Flowit.Workflows[0].Stages[1].Actions[0].Git.Remote = "upstream"
+			Expect(to.Flowit.Hash()).ToNot(Equal(from.Flowit.Hash()))
+		})
+
+		It("should not consider repository or retention edits a definition change", func() {
+			from, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+			to, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+
+			to.Flowit.Config.Repository = config.Repository{Type: config.JSONRepository, Location: ".workflows"}
+			to.Flowit.Config.Retention = config.Retention{Days: 7, Keep: 3, Archive: ".flowit-archive"}
+			Expect(to.Flowit.Hash()).To(Equal(from.Hash))
+		})
+
+		It("should only report differences relevant to the workflow", func() {
+			from, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+			to, err := config.Load("./testdata/valid.yaml")
+			Expect(err).To(BeNil())
+			Expect(from.Hash).To(Equal(to.Hash))
+
+			diff, err := config.Diff(from.Flowit, to.Flowit, "development")
+			Expect(err).To(BeNil())
+			Expect(diff).To(BeEmpty())
+
+			to.Flowit.Workflows[0].Stages[1].Actions = []config.Command{{Run: "git fetch"}}
+			to.Flowit.Variables["jira-host"] = "jira.company.com"
+			delete(to.Flowit.Variables, "gerrit-port")
+			Expect(to.Flowit.Hash()).ToNot(Equal(from.Hash))
+
+			diff, err = config.Diff(from.Flowit, to.Flowit, "development")
+			Expect(err).To(BeNil())
+			Expect(diff).To(Equal([]string{
+				"- Variables.gerrit-port",
+				"    - 29418",
+				"+ Variables.jira-host",
+				"    + jira.company.com",
+				"~ Workflow.Stages.sync.Actions",
+				"    - git checkout master",
+				"    - git pull origin master",
+				"    + git fetch",
+			}))
+		})
+
+	})
+})
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Migrate rewrites the specified workflow definition file into the latest workflow definition version
+// Comments are preserved where possible. The migrated definition is validated before replacing the original file
+// It returns the version the file was written in and the version it was migrated to
+func Migrate(fileLocation string) (string, string, error) {
+	content, err := ioutil.ReadFile(fileLocation) // nolint:gosec
+	if err != nil {
+		return "", "", errors.Wrap(err, "Workflow definition read error")
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return "", "", errors.Wrap(err, "Workflow definition parse error")
+	}
+
+	fromVersion, err := documentVersion(&document)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	index, found := definitionVersionIndex(fromVersion)
+	if !found {
+		return "", "", errors.New("Unsupported workflow definition version: " + fromVersion)
+	}
+
+	versions := definitionVersions()
+	if index == len(versions)-1 {
+		return fromVersion, fromVersion, nil
+	}
+	for _, version := range versions[index : len(versions)-1] {
+		if err := version.migrate(&document); err != nil {
+			return "", "", errors.Wrap(err, "Error migrating workflow definition from version "+version.version)
+		}
+	}
+
+	var migrated bytes.Buffer
+	encoder := yaml.NewEncoder(&migrated)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return "", "", errors.Wrap(err, "Workflow definition encoding error")
+	}
+	if err := encoder.Close(); err != nil {
+		return "", "", errors.Wrap(err, "Workflow definition encoding error")
+	}
+
+	if err := replaceValidatedDefinition(fileLocation, migrated.Bytes()); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	return fromVersion, LatestVersion(), nil
+}
+
+// replaceValidatedDefinition writes the content next to the original file, loads it to make sure
+// it is a valid workflow definition and only then replaces the original file
+func replaceValidatedDefinition(fileLocation string, content []byte) error {
+	info, err := os.Stat(fileLocation)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileLocation), "migrated-*"+filepath.Ext(fileLocation))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpLocation := tmpFile.Name()
+	defer os.Remove(tmpLocation) // nolint:errcheck
+
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close() // nolint:errcheck,gosec
+		return errors.WithStack(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := Load(tmpLocation); err != nil {
+		return errors.Wrap(err, "Migrated workflow definition is invalid")
+	}
+	if err := os.Chmod(tmpLocation, info.Mode()); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpLocation, fileLocation))
+}
+
+// mainDefinitionNode returns the mapping node under the main 'flowit' key
+func mainDefinitionNode(document *yaml.Node) (*yaml.Node, error) {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil, errors.New("Workflow definition is empty")
+	}
+	mainDefinition := mappingValue(document.Content[0], "flowit")
+	if mainDefinition == nil || mainDefinition.Kind != yaml.MappingNode {
+		return nil, errors.New("Workflow definition must contain a main 'flowit' key")
+	}
+	return mainDefinition, nil
+}
+
+func documentVersion(document *yaml.Node) (string, error) {
+	mainDefinition, err := mainDefinitionNode(document)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	version := mappingValue(mainDefinition, "version")
+	if version == nil || version.Kind != yaml.ScalarNode {
+		return "", errors.New("Workflow definition must contain a 'version' key")
+	}
+	return version.Value, nil
+}
+
+func setDocumentVersion(document *yaml.Node, version string) error {
+	mainDefinition, err := mainDefinitionNode(document)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	versionNode := mappingValue(mainDefinition, "version")
+	if versionNode == nil {
+		return errors.New("Workflow definition must contain a 'version' key")
+	}
+	versionNode.Value = version
+	versionNode.Tag = "!!str"
+	versionNode.Style = yaml.DoubleQuotedStyle
+	return nil
+}
+
+// mappingValue returns the value node associated to key in a mapping node or nil if it is not present
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/config"
+)
+
+var _ = Describe("Config", func() {
+
+	Describe("Migrating a workflow definition file", func() {
+
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "flowit-migration")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		copyDefinition := func(source string) string {
+			content, err := ioutil.ReadFile(source)
+			Expect(err).To(BeNil())
+			content = append([]byte("# Team workflows\n"), content...)
+			target := filepath.Join(dir, "definition.yaml")
+			Expect(ioutil.WriteFile(target, content, 0600)).To(Succeed())
+			return target
+		}
+
+		It("should rewrite a 0.1 definition into the latest version preserving comments", func() {
+			location := copyDefinition("./testdata/valid.yaml")
+			original, err := config.Load(location)
+			Expect(err).To(BeNil())
+
+			fromVersion, toVersion, err := config.Migrate(location)
+			Expect(err).To(BeNil())
+			Expect(fromVersion).To(Equal("0.1"))
+			Expect(toVersion).To(Equal(config.LatestVersion()))
+
+			content, err := ioutil.ReadFile(location)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(ContainSubstring("# Team workflows"))
+
+			migrated, err := config.Load(location)
+			Expect(err).To(BeNil())
+			Expect(migrated.Flowit.Version).To(Equal(config.LatestVersion()))
+			Expect(migrated.Flowit.Workflows).To(Equal(original.Flowit.Workflows))
+			Expect(migrated.Flowit.StateMachines).To(Equal(original.Flowit.StateMachines))
+		})
+
+		It("should replace the initial stage of the state machines with a list of initial stages", func() {
+			location := copyDefinition("./testdata/valid.yaml")
+
+			_, _, err := config.Migrate(location)
+			Expect(err).To(BeNil())
+
+			content, err := ioutil.ReadFile(location)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(ContainSubstring("initial-stages: [start]"))
+			Expect(string(content)).ToNot(ContainSubstring("initial-stage:"))
+
+			migrated, err := config.Load(location)
+			Expect(err).To(BeNil())
+			Expect(migrated.Flowit.StateMachines[0].InitialStages).To(Equal([]string{"start"}))
+		})
+
+		It("should leave a definition on the latest version untouched", func() {
+			location := copyDefinition("./testdata/valid.yaml")
+			_, _, err := config.Migrate(location)
+			Expect(err).To(BeNil())
+			before, err := ioutil.ReadFile(location)
+			Expect(err).To(BeNil())
+
+			fromVersion, toVersion, err := config.Migrate(location)
+			Expect(err).To(BeNil())
+			Expect(fromVersion).To(Equal(toVersion))
+
+			after, err := ioutil.ReadFile(location)
+			Expect(err).To(BeNil())
+			Expect(after).To(Equal(before))
+		})
+
+		It("should fail on unsupported versions", func() {
+			location := filepath.Join(dir, "definition.yaml")
+			Expect(ioutil.WriteFile(location, []byte("flowit:\n  version: \"9.9\"\n"), 0600)).To(Succeed())
+
+			_, _, err := config.Migrate(location)
+			Expect(err).To(Not(BeNil()))
+		})
+
+	})
+})
@@ -1,10 +1,20 @@
 package config
 
-import "github.com/pkg/errors"
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/utils"
+)
 
 // WorkflowDefinition is the consumer friendly data structure that hosts the loaded workflow definition
 type WorkflowDefinition struct {
 	Flowit Flowit
+	// Hash identifies the loaded definition contents. It changes whenever the definition does
+	Hash string
+	// Warnings point out valid but risky parts of the definition
+	Warnings []string
 }
 
 // Flowit is the consumer friendly data structure that hosts the loaded workflow definition main body
@@ -20,19 +30,71 @@ type Flowit struct {
 type Config struct {
 	CheckpointExecution bool
 	Shell               string
+	// VariableMode defines how the values of variable references reach the commands
+	VariableMode string
+	// Env holds the environment variables every command is run with. Their values may reference variables
+	Env map[string]string `json:",omitempty"`
+	// CleanEnv runs commands without the environment flowit runs with, except for the AllowEnv variables
+	CleanEnv   bool     `json:",omitempty"`
+	AllowEnv   []string `json:",omitempty"`
+	Repository Repository
+	Retention  Retention
+	Audit      Audit
+}
+
+// Repository is the consumer friendly data structure that hosts
+// the loaded workflow definition repository configuration
+type Repository struct {
+	Type     string
+	Location string
+}
+
+// Retention is the consumer friendly data structure that hosts
+// the loaded workflow definition retention policy for finished and cancelled workflows
+// Zero values disable the corresponding rule
+type Retention struct {
+	// Days is how long workflows are kept after they finished
+	Days int
+	// Keep is how many of the most recently finished workflows are kept per workflow
+	Keep int
+	// Archive is the directory where workflows are archived before being removed
+	Archive string
+}
+
+// Audit is the consumer friendly data structure that hosts the loaded workflow definition audit configuration
+type Audit struct {
+	// File is the JSON lines file audit events are appended to besides the repository
+	File string
 }
 
+// Supported repository types
+const (
+	BoltRepository   = "bolt"
+	MemoryRepository = "memory"
+	JSONRepository   = "json"
+	SQLiteRepository = "sqlite"
+)
+
+// Supported variable modes
+// Values are either substituted into the commands, quoted, or passed
+// to them as environment variables or positional parameters
+const (
+	SubstituteVariables  = "substitute"
+	EnvironmentVariables = "environment"
+	PositionalVariables  = "positional"
+)
+
 // Variables is the consumer friendly data structure that hosts the loaded workflow definition variables
 type Variables map[string]interface{}
 
 // StateMachine is the consumer friendly data structure that hosts
 // the loaded workflow definition state machine
 type StateMachine struct {
-	ID           string
-	Stages       []string
-	InitialStage string
-	FinalStages  []string
-	Transitions  []StateMachineTransition
+	ID            string
+	Stages        []string
+	InitialStages []string
+	FinalStages   []string
+	Transitions   []StateMachineTransition
 }
 
 // StateMachineTransition is the consumer friendly data structure that hosts
@@ -40,6 +102,9 @@ type StateMachine struct {
 type StateMachineTransition struct {
 	From []string
 	To   []string
+	// Guard is the command that must succeed for the transition to be taken, if any
+	// It is omitted when empty so that copying an unguarded transition into its raw model leaves it unset
+	Guard string `json:",omitempty"`
 }
 
 // Stages is the consumer friendly data structure that hosts
@@ -51,16 +116,108 @@ type Stages map[string][]string
 type Workflow struct {
 	ID           string
 	StateMachine string
-	Stages       []Stage
+	// Env holds the environment variables the workflow commands are run with on top of the config ones
+	Env map[string]string `json:",omitempty"`
+	// Workdir is the directory the workflow commands are run in. It may reference variables
+	Workdir string `json:",omitempty"`
+	Stages  []Stage
 }
 
 // Stage is the consumer friendly data structure that hosts
 // the loaded workflow definition workflow stage
 type Stage struct {
-	ID         string
+	ID string
+	// Type is empty for regular stages or ApprovalStage for stages another user must approve before leaving them
+	Type       string `json:",omitempty"`
 	Args       []string
-	Conditions []string
-	Actions    []string
+	Conditions []Command
+	Actions    []Command
+	Spawn      []Spawn
+	// Env holds the environment variables the stage commands are run with on top of the workflow ones
+	Env map[string]string `json:",omitempty"`
+	// Workdir is the directory the stage commands are run in instead of the workflow one. It may reference variables
+	Workdir string `json:",omitempty"`
+	// Confirm is the question that must be answered yes before the stage actions are run. It may reference variables
+	Confirm string `json:",omitempty"`
+}
+
+// ApprovalStage is the type of the stages which require the approval of another user before the workflow leaves them
+const ApprovalStage = "approval"
+
+// Command is the consumer friendly data structure that hosts
+// the loaded workflow definition stage command. It is either a single command,
+// a group of commands run concurrently if Parallel is not empty or a built-in git command if Git is set
+type Command struct {
+	Run      string      `json:",omitempty"`
+	Parallel []string    `json:",omitempty"`
+	Git      *GitCommand `json:",omitempty"`
+	// MaxConcurrency bounds how many commands of the group run at the same time. Zero means no bound
+	MaxConcurrency int `json:",omitempty"`
+	// FailFast stops starting the remaining commands of the group once one of them fails
+	FailFast bool `json:",omitempty"`
+	// When is a command which must succeed for the command to be run. Otherwise it is skipped
+	When string `json:",omitempty"`
+	// Foreach names the list variable the command is run for every item of
+	Foreach string `json:",omitempty"`
+	// As names the variable holding the item the command is run for
+	As string `json:",omitempty"`
+	// Confirm is the question that must be answered yes before the action is run. It may reference variables
+	Confirm string `json:",omitempty"`
+}
+
+// GitCommand is the consumer friendly data structure that hosts
+// the loaded workflow definition built-in git action or check. Every field but Action may reference variables
+type GitCommand struct {
+	Action string
+	// Branch is the branch the command is run on. Every action but create-branch and delete-branch,
+	// and every check but branch-exists, use the current branch if it is empty
+	Branch string `json:",omitempty"`
+	// From is the branch create-branch branches off. The current branch is used if it is empty
+	From string `json:",omitempty"`
+	// Onto is the branch rebase-onto rebases Branch onto
+	Onto string `json:",omitempty"`
+	// Into is the branch merge merges Branch into
+	Into string `json:",omitempty"`
+	// Remote is the remote branches are compared with and pushed to. It is origin if it is empty
+	Remote string `json:",omitempty"`
+}
+
+// String formats the git command fields rather than its address, so that definitions holding it always hash the same
+func (g GitCommand) String() string {
+	type fields GitCommand
+	return fmt.Sprintf("%+v", fields(g))
+}
+
+// Supported git actions, which change the repository
+const (
+	GitCreateBranch = "create-branch"
+	GitRebaseOnto   = "rebase-onto"
+	GitMerge        = "merge"
+	GitDeleteBranch = "delete-branch"
+	GitPush         = "push"
+)
+
+// Supported git checks, which fail unless the repository is in the expected state
+const (
+	GitBranchExists = "branch-exists"
+	GitClean        = "clean"
+	GitUpToDate     = "up-to-date"
+)
+
+// ContextEnvPrefix starts the names of the environment variables exposing the workflow context to commands
+// Variables defined in env sections can not use it
+const ContextEnvPrefix = "FLOWIT_"
+
+// DefaultForeachVariable is the variable holding the item a command is run for if the command does not name it
+const DefaultForeachVariable = "item"
+
+// Spawn is the consumer friendly data structure that hosts
+// the loaded workflow definition child workflow spawned by a stage
+type Spawn struct {
+	Workflow string
+	// Stage is left empty when the child workflow has a single initial stage
+	Stage     string `json:",omitempty"`
+	Variables map[string]string
 }
 
 // Transition is the consumer friendly data structure that hosts
@@ -70,6 +227,19 @@ type Transition struct {
 	To   []string
 }
 
+// Hash returns a hex encoded digest of the definition parts workflows are run with: its variables,
+// state machines and workflows. Editing the rest of the configuration, such as where workflows are stored
+// or how long they are kept, does not make existing workflows drift
+func (f Flowit) Hash() string {
+	// hash.Hash writes never return an error
+	digest, _ := utils.Sha256(struct {
+		Variables     Variables
+		StateMachines []StateMachine
+		Workflows     []Workflow
+	}{f.Variables, f.StateMachines, f.Workflows})
+	return hex.EncodeToString(digest)
+}
+
 // StateMachine receives a state machine ID and returns the correspoding
 // state machine
 func (wd WorkflowDefinition) StateMachine(stateMachineID string) (StateMachine, error) {
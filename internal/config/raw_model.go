@@ -15,23 +15,47 @@ type rawMainDefinition struct {
 }
 
 type rawConfig struct {
-	Checkpoints *bool `mapstructure:"checkpoints"`
-	Shell       *string
+	// The JSON name matches the model field, as raw models are converted through encoding/json
+	Checkpoints  *bool `mapstructure:"checkpoints" json:"CheckpointExecution"`
+	Shell        *string
+	VariableMode *string `mapstructure:"variable-mode"`
+	Env          map[string]*string
+	CleanEnv     *bool     `mapstructure:"clean-env"`
+	AllowEnv     []*string `mapstructure:"allow-env"`
+	Repository   *rawRepository
+	Retention    *rawRetention
+	Audit        *rawAudit
+}
+
+type rawRepository struct {
+	Type     *string
+	Location *string
+}
+
+type rawRetention struct {
+	Days    *int
+	Keep    *int
+	Archive *string
+}
+
+type rawAudit struct {
+	File *string
 }
 
 type rawVariables map[string]interface{}
 
 type rawStateMachine struct {
-	ID           *string
-	Stages       []*string
-	InitialStage *string   `mapstructure:"initial-stage"`
-	FinalStages  []*string `mapstructure:"final-stages"`
-	Transitions  []*rawStateMachineTransition
+	ID            *string
+	Stages        []*string
+	InitialStages []*string `mapstructure:"initial-stages"`
+	FinalStages   []*string `mapstructure:"final-stages"`
+	Transitions   []*rawStateMachineTransition
 }
 
 type rawStateMachineTransition struct {
-	From []*string
-	To   []*string
+	From  []*string
+	To    []*string
+	Guard *string
 }
 
 type rawStages map[string][]*string
@@ -39,12 +63,47 @@ type rawStages map[string][]*string
 type rawWorkflow struct {
 	ID           *string
 	StateMachine *string `mapstructure:"state-machine"`
+	Env          map[string]*string
+	Workdir      *string
 	Stages       []*rawStage
 }
 
 type rawStage struct {
 	ID         *string
+	Type       *string
 	Args       []*string
-	Conditions []*string
-	Actions    []*string
+	Conditions []*rawCommand
+	Actions    []*rawCommand
+	Spawn      []*rawSpawn
+	Env        map[string]*string
+	Workdir    *string
+	Confirm    *string
+}
+
+// rawCommand is written as a plain string unless it is a parallel group or a git command
+type rawCommand struct {
+	Run            *string
+	Parallel       []*string
+	Git            *rawGitCommand
+	MaxConcurrency *int  `mapstructure:"max-concurrency"`
+	FailFast       *bool `mapstructure:"fail-fast"`
+	When           *string
+	Foreach        *string
+	As             *string
+	Confirm        *string
+}
+
+type rawGitCommand struct {
+	Action *string
+	Branch *string
+	From   *string
+	Onto   *string
+	Into   *string
+	Remote *string
+}
+
+type rawSpawn struct {
+	Workflow  *string
+	Stage     *string
+	Variables map[string]*string
 }
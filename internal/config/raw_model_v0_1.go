@@ -0,0 +1,34 @@
+package config
+
+// rawWorkflowDefinitionV01 is the typed data structure used for populating version 0.1 workflow definitions
+// Sections that did not change in version 0.2 reuse its raw model types, which are frozen as well
+type rawWorkflowDefinitionV01 struct {
+	Flowit *rawMainDefinitionV01
+}
+
+type rawMainDefinitionV01 struct {
+	Version       *string
+	Config        *rawConfigV01
+	Variables     *rawVariables
+	StateMachines []*rawStateMachineV01 `mapstructure:"state-machines"`
+	Workflows     []*rawWorkflowV02
+}
+
+type rawConfigV01 struct {
+	Checkpoints *bool `mapstructure:"checkpoints"`
+	Shell       *string
+}
+
+type rawStateMachineV01 struct {
+	ID           *string
+	Stages       []*string
+	InitialStage *string   `mapstructure:"initial-stage"`
+	FinalStages  []*string `mapstructure:"final-stages"`
+	Transitions  []*rawStateMachineTransitionV01
+}
+
+// rawStateMachineTransitionV01 has no guard
+type rawStateMachineTransitionV01 struct {
+	From []*string
+	To   []*string
+}
@@ -0,0 +1,53 @@
+package config
+
+// rawWorkflowDefinitionV02 is the typed data structure used for populating version 0.2 workflow definitions
+// Its sections have their own raw model types so that syntax added in later versions is not accepted,
+// except for the leaf sections which did not change since
+type rawWorkflowDefinitionV02 struct {
+	Flowit *rawMainDefinitionV02
+}
+
+type rawMainDefinitionV02 struct {
+	Version       *string
+	Config        *rawConfigV02
+	Variables     *rawVariables
+	StateMachines []*rawStateMachineV02 `mapstructure:"state-machines"`
+	Workflows     []*rawWorkflowV02
+}
+
+type rawConfigV02 struct {
+	Checkpoints *bool `mapstructure:"checkpoints"`
+	Shell       *string
+	Repository  *rawRepository
+	Retention   *rawRetention
+	Audit       *rawAudit
+}
+
+// rawStateMachineV02 has a single initial stage
+type rawStateMachineV02 struct {
+	ID           *string
+	Stages       []*string
+	InitialStage *string   `mapstructure:"initial-stage"`
+	FinalStages  []*string `mapstructure:"final-stages"`
+	Transitions  []*rawStateMachineTransitionV02
+}
+
+type rawStateMachineTransitionV02 struct {
+	From  []*string
+	To    []*string
+	Guard *string
+}
+
+type rawWorkflowV02 struct {
+	ID           *string
+	StateMachine *string `mapstructure:"state-machine"`
+	Stages       []*rawStageV02
+}
+
+// rawStageV02 commands are plain strings
+type rawStageV02 struct {
+	ID         *string
+	Args       []*string
+	Conditions []*string
+	Actions    []*string
+}
@@ -4,6 +4,32 @@ import "strings"
 
 func applyTransformations(workflowDefinition *rawWorkflowDefinition) {
 	transformStateMachines(workflowDefinition.Flowit.StateMachines)
+	transformEnvs(workflowDefinition.Flowit)
+}
+
+// transformEnvs uppercases the names of the environment variables of every env section
+// Names are read case insensitively, as the configuration keys are, so they are exported uppercased
+func transformEnvs(definition *rawMainDefinition) {
+	if definition.Config != nil {
+		definition.Config.Env = upperEnv(definition.Config.Env)
+	}
+	for _, workflow := range definition.Workflows {
+		workflow.Env = upperEnv(workflow.Env)
+		for _, stage := range workflow.Stages {
+			stage.Env = upperEnv(stage.Env)
+		}
+	}
+}
+
+func upperEnv(env map[string]*string) map[string]*string {
+	if env == nil {
+		return nil
+	}
+	transformed := make(map[string]*string, len(env))
+	for name, value := range env {
+		transformed[strings.ToUpper(name)] = value
+	}
+	return transformed
 }
 
 func transformStateMachines(stateMachines []*rawStateMachine) {
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"reflect"
+
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
@@ -11,15 +13,58 @@ func unmarshallWorkflowDefinition(v *viper.Viper) (*rawWorkflowDefinition, error
 
 	var workflowDefinition rawWorkflowDefinition
 
+	if err := unmarshallExact(v, &workflowDefinition); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &workflowDefinition, nil
+}
+
+func unmarshallWorkflowDefinitionV01(v *viper.Viper) (interface{}, error) {
+
+	var workflowDefinition rawWorkflowDefinitionV01
+
+	if err := unmarshallExact(v, &workflowDefinition); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &workflowDefinition, nil
+}
+
+func unmarshallWorkflowDefinitionV02(v *viper.Viper) (interface{}, error) {
+
+	var workflowDefinition rawWorkflowDefinitionV02
+
+	if err := unmarshallExact(v, &workflowDefinition); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &workflowDefinition, nil
+}
+
+func unmarshallExact(v *viper.Viper, workflowDefinition interface{}) error {
+
 	config := func(c *mapstructure.DecoderConfig) {
 		c.ErrorUnused = true
 		c.WeaklyTypedInput = false
 		c.ZeroFields = true
+		c.DecodeHook = mapstructure.ComposeDecodeHookFunc(c.DecodeHook, commandDecodeHook)
 	}
 
-	if err := (*v).UnmarshalExact(&workflowDefinition, config); err != nil {
-		return nil, errors.Wrap(err, "Workflow definition unmarshalling error")
+	if err := (*v).UnmarshalExact(workflowDefinition, config); err != nil {
+		return errors.Wrap(err, "Workflow definition unmarshalling error")
 	}
 
-	return &workflowDefinition, nil
+	return nil
+}
+
+// commandDecodeHook decodes the stage commands written as plain strings
+func commandDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to.Kind() == reflect.Ptr {
+		to = to.Elem()
+	}
+	if from.Kind() == reflect.String && to == reflect.TypeOf(rawCommand{}) {
+		return map[string]interface{}{"run": data}, nil
+	}
+	return data, nil
 }
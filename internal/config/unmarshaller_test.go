@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -22,9 +23,35 @@ var _ = Describe("Config", func() {
 					Fail(fmt.Sprintf("Error reading config %+v", err))
 				}
 
-				definition, err := unmarshallWorkflowDefinition(viper)
+				definition, err := loadWorkflowDefinition(viper)
 				Expect(err).To(BeNil())
 				Expect(*definition.Flowit.Version).To(Equal("0.1"))
+				Expect(definition.Flowit.StateMachines[0].InitialStages).To(HaveLen(1))
+				Expect(*definition.Flowit.StateMachines[0].InitialStages[0]).To(Equal("start"))
+				conditions := definition.Flowit.Workflows[0].Stages[2].Conditions
+				Expect(conditions).To(HaveLen(2))
+				Expect(*conditions[0].Run).To(Equal("./run-tests.sh"))
+				Expect(*conditions[1].Run).To(ContainSubstring("In Progress"))
+			})
+
+			It("should populate parallel groups", func() {
+				viper := viper.New()
+				viper.SetConfigFile("./testdata/parallel.yaml")
+				if err := viper.ReadInConfig(); err != nil {
+					Fail(fmt.Sprintf("Error reading config %+v", err))
+				}
+
+				definition, err := loadWorkflowDefinition(viper)
+				Expect(err).To(BeNil())
+				conditions := definition.Flowit.Workflows[0].Stages[1].Conditions
+				Expect(conditions).To(HaveLen(2))
+				Expect(conditions[0].Run).To(BeNil())
+				Expect(conditions[0].Parallel).To(HaveLen(2))
+				Expect(*conditions[0].Parallel[1]).To(Equal("./run-lint.sh"))
+				Expect(*conditions[0].MaxConcurrency).To(Equal(2))
+				Expect(*conditions[0].FailFast).To(BeTrue())
+				Expect(*conditions[1].Run).To(Equal("./check-status.sh"))
+				Expect(validateWorkflowDefinition(definition)).To(Succeed())
 			})
 
 			It("should set nil on missing sections", func() {
@@ -41,6 +68,41 @@ var _ = Describe("Config", func() {
 
 		})
 
+		Context("Unmarshalling older versions", func() {
+
+			load := func(version, stateMachine, workflow string) (*rawWorkflowDefinition, error) {
+				viper := viper.New()
+				viper.SetConfigType("yaml")
+				document := "flowit:\n  version: \"" + version + "\"\n" +
+					"  state-machines:\n  - id: machine\n    stages: [ start, finish ]\n    initial-stage: start\n" +
+					"    final-stages: [ finish ]\n    transitions:\n    - from: [ start ]\n      to: [ finish ]\n" + stateMachine +
+					"  workflows:\n  - id: feature\n    state-machine: machine\n" + workflow +
+					"    stages:\n    - id: start\n      actions:\n      - ./start.sh\n"
+				if err := viper.ReadConfig(strings.NewReader(document)); err != nil {
+					Fail(fmt.Sprintf("Error reading config %+v", err))
+				}
+				return loadWorkflowDefinition(viper)
+			}
+
+			It("should upgrade the syntax each version supports", func() {
+				definition, err := load("0.2", "      guard: ./ready.sh\n", "")
+				Expect(err).To(BeNil())
+				Expect(*definition.Flowit.StateMachines[0].Transitions[0].Guard).To(Equal("./ready.sh"))
+				Expect(*definition.Flowit.Workflows[0].Stages[0].Actions[0].Run).To(Equal("./start.sh"))
+			})
+
+			It("should not accept the syntax added in later versions", func() {
+				_, err := load("0.1", "      guard: ./ready.sh\n", "")
+				Expect(err).To(Not(BeNil()))
+				Expect(errors.Cause(err).Error()).To(ContainSubstring("guard"))
+
+				_, err = load("0.2", "", "    workdir: ./services\n")
+				Expect(err).To(Not(BeNil()))
+				Expect(errors.Cause(err).Error()).To(ContainSubstring("workdir"))
+			})
+
+		})
+
 		Context("Unmarshalling an invalid configuration", func() {
 
 			It("should return an informative error for incorrect types", func() {
@@ -25,6 +25,7 @@ func mainDefinitionFieldRules(mainDefinition *rawMainDefinition) []*validator.Fi
 		validator.Field(&mainDefinition.Workflows,
 			validator.Required,
 			validator.Each(validator.Required, validator.By(workflowValidator(mainDefinition.StateMachines))),
+			validator.By(workflowSpawnsValidator(mainDefinition.StateMachines)),
 		),
 	}
 }
@@ -43,7 +43,7 @@ var _ = Describe("Config", func() {
 
 				config.Flowit.StateMachines[0].ID = "simple-machine"
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3", "stage-4"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-4"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					// This means that from every stage except 'stage-4' going to every stage except 'stage-1' is allowed
@@ -56,10 +56,10 @@ var _ = Describe("Config", func() {
 				config.Flowit.Workflows[0].ID = "feature"
 				config.Flowit.Workflows[0].StateMachine = "simple-machine"
 				config.Flowit.Workflows[0].Stages = []Stage{
-					{ID: "stage-1", Actions: []string{"action-1"}},
-					{ID: "stage-2", Actions: []string{"action-2"}},
-					{ID: "stage-3", Actions: []string{"action-3"}},
-					{ID: "stage-4", Actions: []string{"action-4"}},
+					{ID: "stage-1", Actions: []Command{{Run: "action-1"}}},
+					{ID: "stage-2", Actions: []Command{{Run: "action-2"}}},
+					{ID: "stage-3", Actions: []Command{{Run: "action-3"}}},
+					{ID: "stage-4", Actions: []Command{{Run: "action-4"}}},
 				}
 
 				rawConfig := rawify(&config)
@@ -118,6 +118,109 @@ var _ = Describe("Config", func() {
 
 			})
 
+			It("should return a descriptive error for an unsupported repository type", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.Repository.Type = "mongo"
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Repository: (Type: must be one of: bolt, memory, json, sqlite.)"))
+
+			})
+
+			It("should return a descriptive error for an unsupported variable mode", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.VariableMode = "arguments"
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("VariableMode: must be one of: substitute, environment, positional."))
+
+			})
+
+			It("should return a descriptive error for invalid environment variables", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.Env = map[string]string{"GO FLAGS": "-v"}
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid environment variable name: GO FLAGS"))
+
+				config = validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Env = map[string]string{"flowit_stage": "start"}
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Environment variable: flowit_stage can not start with FLOWIT_"))
+
+				config = validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Env = map[string]string{"ISSUE": "$<my-var-1 | capitalize>"}
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid stage env: Invalid environment variable: ISSUE"))
+
+			})
+
+			It("should return a descriptive error for an allow list without a clean environment", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.AllowEnv = []string{"PATH"}
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("AllowEnv: requires clean-env."))
+
+				config.Flowit.Config.CleanEnv = true
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+
+				config.Flowit.Config.AllowEnv = []string{"PATH", "SSH AUTH SOCK"}
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid environment variable name: SSH AUTH SOCK"))
+
+			})
+
+			It("should return a descriptive error for an invalid working directory", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Workdir = "services/$<service"
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid stage workdir"))
+				Expect(err.Error()).To(ContainSubstring("Unterminated variable reference: $<service"))
+
+			})
+
+		})
+
+		Context("Validating retention", func() {
+
+			It("should return a descriptive error for a non positive retention", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.Retention.Days = 0
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Retention: (Days: must be no less than 1.)"))
+
+			})
+
+			It("should return a descriptive error for an empty audit file", func() {
+
+				config := validConfigWithOptionalFields()
+				config.Flowit.Config.Audit.File = ""
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Audit: (File: cannot be blank.)"))
+
+			})
+
 		})
 
 		Context("Validating variables", func() {
@@ -205,24 +308,59 @@ var _ = Describe("Config", func() {
 
 				rawConfig := rawify(&config)
 
-				rawConfig.Flowit.StateMachines[0].InitialStage = nil
+				rawConfig.Flowit.StateMachines[0].InitialStages = nil
 
 				err := validateWorkflowDefinition(rawConfig)
 				Expect(err).To(Not(BeNil()))
 				Expect(err.Error()).To(ContainSubstring("StateMachines:"))
-				Expect(err.Error()).To(ContainSubstring("InitialStage:"))
+				Expect(err.Error()).To(ContainSubstring("InitialStages:"))
 			})
 
 			It("should return a descriptive error for an invalid state-machine initial stage ID", func() {
 				config := validConfigWithOptionalFields()
-				config.Flowit.StateMachines[0].InitialStage = "initial-stage"
+				config.Flowit.StateMachines[0].InitialStages = []string{"initial-stage"}
 
 				rawConfig := rawify(&config)
 
 				err := validateWorkflowDefinition(rawConfig)
 				Expect(err).To(Not(BeNil()))
 				Expect(err.Error()).To(ContainSubstring("StateMachines:"))
-				Expect(err.Error()).To(ContainSubstring("InitialStage:"))
+				Expect(err.Error()).To(ContainSubstring("InitialStages:"))
+			})
+
+			It("should return a descriptive error for a stage that can not be reached from an initial stage", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.StateMachines[0].Stages = []string{"start", "triage", "finish"}
+				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
+					{From: []string{"start", "triage"}, To: []string{"finish"}},
+				}
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Cannot reach 'triage' stage from an initial stage"))
+
+				config.Flowit.StateMachines[0].InitialStages = []string{"start", "triage"}
+				rawConfig = rawify(&config)
+
+				err = validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).ToNot(ContainSubstring("StateMachines:"))
+			})
+
+			It("should allow an initial stage to follow another initial stage", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.StateMachines[0].Stages = []string{"start", "fix", "finish"}
+				config.Flowit.StateMachines[0].InitialStages = []string{"start", "fix"}
+				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
+					{From: []string{"start"}, To: []string{"fix"}},
+					{From: []string{"fix"}, To: []string{"finish"}},
+				}
+				rawConfig := rawify(&config)
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).ToNot(ContainSubstring("StateMachines:"))
 			})
 
 			It("should return a descriptive error for a nonexistent state-machine final stage ID", func() {
@@ -282,6 +420,28 @@ var _ = Describe("Config", func() {
 				Expect(err.Error()).To(ContainSubstring("Transitions:"))
 			})
 
+			It("should return a descriptive error for an empty state-machine transition guard", func() {
+				config := validConfigWithOptionalFields()
+				rawConfig := rawify(&config)
+				emptyGuard := ""
+				rawConfig.Flowit.StateMachines[0].Transitions[0].Guard = &emptyGuard
+
+				err := validateWorkflowDefinition(rawConfig)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Transitions:"))
+				Expect(err.Error()).To(ContainSubstring("Guard: cannot be blank."))
+			})
+
+			It("should return a descriptive error for a state-machine transition guard with an invalid expression", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.StateMachines[0].Transitions[0].Guard = "${{ previous.stage == }}"
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid expression: previous.stage =="))
+				Expect(err.Error()).To(ContainSubstring("Unexpected end of expression"))
+			})
+
 			It("should return a descriptive error for an invalid state-machine transition stage ID", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
@@ -302,7 +462,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -322,7 +482,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -350,7 +510,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -374,7 +534,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -402,7 +562,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -430,7 +590,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3", "stage-4"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -454,7 +614,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-3"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -474,7 +634,7 @@ var _ = Describe("Config", func() {
 			It("should return a descriptive error for an invalid state-machine", func() {
 				config := validConfigWithOptionalFields()
 				config.Flowit.StateMachines[0].Stages = []string{"stage-1", "stage-2", "stage-3", "stage-4"}
-				config.Flowit.StateMachines[0].InitialStage = "stage-1"
+				config.Flowit.StateMachines[0].InitialStages = []string{"stage-1"}
 				config.Flowit.StateMachines[0].FinalStages = []string{"stage-4"}
 				config.Flowit.StateMachines[0].Transitions = []StateMachineTransition{
 					{
@@ -578,9 +738,9 @@ var _ = Describe("Config", func() {
 					Args: []string{
 						"< my-var-without-description >",
 					},
-					Actions: []string{
-						"action1",
-						"action2",
+					Actions: []Command{
+						{Run: "action1"},
+						{Run: "action2"},
 					},
 				}
 				config.Flowit.Workflows[0] = firstWorkflow
@@ -613,6 +773,272 @@ var _ = Describe("Config", func() {
 
 		})
 
+		Context("Validating parallel groups", func() {
+
+			It("should return a descriptive error for a command which is both run and a parallel group", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Run: "lint", Parallel: []string{"test"}}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("A command can not be both run and a parallel group"))
+			})
+
+			It("should return a descriptive error for an invalid parallel group max-concurrency", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Parallel: []string{"lint", "test"}, MaxConcurrency: -1}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid parallel group max-concurrency"))
+			})
+
+			It("should return a descriptive error for a fail-fast command which is not a parallel group", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{{Run: "lint", FailFast: true}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Only parallel groups accept max-concurrency and fail-fast"))
+			})
+
+		})
+
+		Context("Validating conditional and looped commands", func() {
+
+			It("should return a descriptive error for a parallel group with foreach", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Parallel: []string{"lint", "test"}, Foreach: "services"}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Only single commands accept foreach"))
+			})
+
+			It("should return a descriptive error for a command with as but no foreach", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Run: "deploy $<service>", As: "service"}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Only commands with foreach accept as"))
+			})
+
+			It("should return a descriptive error for a command or when clause with an invalid expression", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{{Run: "${{ status =~ '[Open' }}"}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid regular expression at position 7"))
+
+				config = validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Run: "deploy", When: "${{ size(services) > 0 }}"}}
+
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Unknown function size at position 0"))
+			})
+
+			It("should return a descriptive error for a command with an invalid variable reference", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Parallel: []string{"lint", "git push origin $<branch | capitalize>"}},
+				}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Unknown filter: capitalize"))
+			})
+
+			It("should return a descriptive error for an expression inside a parallel group", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{{Parallel: []string{"lint", "${{ succeeds('test') }}"}}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Expressions can not be run in parallel"))
+			})
+
+			It("should accept a looped command with a when clause", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Run: "deploy $<service>", When: "test -n \"$<service>\"", Foreach: "services", As: "service"},
+				}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(BeNil())
+			})
+
+		})
+
+		Context("Validating confirmations and approval stages", func() {
+
+			It("should only accept confirmations on stages and actions", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Confirm = "Deploy $<my-var-1> to prod?"
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Run: "deploy", Confirm: "Deploy $<my-var-1>?"}}
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{{Run: "lint", Confirm: "Lint?"}}
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Only actions accept confirm"))
+
+				config = validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Confirm = "Deploy $<my-var-1 to prod?"
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid stage confirm"))
+			})
+
+			It("should accept approval stages without actions", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Type = ApprovalStage
+				config.Flowit.Workflows[0].Stages[0].Actions = nil
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+
+				config.Flowit.Workflows[0].Stages[0].Type = "review"
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid stage type: must be a valid value"))
+			})
+
+		})
+
+		Context("Validating git commands", func() {
+
+			It("should accept git actions and checks with the fields they require", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{
+					{Git: &GitCommand{Action: GitClean}},
+					{Git: &GitCommand{Action: GitUpToDate, Branch: "master", Remote: "upstream"}},
+				}
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Git: &GitCommand{Action: GitCreateBranch, Branch: "feature/$<my-var-1>", From: "master"}},
+					{Git: &GitCommand{Action: GitPush}, When: "test -n \"$<my-var-1>\""},
+					{Git: &GitCommand{Action: GitDeleteBranch, Branch: "$<item>"}, Foreach: "services"},
+				}
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+			})
+
+			It("should return a descriptive error for a git action in conditions", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Conditions = []Command{{Git: &GitCommand{Action: GitMerge, Into: "master"}}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Git action: merge can only be run as an action"))
+			})
+
+			It("should return a descriptive error for a git command with missing or unexpected fields", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Git: &GitCommand{Action: GitRebaseOnto, Branch: "feature/x"}},
+				}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Git action: rebase-onto requires onto"))
+
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Git: &GitCommand{Action: GitDeleteBranch, Branch: "feature/x", Remote: "origin"}},
+				}
+
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Git action: delete-branch does not accept remote"))
+
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Git: &GitCommand{Action: GitPush, Branch: "$<my-var-1"}}}
+
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid git command"))
+			})
+
+			It("should return a descriptive error for an invalid git command", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Git: &GitCommand{Action: "cherry-pick", Branch: "feature/x"}},
+				}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Invalid action"))
+
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{{Run: "git push", Git: &GitCommand{Action: GitPush}}}
+
+				err = validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("A git command can not be run nor a parallel group"))
+			})
+
+		})
+
+		Context("Validating spawned workflows", func() {
+
+			withDocsWorkflow := func(spawn Spawn) WorkflowDefinition {
+				config := validConfigWithOptionalFields()
+				docs := config.Flowit.Workflows[0]
+				docs.ID = "docs"
+				docs.Stages = append([]Stage{}, docs.Stages...)
+				config.Flowit.Workflows = append(config.Flowit.Workflows, docs)
+				config.Flowit.Workflows[0].Stages[1].Actions = nil
+				config.Flowit.Workflows[0].Stages[1].Spawn = []Spawn{spawn}
+				return config
+			}
+
+			It("should allow a stage without actions to spawn a workflow", func() {
+				config := withDocsWorkflow(Spawn{Workflow: "docs", Variables: map[string]string{"my-var-1": "$<my-var-1>"}})
+
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+			})
+
+			It("should return a descriptive error for a non existent spawned workflow", func() {
+				config := withDocsWorkflow(Spawn{Workflow: "changelog"})
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Spawned workflow 'changelog' does not exist"))
+			})
+
+			It("should return a descriptive error for a spawned workflow starting at a non initial stage", func() {
+				config := withDocsWorkflow(Spawn{Workflow: "docs", Stage: "finish"})
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("Spawned workflow 'docs' cannot start at 'finish'"))
+			})
+
+			It("should return a descriptive error for a workflow spawning itself", func() {
+				config := withDocsWorkflow(Spawn{Workflow: "docs"})
+				config.Flowit.Workflows[1].Stages[0].Spawn = []Spawn{{Workflow: "feature"}}
+
+				err := validateWorkflowDefinition(rawify(&config))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("cannot spawn itself"))
+			})
+
+		})
+
+		Context("Collecting warnings", func() {
+
+			It("should warn about variable references which values are not quoted", func() {
+				config := validConfigWithOptionalFields()
+				config.Flowit.Workflows[0].Stages[0].Actions = []Command{
+					{Run: "git checkout $<my-var-1>"},
+					{Run: "eval $<my-var-2 | raw>", When: "${{ my-var-2 != '' }}"},
+				}
+
+				Expect(validateWorkflowDefinition(rawify(&config))).To(Succeed())
+				warnings := collectWarnings(config.Flowit)
+				Expect(warnings).To(HaveLen(1))
+				Expect(warnings[0]).To(ContainSubstring(
+					"Variable reference: $<my-var-2 | raw> in workflow feature stage start command"))
+			})
+
+		})
+
 	})
 
 })
@@ -633,7 +1059,9 @@ func validConfigJustMandatoryFields() rawWorkflowDefinition {
 	stateMachineStages := []*string{
 		&startStageID, &finishStageID,
 	}
-	stateMachineInitialStage := startStageID
+	stateMachineInitialStages := []*string{
+		&startStageID,
+	}
 	stateMachineFinalStages := []*string{
 		&finishStageID,
 	}
@@ -650,7 +1078,7 @@ func validConfigJustMandatoryFields() rawWorkflowDefinition {
 
 	stateMachine.ID = &stateMachineID
 	stateMachine.Stages = stateMachineStages
-	stateMachine.InitialStage = &stateMachineInitialStage
+	stateMachine.InitialStages = stateMachineInitialStages
 	stateMachine.FinalStages = stateMachineFinalStages
 	stateMachine.Transitions = stateMachineTransitions
 
@@ -658,13 +1086,13 @@ func validConfigJustMandatoryFields() rawWorkflowDefinition {
 	startStageAction2 := "start action2"
 	startStage := rawStage{
 		ID:      &startStageID,
-		Actions: []*string{&startStageAction1, &startStageAction2},
+		Actions: []*rawCommand{{Run: &startStageAction1}, {Run: &startStageAction2}},
 	}
 	finishStageAction1 := "finish action1"
 	finishStageAction2 := "finish action2"
 	finishStage := rawStage{
 		ID:      &finishStageID,
-		Actions: []*string{&finishStageAction1, &finishStageAction2},
+		Actions: []*rawCommand{{Run: &finishStageAction1}, {Run: &finishStageAction2}},
 	}
 	workflowID := "feature"
 	workflowType := rawWorkflow{
@@ -698,6 +1126,19 @@ func validConfigWithOptionalFields() WorkflowDefinition {
 	flowit.Config = Config{
 		CheckpointExecution: true,
 		Shell:               "/usr/bin/env bash",
+		VariableMode:        PositionalVariables,
+		Repository: Repository{
+			Type:     BoltRepository,
+			Location: ".flowitDS",
+		},
+		Retention: Retention{
+			Days:    30,
+			Keep:    10,
+			Archive: ".flowit-archive",
+		},
+		Audit: Audit{
+			File: ".flowit-audit.jsonl",
+		},
 	}
 	flowit.Variables = map[string]interface{}{
 		"var1": "value",
@@ -710,8 +1151,8 @@ func validConfigWithOptionalFields() WorkflowDefinition {
 			Stages: []string{
 				"start", "finish",
 			},
-			InitialStage: "start",
-			FinalStages:  []string{"finish"},
+			InitialStages: []string{"start"},
+			FinalStages:   []string{"finish"},
 			Transitions: []StateMachineTransition{
 				{
 					From: []string{"start"},
@@ -728,18 +1169,18 @@ func validConfigWithOptionalFields() WorkflowDefinition {
 				{
 					ID:   "start",
 					Args: []string{"< my-var-1 | My-desc-1 >", "< my-var-2 | My-desc-2 >"},
-					Conditions: []string{
-						"start condition1",
+					Conditions: []Command{
+						{Run: "start condition1"},
 					},
-					Actions: []string{"start action1", "start action2"},
+					Actions: []Command{{Run: "start action1"}, {Run: "start action2"}},
 				},
 				{
 					ID:   "finish",
 					Args: []string{"< my-var-1 | My-desc-1 >", "< my-var-2 | My-desc-2 >"},
-					Conditions: []string{
-						"finish condition1",
+					Conditions: []Command{
+						{Run: "finish condition1"},
 					},
-					Actions: []string{"finish action1", "finish action2"},
+					Actions: []Command{{Run: "finish action1"}, {Run: "finish action2"}},
 				},
 			},
 		},
@@ -10,8 +10,7 @@ import (
 func versionValidator(version interface{}) error {
 	switch version := version.(type) {
 	case *string:
-		var supportedVersions = []string{"0.1"}
-		if found := utils.FindStringInArray(*version, supportedVersions); !found {
+		if found := utils.FindStringInArray(*version, supportedVersions()); !found {
 			return errors.New("Unsupported workflow definition version")
 		}
 	default:
@@ -17,7 +17,21 @@ func configValidator(config interface{}) error {
 		if config == nil {
 			return nil
 		}
-		return validator.Validate(config.Shell, validator.By(shellValidator))
+		return validator.ValidateStruct(config,
+			validator.Field(&config.Shell, validator.By(shellValidator)),
+			validator.Field(&config.VariableMode,
+				validator.NilOrNotEmpty,
+				validator.In(SubstituteVariables, EnvironmentVariables, PositionalVariables).
+					Error("must be one of: "+
+						strings.Join([]string{SubstituteVariables, EnvironmentVariables, PositionalVariables}, ", "))),
+			validator.Field(&config.Env, validator.By(validEnv)),
+			validator.Field(&config.AllowEnv,
+				validator.When(config.CleanEnv == nil || !*config.CleanEnv, validator.Empty.Error("requires clean-env")),
+				validator.Each(validator.Required, validator.By(validEnvName))),
+			validator.Field(&config.Repository, validator.By(repositoryValidator)),
+			validator.Field(&config.Retention, validator.By(retentionValidator)),
+			validator.Field(&config.Audit, validator.By(auditValidator)),
+		)
 	default:
 		return errors.New("Invalid config type. Got " + reflect.TypeOf(config).Name())
 	}
@@ -39,3 +53,56 @@ func shellValidator(shell interface{}) error {
 	}
 	return nil
 }
+
+func repositoryValidator(repository interface{}) error {
+	switch repository := repository.(type) {
+	case *rawRepository:
+		// repository section is optional
+		if repository == nil {
+			return nil
+		}
+		return validator.ValidateStruct(repository,
+			validator.Field(&repository.Type,
+				validator.NilOrNotEmpty,
+				validator.In(BoltRepository, MemoryRepository, JSONRepository, SQLiteRepository).
+					Error("must be one of: "+
+						strings.Join([]string{BoltRepository, MemoryRepository, JSONRepository, SQLiteRepository}, ", "))),
+			validator.Field(&repository.Location, validator.NilOrNotEmpty),
+		)
+	default:
+		return errors.New("Invalid config repository type. Got " + reflect.TypeOf(repository).Name())
+	}
+}
+
+func retentionValidator(retention interface{}) error {
+	switch retention := retention.(type) {
+	case *rawRetention:
+		// retention section is optional
+		if retention == nil {
+			return nil
+		}
+		return validator.ValidateStruct(retention,
+			// Min skips zero values, which are rejected by NilOrNotEmpty instead
+			validator.Field(&retention.Days, validator.NilOrNotEmpty.Error("must be no less than 1"), validator.Min(1)),
+			validator.Field(&retention.Keep, validator.NilOrNotEmpty.Error("must be no less than 1"), validator.Min(1)),
+			validator.Field(&retention.Archive, validator.NilOrNotEmpty),
+		)
+	default:
+		return errors.New("Invalid config retention type. Got " + reflect.TypeOf(retention).Name())
+	}
+}
+
+func auditValidator(audit interface{}) error {
+	switch audit := audit.(type) {
+	case *rawAudit:
+		// audit section is optional
+		if audit == nil {
+			return nil
+		}
+		return validator.ValidateStruct(audit,
+			validator.Field(&audit.File, validator.NilOrNotEmpty),
+		)
+	default:
+		return errors.New("Invalid config audit type. Got " + reflect.TypeOf(audit).Name())
+	}
+}
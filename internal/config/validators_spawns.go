@@ -0,0 +1,130 @@
+package config
+
+import (
+	"reflect"
+
+	validator "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/utils"
+)
+
+func stageSpawnValidator(spawn interface{}) error {
+	switch spawn := spawn.(type) {
+	case rawSpawn:
+		if err := validator.Validate(spawn.Workflow, validator.Required, validator.By(workflowIDValidator)); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := validator.Validate(spawn.Stage, validator.NilOrNotEmpty, validator.By(stageValidator)); err != nil {
+			return errors.WithStack(err)
+		}
+		for variable, value := range spawn.Variables {
+			if err := validIdentifier(variable); err != nil {
+				return errors.Wrap(err, "Invalid spawned workflow variable: "+variable)
+			}
+			if value == nil {
+				return errors.New("Spawned workflow variable " + variable + " value is nil")
+			}
+			if _, err := utils.ParseTemplate(*value); err != nil {
+				return errors.Wrap(err, "Invalid spawned workflow variable: "+variable)
+			}
+		}
+		return nil
+	default:
+		return errors.New("Invalid workflow stage spawn type. Got " + reflect.TypeOf(spawn).Name())
+	}
+}
+
+// workflowSpawnsValidator checks spawned workflows exist, start at one of their initial stages
+// and never spawn, directly or not, a workflow of the same kind as the one spawning them
+func workflowSpawnsValidator(stateMachines []*rawStateMachine) func(interface{}) error {
+	return func(workflows interface{}) error {
+		switch workflows := workflows.(type) {
+		case []*rawWorkflow:
+			spawned := make(map[string][]string)
+			for _, workflow := range workflows {
+				if workflow == nil || workflow.ID == nil {
+					continue
+				}
+				for _, stage := range workflow.Stages {
+					if stage == nil {
+						continue
+					}
+					for _, spawn := range stage.Spawn {
+						if spawn == nil || spawn.Workflow == nil {
+							continue
+						}
+						child := findRawWorkflow(workflows, *spawn.Workflow)
+						if child == nil {
+							return errors.New("Spawned workflow '" + *spawn.Workflow + "' does not exist")
+						}
+						if err := validateSpawnStage(spawn, child, stateMachines); err != nil {
+							return errors.WithStack(err)
+						}
+						spawned[*workflow.ID] = append(spawned[*workflow.ID], *spawn.Workflow)
+					}
+				}
+			}
+			for workflowID := range spawned {
+				if spawnsWorkflow(spawned, workflowID, workflowID, make(map[string]bool)) {
+					return errors.New("Workflow '" + workflowID + "' cannot spawn itself")
+				}
+			}
+			return nil
+		default:
+			return errors.New("Invalid workflows type. Got " + reflect.TypeOf(workflows).Name())
+		}
+	}
+}
+
+func findRawWorkflow(workflows []*rawWorkflow, workflowID string) *rawWorkflow {
+	for _, workflow := range workflows {
+		if workflow != nil && workflow.ID != nil && *workflow.ID == workflowID {
+			return workflow
+		}
+	}
+	return nil
+}
+
+func validateSpawnStage(spawn *rawSpawn, child *rawWorkflow, stateMachines []*rawStateMachine) error {
+	if child.StateMachine == nil {
+		return nil
+	}
+	var initialStages []string
+	for _, stateMachine := range stateMachines {
+		if stateMachine == nil || stateMachine.ID == nil || *stateMachine.ID != *child.StateMachine {
+			continue
+		}
+		for _, initialStage := range stateMachine.InitialStages {
+			if initialStage != nil {
+				initialStages = append(initialStages, *initialStage)
+			}
+		}
+	}
+	if spawn.Stage == nil {
+		if len(initialStages) != 1 {
+			return errors.New("Spawned workflow '" + *spawn.Workflow +
+				"' has several initial stages, the stage to start at must be specified")
+		}
+		return nil
+	}
+	for _, initialStage := range initialStages {
+		if initialStage == *spawn.Stage {
+			return nil
+		}
+	}
+	return errors.New("Spawned workflow '" + *spawn.Workflow + "' cannot start at '" + *spawn.Stage +
+		"' since it is not an initial stage")
+}
+
+func spawnsWorkflow(spawned map[string][]string, from, target string, visited map[string]bool) bool {
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+	for _, child := range spawned[from] {
+		if child == target || spawnsWorkflow(spawned, child, target, visited) {
+			return true
+		}
+	}
+	return false
+}
@@ -28,9 +28,10 @@ func stateMachineValidator(stateMachine interface{}) error {
 			validator.Field(&stateMachine.Stages,
 				validator.Required,
 				validator.Each(validator.By(stateMachineStageValidator))),
-			validator.Field(&stateMachine.InitialStage,
+			validator.Field(&stateMachine.InitialStages,
 				validator.Required,
-				validator.NewStringRule(isStateMachineStageValid(stateMachine.Stages), "State Machine Initial Stage is invalid")),
+				validator.Each(
+					validator.NewStringRule(isStateMachineStageValid(stateMachine.Stages), "State Machine Initial Stage is invalid"))),
 			validator.Field(&stateMachine.FinalStages,
 				validator.Required,
 				validator.Each(
@@ -84,6 +85,7 @@ func stateMachineTransitionValidator(stateMachineStages []*string) func(interfac
 					validator.Each(
 						validator.NewStringRule(
 							isStateMachineStageValid(stateMachineStages), "State Machine Transition 'To' is invalid"))),
+				validator.Field(&parsedTransition.Guard, validator.NilOrNotEmpty, validator.By(validCommand)),
 			)
 		default:
 			return errors.New("Invalid state machine transition type. Got " + reflect.TypeOf(transition).Name())
@@ -91,7 +93,8 @@ func stateMachineTransitionValidator(stateMachineStages []*string) func(interfac
 	}
 }
 
-func parseStateMachineTransition(transition rawStateMachineTransition, stages []*string) (rawStateMachineTransition, error) {
+func parseStateMachineTransition(transition rawStateMachineTransition,
+	stages []*string) (rawStateMachineTransition, error) {
 	var result rawStateMachineTransition
 
 	from, err := parseTransitionStages(transition.From, stages)
@@ -105,6 +108,7 @@ func parseStateMachineTransition(transition rawStateMachineTransition, stages []
 		return rawStateMachineTransition{}, errors.WithStack(err)
 	}
 	result.To = to
+	result.Guard = transition.Guard
 
 	return result, nil
 }
@@ -146,8 +150,10 @@ func getAllOtherStages(transitionStage string, stages []*string) ([]*string, err
 
 func validateStateMachineGraph(sm rawStateMachine) error {
 	dg := buildDirectedGraph(sm)
-	if err := validateInitialStage(dg, *sm.InitialStage); err != nil {
-		return errors.WithStack(err)
+	for _, initialStage := range sm.InitialStages {
+		if err := validateInitialStage(dg, *initialStage); err != nil {
+			return errors.WithStack(err)
+		}
 	}
 	if err := validatePaths(dg, sm); err != nil {
 		return errors.WithStack(err)
@@ -158,13 +164,24 @@ func validateStateMachineGraph(sm rawStateMachine) error {
 	return nil
 }
 
+// validateInitialStage verifies that workflows never return to an initial stage once they started from it
+// Initial stages can still be the destination of transitions from the stages other initial stages lead to
 func validateInitialStage(dg graph.Directed, initialStage string) error {
-	if dg.To(generateNodeID(initialStage)).Len() > 0 {
-		return errors.New("Initial Stage '" + initialStage + "' cannot be the destination in a transition")
+	reachableStages := make(map[int64]bool)
+	for _, reachableNode := range getReachableNodes(dg, dg.Node(generateNodeID(initialStage))) {
+		reachableStages[reachableNode.ID()] = true
+	}
+	sources := dg.To(generateNodeID(initialStage))
+	for sources.Next() {
+		if reachableStages[sources.Node().ID()] {
+			return errors.New("Initial Stage '" + initialStage +
+				"' cannot be the destination in a transition from a stage it leads to")
+		}
 	}
 	return nil
 }
 
+// validatePaths verifies that every stage can be reached from an initial stage and can reach a final stage
 func validatePaths(dg graph.Directed, sm rawStateMachine) error {
 	for _, stage := range sm.Stages {
 		if found := utils.FindStringInPtrArray(*stage, sm.FinalStages); found {
@@ -184,6 +201,18 @@ func validatePaths(dg graph.Directed, sm rawStateMachine) error {
 		}
 
 	}
+
+	reachableStages := make(map[int64]bool)
+	for _, initialStage := range sm.InitialStages {
+		for _, reachableNode := range getReachableNodes(dg, dg.Node(generateNodeID(*initialStage))) {
+			reachableStages[reachableNode.ID()] = true
+		}
+	}
+	for _, stage := range sm.Stages {
+		if !reachableStages[generateNodeID(*stage)] {
+			return errors.New("Cannot reach '" + *stage + "' stage from an initial stage")
+		}
+	}
 	return nil
 }
 
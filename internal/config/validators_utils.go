@@ -3,10 +3,13 @@ package config
 import (
 	"reflect"
 	"regexp"
+	"strings"
 
 	validator "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/expression"
+	"github.com/yamil-rivera/flowit/internal/utils"
 )
 
 // TODO: This needs more thought
@@ -24,6 +27,89 @@ func validIdentifier(identifier interface{}) error {
 	}
 }
 
+// validCommand parses the value, either an expression or a shell command with variable references,
+// so that syntax errors are reported when the configuration is loaded
+func validCommand(value interface{}) error {
+	switch value := value.(type) {
+	case *string:
+		if value == nil {
+			return nil
+		}
+		return validCommand(*value)
+	case string:
+		if expression.IsExpression(value) {
+			_, err := expression.Parse(value)
+			return errors.WithStack(err)
+		}
+		_, err := utils.ParseTemplate(value)
+		return errors.WithStack(err)
+	default:
+		return errors.New("Invalid command type. Got " + reflect.TypeOf(value).Name())
+	}
+}
+
+// validEnv checks the names of the environment variables of an env section and parses their values
+func validEnv(env interface{}) error {
+	switch env := env.(type) {
+	case map[string]*string:
+		names := make(map[string]bool, len(env))
+		for name, value := range env {
+			if !isEnvName(name) {
+				return errors.New("Invalid environment variable name: " + name)
+			}
+			// Names are exported uppercased
+			if names[strings.ToUpper(name)] {
+				return errors.New("Environment variable: " + strings.ToUpper(name) + " is defined more than once")
+			}
+			names[strings.ToUpper(name)] = true
+			if strings.HasPrefix(strings.ToUpper(name), ContextEnvPrefix) {
+				return errors.New("Environment variable: " + name + " can not start with " + ContextEnvPrefix +
+					" since those names are reserved for the workflow context")
+			}
+			if value == nil {
+				return errors.New("Environment variable " + name + " value is nil")
+			}
+			if _, err := utils.ParseTemplate(*value); err != nil {
+				return errors.Wrap(err, "Invalid environment variable: "+name)
+			}
+		}
+		return nil
+	default:
+		return errors.New("Invalid env type. Got " + reflect.TypeOf(env).Name())
+	}
+}
+
+func validEnvName(name interface{}) error {
+	switch name := name.(type) {
+	case *string:
+		if name == nil {
+			return nil
+		}
+		return validEnvName(*name)
+	case string:
+		if !isEnvName(name) {
+			return errors.New("Invalid environment variable name: " + name)
+		}
+		return nil
+	default:
+		return errors.New("Invalid environment variable name type. Got " + reflect.TypeOf(name).Name())
+	}
+}
+
+// validTemplate parses the variable references of a value which is not a command, like a working directory
+func validTemplate(value interface{}) error {
+	switch value := value.(type) {
+	case *string:
+		if value == nil {
+			return nil
+		}
+		_, err := utils.ParseTemplate(*value)
+		return errors.WithStack(err)
+	default:
+		return errors.New("Invalid template type. Got " + reflect.TypeOf(value).Name())
+	}
+}
+
 func commonNamingRules() []validator.Rule {
 	return []validator.Rule{
 		is.PrintableASCII,
@@ -44,3 +130,13 @@ func doesNotContainVariables(str string) bool {
 	}
 	return true
 }
+
+// isEnvName returns whether the name is made of letters, digits and underscores and does not start with a digit
+func isEnvName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
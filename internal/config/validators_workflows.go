@@ -5,6 +5,7 @@ import (
 
 	validator "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/expression"
 	"github.com/yamil-rivera/flowit/internal/utils"
 )
 
@@ -30,6 +31,12 @@ func workflowValidator(stateMachines []*rawStateMachine) func(interface{}) error
 					"Workflow State Machine ID is not a valid state machine")); err != nil {
 				return errors.WithStack(err)
 			}
+			if err := validator.Validate(workflow.Env, validator.By(validEnv)); err != nil {
+				return errors.Wrap(err, "Invalid workflow env")
+			}
+			if err := validator.Validate(workflow.Workdir, validator.NilOrNotEmpty, validator.By(validTemplate)); err != nil {
+				return errors.Wrap(err, "Invalid workflow workdir")
+			}
 			if err := validator.Validate(workflow.Stages,
 				validator.Required,
 				validator.By(workflowStagesValidator(*workflow.StateMachine, stateMachines)),
@@ -117,15 +124,35 @@ func workflowStageValidator(stage interface{}) error {
 		if err := validator.Validate(stage.ID, validator.Required, validator.By(stageValidator)); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := validator.Validate(stage.Type, validator.NilOrNotEmpty, validator.In(ApprovalStage)); err != nil {
+			return errors.Wrap(err, "Invalid stage type")
+		}
 		if err := validator.Validate(stage.Args, validator.By(stageArgsValidator)); err != nil {
 			return errors.WithStack(err)
 		}
 		if err := validator.Validate(stage.Conditions, validator.By(stageConditionsValidator)); err != nil {
 			return errors.WithStack(err)
 		}
-		if err := validator.Validate(stage.Actions, validator.Required, validator.By(stageActionsValidator)); err != nil {
+		// Stages spawning child workflows and approval stages may have no actions of their own
+		isApproval := stage.Type != nil && *stage.Type == ApprovalStage
+		if err := validator.Validate(stage.Actions,
+			validator.When(len(stage.Spawn) == 0 && !isApproval, validator.Required),
+			validator.By(stageActionsValidator)); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := validator.Validate(stage.Spawn,
+			validator.Each(validator.Required, validator.By(stageSpawnValidator))); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := validator.Validate(stage.Env, validator.By(validEnv)); err != nil {
+			return errors.Wrap(err, "Invalid stage env")
+		}
+		if err := validator.Validate(stage.Workdir, validator.NilOrNotEmpty, validator.By(validTemplate)); err != nil {
+			return errors.Wrap(err, "Invalid stage workdir")
+		}
+		if err := validator.Validate(stage.Confirm, validator.NilOrNotEmpty, validator.By(validTemplate)); err != nil {
+			return errors.Wrap(err, "Invalid stage confirm")
+		}
 	default:
 		return errors.New("Invalid workflow stage type. Got " + reflect.TypeOf(stage).Name())
 	}
@@ -153,8 +180,9 @@ func stageArgsValidator(args interface{}) error {
 // TODO: We may need to validate our variable syntax
 func stageConditionsValidator(conditions interface{}) error {
 	switch conditions := conditions.(type) {
-	case []*string:
-		return nil
+	case []*rawCommand:
+		return validator.Validate(conditions,
+			validator.Each(validator.Required, validator.By(stageCommandValidator), validator.By(conditionValidator)))
 	default:
 		return errors.New("Invalid workflow stage conditions type. Got " + reflect.TypeOf(conditions).Name())
 	}
@@ -163,9 +191,149 @@ func stageConditionsValidator(conditions interface{}) error {
 // TODO: We may need to validate our variable syntax
 func stageActionsValidator(actions interface{}) error {
 	switch actions := actions.(type) {
-	case []*string:
-		return nil
+	case []*rawCommand:
+		return validator.Validate(actions, validator.Each(validator.Required, validator.By(stageCommandValidator)))
 	default:
 		return errors.New("Invalid workflow stage actions type. Got " + reflect.TypeOf(actions).Name())
 	}
 }
+
+// conditionValidator rejects the command settings which only make sense for actions
+func conditionValidator(command interface{}) error {
+	switch command := command.(type) {
+	case rawCommand:
+		if command.Confirm != nil {
+			return errors.New("Only actions accept confirm")
+		}
+		if command.Git != nil && command.Git.Action != nil && !utils.FindStringInArray(*command.Git.Action, gitChecks()) {
+			return errors.New("Git action: " + *command.Git.Action + " can only be run as an action")
+		}
+		return nil
+	default:
+		return errors.New("Invalid workflow stage condition type. Got " + reflect.TypeOf(command).Name())
+	}
+}
+
+func stageCommandValidator(command interface{}) error {
+	switch command := command.(type) {
+	case rawCommand:
+		if err := validator.Validate(command.When, validator.NilOrNotEmpty, validator.By(validCommand)); err != nil {
+			return errors.Wrap(err, "Invalid command when")
+		}
+		if err := validator.Validate(command.Confirm, validator.NilOrNotEmpty, validator.By(validTemplate)); err != nil {
+			return errors.Wrap(err, "Invalid command confirm")
+		}
+		if command.Foreach != nil {
+			if command.Run == nil && command.Git == nil {
+				return errors.New("Only single commands accept foreach")
+			}
+			if err := validator.Validate(*command.Foreach, validator.Required, validator.By(validIdentifier)); err != nil {
+				return errors.Wrap(err, "Invalid command foreach variable")
+			}
+		}
+		if command.As != nil {
+			if command.Foreach == nil {
+				return errors.New("Only commands with foreach accept as")
+			}
+			if err := validator.Validate(*command.As, validator.Required, validator.By(validIdentifier)); err != nil {
+				return errors.Wrap(err, "Invalid command foreach item variable")
+			}
+		}
+		if command.Parallel == nil && (command.MaxConcurrency != nil || command.FailFast != nil) {
+			return errors.New("Only parallel groups accept max-concurrency and fail-fast")
+		}
+		if command.Git != nil {
+			if command.Run != nil || command.Parallel != nil {
+				return errors.New("A git command can not be run nor a parallel group")
+			}
+			return errors.Wrap(gitCommandValidator(*command.Git), "Invalid git command")
+		}
+		if command.Parallel == nil {
+			return validator.Validate(command.Run, validator.Required, validator.By(validCommand))
+		}
+		if command.Run != nil {
+			return errors.New("A command can not be both run and a parallel group")
+		}
+		if err := validator.Validate(command.Parallel,
+			validator.Required,
+			validator.Each(validator.Required, validator.NewStringRule(isCommand, "Expressions can not be run in parallel"),
+				validator.By(validCommand))); err != nil {
+			return errors.Wrap(err, "Invalid parallel group")
+		}
+		if err := validator.Validate(command.MaxConcurrency, validator.Min(1)); err != nil {
+			return errors.Wrap(err, "Invalid parallel group max-concurrency")
+		}
+		return nil
+	default:
+		return errors.New("Invalid workflow stage command type. Got " + reflect.TypeOf(command).Name())
+	}
+}
+
+// gitCommandFields returns the fields every git action and check requires and the ones it also accepts
+func gitCommandFields() map[string]struct{ required, optional []string } {
+	return map[string]struct{ required, optional []string }{
+		GitCreateBranch: {[]string{"branch"}, []string{"from"}},
+		GitRebaseOnto:   {[]string{"onto"}, []string{"branch", "remote"}},
+		GitMerge:        {[]string{"into"}, []string{"branch", "remote"}},
+		GitDeleteBranch: {[]string{"branch"}, nil},
+		GitPush:         {nil, []string{"branch", "remote"}},
+		GitBranchExists: {[]string{"branch"}, nil},
+		GitClean:        {nil, nil},
+		GitUpToDate:     {nil, []string{"branch", "remote"}},
+	}
+}
+
+func gitActions() []string {
+	return []string{GitCreateBranch, GitRebaseOnto, GitMerge, GitDeleteBranch, GitPush}
+}
+
+func gitChecks() []string {
+	return []string{GitBranchExists, GitClean, GitUpToDate}
+}
+
+func gitCommandValidator(command interface{}) error {
+	switch command := command.(type) {
+	case rawGitCommand:
+		var actions []interface{}
+		for _, action := range append(gitActions(), gitChecks()...) {
+			actions = append(actions, action)
+		}
+		if err := validator.Validate(command.Action, validator.Required, validator.In(actions...)); err != nil {
+			return errors.Wrap(err, "Invalid action")
+		}
+		fields := gitCommandFields()[*command.Action]
+		values := []struct {
+			name  string
+			value *string
+		}{
+			{"branch", command.Branch},
+			{"from", command.From},
+			{"onto", command.Onto},
+			{"into", command.Into},
+			{"remote", command.Remote},
+		}
+		for _, field := range values {
+			required := utils.FindStringInArray(field.name, fields.required)
+			if field.value == nil {
+				if required {
+					return errors.New("Git action: " + *command.Action + " requires " + field.name)
+				}
+				continue
+			}
+			if !required && !utils.FindStringInArray(field.name, fields.optional) {
+				return errors.New("Git action: " + *command.Action + " does not accept " + field.name)
+			}
+			if err := validator.Validate(field.value, validator.Required, validator.By(validTemplate)); err != nil {
+				return errors.Wrap(err, "Invalid "+field.name)
+			}
+		}
+		return nil
+	default:
+		return errors.New("Invalid git command type. Got " + reflect.TypeOf(command).Name())
+	}
+}
+
+// isCommand returns whether the value is a shell command rather than an expression
+func isCommand(value string) bool {
+	return !expression.IsExpression(value)
+}
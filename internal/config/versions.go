@@ -0,0 +1,284 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// definitionVersion describes how a workflow definition written in a specific version
+// is unmarshalled and how it is converted into the version that follows it
+type definitionVersion struct {
+	version string
+	// unmarshall decodes a definition written in this version into its raw model
+	unmarshall func(v *viper.Viper) (interface{}, error)
+	// upgrade converts the raw model of this version into the raw model of the next version
+	upgrade func(definition interface{}) (interface{}, error)
+	// migrate rewrites a YAML document of this version into a YAML document of the next version
+	migrate func(document *yaml.Node) error
+}
+
+// definitionVersions returns all supported workflow definition versions sorted from oldest to latest
+// The latest version has no upgrade nor migrate functions since its raw model is the one being validated
+func definitionVersions() []definitionVersion {
+	return []definitionVersion{
+		{
+			version:    "0.1",
+			unmarshall: unmarshallWorkflowDefinitionV01,
+			upgrade:    upgradeWorkflowDefinitionV01,
+			migrate:    migrateWorkflowDefinitionV01,
+		},
+		{
+			version:    "0.2",
+			unmarshall: unmarshallWorkflowDefinitionV02,
+			upgrade:    upgradeWorkflowDefinitionV02,
+			migrate:    migrateWorkflowDefinitionV02,
+		},
+		{
+			version: "0.3",
+			unmarshall: func(v *viper.Viper) (interface{}, error) {
+				return unmarshallWorkflowDefinition(v)
+			},
+		},
+	}
+}
+
+func supportedVersions() []string {
+	versions := definitionVersions()
+	supported := make([]string, len(versions))
+	for i, version := range versions {
+		supported[i] = version.version
+	}
+	return supported
+}
+
+// LatestVersion returns the newest workflow definition version supported
+func LatestVersion() string {
+	versions := definitionVersions()
+	return versions[len(versions)-1].version
+}
+
+func definitionVersionIndex(version string) (int, bool) {
+	for i, definitionVersion := range definitionVersions() {
+		if definitionVersion.version == version {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// loadWorkflowDefinition unmarshalls a workflow definition using the raw model of the version it declares
+// and upgrades it to the latest raw model
+// Definitions declaring an unknown version are unmarshalled with the latest raw model so that
+// the version validator can report the error
+func loadWorkflowDefinition(v *viper.Viper) (*rawWorkflowDefinition, error) {
+	index, found := definitionVersionIndex(v.GetString("flowit.version"))
+	if !found {
+		return unmarshallWorkflowDefinition(v)
+	}
+
+	versions := definitionVersions()
+	definition, err := versions[index].unmarshall(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, version := range versions[index : len(versions)-1] {
+		if definition, err = version.upgrade(definition); err != nil {
+			return nil, errors.Wrap(err, "Error upgrading workflow definition from version "+version.version)
+		}
+	}
+
+	latestDefinition, ok := definition.(*rawWorkflowDefinition)
+	if !ok {
+		return nil, errors.New("Workflow definition could not be upgraded to version " + LatestVersion())
+	}
+	return latestDefinition, nil
+}
+
+func upgradeWorkflowDefinitionV01(definition interface{}) (interface{}, error) {
+	v01, ok := definition.(*rawWorkflowDefinitionV01)
+	if !ok {
+		return nil, errors.New("Invalid 0.1 workflow definition")
+	}
+	if v01.Flowit == nil {
+		return &rawWorkflowDefinitionV02{}, nil
+	}
+	var config *rawConfigV02
+	if v01.Flowit.Config != nil {
+		config = &rawConfigV02{
+			Checkpoints: v01.Flowit.Config.Checkpoints,
+			Shell:       v01.Flowit.Config.Shell,
+		}
+	}
+	var stateMachines []*rawStateMachineV02 // nolint:prealloc
+	for _, stateMachine := range v01.Flowit.StateMachines {
+		if stateMachine == nil {
+			stateMachines = append(stateMachines, nil)
+			continue
+		}
+		var transitions []*rawStateMachineTransitionV02 // nolint:prealloc
+		for _, transition := range stateMachine.Transitions {
+			if transition == nil {
+				transitions = append(transitions, nil)
+				continue
+			}
+			transitions = append(transitions, &rawStateMachineTransitionV02{From: transition.From, To: transition.To})
+		}
+		stateMachines = append(stateMachines, &rawStateMachineV02{
+			ID:           stateMachine.ID,
+			Stages:       stateMachine.Stages,
+			InitialStage: stateMachine.InitialStage,
+			FinalStages:  stateMachine.FinalStages,
+			Transitions:  transitions,
+		})
+	}
+	return &rawWorkflowDefinitionV02{
+		Flowit: &rawMainDefinitionV02{
+			// The declared version is kept so workflows can record the version they were created with
+			Version:       v01.Flowit.Version,
+			Config:        config,
+			Variables:     v01.Flowit.Variables,
+			StateMachines: stateMachines,
+			Workflows:     v01.Flowit.Workflows,
+		},
+	}, nil
+}
+
+func migrateWorkflowDefinitionV01(document *yaml.Node) error {
+	return setDocumentVersion(document, "0.2")
+}
+
+func upgradeWorkflowDefinitionV02(definition interface{}) (interface{}, error) {
+	v02, ok := definition.(*rawWorkflowDefinitionV02)
+	if !ok {
+		return nil, errors.New("Invalid 0.2 workflow definition")
+	}
+	if v02.Flowit == nil {
+		return &rawWorkflowDefinition{}, nil
+	}
+	var config *rawConfig
+	if v02.Flowit.Config != nil {
+		config = &rawConfig{
+			Checkpoints: v02.Flowit.Config.Checkpoints,
+			Shell:       v02.Flowit.Config.Shell,
+			Repository:  v02.Flowit.Config.Repository,
+			Retention:   v02.Flowit.Config.Retention,
+			Audit:       v02.Flowit.Config.Audit,
+		}
+	}
+	return &rawWorkflowDefinition{
+		Flowit: &rawMainDefinition{
+			Version:       v02.Flowit.Version,
+			Config:        config,
+			Variables:     v02.Flowit.Variables,
+			StateMachines: upgradeStateMachinesV02(v02.Flowit.StateMachines),
+			Workflows:     upgradeWorkflowsV02(v02.Flowit.Workflows),
+		},
+	}, nil
+}
+
+// upgradeStateMachinesV02 turns the initial stage of 0.2 state machines into their only initial stage
+func upgradeStateMachinesV02(v02StateMachines []*rawStateMachineV02) []*rawStateMachine {
+	var stateMachines []*rawStateMachine // nolint:prealloc
+	for _, stateMachine := range v02StateMachines {
+		if stateMachine == nil {
+			stateMachines = append(stateMachines, nil)
+			continue
+		}
+		var initialStages []*string
+		if stateMachine.InitialStage != nil {
+			initialStages = []*string{stateMachine.InitialStage}
+		}
+		var transitions []*rawStateMachineTransition // nolint:prealloc
+		for _, transition := range stateMachine.Transitions {
+			if transition == nil {
+				transitions = append(transitions, nil)
+				continue
+			}
+			transitions = append(transitions, &rawStateMachineTransition{
+				From:  transition.From,
+				To:    transition.To,
+				Guard: transition.Guard,
+			})
+		}
+		stateMachines = append(stateMachines, &rawStateMachine{
+			ID:            stateMachine.ID,
+			Stages:        stateMachine.Stages,
+			InitialStages: initialStages,
+			FinalStages:   stateMachine.FinalStages,
+			Transitions:   transitions,
+		})
+	}
+	return stateMachines
+}
+
+// upgradeWorkflowsV02 turns the plain string commands of the stages of 0.2 workflows into single commands
+func upgradeWorkflowsV02(v02Workflows []*rawWorkflowV02) []*rawWorkflow {
+	var workflows []*rawWorkflow // nolint:prealloc
+	for _, workflow := range v02Workflows {
+		if workflow == nil {
+			workflows = append(workflows, nil)
+			continue
+		}
+		var stages []*rawStage // nolint:prealloc
+		for _, stage := range workflow.Stages {
+			if stage == nil {
+				stages = append(stages, nil)
+				continue
+			}
+			stages = append(stages, &rawStage{
+				ID:         stage.ID,
+				Args:       stage.Args,
+				Conditions: upgradeCommandsV02(stage.Conditions),
+				Actions:    upgradeCommandsV02(stage.Actions),
+			})
+		}
+		workflows = append(workflows, &rawWorkflow{
+			ID:           workflow.ID,
+			StateMachine: workflow.StateMachine,
+			Stages:       stages,
+		})
+	}
+	return workflows
+}
+
+// upgradeCommandsV02 turns the plain string commands of a 0.2 stage into single commands
+func upgradeCommandsV02(commands []*string) []*rawCommand {
+	var upgraded []*rawCommand // nolint:prealloc
+	for _, command := range commands {
+		if command == nil {
+			upgraded = append(upgraded, nil)
+			continue
+		}
+		upgraded = append(upgraded, &rawCommand{Run: command})
+	}
+	return upgraded
+}
+
+// migrateWorkflowDefinitionV02 replaces the single 'initial-stage' of every state machine with an 'initial-stages' list
+func migrateWorkflowDefinitionV02(document *yaml.Node) error {
+	mainDefinition, err := mainDefinitionNode(document)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if stateMachines := mappingValue(mainDefinition, "state-machines"); stateMachines != nil {
+		for _, stateMachine := range stateMachines.Content {
+			if stateMachine.Kind != yaml.MappingNode {
+				continue
+			}
+			for i := 0; i+1 < len(stateMachine.Content); i += 2 {
+				if stateMachine.Content[i].Value != "initial-stage" {
+					continue
+				}
+				stateMachine.Content[i].Value = "initial-stages"
+				stateMachine.Content[i+1] = &yaml.Node{
+					Kind:    yaml.SequenceNode,
+					Tag:     "!!seq",
+					Style:   yaml.FlowStyle,
+					Content: []*yaml.Node{stateMachine.Content[i+1]},
+				}
+			}
+		}
+	}
+	return setDocumentVersion(document, "0.3")
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"github.com/yamil-rivera/flowit/internal/expression"
+	"github.com/yamil-rivera/flowit/internal/utils"
+)
+
+// collectWarnings returns the warnings of a valid workflow definition
+// Variable references filtered with raw are not quoted, so their values can inject shell code into the commands
+func collectWarnings(definition Flowit) []string {
+	var warnings []string
+	check := func(command, location string) {
+		if command == "" || expression.IsExpression(command) {
+			return
+		}
+		// The definition is valid, so its commands parse
+		template, _ := utils.ParseTemplate(command)
+		for _, reference := range template.RawReferences() {
+			warnings = append(warnings, "Variable reference: "+reference+" in "+location+" is not quoted, "+
+				"so its value is run as shell code. Remove the raw filter unless the value is trusted")
+		}
+	}
+	for _, stateMachine := range definition.StateMachines {
+		for _, transition := range stateMachine.Transitions {
+			check(transition.Guard, "state machine "+stateMachine.ID+" guard: "+transition.Guard)
+		}
+	}
+	for _, workflow := range definition.Workflows {
+		for _, stage := range workflow.Stages {
+			location := "workflow " + workflow.ID + " stage " + stage.ID
+			for _, command := range append(append([]Command{}, stage.Conditions...), stage.Actions...) {
+				check(command.Run, location+" command: "+command.Run)
+				check(command.When, location+" when: "+command.When)
+				for _, member := range command.Parallel {
+					check(member, location+" command: "+member)
+				}
+			}
+		}
+	}
+	return warnings
+}
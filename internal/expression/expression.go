@@ -0,0 +1,333 @@
+// Package expression implements the expression language conditions, when clauses and guards can be written in
+// instead of shell commands
+package expression
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	openingDelimiter = "${{"
+	closingDelimiter = "}}"
+)
+
+// Expression is a parsed expression ready to be evaluated any number of times
+type Expression struct {
+	source string
+	root   node
+}
+
+// Environment holds everything an expression can refer to when it is evaluated
+type Environment struct {
+	// Variables are referenced by their name or through vars
+	Variables map[string]interface{}
+	// Workflow holds the workflow instance metadata, referenced through workflow
+	Workflow map[string]interface{}
+	// Previous holds the metadata of the previous execution, referenced through previous. It is nil if there is none
+	Previous map[string]interface{}
+	// Outputs are the outputs of the commands already run in the current execution, referenced through outputs
+	Outputs []string
+	// Run runs a shell command returning its output. It backs the output and succeeds functions
+	Run func(command string) (string, error)
+}
+
+// node is a node of the expression syntax tree
+type node interface {
+	evaluate(env Environment) (interface{}, error)
+}
+
+// IsExpression returns whether or not the value is written as an expression, ${{ expression }},
+// rather than as a shell command
+func IsExpression(value string) bool {
+	_, ok := Extract(value)
+	return ok
+}
+
+// Extract returns the expression the value holds if the value is written as ${{ expression }}
+func Extract(value string) (string, bool) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, openingDelimiter) || !strings.HasSuffix(trimmed, closingDelimiter) {
+		return "", false
+	}
+	return strings.TrimSpace(trimmed[len(openingDelimiter) : len(trimmed)-len(closingDelimiter)]), true
+}
+
+// Parse parses the expression a value written as ${{ expression }} holds
+// The returned error points at the position of the expression where parsing failed
+func Parse(value string) (*Expression, error) {
+	source, ok := Extract(value)
+	if !ok {
+		return nil, errors.New("Expressions must be written as " + openingDelimiter + " expression " + closingDelimiter)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid expression: "+source)
+	}
+	p := newParser(tokens)
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = unexpected(p.peek(), "expected the end of the expression")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid expression: "+source)
+	}
+	return &Expression{source, root}, nil
+}
+
+// String returns the expression as it was written, without its delimiters
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate returns the value of the expression in the provided environment
+func (e *Expression) Evaluate(env Environment) (interface{}, error) {
+	value, err := e.root.evaluate(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error evaluating expression: "+e.source)
+	}
+	return value, nil
+}
+
+// EvaluateBool returns the value of an expression which must evaluate to a boolean
+func (e *Expression) EvaluateBool(env Environment) (bool, error) {
+	value, err := e.Evaluate(env)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	result, ok := toBool(value)
+	if !ok {
+		return false, errors.Errorf("Expression: %s evaluates to %s instead of a boolean", e.source, describe(value))
+	}
+	return result, nil
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) evaluate(env Environment) (interface{}, error) {
+	return n.value, nil
+}
+
+// reference is a variable or one of the names the environment provides
+// The names the environment provides take precedence, so variables with those names are only reachable through vars
+type reference struct {
+	name string
+}
+
+func (n reference) evaluate(env Environment) (interface{}, error) {
+	switch n.name {
+	case "vars":
+		return normalize(env.Variables), nil
+	case "workflow":
+		return normalize(env.Workflow), nil
+	case "previous":
+		if env.Previous == nil {
+			return nil, nil
+		}
+		return normalize(env.Previous), nil
+	case "outputs":
+		return normalize(env.Outputs), nil
+	}
+	value, ok := env.Variables[n.name]
+	if !ok {
+		return nil, errors.New("Variable: " + n.name + " is not defined")
+	}
+	return normalize(value), nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n list) evaluate(env Environment) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// index is a member access or an indexing. Indexing null, or a map with a missing key, evaluates to null
+type index struct {
+	target node
+	key    node
+}
+
+func (n index) evaluate(env Environment) (interface{}, error) {
+	target, err := n.target.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	switch target := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		name, ok := key.(string)
+		if !ok {
+			return nil, errors.Errorf("Maps can not be indexed with %s", describe(key))
+		}
+		return target[name], nil
+	case []interface{}:
+		position, ok := toNumber(key)
+		if !ok || position != float64(int(position)) {
+			return nil, errors.Errorf("Lists can not be indexed with %s", describe(key))
+		}
+		i := int(position)
+		if i < 0 {
+			i += len(target)
+		}
+		if i < 0 || i >= len(target) {
+			return nil, errors.Errorf("Index %d is out of range of a list of %d items", int(position), len(target))
+		}
+		return target[i], nil
+	default:
+		return nil, errors.Errorf("%s can not be indexed", describe(target))
+	}
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) evaluate(env Environment) (interface{}, error) {
+	value, err := n.operand.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := toBool(value)
+	if !ok {
+		return nil, errors.Errorf("Operand of ! is %s instead of a boolean", describe(value))
+	}
+	return !result, nil
+}
+
+// logical is a && or || operation. The right operand is only evaluated if the left one does not decide the result
+type logical struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (n logical) evaluate(env Environment) (interface{}, error) {
+	left, err := n.operand(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if (n.operator == "&&") != left {
+		return left, nil
+	}
+	return n.operand(n.right, env)
+}
+
+func (n logical) operand(operand node, env Environment) (bool, error) {
+	value, err := operand.evaluate(env)
+	if err != nil {
+		return false, err
+	}
+	result, ok := toBool(value)
+	if !ok {
+		return false, errors.Errorf("Operand of %s is %s instead of a boolean", n.operator, describe(value))
+	}
+	return result, nil
+}
+
+// comparison is a comparison between two values. pattern holds the regular expression of matches against literals
+type comparison struct {
+	operator string
+	left     node
+	right    node
+	pattern  *regexp.Regexp
+}
+
+func (n comparison) evaluate(env Environment) (interface{}, error) {
+	left, err := n.left.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "=~", "!~":
+		matched, err := n.match(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return matched == (n.operator == "=~"), nil
+	case "in":
+		return contains(right, left)
+	default:
+		order, err := compare(left, right)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid operands of "+n.operator)
+		}
+		switch n.operator {
+		case "<":
+			return order < 0, nil
+		case "<=":
+			return order <= 0, nil
+		case ">":
+			return order > 0, nil
+		default:
+			return order >= 0, nil
+		}
+	}
+}
+
+func (n comparison) match(value, pattern interface{}) (bool, error) {
+	text, ok := toText(value)
+	if !ok {
+		return false, errors.Errorf("Only strings can be matched against regular expressions. Got %s", describe(value))
+	}
+	expression := n.pattern
+	if expression == nil {
+		source, ok := pattern.(string)
+		if !ok {
+			return false, errors.Errorf("Regular expression is %s instead of a string", describe(pattern))
+		}
+		var err error
+		if expression, err = regexp.Compile(source); err != nil {
+			return false, errors.Wrap(err, "Invalid regular expression")
+		}
+	}
+	return expression.MatchString(text), nil
+}
+
+type call struct {
+	name       string
+	definition function
+	arguments  []node
+}
+
+func (n call) evaluate(env Environment) (interface{}, error) {
+	arguments := make([]interface{}, len(n.arguments))
+	for i, argument := range n.arguments {
+		value, err := argument.evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+		arguments[i] = value
+	}
+	result, err := n.definition.call(env, arguments)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error calling "+n.name)
+	}
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package expression_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/expression"
+)
+
+var _ = Describe("Expression", func() {
+
+	var ran []string
+	environment := func() expression.Environment {
+		return expression.Environment{
+			Variables: map[string]interface{}{
+				"jira-issue-id": "FLW-12",
+				"count":         "3",
+				"draft":         "false",
+				"services":      []interface{}{"api", "web", 2},
+			},
+			Workflow: map[string]interface{}{"name": "feature", "id": "1234"},
+			Previous: map[string]interface{}{"stage": "start", "failed": true, "outputs": []string{"one", "two"}},
+			Outputs:  []string{"checked"},
+			Run: func(command string) (string, error) {
+				ran = append(ran, command)
+				if command == "false" {
+					return "", errors.New("exit status 1")
+				}
+				return "In Progress\n", nil
+			},
+		}
+	}
+
+	evaluate := func(value string) bool {
+		parsed, err := expression.Parse(value)
+		Expect(err).To(BeNil())
+		result, err := parsed.EvaluateBool(environment())
+		Expect(err).To(BeNil())
+		return result
+	}
+
+	BeforeEach(func() {
+		ran = nil
+	})
+
+	Context("Extracting expressions", func() {
+
+		It("should only consider values written between the expression delimiters", func() {
+			source, ok := expression.Extract("  ${{ count > 2 }} ")
+			Expect(ok).To(BeTrue())
+			Expect(source).To(Equal("count > 2"))
+			Expect(expression.IsExpression("test -n \"$<count>\"")).To(BeFalse())
+			Expect(expression.IsExpression("echo ${HOME}")).To(BeFalse())
+		})
+
+	})
+
+	Context("Parsing expressions", func() {
+
+		It("should point at the position of syntax errors", func() {
+			_, err := expression.Parse("${{ count > }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unexpected end of expression"))
+
+			_, err = expression.Parse("${{ count == 1) }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unexpected \")\" at position 10"))
+
+			_, err = expression.Parse("${{ 'open }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unterminated string at position 0"))
+		})
+
+		It("should return a descriptive error for unknown functions and wrong numbers of arguments", func() {
+			_, err := expression.Parse("${{ size(services) > 1 }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unknown function size at position 0"))
+
+			_, err = expression.Parse("${{ contains(services) }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Function contains at position 0 expects 2 arguments but got 1"))
+		})
+
+		It("should return a descriptive error for invalid regular expressions", func() {
+			_, err := expression.Parse("${{ jira-issue-id =~ '[A-Z+' }}")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Invalid regular expression at position 14"))
+		})
+
+	})
+
+	Context("Evaluating expressions", func() {
+
+		It("should compare variables as numbers, booleans or strings", func() {
+			Expect(evaluate("${{ count > 2 && count <= 3 }}")).To(BeTrue())
+			Expect(evaluate("${{ count == 3 }}")).To(BeTrue())
+			Expect(evaluate("${{ draft == false }}")).To(BeTrue())
+			Expect(evaluate("${{ !draft }}")).To(BeTrue())
+			Expect(evaluate("${{ jira-issue-id != 'FLW-13' }}")).To(BeTrue())
+			Expect(evaluate("${{ 'b' > 'a' || count > 5 }}")).To(BeTrue())
+		})
+
+		It("should match regular expressions and apply string functions", func() {
+			Expect(evaluate(`${{ jira-issue-id =~ "^FLW-[0-9]+$" }}`)).To(BeTrue())
+			Expect(evaluate(`${{ jira-issue-id !~ "^ABC" }}`)).To(BeTrue())
+			Expect(evaluate(`${{ startsWith(lower(jira-issue-id), "flw") && endsWith(jira-issue-id, "12") }}`)).To(BeTrue())
+			Expect(evaluate(`${{ split(jira-issue-id, "-")[1] == 12 }}`)).To(BeTrue())
+			Expect(evaluate(`${{ len(services) == 3 && join(services, ",") == "api,web,2" }}`)).To(BeTrue())
+		})
+
+		It("should look values up in lists, maps and strings", func() {
+			Expect(evaluate(`${{ 'web' in services && 2 in services }}`)).To(BeTrue())
+			Expect(evaluate(`${{ 'count' in vars && vars['count'] == 3 }}`)).To(BeTrue())
+			Expect(evaluate(`${{ contains(jira-issue-id, 'W-1') }}`)).To(BeTrue())
+			Expect(evaluate(`${{ workflow.name in ['feature', 'hotfix'] }}`)).To(BeTrue())
+		})
+
+		It("should give access to the previous execution and the captured outputs", func() {
+			Expect(evaluate(`${{ previous.stage == 'start' && previous.failed }}`)).To(BeTrue())
+			Expect(evaluate(`${{ previous.outputs[-1] == 'two' && outputs[0] == 'checked' }}`)).To(BeTrue())
+
+			env := environment()
+			env.Previous = nil
+			parsed, err := expression.Parse(`${{ previous == null && previous.stage == null }}`)
+			Expect(err).To(BeNil())
+			Expect(parsed.EvaluateBool(env)).To(BeTrue())
+		})
+
+		It("should run commands to capture their output only when needed", func() {
+			Expect(evaluate(`${{ contains(output("jira view FLW-12"), "Progress") }}`)).To(BeTrue())
+			Expect(ran).To(Equal([]string{"jira view FLW-12"}))
+
+			ran = nil
+			Expect(evaluate(`${{ succeeds("false") || succeeds("true") }}`)).To(BeTrue())
+			Expect(ran).To(Equal([]string{"false", "true"}))
+
+			ran = nil
+			Expect(evaluate(`${{ count > 5 && succeeds("true") }}`)).To(BeFalse())
+			Expect(ran).To(BeEmpty())
+		})
+
+		It("should return a descriptive error for expressions which can not be evaluated", func() {
+			parsed, err := expression.Parse("${{ missing == 1 }}")
+			Expect(err).To(BeNil())
+			_, err = parsed.EvaluateBool(environment())
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Variable: missing is not defined"))
+
+			parsed, err = expression.Parse("${{ jira-issue-id }}")
+			Expect(err).To(BeNil())
+			_, err = parsed.EvaluateBool(environment())
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("evaluates to the string \"FLW-12\" instead of a boolean"))
+
+			parsed, err = expression.Parse("${{ services > 1 }}")
+			Expect(err).To(BeNil())
+			_, err = parsed.EvaluateBool(environment())
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("a list and the number 1 can not be ordered"))
+		})
+
+	})
+
+})
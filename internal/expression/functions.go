@@ -0,0 +1,121 @@
+package expression
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// function is a function expressions can call. Every function takes a fixed number of arguments
+type function struct {
+	arity int
+	call  func(env Environment, arguments []interface{}) (interface{}, error)
+}
+
+// newFunctions returns the functions expressions can call. output and succeeds run their argument as a shell command
+func newFunctions() map[string]function {
+	return map[string]function{
+		"contains": {2, func(env Environment, arguments []interface{}) (interface{}, error) {
+			found, err := contains(arguments[0], arguments[1])
+			return found, err
+		}},
+		"startsWith": {2, stringsFunction(func(s, prefix string) interface{} { return strings.HasPrefix(s, prefix) })},
+		"endsWith":   {2, stringsFunction(func(s, suffix string) interface{} { return strings.HasSuffix(s, suffix) })},
+		"split":      {2, stringsFunction(split)},
+		"lower":      {1, stringFunction(func(s string) interface{} { return strings.ToLower(s) })},
+		"upper":      {1, stringFunction(func(s string) interface{} { return strings.ToUpper(s) })},
+		"trim":       {1, stringFunction(func(s string) interface{} { return strings.TrimSpace(s) })},
+		"len":        {1, length},
+		"join":       {2, join},
+		"output": {1, command(func(out string, err error) (interface{}, error) {
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return strings.TrimSpace(out), nil
+		})},
+		"succeeds": {1, command(func(out string, err error) (interface{}, error) {
+			return err == nil, nil
+		})},
+	}
+}
+
+// stringFunction adapts a function of a single string
+func stringFunction(f func(string) interface{}) func(Environment, []interface{}) (interface{}, error) {
+	return func(env Environment, arguments []interface{}) (interface{}, error) {
+		s, ok := toText(arguments[0])
+		if !ok {
+			return nil, errors.Errorf("Expected a string but got %s", describe(arguments[0]))
+		}
+		return f(s), nil
+	}
+}
+
+// stringsFunction adapts a function of two strings
+func stringsFunction(f func(string, string) interface{}) func(Environment, []interface{}) (interface{}, error) {
+	return func(env Environment, arguments []interface{}) (interface{}, error) {
+		first, ok := toText(arguments[0])
+		if !ok {
+			return nil, errors.Errorf("Expected a string but got %s", describe(arguments[0]))
+		}
+		second, ok := toText(arguments[1])
+		if !ok {
+			return nil, errors.Errorf("Expected a string but got %s", describe(arguments[1]))
+		}
+		return f(first, second), nil
+	}
+}
+
+// command adapts a function of the outcome of running its argument as a shell command
+func command(f func(string, error) (interface{}, error)) func(Environment, []interface{}) (interface{}, error) {
+	return func(env Environment, arguments []interface{}) (interface{}, error) {
+		commandLine, ok := arguments[0].(string)
+		if !ok {
+			return nil, errors.Errorf("Expected a command but got %s", describe(arguments[0]))
+		}
+		if env.Run == nil {
+			return nil, errors.New("Commands can not be run here")
+		}
+		return f(env.Run(commandLine))
+	}
+}
+
+func split(s, separator string) interface{} {
+	var items []interface{}
+	for _, item := range strings.Split(s, separator) {
+		items = append(items, item)
+	}
+	return items
+}
+
+func length(env Environment, arguments []interface{}) (interface{}, error) {
+	switch value := arguments[0].(type) {
+	case string:
+		return float64(len([]rune(value))), nil
+	case []interface{}:
+		return float64(len(value)), nil
+	case map[string]interface{}:
+		return float64(len(value)), nil
+	case nil:
+		return float64(0), nil
+	default:
+		return nil, errors.Errorf("%s has no length", describe(value))
+	}
+}
+
+func join(env Environment, arguments []interface{}) (interface{}, error) {
+	items, ok := arguments[0].([]interface{})
+	if !ok {
+		return nil, errors.Errorf("Expected a list but got %s", describe(arguments[0]))
+	}
+	separator, ok := toText(arguments[1])
+	if !ok {
+		return nil, errors.Errorf("Expected a string but got %s", describe(arguments[1]))
+	}
+	texts := make([]string, len(items))
+	for i, item := range items {
+		if texts[i], ok = toText(item); !ok {
+			return nil, errors.Errorf("Only strings and numbers can be joined. Got %s", describe(item))
+		}
+	}
+	return strings.Join(texts, separator), nil
+}
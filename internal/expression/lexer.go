@@ -0,0 +1,121 @@
+package expression
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// tokenKind defines all the possible token kinds
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunctuation
+)
+
+// token is a lexical unit of an expression together with its position in the expression source
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+const punctuation = "()[].,"
+
+// tokenize splits the expression source into tokens
+// Identifiers may contain dashes so that every variable name can be referenced
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdentifier, string(runes[start:i]), start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, errors.Errorf("Invalid number %s at position %d", text, start)
+			}
+			tokens = append(tokens, token{tokenNumber, text, start})
+		case r == '"' || r == '\'':
+			value, end, err := readString(runes, i)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			tokens = append(tokens, token{tokenString, value, i})
+			i = end
+		case strings.ContainsRune(punctuation, r):
+			tokens = append(tokens, token{tokenPunctuation, string(r), i})
+			i++
+		default:
+			operator := matchOperator(runes[i:])
+			if operator == "" {
+				return nil, errors.Errorf("Unexpected character %q at position %d", r, i)
+			}
+			tokens = append(tokens, token{tokenOperator, operator, i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(runes)}), nil
+}
+
+// readString reads the string literal starting at the quote in position start
+// It returns the unquoted value and the position following the closing quote
+func readString(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	var value strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(runes) {
+				break
+			}
+			switch runes[i] {
+			case 'n':
+				value.WriteRune('\n')
+			case 't':
+				value.WriteRune('\t')
+			default:
+				value.WriteRune(runes[i])
+			}
+		default:
+			value.WriteRune(runes[i])
+		}
+	}
+	return "", 0, errors.Errorf("Unterminated string at position %d", start)
+}
+
+// matchOperator returns the operator the runes start with, if any
+func matchOperator(runes []rune) string {
+	// operators are sorted so that the longest operators are matched first
+	operators := []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!"}
+	for _, operator := range operators {
+		if strings.HasPrefix(string(runes), operator) {
+			return operator
+		}
+	}
+	return ""
+}
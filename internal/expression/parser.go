@@ -0,0 +1,243 @@
+package expression
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// parser builds the syntax tree of an expression using recursive descent
+// From lowest to highest precedence: ||, &&, comparisons, !, member access, indexing and calls
+type parser struct {
+	tokens              []token
+	current             int
+	comparisonOperators map[string]bool
+	functions           map[string]function
+}
+
+// newParser returns a parser of the tokens of an expression
+func newParser(tokens []token) *parser {
+	return &parser{
+		tokens: tokens,
+		comparisonOperators: map[string]bool{
+			"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "=~": true, "!~": true, "in": true,
+		},
+		functions: newFunctions(),
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.current]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.current]
+	if t.kind != tokenEOF {
+		p.current++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the provided operator, punctuation or keyword
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if t.kind != tokenString && t.kind != tokenEOF && t.text == text {
+		p.current++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return unexpected(p.peek(), "expected "+text)
+	}
+	return nil
+}
+
+func unexpected(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return errors.Errorf("Unexpected end of expression, %s", expected)
+	}
+	return errors.Errorf("Unexpected %s at position %d, %s", strconv.Quote(t.text), t.position, expected)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"||", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"&&", left, right}
+	}
+	return left, nil
+}
+
+// parseComparison parses a comparison. Comparisons can not be chained
+// Regular expressions written as string literals are compiled here so that invalid ones are reported when parsing
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind == tokenString || !p.comparisonOperators[t.text] {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	result := comparison{operator: t.text, left: left, right: right}
+	if pattern, ok := right.(literal); ok && (t.text == "=~" || t.text == "!~") {
+		source, isString := pattern.value.(string)
+		if !isString {
+			return nil, errors.Errorf("Regular expression at position %d is not a string", t.position)
+		}
+		if result.pattern, err = regexp.Compile(source); err != nil {
+			return nil, errors.Wrapf(err, "Invalid regular expression at position %d", t.position)
+		}
+	}
+	return result, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	target, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdentifier {
+				return nil, unexpected(t, "expected a field name")
+			}
+			target = index{target, literal{t.text}}
+		case p.accept("["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			target = index{target, key}
+		default:
+			return target, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{t.text}, nil
+	case tokenNumber:
+		number, _ := strconv.ParseFloat(t.text, 64)
+		return literal{number}, nil
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		return reference{t.text}, nil
+	case tokenPunctuation:
+		switch t.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return list{items}, nil
+		}
+	}
+	return nil, unexpected(t, "expected a value")
+}
+
+// parseCall parses the arguments of a call to the function named by t, which must exist and take as many arguments
+func (p *parser) parseCall(t token) (node, error) {
+	definition, ok := p.functions[t.text]
+	if !ok {
+		return nil, errors.Errorf("Unknown function %s at position %d", t.text, t.position)
+	}
+	arguments, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	if len(arguments) != definition.arity {
+		return nil, errors.Errorf("Function %s at position %d expects %d arguments but got %d",
+			t.text, t.position, definition.arity, len(arguments))
+	}
+	return call{t.text, definition, arguments}, nil
+}
+
+// parseList parses comma separated expressions until the closing punctuation
+func (p *parser) parseList(closing string) ([]node, error) {
+	var items []node
+	if p.accept(closing) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(closing) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
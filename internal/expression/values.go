@@ -0,0 +1,186 @@
+package expression
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// normalize converts a value into the types expressions work with:
+// strings, float64 numbers, booleans, nil, []interface{} lists and map[string]interface{} maps
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil, string, bool, float64:
+		return value
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case []string:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = item
+		}
+		return items
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = normalize(item)
+		}
+		return items
+	case map[string]interface{}:
+		entries := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			entries[key] = normalize(entry)
+		}
+		return entries
+	case map[interface{}]interface{}:
+		entries := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			entries[fmt.Sprint(key)] = normalize(entry)
+		}
+		return entries
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// toBool returns the boolean a value holds. Variables set by stage arguments are strings, so "true" and "false" count
+func toBool(value interface{}) (bool, bool) {
+	switch value := value.(type) {
+	case bool:
+		return value, true
+	case string:
+		result, err := strconv.ParseBool(value)
+		return result, err == nil
+	default:
+		return false, false
+	}
+}
+
+// toNumber returns the number a value holds. Strings count if they are numbers
+func toNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case string:
+		result, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return result, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toText returns the text a string or a number is written as
+func toText(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// describe returns the type of a value to be shown in errors
+func describe(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "the string " + strconv.Quote(value)
+	case float64:
+		text, _ := toText(value)
+		return "the number " + text
+	case bool:
+		return fmt.Sprint("the boolean ", value)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a map"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+// equal compares two values. Strings are compared to numbers and booleans by the value they hold
+func equal(left, right interface{}) bool {
+	switch left.(type) {
+	case float64:
+		if number, ok := toNumber(right); ok {
+			return left == number
+		}
+		return false
+	case bool:
+		if result, ok := toBool(right); ok {
+			return left == result
+		}
+		return false
+	case string:
+		switch right.(type) {
+		case float64, bool:
+			return equal(right, left)
+		}
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// compare orders two numbers, or two strings which are not both numbers
+func compare(left, right interface{}) (int, error) {
+	leftNumber, leftIsNumber := toNumber(left)
+	rightNumber, rightIsNumber := toNumber(right)
+	if leftIsNumber && rightIsNumber {
+		switch {
+		case leftNumber < rightNumber:
+			return -1, nil
+		case leftNumber > rightNumber:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+	leftText, leftIsString := left.(string)
+	rightText, rightIsString := right.(string)
+	if leftIsString && rightIsString {
+		return strings.Compare(leftText, rightText), nil
+	}
+	return 0, errors.Errorf("%s and %s can not be ordered", describe(left), describe(right))
+}
+
+// contains returns whether a list holds a value, a map holds a key or a string holds a substring
+func contains(container, value interface{}) (bool, error) {
+	switch container := container.(type) {
+	case []interface{}:
+		for _, item := range container {
+			if equal(item, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := container[key]
+		return found, nil
+	case string:
+		text, ok := toText(value)
+		if !ok {
+			return false, errors.Errorf("Strings can only contain strings. Got %s", describe(value))
+		}
+		return strings.Contains(container, text), nil
+	case nil:
+		return false, nil
+	default:
+		return false, errors.Errorf("%s can not contain values", describe(container))
+	}
+}
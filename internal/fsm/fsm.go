@@ -1,7 +1,8 @@
 package fsm
 
 import (
-	"github.com/looplab/fsm"
+	"strings"
+
 	"github.com/pkg/errors"
 	"github.com/yamil-rivera/flowit/internal/config"
 	"github.com/yamil-rivera/flowit/internal/utils"
@@ -16,24 +17,62 @@ type FsmServiceFactory interface {
 type ServiceFactory struct{}
 
 // Service exposes the methods to interact with the FSM service
+// It is immutable once created, so it can be queried concurrently
 type Service struct {
-	stateMachines map[string]*fsm.FSM
+	stateMachines map[string]machine
+}
+
+// GuardEvaluator defines the methods that must be implemented in order for a struct to evaluate transition guards
+// A guard passes if Evaluate returns nil. Otherwise the error explains why the transition is blocked
+type GuardEvaluator interface {
+	Evaluate(guard string) error
+}
+
+// AvailableState is a state that can be transitioned to from the current state
+// Blocked holds the reason the guards of the transition currently block it, if they do
+type AvailableState struct {
+	ID      string
+	Blocked string
+}
+
+// Transition is an allowed transition between two states together with its metadata
+// Guards are empty if the transition is unguarded
+type Transition struct {
+	From   string
+	To     string
+	Guards []string
+}
+
+// edge is a transition between two states
+type edge struct {
+	from string
+	to   string
+}
+
+// machine is the adjacency representation of a state machine
+type machine struct {
+	// adjacency maps every state to the states it can transition to, in the order they were declared
+	adjacency map[string][]string
+	// transitions holds the metadata of every allowed transition
+	transitions map[edge]Transition
 }
 
 // StateMachine is the data structure representing the state machine properties
 // that will initialize the FSM service
 type StateMachine struct {
-	ID           string
-	States       []string
-	InitialState string
-	FinalStates  []string
-	Transitions  []StateMachineTransition
+	ID            string
+	States        []string
+	InitialStates []string
+	FinalStates   []string
+	Transitions   []StateMachineTransition
 }
 
 // StateMachineTransition encodes the allowed transitions between state machine states
+// If Guard is not empty, it must pass for the transitions to be taken
 type StateMachineTransition struct {
-	From []string
-	To   []string
+	From  []string
+	To    []string
+	Guard string
 }
 
 // NewServiceFactory returns the default implementation of the FSM Service Factory
@@ -43,119 +82,152 @@ func NewServiceFactory() *ServiceFactory {
 
 // NewService initializes and returns a new instance of the FSM service
 func NewService(stateMachines []StateMachine) *Service {
-	var smMap = make(map[string]*fsm.FSM, len(stateMachines))
+	machines := make(map[string]machine, len(stateMachines))
 	for _, stateMachine := range stateMachines {
-		stateMachineID := stateMachine.ID
-		states := stateMachine.States
-
-		fsmEvents := make([]fsm.EventDesc, len(states))
-		allStates := make([]string, len(states)+1)
-		allStates[0] = originState()
-		for j, state := range states {
-			allStates[j+1] = state
-		}
-
-		for j, state := range states {
+		machines[stateMachine.ID] = newMachine(stateMachine)
+	}
+	return &Service{stateMachines: machines}
+}
 
-			if state == stateMachine.InitialState {
-				fsmEvents[j] = fsm.EventDesc{
-					Name: state,
-					Src:  []string{originState()},
-					Dst:  state,
-				}
-			} else {
-				src, dst := generateStates(state, stateMachine.Transitions)
-				fsmEvents[j] = fsm.EventDesc{
-					Name: state,
-					Src:  src,
-					Dst:  dst,
-				}
+// newMachine builds the adjacency of a state machine
+// Initial states can also be transitioned to from the origin state and transitions to undeclared states are ignored
+func newMachine(stateMachine StateMachine) machine {
+	m := machine{
+		adjacency:   make(map[string][]string),
+		transitions: make(map[edge]Transition),
+	}
+	for _, state := range stateMachine.States {
+		if utils.FindStringInArray(state, stateMachine.InitialStates) {
+			m.add(originState(), state, "")
+		}
+		for _, transition := range stateMachine.Transitions {
+			if !utils.FindStringInArray(state, transition.To) {
+				continue
+			}
+			for _, from := range transition.From {
+				m.add(from, state, transition.Guard)
 			}
+		}
+	}
+	return m
+}
 
+// add allows the transition between two states
+// A transition allowed by several state machine transitions is guarded only if every one of them is
+func (m machine) add(from, to, guard string) {
+	e := edge{from, to}
+	transition, exists := m.transitions[e]
+	if !exists {
+		m.adjacency[from] = append(m.adjacency[from], to)
+		transition = Transition{From: from, To: to}
+		if guard != "" {
+			transition.Guards = []string{guard}
 		}
-		smMap[stateMachineID] = fsm.NewFSM(originState(), fsmEvents, map[string]fsm.Callback{})
+		m.transitions[e] = transition
+		return
 	}
-	return &Service{stateMachines: smMap}
+	if guard == "" || len(transition.Guards) == 0 {
+		transition.Guards = nil
+	} else {
+		transition.Guards = append(transition.Guards, guard)
+	}
+	m.transitions[e] = transition
 }
 
 // IsTransitionValid verifies whether or not a state machine can transition between two given states
+// A single state is checked as the destination of a transition from the origin state
 func (s Service) IsTransitionValid(stateMachineID string, states ...string) bool {
 	if len(states) == 0 || len(states) > 2 {
 		return false
 	}
 
-	stateMachine := s.stateMachines[stateMachineID]
-	originalState := stateMachine.Current()
-
-	var fromState, toState string
-	if len(states) == 1 {
-		fromState = originalState
-		toState = states[0]
-	} else {
-		fromState = states[0]
-		toState = states[1]
+	fromState, toState := originState(), states[0]
+	if len(states) == 2 {
+		fromState, toState = states[0], states[1]
 	}
+	_, valid := s.Transition(stateMachineID, fromState, toState)
+	return valid
+}
 
-	stateMachine.SetState(fromState)
-	canTransition := stateMachine.Can(toState)
-	stateMachine.SetState(originalState)
-
-	return canTransition
+// Transition returns the transition between two states and whether or not it is allowed
+func (s Service) Transition(stateMachineID, fromState, toState string) (Transition, bool) {
+	transition, ok := s.stateMachines[stateMachineID].transitions[edge{fromState, toState}]
+	// Callers must not be able to modify the guards
+	if len(transition.Guards) > 0 {
+		transition.Guards = append([]string(nil), transition.Guards...)
+	}
+	return transition, ok
 }
 
 // AvailableStates returns the states that are immediately available to transition to
 // for a given state machine
 func (s Service) AvailableStates(stateMachineID string, currentState string) []string {
-	stateMachine := s.stateMachines[stateMachineID]
-	originalState := stateMachine.Current()
-	stateMachine.SetState(currentState)
-	availableTransitions := stateMachine.AvailableTransitions()
-	stateMachine.SetState(originalState)
+	available := s.stateMachines[stateMachineID].adjacency[currentState]
+	// Callers must not be able to modify the adjacency
+	return append([]string(nil), available...)
+}
+
+// BlockedReason returns why the guards of the transition between two states currently block it
+// An empty reason means the transition is not blocked. Transitions covered by several state machine transitions
+// are blocked only if every one of them is guarded and none of their guards passes
+func (s Service) BlockedReason(stateMachineID, fromState, toState string, evaluator GuardEvaluator) string {
+	guards := s.Guards(stateMachineID, fromState, toState)
+	if len(guards) == 0 {
+		return ""
+	}
+	var reasons []string
+	for _, guard := range guards {
+		err := evaluator.Evaluate(guard)
+		if err == nil {
+			return ""
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return strings.Join(reasons, "; ")
+}
 
-	return availableTransitions
+// Guards returns the guards of the transition between two states. Unguarded transitions have none
+func (s Service) Guards(stateMachineID, fromState, toState string) []string {
+	transition, _ := s.Transition(stateMachineID, fromState, toState)
+	return transition.Guards
+}
+
+// GuardedStates returns the states that are immediately available to transition to for a given state machine
+// together with the reason their transition is currently blocked, if it is
+func (s Service) GuardedStates(stateMachineID, currentState string, evaluator GuardEvaluator) []AvailableState {
+	availableStates := s.AvailableStates(stateMachineID, currentState)
+	guardedStates := make([]AvailableState, len(availableStates))
+	for i, state := range availableStates {
+		guardedStates[i] = AvailableState{
+			ID:      state,
+			Blocked: s.BlockedReason(stateMachineID, currentState, state, evaluator),
+		}
+	}
+	return guardedStates
 }
 
 // OriginState returns the very first state that ALL state machines start with`
-// This is different than the InitialState and is the same for ALL state machines
+// This is different than the InitialStates and is the same for ALL state machines
 func (s Service) OriginState() string {
 	return originState()
 }
 
-// InitialState returns the initial state of a state machine given a state machine ID
-func (s Service) InitialState(stateMachineID string) string {
-	stateMachine := s.stateMachines[stateMachineID]
-	return stateMachine.AvailableTransitions()[0]
+// InitialStates returns the states a state machine can start from given a state machine ID
+func (s Service) InitialStates(stateMachineID string) []string {
+	return s.AvailableStates(stateMachineID, originState())
 }
 
 // IsActiveState validates whether or not a particular state is active
 // for a given state machine. Active states are all state machine states
 // except the origin state and the final state
 func (s Service) IsActiveState(stateMachineID, state string) bool {
-	stateMachine := s.stateMachines[stateMachineID]
-	originState := stateMachine.Current()
-	stateMachine.SetState(state)
-	availableTransitions := len(stateMachine.AvailableTransitions())
-	stateMachine.SetState(originState)
-	return originState != state && availableTransitions > 0
+	return state != originState() && len(s.stateMachines[stateMachineID].adjacency[state]) > 0
 }
 
 // IsFinalState validates whether or not a particular state is the last state
 // for a given state machine
 func (s Service) IsFinalState(stateMachineID, state string) bool {
-	originState := s.stateMachines[stateMachineID].Current()
-	return !s.IsActiveState(stateMachineID, state) && originState != state
-}
-
-func generateStates(stage string, transitions []StateMachineTransition) ([]string, string) {
-	var srcStages []string
-	for _, transition := range transitions {
-		for _, to := range transition.To {
-			if to == stage {
-				srcStages = append(srcStages, transition.From...)
-			}
-		}
-	}
-	return srcStages, stage
+	return !s.IsActiveState(stateMachineID, state) && state != originState()
 }
 
 func originState() string {
@@ -172,18 +244,23 @@ func (s ServiceFactory) NewFsmService(definition config.Flowit) (service Service
 	return *NewService(fsms), nil
 }
 
+// buildFSMs builds the state machines used by the workflows, once each, as several workflows can share one
 func buildFSMs(stateMachines []config.StateMachine, workflows []config.Workflow) ([]StateMachine, error) {
 
-	fsmWorkflows := make([]StateMachine, len(workflows))
-	for i, workflow := range workflows {
-		fsmWorkflows[i].ID = workflow.StateMachine
+	var fsms []StateMachine
+	built := make(map[string]bool)
+	for _, workflow := range workflows {
+		if built[workflow.StateMachine] {
+			continue
+		}
+		built[workflow.StateMachine] = true
 
-		var stateMachine StateMachine
+		stateMachine := StateMachine{ID: workflow.StateMachine}
 		for _, sm := range stateMachines {
 
 			if workflow.StateMachine == sm.ID {
 				stateMachine.States = sm.Stages
-				stateMachine.InitialState = sm.InitialStage
+				stateMachine.InitialStates = sm.InitialStages
 				stateMachine.FinalStates = sm.FinalStages
 				fsmTransitions, err := buildTransitions(sm.Transitions)
 				if err != nil {
@@ -193,14 +270,10 @@ func buildFSMs(stateMachines []config.StateMachine, workflows []config.Workflow)
 			}
 
 		}
-
-		fsmWorkflows[i].States = stateMachine.States
-		fsmWorkflows[i].InitialState = stateMachine.InitialState
-		fsmWorkflows[i].FinalStates = stateMachine.FinalStates
-		fsmWorkflows[i].Transitions = stateMachine.Transitions
+		fsms = append(fsms, stateMachine)
 
 	}
-	return fsmWorkflows, nil
+	return fsms, nil
 }
 
 func buildTransitions(configTransitions []config.StateMachineTransition) ([]StateMachineTransition, error) {
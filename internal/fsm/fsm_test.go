@@ -1,9 +1,13 @@
 package fsm_test
 
 import (
+	"errors"
+	"sync"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/yamil-rivera/flowit/internal/config"
 	"github.com/yamil-rivera/flowit/internal/fsm"
 )
 
@@ -18,7 +22,7 @@ var _ = Describe("FSM", func() {
 				"stage-3",
 				"stage-4",
 			},
-			InitialState: "stage-1",
+			InitialStates: []string{"stage-1"},
 			FinalStates: []string{
 				"stage-4",
 			},
@@ -51,8 +55,8 @@ var _ = Describe("FSM", func() {
 
 		It("should successfully return the initial state", func() {
 
-			states := service.InitialState("state-machine-1")
-			Expect(states).To(BeEquivalentTo("stage-1"))
+			states := service.InitialStates("state-machine-1")
+			Expect(states).To(Equal([]string{"stage-1"}))
 
 		})
 
@@ -168,4 +172,137 @@ var _ = Describe("FSM", func() {
 
 	})
 
+	Context("Evaluating guarded transitions", func() {
+
+		guardedService := fsm.NewService([]fsm.StateMachine{
+			{
+				ID:            "guarded",
+				States:        []string{"stage-1", "stage-2", "stage-3", "stage-4"},
+				InitialStates: []string{"stage-1"},
+				FinalStates:   []string{"stage-4"},
+				Transitions: []fsm.StateMachineTransition{
+					{From: []string{"stage-1"}, To: []string{"stage-2", "stage-3"}, Guard: "failing"},
+					{From: []string{"stage-1"}, To: []string{"stage-3"}},
+					{From: []string{"stage-2", "stage-3"}, To: []string{"stage-4"}, Guard: "failing"},
+					{From: []string{"stage-2"}, To: []string{"stage-4"}, Guard: "passing"},
+				},
+			},
+		})
+		evaluator := mockEvaluator{}
+
+		It("should block transitions whose guards fail", func() {
+
+			Expect(guardedService.IsTransitionValid("guarded", "stage-1", "stage-2")).To(BeTrue())
+			Expect(guardedService.Guards("guarded", "stage-1", "stage-2")).To(Equal([]string{"failing"}))
+			Expect(guardedService.BlockedReason("guarded", "stage-1", "stage-2", evaluator)).To(Equal("failing failed"))
+			Expect(guardedService.BlockedReason("guarded", "stage-3", "stage-4", evaluator)).To(Equal("failing failed"))
+
+		})
+
+		It("should not block transitions with a passing guard or an unguarded alternative", func() {
+
+			Expect(guardedService.Guards("guarded", "stage-1", "stage-3")).To(BeEmpty())
+			Expect(guardedService.BlockedReason("guarded", "stage-1", "stage-3", evaluator)).To(BeEmpty())
+			Expect(guardedService.Guards("guarded", "stage-2", "stage-4")).To(Equal([]string{"failing", "passing"}))
+			Expect(guardedService.BlockedReason("guarded", "stage-2", "stage-4", evaluator)).To(BeEmpty())
+
+		})
+
+		It("should return the available states together with the reason they are blocked", func() {
+
+			states := guardedService.GuardedStates("guarded", "stage-1", evaluator)
+			Expect(states).To(ConsistOf(
+				fsm.AvailableState{ID: "stage-2", Blocked: "failing failed"},
+				fsm.AvailableState{ID: "stage-3"},
+			))
+
+		})
+
+	})
+
+	Context("Building state machines", func() {
+
+		It("should start from any of the initial states", func() {
+
+			bugfixService := fsm.NewService([]fsm.StateMachine{
+				{
+					ID:            "bugfix",
+					States:        []string{"triage", "fix", "finish"},
+					InitialStates: []string{"triage", "fix"},
+					FinalStates:   []string{"finish"},
+					Transitions: []fsm.StateMachineTransition{
+						{From: []string{"triage"}, To: []string{"fix"}},
+						{From: []string{"fix"}, To: []string{"finish"}},
+					},
+				},
+			})
+			Expect(bugfixService.InitialStates("bugfix")).To(Equal([]string{"triage", "fix"}))
+			Expect(bugfixService.IsTransitionValid("bugfix", "fix")).To(BeTrue())
+			Expect(bugfixService.IsTransitionValid("bugfix", "finish")).To(BeFalse())
+			Expect(bugfixService.AvailableStates("bugfix", "triage")).To(Equal([]string{"fix"}))
+			Expect(bugfixService.IsTransitionValid("bugfix", "fix", "triage")).To(BeFalse())
+
+		})
+
+		It("should not be modified by the returned states", func() {
+
+			states := service.AvailableStates("state-machine-1", "stage-1")
+			states[0] = "stage-4"
+			Expect(service.AvailableStates("state-machine-1", "stage-1")).To(Equal([]string{"stage-2", "stage-3"}))
+
+		})
+
+		It("should answer concurrent queries", func() {
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(service.IsTransitionValid("state-machine-1", "stage-2", "stage-4")).To(BeTrue())
+					Expect(service.AvailableStates("state-machine-1", "stage-1")).To(Equal([]string{"stage-2", "stage-3"}))
+					Expect(service.IsActiveState("state-machine-1", "stage-3")).To(BeTrue())
+				}()
+			}
+			wg.Wait()
+
+		})
+
+		It("should build the state machines shared by several workflows", func() {
+
+			definition := config.Flowit{
+				StateMachines: []config.StateMachine{{
+					ID:            "shared",
+					Stages:        []string{"start", "finish"},
+					InitialStages: []string{"start"},
+					FinalStages:   []string{"finish"},
+					Transitions:   []config.StateMachineTransition{{From: []string{"start"}, To: []string{"finish"}, Guard: "true"}},
+				}},
+				Workflows: []config.Workflow{
+					{ID: "feature", StateMachine: "shared"},
+					{ID: "hotfix", StateMachine: "shared"},
+				},
+			}
+			sharedService, err := fsm.NewServiceFactory().NewFsmService(definition)
+			Expect(err).To(BeNil())
+			Expect(sharedService.InitialStates("shared")).To(Equal([]string{"start"}))
+			transition, ok := sharedService.Transition("shared", "start", "finish")
+			Expect(ok).To(BeTrue())
+			Expect(transition).To(Equal(fsm.Transition{From: "start", To: "finish", Guards: []string{"true"}}))
+			Expect(sharedService.IsFinalState("shared", "finish")).To(BeTrue())
+
+		})
+
+	})
+
 })
+
+type mockEvaluator struct{}
+
+func (e mockEvaluator) Evaluate(guard string) error {
+	if guard == "failing" {
+		return errors.New(guard + " failed")
+	}
+	return nil
+}
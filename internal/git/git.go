@@ -0,0 +1,270 @@
+package git
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/utils"
+)
+
+// DefaultRemote is the remote branches are compared with and pushed to unless another one is specified
+const DefaultRemote = "origin"
+
+// Repository runs git commands in the working tree of a git repository
+type Repository struct {
+	dir string
+}
+
+// DirtyTreeError is returned by the actions which refuse to run on a working tree with uncommitted changes
+type DirtyTreeError struct {
+	Changes []string
+}
+
+func (e DirtyTreeError) Error() string {
+	return "The working tree has uncommitted changes: " + strings.Join(e.Changes, ", ")
+}
+
+// DivergedError is returned by the actions which refuse to run on a branch missing commits of its remote branch
+type DivergedError struct {
+	Branch string
+	Remote string
+	// Ahead and Behind are the number of commits only the branch and only the remote branch have
+	Ahead  int
+	Behind int
+}
+
+func (e DivergedError) Error() string {
+	reason := "is behind " + e.Remote + "/" + e.Branch + " by " + strconv.Itoa(e.Behind) + " commits"
+	if e.Ahead > 0 {
+		reason = "has diverged from " + e.Remote + "/" + e.Branch + ", having " + strconv.Itoa(e.Ahead) +
+			" and " + strconv.Itoa(e.Behind) + " different commits each"
+	}
+	return "Branch: " + e.Branch + " " + reason
+}
+
+// NewRepository returns a Repository running git commands in dir. An empty dir is the current directory
+func NewRepository(dir string) Repository {
+	return Repository{dir}
+}
+
+// CurrentBranch returns the branch checked out in the working tree
+func (r Repository) CurrentBranch() (string, error) {
+	branch, err := r.git("symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return "", errors.Wrap(err, "No branch is checked out")
+	}
+	return branch, nil
+}
+
+// BranchExists returns whether or not the local branch exists
+func (r Repository) BranchExists(branch string) (bool, error) {
+	if _, err := r.git("check-ref-format", "--branch", branch); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return r.refExists("refs/heads/" + branch), nil
+}
+
+// IsClean returns whether or not the working tree has no uncommitted changes. Untracked files are not changes
+func (r Repository) IsClean() (bool, error) {
+	changes, err := r.changes()
+	return len(changes) == 0, errors.WithStack(err)
+}
+
+// IsUpToDate returns whether or not the branch points to the same commit as its remote branch, once fetched
+// An empty branch is the current branch
+func (r Repository) IsUpToDate(branch, remote string) (bool, error) {
+	branch, err := r.branchOrCurrent(branch)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if _, err := r.git("fetch", "--quiet", remote); err != nil {
+		return false, errors.WithStack(err)
+	}
+	if !r.refExists("refs/remotes/" + remote + "/" + branch) {
+		return false, errors.New("Branch: " + branch + " does not exist in remote: " + remote)
+	}
+	ahead, behind, err := r.divergence(branch, remote)
+	return ahead == 0 && behind == 0, errors.WithStack(err)
+}
+
+// CreateBranch creates the branch from another branch, or from the current branch if from is empty, and checks it out
+func (r Repository) CreateBranch(branch, from string) (string, error) {
+	if err := r.checkClean(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	exists, err := r.BranchExists(branch)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid branch name: "+branch)
+	}
+	if exists {
+		return "", errors.New("Branch: " + branch + " can not be created. It already exists")
+	}
+	args := []string{"checkout", "--quiet", "-b", branch}
+	if from != "" {
+		args = append(args, from)
+	}
+	return r.git(args...)
+}
+
+// RebaseOnto rebases the branch, or the current branch if it is empty, onto another branch and leaves it checked out
+// The branch it is rebased onto must have every commit of its remote branch. A rebase with conflicts is aborted
+func (r Repository) RebaseOnto(branch, onto, remote string) (string, error) {
+	if err := r.checkClean(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	branch, err := r.branchOrCurrent(branch)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := r.checkNotBehind(onto, remote); err != nil {
+		return "", errors.WithStack(err)
+	}
+	out, err := r.git("rebase", "--quiet", onto, branch)
+	if err != nil {
+		// nolint: errcheck
+		r.git("rebase", "--abort")
+		return out, errors.Wrap(err, "The rebase of branch: "+branch+" onto: "+onto+" was aborted")
+	}
+	return out, nil
+}
+
+// Merge merges the branch, or the current branch if it is empty, into another branch and leaves the latter checked out
+// The branch merged into must have every commit of its remote branch. A merge with conflicts is aborted
+func (r Repository) Merge(branch, into, remote string) (string, error) {
+	if err := r.checkClean(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	branch, err := r.branchOrCurrent(branch)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := r.checkNotBehind(into, remote); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if out, err := r.git("checkout", "--quiet", into); err != nil {
+		return out, errors.WithStack(err)
+	}
+	out, err := r.git("merge", "--no-edit", branch)
+	if err != nil {
+		// nolint: errcheck
+		r.git("merge", "--abort")
+		return out, errors.Wrap(err, "The merge of branch: "+branch+" into: "+into+" was aborted")
+	}
+	return out, nil
+}
+
+// DeleteBranch deletes the local branch, which must be merged into the current branch
+func (r Repository) DeleteBranch(branch string) (string, error) {
+	return r.git("branch", "--delete", branch)
+}
+
+// Push pushes the branch, or the current branch if it is empty, to the remote and sets it as its upstream
+// The branch must have every commit of its remote branch, so nothing is lost on the remote
+func (r Repository) Push(branch, remote string) (string, error) {
+	branch, err := r.branchOrCurrent(branch)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := r.checkNotBehind(branch, remote); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return r.git("push", "--quiet", "--set-upstream", remote, branch)
+}
+
+// checkClean returns a DirtyTreeError if the working tree has uncommitted changes
+func (r Repository) checkClean() error {
+	changes, err := r.changes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(changes) > 0 {
+		return DirtyTreeError{changes}
+	}
+	return nil
+}
+
+// checkNotBehind returns a DivergedError if the remote branch has commits the branch does not have, once fetched
+// Branches the remote does not have, and remotes the repository does not have, are never behind
+func (r Repository) checkNotBehind(branch, remote string) error {
+	remotes, err := r.git("remote")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !utils.FindStringInArray(remote, strings.Split(remotes, "\n")) {
+		return nil
+	}
+	if _, err := r.git("fetch", "--quiet", remote); err != nil {
+		return errors.WithStack(err)
+	}
+	if !r.refExists("refs/remotes/" + remote + "/" + branch) {
+		return nil
+	}
+	ahead, behind, err := r.divergence(branch, remote)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if behind > 0 {
+		return DivergedError{branch, remote, ahead, behind}
+	}
+	return nil
+}
+
+// divergence returns the number of commits only the branch and only its remote branch have
+func (r Repository) divergence(branch, remote string) (int, int, error) {
+	out, err := r.git("rev-list", "--left-right", "--count", "refs/heads/"+branch+"...refs/remotes/"+remote+"/"+branch)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	counts := strings.Fields(out)
+	if len(counts) != 2 {
+		return 0, 0, errors.New("Unexpected git rev-list output: " + out)
+	}
+	ahead, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	behind, err := strconv.Atoi(counts[1])
+	return ahead, behind, errors.WithStack(err)
+}
+
+// changes returns the files with uncommitted changes
+func (r Repository) changes() ([]string, error) {
+	out, err := r.git("status", "--porcelain", "--untracked-files=no")
+	if err != nil || out == "" {
+		return nil, errors.WithStack(err)
+	}
+	var changes []string
+	for _, line := range strings.Split(out, "\n") {
+		// Lines are the status of the file followed by its path
+		if fields := strings.SplitN(strings.TrimSpace(line), " ", 2); len(fields) == 2 {
+			changes = append(changes, strings.TrimSpace(fields[1]))
+		}
+	}
+	return changes, nil
+}
+
+func (r Repository) branchOrCurrent(branch string) (string, error) {
+	if branch != "" {
+		return branch, nil
+	}
+	return r.CurrentBranch()
+}
+
+func (r Repository) refExists(ref string) bool {
+	_, err := r.git("show-ref", "--verify", "--quiet", ref)
+	return err == nil
+}
+
+// git runs a git command in the repository and returns its trimmed output, which includes its standard error
+func (r Repository) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	out, err := cmd.CombinedOutput()
+	trimmedOut := strings.TrimSpace(string(out))
+	if err != nil {
+		return trimmedOut, errors.Wrap(err, "Error executing: git "+strings.Join(args, " ")+": "+trimmedOut)
+	}
+	return trimmedOut, nil
+}
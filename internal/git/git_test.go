@@ -0,0 +1,170 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/git"
+)
+
+var _ = Describe("Git", func() {
+
+	var root string
+
+	// run runs a git command in dir, failing the test if it fails
+	run := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(out))
+	}
+
+	// clone returns a clone of the remote with its own committer
+	clone := func(name string) string {
+		dir := filepath.Join(root, name)
+		run(root, "clone", "--quiet", filepath.Join(root, "remote.git"), dir)
+		run(dir, "config", "user.name", name)
+		run(dir, "config", "user.email", name+"@example.com")
+		return dir
+	}
+
+	commit := func(dir, file string) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0644)).To(Succeed())
+		run(dir, "add", file)
+		run(dir, "commit", "--quiet", "-m", file)
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "flowit-git")
+		Expect(err).To(BeNil())
+		// The remote is a bare repository with a master branch holding a single commit
+		run(root, "init", "--quiet", "--bare", "remote.git")
+		run(filepath.Join(root, "remote.git"), "symbolic-ref", "HEAD", "refs/heads/master")
+		seed := clone("seed")
+		run(seed, "checkout", "--quiet", "-b", "master")
+		commit(seed, "README")
+		run(seed, "push", "--quiet", "origin", "master")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	It("should create branches on clean working trees only", func() {
+		repository := git.NewRepository(clone("alice"))
+
+		branch, err := repository.CurrentBranch()
+		Expect(err).To(BeNil())
+		Expect(branch).To(Equal("master"))
+
+		_, err = repository.CreateBranch("feature/abc-12", "master")
+		Expect(err).To(BeNil())
+		branch, err = repository.CurrentBranch()
+		Expect(err).To(BeNil())
+		Expect(branch).To(Equal("feature/abc-12"))
+		exists, err := repository.BranchExists("feature/abc-12")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeTrue())
+
+		_, err = repository.CreateBranch("feature/abc-12", "master")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("It already exists"))
+
+		Expect(ioutil.WriteFile(filepath.Join(root, "alice", "README"), []byte("changed"), 0644)).To(Succeed())
+		clean, err := repository.IsClean()
+		Expect(err).To(BeNil())
+		Expect(clean).To(BeFalse())
+		_, err = repository.CreateBranch("feature/abc-13", "master")
+		Expect(errors.Cause(err)).To(Equal(git.DirtyTreeError{Changes: []string{"README"}}))
+	})
+
+	It("should refuse to push branches which diverged from the remote", func() {
+		alice := git.NewRepository(clone("alice"))
+		_, err := alice.CreateBranch("feature/abc-12", "")
+		Expect(err).To(BeNil())
+		commit(filepath.Join(root, "alice"), "alice")
+		_, err = alice.Push("", git.DefaultRemote)
+		Expect(err).To(BeNil())
+		upToDate, err := alice.IsUpToDate("feature/abc-12", git.DefaultRemote)
+		Expect(err).To(BeNil())
+		Expect(upToDate).To(BeTrue())
+
+		bob := clone("bob")
+		run(bob, "checkout", "--quiet", "feature/abc-12")
+		commit(bob, "bob")
+		run(bob, "push", "--quiet", "origin", "feature/abc-12")
+
+		commit(filepath.Join(root, "alice"), "alice-again")
+		upToDate, err = alice.IsUpToDate("", git.DefaultRemote)
+		Expect(err).To(BeNil())
+		Expect(upToDate).To(BeFalse())
+		_, err = alice.Push("feature/abc-12", git.DefaultRemote)
+		Expect(errors.Cause(err)).To(Equal(
+			git.DivergedError{Branch: "feature/abc-12", Remote: "origin", Ahead: 1, Behind: 1}))
+	})
+
+	It("should rebase and merge onto branches which are not behind the remote", func() {
+		dir := clone("alice")
+		alice := git.NewRepository(dir)
+		_, err := alice.CreateBranch("feature/abc-12", "master")
+		Expect(err).To(BeNil())
+		commit(dir, "feature")
+
+		bob := clone("bob")
+		commit(bob, "fix")
+		run(bob, "push", "--quiet", "origin", "master")
+
+		_, err = alice.RebaseOnto("", "master", git.DefaultRemote)
+		Expect(errors.Cause(err)).To(Equal(git.DivergedError{Branch: "master", Remote: "origin", Ahead: 0, Behind: 1}))
+		_, err = alice.Merge("feature/abc-12", "master", git.DefaultRemote)
+		Expect(errors.Cause(err)).To(Equal(git.DivergedError{Branch: "master", Remote: "origin", Ahead: 0, Behind: 1}))
+
+		run(dir, "fetch", "--quiet", "origin", "master:master")
+		_, err = alice.RebaseOnto("feature/abc-12", "master", git.DefaultRemote)
+		Expect(err).To(BeNil())
+		_, err = alice.Merge("", "master", git.DefaultRemote)
+		Expect(err).To(BeNil())
+		branch, err := alice.CurrentBranch()
+		Expect(err).To(BeNil())
+		Expect(branch).To(Equal("master"))
+		Expect(filepath.Join(dir, "feature")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "fix")).To(BeAnExistingFile())
+
+		_, err = alice.DeleteBranch("feature/abc-12")
+		Expect(err).To(BeNil())
+		exists, err := alice.BranchExists("feature/abc-12")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("should abort merges with conflicts and refuse to delete unmerged branches", func() {
+		dir := clone("alice")
+		alice := git.NewRepository(dir)
+		_, err := alice.CreateBranch("feature/abc-12", "master")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("feature"), 0644)).To(Succeed())
+		run(dir, "commit", "--quiet", "-am", "feature")
+		run(dir, "checkout", "--quiet", "master")
+		Expect(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("master"), 0644)).To(Succeed())
+		run(dir, "commit", "--quiet", "-am", "master")
+
+		_, err = alice.DeleteBranch("feature/abc-12")
+		Expect(err).To(HaveOccurred())
+
+		_, err = alice.Merge("feature/abc-12", "master", git.DefaultRemote)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("was aborted"))
+		clean, err := alice.IsClean()
+		Expect(err).To(BeNil())
+		Expect(clean).To(BeTrue())
+	})
+
+})
@@ -2,6 +2,7 @@ package io
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 )
@@ -54,3 +55,12 @@ func Printfln(format string, a ...interface{}) error {
 	}
 	return nil
 }
+
+// Warn receives a warning and writes it to standard error so that it does not mix with the command output
+// Returns an error in case of failure
+func Warn(warning string) error {
+	if _, err := fmt.Fprintln(os.Stderr, "Warning: "+warning); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
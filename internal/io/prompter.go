@@ -0,0 +1,44 @@
+package io
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ConsolePrompter asks the user to confirm in the console
+type ConsolePrompter struct {
+	yes bool
+}
+
+// NewConsolePrompter returns a new ConsolePrompter instance. If yes is true every question is confirmed without asking
+func NewConsolePrompter(yes bool) ConsolePrompter {
+	return ConsolePrompter{yes}
+}
+
+// Confirm writes the question and returns whether or not the answer read from standard input is yes
+// Standard input must be a terminal, so that non interactive invocations fail instead of waiting for an answer
+func (p ConsolePrompter) Confirm(question string) (bool, error) {
+	if p.yes {
+		return true, Println(question + " [y/N] yes")
+	}
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("Can not ask: " + question + ". Standard input is not a terminal, use --yes to confirm")
+	}
+	if err := Print(question + " [y/N] "); err != nil {
+		return false, errors.WithStack(err)
+	}
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, errors.Wrap(err, "Error reading the answer to: "+question)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
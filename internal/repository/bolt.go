@@ -0,0 +1,510 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/io"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// BoltStore is the Store implementation backed by a single BoltDB file
+type BoltStore struct {
+	location string
+}
+
+// NewBoltStore creates and returns a BoltStore instance persisting workflows in the specified file
+func NewBoltStore(location string) *BoltStore {
+	return &BoltStore{location}
+}
+
+// Drop wipes the DB clean
+func (rs BoltStore) Drop() error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	if err := db.Update(
+		func(tx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				return tx.DeleteBucket(name)
+			})
+		}); err != nil {
+		return errors.Wrap(err, "Error trying to open update transaction")
+	}
+	removeLockHolder(rs.location)
+	return os.RemoveAll(rs.location)
+}
+
+// Compact removes the workflow definitions no longer referenced by any workflow and rewrites the DB file
+// so the space freed by deleted workflows is given back to the file system
+func (rs BoltStore) Compact() error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	if err := db.Update(removeOrphanDefinitions); err != nil {
+		return errors.Wrap(err, "Error trying to remove unused workflow definitions")
+	}
+
+	// BoltDB never shrinks its file, so the DB is copied into a new file which replaces it while the lock is held
+	compactLocation := rs.location + ".compact"
+	if err := os.Remove(compactLocation); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	compactDB, err := bolt.Open(compactLocation, 0600, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return compactDB.Update(func(compactTx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				bucket, err := compactTx.CreateBucket(name)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				return copyBucket(b, bucket)
+			})
+		})
+	})
+	if closeErr := compactDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compactLocation) // nolint:errcheck,gosec
+		return errors.Wrap(err, "Error trying to compact DB")
+	}
+	return errors.WithStack(os.Rename(compactLocation, rs.location))
+}
+
+// PutWorkflow takes a workflow.Workflow struct and saves it into the DB
+func (rs BoltStore) PutWorkflow(workflow w.Workflow) error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	if err := db.Update(
+		func(tx *bolt.Tx) error {
+			storedVersion, found, err := storedWorkflowVersion(tx, workflow.Name, workflow.ID)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if found {
+				if err := checkVersion(storedVersion, workflow); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			return putWorkflow(tx, workflow)
+		}); err != nil {
+		return errors.Wrap(err, "Error trying to save workflow")
+	}
+	return nil
+}
+
+// DeleteWorkflow takes a workflowName and workflowID and removes the workflow from the DB
+// If the workflow or bucket does not exist, an error is returned
+func (rs BoltStore) DeleteWorkflow(workflowName, workflowID string) error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	if err := db.Update(
+		func(tx *bolt.Tx) error {
+			bucketName := workflowsBucketPrefix + workflowName
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				return errors.New("Bucket " + bucketName + " does not exist")
+			}
+			if b.Get([]byte(workflowID)) == nil {
+				return errors.New("Workflow " + workflowID + " does not exist")
+			}
+			if err := b.Delete([]byte(workflowID)); err != nil {
+				return errors.Wrap(err, "Error trying to delete workflow")
+			}
+			return nil
+		}); err != nil {
+		return errors.Wrap(err, "Error trying to open update transaction")
+	}
+	return nil
+}
+
+// GetWorkflowFromPreffix takes a workflowName and workflowPreffix and returns a workflow
+// which ID begins with the preffix wrapped in an optional.
+// If no workflow is found or the bucket does not exist, an empty optional is returned.
+// If several workflows are found, an error is returned
+func (rs BoltStore) GetWorkflowFromPreffix(workflowName, workflowPreffix string) (w.OptionalWorkflow, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	var workflows []w.Workflow
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			bucketName := workflowsBucketPrefix + workflowName
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				return nil
+			}
+			definitions := newDefinitionCache(tx)
+			c := b.Cursor()
+			prefix := []byte(workflowPreffix)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				w, err := definitions.decodeWorkflow(v)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				workflows = append(workflows, *w)
+			}
+			return nil
+		}); err != nil {
+		return w.OptionalWorkflow{}, errors.Wrap(err, "Error within happened within transaction")
+	}
+	return findWorkflowFromPreffix(workflows, workflowPreffix)
+}
+
+// GetWorkflow takes a workflowName and workflowID and returns the workflow which ID exactly matches the workflowID
+// wrapped in an optional.
+// If no workflow is found or the bucket does not exist, an empty optional is returned
+func (rs BoltStore) GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	var workflow w.Workflow
+	workflowSet := false
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			bucketName := workflowsBucketPrefix + workflowName
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				return nil
+			}
+			entry := b.Get([]byte(workflowID))
+			if entry == nil {
+				return nil
+			}
+			w, err := newDefinitionCache(tx).decodeWorkflow(entry)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			workflow = *w
+			workflowSet = true
+			return nil
+		}); err != nil {
+		return w.OptionalWorkflow{}, errors.Wrap(err, "Error within happened within transaction")
+	}
+	if workflowSet {
+		return w.NewWorkflowOptional(workflow), nil
+	}
+	return w.OptionalWorkflow{}, nil
+}
+
+// GetWorkflows takes a workflowName, an integer 'n' and whether or not inactive workflows are excluded
+// and returns a list of 'n' workflows that match the criteria.
+// If n is 0, all existing workflows that match the criteria are returned
+func (rs BoltStore) GetWorkflows(workflowName string, n int, excludeInactive bool) ([]w.Workflow, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	if n < 0 {
+		return nil, nil
+	}
+
+	var workflows []w.Workflow
+	var copied bool
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			bucketName := workflowsBucketPrefix + workflowName
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				return nil
+			}
+			count := n
+			definitions := newDefinitionCache(tx)
+			if err := b.ForEach(func(k, v []byte) error {
+				w, err := definitions.decodeWorkflow(v)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				if (!excludeInactive || w.IsActive) && (n == 0 || count > 0) {
+					workflows = append(workflows, *w)
+					count--
+					copied = true
+				}
+				return nil
+			}); err != nil {
+				return errors.WithStack(err)
+			}
+			if n > 0 && count > 0 {
+				return errors.New("Number of requested workflows: " + strconv.Itoa(n) +
+					" is greater than the number of items: " + strconv.Itoa(n-count))
+			}
+			return nil
+		}); err != nil {
+		return workflows, errors.Wrap(err, "Error within happened within transaction")
+	}
+	if copied {
+		return workflows, nil
+	}
+	return nil, nil
+}
+
+// GetAllWorkflows returns a list of all workflows if excludeInactive is false.
+// It returns all active workflows if excludeInactive is true.
+// TODO: Unit test
+func (rs BoltStore) GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	var workflows []w.Workflow
+	var copied bool
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			definitions := newDefinitionCache(tx)
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				if strings.HasPrefix(string(name), workflowsBucketPrefix) {
+					if err := b.ForEach(func(k, v []byte) error {
+						w, err := definitions.decodeWorkflow(v)
+						if err != nil {
+							return errors.WithStack(err)
+						}
+						if !excludeInactive || w.IsActive {
+							workflows = append(workflows, *w)
+							copied = true
+						}
+						return nil
+					}); err != nil {
+						return errors.WithStack(err)
+					}
+					return nil
+				}
+				return nil
+			})
+		}); err != nil {
+		return workflows, errors.Wrap(err, "Error within happened within transaction")
+	}
+	if copied {
+		return workflows, nil
+	}
+	return nil, nil
+}
+
+// QueryWorkflows returns the workflows matching the query, most recently updated first
+func (rs BoltStore) QueryWorkflows(query Query) ([]w.Workflow, error) {
+	return queryStore(rs, query)
+}
+
+// AcquireLease grants the lease unless another process holds a lease on the same workflow that is not stale
+func (rs BoltStore) AcquireLease(lease Lease) error {
+	return rs.updateLease(lease.WorkflowName, lease.WorkflowID, func(stored *Lease) (*Lease, error) {
+		if err := checkLease(stored, lease); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &lease, nil
+	})
+}
+
+// ReleaseLease removes the lease unless it was broken and granted to another process meanwhile
+func (rs BoltStore) ReleaseLease(lease Lease) error {
+	return rs.updateLease(lease.WorkflowName, lease.WorkflowID, func(stored *Lease) (*Lease, error) {
+		if stored != nil && !stored.isHeldBy(lease) {
+			return stored, nil
+		}
+		return nil, nil
+	})
+}
+
+// BreakLease removes any lease on the workflow regardless of its holder
+func (rs BoltStore) BreakLease(workflowName, workflowID string) error {
+	return rs.updateLease(workflowName, workflowID, func(stored *Lease) (*Lease, error) {
+		return nil, nil
+	})
+}
+
+// GetLease returns the lease on the workflow, if any
+func (rs BoltStore) GetLease(workflowName, workflowID string) (*Lease, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	var lease *Lease
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			lease, err = storedLease(tx, workflowName, workflowID)
+			return errors.WithStack(err)
+		}); err != nil {
+		return nil, errors.Wrap(err, "Error trying to read lease")
+	}
+	return lease, nil
+}
+
+// PutAuditEvent appends the audit event to the audit bucket
+func (rs BoltStore) PutAuditEvent(event audit.Event) error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode audit event")
+	}
+	return db.Update(
+		func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(auditBucket))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			// Sequential keys keep the events in the order they were recorded
+			sequence, err := b.NextSequence()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, sequence)
+			return errors.WithStack(b.Put(key, eventBytes))
+		})
+}
+
+// GetAuditEvents returns the audit events matching the query in the order they were recorded
+func (rs BoltStore) GetAuditEvents(query audit.Query) ([]audit.Event, error) {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	var events []audit.Event
+	if err := db.View(
+		func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(auditBucket))
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				var event audit.Event
+				if err := json.Unmarshal(v, &event); err != nil {
+					return errors.Wrap(err, "Error trying to decode audit event")
+				}
+				if query.Matches(event) {
+					events = append(events, event)
+				}
+				return nil
+			})
+		}); err != nil {
+		return nil, errors.Wrap(err, "Error trying to read audit events")
+	}
+	return events, nil
+}
+
+// updateLease replaces the stored lease with the one returned by update, removing it if none is returned
+func (rs BoltStore) updateLease(workflowName, workflowID string, update func(stored *Lease) (*Lease, error)) error {
+	db, err := openDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeDB(db)
+
+	return db.Update(
+		func(tx *bolt.Tx) error {
+			stored, err := storedLease(tx, workflowName, workflowID)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			lease, err := update(stored)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte(leasesBucket))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			key := []byte(leaseKey(workflowName, workflowID))
+			if lease == nil {
+				return errors.WithStack(b.Delete(key))
+			}
+			leaseBytes, err := encode(lease)
+			if err != nil {
+				return errors.Wrap(err, "Error trying to encode lease")
+			}
+			return errors.WithStack(b.Put(key, leaseBytes))
+		})
+}
+
+func storedLease(tx *bolt.Tx, workflowName, workflowID string) (*Lease, error) {
+	b := tx.Bucket([]byte(leasesBucket))
+	if b == nil {
+		return nil, nil
+	}
+	entry := b.Get([]byte(leaseKey(workflowName, workflowID)))
+	if entry == nil {
+		return nil, nil
+	}
+	var lease Lease
+	if err := gob.NewDecoder(bytes.NewReader(entry)).Decode(&lease); err != nil {
+		return nil, errors.Wrap(err, "Error trying to decode lease")
+	}
+	return &lease, nil
+}
+
+func openDB(location string) (*bolt.DB, error) {
+	before, _ := os.Stat(location)
+	db, err := bolt.Open(location, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, errors.New(busyMessage(location))
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Compaction replaces the DB file while holding its lock, so the lock this process waited for
+	// might belong to the replaced file
+	if after, err := os.Stat(location); before != nil && err == nil && !os.SameFile(before, after) {
+		if err := db.Close(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return openDB(location)
+	}
+	writeLockHolder(location)
+	if err := migrateDB(db); err != nil {
+		closeDB(db)
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+func closeDB(db *bolt.DB) {
+	// The holder file is removed while the lock is still held so the next holder's file is never removed
+	removeLockHolder(db.Path())
+	if err := db.Close(); err != nil {
+		io.Logger.Errorf("%+v", err)
+	}
+}
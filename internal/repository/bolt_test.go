@@ -0,0 +1,359 @@
+package repository_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/config"
+	r "github.com/yamil-rivera/flowit/internal/repository"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// fixtureWorkflow is the workflow stored in the testdata DBs written with each record version
+// It must not change, as the fixtures are never written again
+func fixtureWorkflow() w.Workflow {
+	execution := w.Execution{
+		ID:         "2",
+		FromStage:  "start",
+		Stage:      "finish",
+		Args:       []string{"arg"},
+		Checkpoint: 1,
+		Results: []w.CommandResult{
+			{
+				Command:  "echo arg",
+				Output:   "arg",
+				Started:  0xABABABAB,
+				Finished: 0xBCBCBCBC,
+			},
+		},
+		Metadata: w.ExecutionMetadata{
+			Version:  0xABABABAB,
+			Started:  0xBCBCBCBC,
+			Finished: 0xCDCDCDCD,
+		},
+	}
+	return w.Workflow{
+		ID:          "1",
+		Preffix:     "fixture",
+		Alias:       "alias",
+		Name:        "feature",
+		IsActive:    false,
+		IsCancelled: true,
+		Executions: []w.Execution{
+			execution,
+		},
+		LatestExecution: &execution,
+		State: config.Flowit{
+			Version: "0.2",
+			Config: config.Config{
+				CheckpointExecution: true,
+				Shell:               "/usr/bin/env bash",
+				Repository:          config.Repository{Type: "bolt", Location: ".flowitDS"},
+				Retention:           config.Retention{Days: 30, Keep: 10, Archive: ".flowit-archive"},
+			},
+			Variables: map[string]interface{}{
+				"my-var":    "my-val",
+				"my-number": 3,
+			},
+			StateMachines: []config.StateMachine{{
+				ID:            "machine",
+				Stages:        []string{"start", "finish"},
+				InitialStages: []string{"start"},
+				FinalStages:   []string{"finish"},
+				Transitions:   []config.StateMachineTransition{{From: []string{"start"}, To: []string{"finish"}}},
+			}},
+			Workflows: []config.Workflow{{
+				ID:           "feature",
+				StateMachine: "machine",
+				Stages: []config.Stage{
+					{ID: "start", Args: []string{"<arg | Argument>"}, Actions: []config.Command{{Run: "echo $<arg>"}}},
+					{ID: "finish", Conditions: []config.Command{{Run: "true"}}, Actions: []config.Command{{Run: "echo done"}}},
+				},
+			}},
+		},
+		DefinitionHash: "hash",
+		Metadata: w.WorkflowMetadata{
+			Version:  0xDEDEDEDE,
+			Started:  0xEFEFEFEF,
+			Updated:  0xABABABAB,
+			Finished: 0xBCBCBCBC,
+		},
+	}
+}
+
+// baselineWorkflow is the workflow stored in testdata/baseline.flowitDS, written by the flowit version which embedded
+// the whole definition snapshot in every workflow, once migrated to the current layout
+// It must not change, as the fixture is never written again
+func baselineWorkflow() w.Workflow {
+	execution := w.Execution{
+		ID:         "2",
+		FromStage:  "start",
+		Stage:      "finish",
+		Args:       []string{"arg"},
+		Checkpoint: 1,
+		Failed:     true,
+		Metadata: w.ExecutionMetadata{
+			Version:  0xABABABAB,
+			Started:  0xBCBCBCBC,
+			Finished: 0xCDCDCDCD,
+		},
+	}
+	return w.Workflow{
+		ID:       "1",
+		Preffix:  "fixture",
+		Name:     "feature",
+		IsActive: true,
+		Executions: []w.Execution{
+			execution,
+		},
+		LatestExecution: &execution,
+		State: config.Flowit{
+			Version: "0.1",
+			Config: config.Config{
+				CheckpointExecution: true,
+				Shell:               "/usr/bin/env bash",
+			},
+			Variables: map[string]interface{}{
+				"my-var":    "my-val",
+				"my-number": 3,
+			},
+			StateMachines: []config.StateMachine{{
+				ID:            "machine",
+				Stages:        []string{"start", "finish"},
+				InitialStages: []string{"start"},
+				FinalStages:   []string{"finish"},
+				Transitions:   []config.StateMachineTransition{{From: []string{"start"}, To: []string{"finish"}}},
+			}},
+			Workflows: []config.Workflow{{
+				ID:           "feature",
+				StateMachine: "machine",
+				Stages: []config.Stage{
+					{ID: "start", Args: []string{"<arg | Argument>"}, Actions: []config.Command{{Run: "echo $<arg>"}}},
+					{
+						ID:         "finish",
+						Conditions: []config.Command{{Run: "true"}},
+						Actions:    []config.Command{{Run: "echo done"}, {Run: "echo bye"}},
+					},
+				},
+			}},
+		},
+		Metadata: w.WorkflowMetadata{
+			Version:  0xDEDEDEDE,
+			Started:  0xEFEFEFEF,
+			Updated:  0xABABABAB,
+			Finished: 0xBCBCBCBC,
+		},
+	}
+}
+
+var _ = Describe("Bolt store", func() {
+
+	workflow := testWorkflow()
+
+	Context("Sharing the DB", func() {
+
+		It("should report which process holds the DB instead of waiting forever", func() {
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+
+			db, err := bolt.Open(".flowitDS", 0600, nil)
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(".flowitDS.holder", []byte("1234\nfeature a1b2c3 publish"), 0600)).To(Succeed())
+
+			_, err = rs.GetAllWorkflows(false)
+			Expect(db.Close()).To(Succeed())
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Database busy, held by PID 1234 running feature a1b2c3 publish"))
+
+			_, err = rs.GetAllWorkflows(false)
+			Expect(err).To(BeNil())
+			_, err = os.Stat(".flowitDS.holder")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+		})
+
+	})
+
+	Context("Reading records written by previous versions", func() {
+
+		for _, version := range []string{"v0", "v1"} {
+
+			fixture := "testdata/" + version + ".flowitDS"
+
+			It("should read and upgrade the workflows in "+fixture, func() {
+
+				content, err := ioutil.ReadFile(fixture)
+				Expect(err).To(BeNil())
+				Expect(ioutil.WriteFile(".flowitDS", content, 0600)).To(Succeed())
+
+				rs := r.NewBoltStore(".flowitDS")
+				defer rs.Drop()
+
+				optionalWorkflow, err := rs.GetWorkflow("feature", "1")
+				Expect(err).To(BeNil())
+				storedWorkflow, err := optionalWorkflow.Get()
+				Expect(err).To(BeNil())
+				Expect(storedWorkflow).To(Equal(fixtureWorkflow()))
+
+				storedWorkflow.Metadata.Version++
+				Expect(rs.PutWorkflow(storedWorkflow)).To(Succeed())
+				optionalWorkflow, err = rs.GetWorkflow("feature", "1")
+				Expect(err).To(BeNil())
+				upgradedWorkflow, err := optionalWorkflow.Get()
+				Expect(err).To(BeNil())
+				Expect(upgradedWorkflow).To(Equal(storedWorkflow))
+
+			})
+
+		}
+
+		It("should refuse records written by a newer version", func() {
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+			Expect(rs.PutWorkflow(workflow)).To(Succeed())
+
+			db, err := bolt.Open(".flowitDS", 0600, nil)
+			Expect(err).To(BeNil())
+			err = db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("workflows_definition")).Put([]byte(workflow.ID), []byte("FLWT\x63{}"))
+			})
+			Expect(err).To(BeNil())
+			Expect(db.Close()).To(Succeed())
+
+			_, err = rs.GetWorkflow("definition", workflow.ID)
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unsupported record version: 99"))
+
+		})
+
+	})
+
+	Context("Storing workflow definitions", func() {
+
+		countDefinitions := func() int {
+			db, err := bolt.Open(".flowitDS", 0600, nil)
+			Expect(err).To(BeNil())
+			defer db.Close()
+			count := 0
+			err = db.View(func(tx *bolt.Tx) error {
+				count = tx.Bucket([]byte("definitions")).Stats().KeyN
+				return nil
+			})
+			Expect(err).To(BeNil())
+			return count
+		}
+
+		It("should store a definition shared by several workflows only once", func() {
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+
+			workflow1 := workflow
+			workflow1.ID = "1"
+			workflow2 := workflow
+			workflow2.ID = "2"
+			workflow2.State.Variables = map[string]interface{}{
+				"my-var": "my-other-val",
+			}
+			workflow3 := workflow
+			workflow3.ID = "3"
+			workflow3.State.Version = "0.2"
+
+			Expect(rs.PutWorkflow(workflow1)).To(Succeed())
+			Expect(rs.PutWorkflow(workflow2)).To(Succeed())
+			Expect(rs.PutWorkflow(workflow3)).To(Succeed())
+			Expect(countDefinitions()).To(Equal(2))
+
+			workflows, err := rs.GetWorkflows("definition", 0, false)
+			Expect(err).To(BeNil())
+			Expect(workflows).To(ConsistOf(workflow1, workflow2, workflow3))
+
+		})
+
+		It("should migrate workflows embedding their definition", func() {
+
+			// testdata/baseline.flowitDS was written by flowit before workflow definitions were stored on their own
+			content, err := ioutil.ReadFile("testdata/baseline.flowitDS")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(".flowitDS", content, 0600)).To(Succeed())
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", "1")
+			Expect(err).To(BeNil())
+			migratedWorkflow, err := optionalWorkflow.Get()
+			Expect(err).To(BeNil())
+			Expect(migratedWorkflow).To(Equal(baselineWorkflow()))
+			Expect(countDefinitions()).To(Equal(1))
+
+			migratedWorkflow.Metadata.Version++
+			Expect(rs.PutWorkflow(migratedWorkflow)).To(Succeed())
+			optionalWorkflow, err = rs.GetWorkflow("feature", "1")
+			Expect(err).To(BeNil())
+			storedWorkflow, err := optionalWorkflow.Get()
+			Expect(err).To(BeNil())
+			Expect(storedWorkflow).To(Equal(migratedWorkflow))
+
+		})
+
+		It("should keep the initial stage of workflows embedding their definition", func() {
+
+			content, err := ioutil.ReadFile("testdata/baseline.flowitDS")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(".flowitDS", content, 0600)).To(Succeed())
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", "1")
+			Expect(err).To(BeNil())
+			migratedWorkflow, err := optionalWorkflow.Get()
+			Expect(err).To(BeNil())
+			Expect(migratedWorkflow.State.StateMachines[0].InitialStages).To(Equal([]string{"start"}))
+
+		})
+
+		It("should remove unused definitions and shrink the DB file when compacting", func() {
+
+			rs := r.NewBoltStore(".flowitDS")
+			defer rs.Drop()
+
+			for i := 0; i < 200; i++ {
+				largeWorkflow := workflow
+				largeWorkflow.ID = strconv.Itoa(i)
+				largeWorkflow.State.Version = strconv.Itoa(i % 2)
+				largeWorkflow.State.Variables = map[string]interface{}{
+					"my-var": strings.Repeat("x", 4096),
+				}
+				Expect(rs.PutWorkflow(largeWorkflow)).To(Succeed())
+			}
+			for i := 1; i < 200; i++ {
+				Expect(rs.DeleteWorkflow("definition", strconv.Itoa(i))).To(Succeed())
+			}
+			before, err := os.Stat(".flowitDS")
+			Expect(err).To(BeNil())
+
+			Expect(rs.Compact()).To(Succeed())
+			after, err := os.Stat(".flowitDS")
+			Expect(err).To(BeNil())
+			Expect(after.Size()).To(BeNumerically("<", before.Size()))
+			Expect(countDefinitions()).To(Equal(1))
+
+			workflows, err := rs.GetAllWorkflows(false)
+			Expect(err).To(BeNil())
+			Expect(len(workflows)).To(Equal(1))
+
+		})
+
+	})
+
+})
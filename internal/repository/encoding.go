@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/config"
+	"github.com/yamil-rivera/flowit/internal/utils"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+const workflowsBucketPrefix = "workflows_"
+const definitionsBucket = "definitions"
+const metadataBucket = "metadata"
+const leasesBucket = "leases"
+const auditBucket = "audit"
+const schemaVersionKey = "schema-version"
+
+// schemaVersion is increased every time the way workflows are laid out in the DB changes
+// Version 1 stores workflow definitions in their own bucket instead of embedding them in every workflow
+const schemaVersion = "1"
+
+// workflowRecord is the persisted representation of a workflow
+// The workflow definition snapshot is stored once in the definitions bucket and referenced by DefinitionKey.
+// Only the variables, which are populated per workflow instance, are kept in the record
+type workflowRecord struct {
+	ID              string
+	Preffix         string
+	Alias           string
+	Name            string
+	SchemaVersion   string
+	IsActive        bool
+	IsCancelled     bool
+	Executions      []w.Execution
+	LatestExecution *w.Execution
+	DefinitionKey   string
+	DefinitionHash  string
+	Variables       map[string]interface{}
+	ParentName      string
+	ParentID        string
+	Children        []w.Child
+	Metadata        w.WorkflowMetadata
+}
+
+func newWorkflowRecord(workflow w.Workflow, definitionKey string) workflowRecord {
+	return workflowRecord{
+		ID:              workflow.ID,
+		Preffix:         workflow.Preffix,
+		Alias:           workflow.Alias,
+		Name:            workflow.Name,
+		SchemaVersion:   workflow.SchemaVersion,
+		IsActive:        workflow.IsActive,
+		IsCancelled:     workflow.IsCancelled,
+		Executions:      workflow.Executions,
+		LatestExecution: workflow.LatestExecution,
+		DefinitionKey:   definitionKey,
+		DefinitionHash:  workflow.DefinitionHash,
+		Variables:       workflow.State.Variables,
+		ParentName:      workflow.ParentName,
+		ParentID:        workflow.ParentID,
+		Children:        workflow.Children,
+		Metadata:        workflow.Metadata,
+	}
+}
+
+// workflow rebuilds the workflow from the record and the definition it references
+func (record workflowRecord) workflow(definition config.Flowit) w.Workflow {
+	definition.Variables = record.Variables
+	return w.Workflow{
+		ID:              record.ID,
+		Preffix:         record.Preffix,
+		Alias:           record.Alias,
+		Name:            record.Name,
+		SchemaVersion:   record.SchemaVersion,
+		IsActive:        record.IsActive,
+		IsCancelled:     record.IsCancelled,
+		Executions:      record.Executions,
+		LatestExecution: record.LatestExecution,
+		State:           definition,
+		DefinitionHash:  record.DefinitionHash,
+		ParentName:      record.ParentName,
+		ParentID:        record.ParentID,
+		Children:        record.Children,
+		Metadata:        record.Metadata,
+	}
+}
+
+// definitionCache decodes each workflow definition at most once within a transaction
+type definitionCache struct {
+	tx          *bolt.Tx
+	definitions map[string]config.Flowit
+}
+
+func newDefinitionCache(tx *bolt.Tx) *definitionCache {
+	return &definitionCache{tx, make(map[string]config.Flowit)}
+}
+
+func (c *definitionCache) definition(key string) (config.Flowit, error) {
+	if definition, ok := c.definitions[key]; ok {
+		return definition, nil
+	}
+	var definition config.Flowit
+	b := c.tx.Bucket([]byte(definitionsBucket))
+	if b == nil {
+		return definition, errors.New("Bucket " + definitionsBucket + " does not exist")
+	}
+	entry := b.Get([]byte(key))
+	if entry == nil {
+		return definition, errors.New("Workflow definition " + key + " does not exist")
+	}
+	definition, err := decodeDefinition(entry)
+	if err != nil {
+		return definition, errors.WithStack(err)
+	}
+	c.definitions[key] = definition
+	return definition, nil
+}
+
+// decodeWorkflow decodes a workflow record of any record version, upgrading it to the current workflow layout
+// Records written with a previous record version are rewritten with the current one the next time they are saved
+func (c *definitionCache) decodeWorkflow(buf []byte) (*w.Workflow, error) {
+	record, err := decodeWorkflowRecord(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	definition, err := c.definition(record.DefinitionKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	workflow := record.workflow(definition)
+	return &workflow, nil
+}
+
+// storedWorkflowVersion returns the version of the stored workflow record, if any
+func storedWorkflowVersion(tx *bolt.Tx, workflowName, workflowID string) (uint64, bool, error) {
+	b := tx.Bucket([]byte(workflowsBucketPrefix + workflowName))
+	if b == nil {
+		return 0, false, nil
+	}
+	entry := b.Get([]byte(workflowID))
+	if entry == nil {
+		return 0, false, nil
+	}
+	record, err := decodeWorkflowRecord(entry)
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	return record.Metadata.Version, true, nil
+}
+
+// putWorkflow stores the workflow record in its workflow bucket and its definition snapshot
+// in the definitions bucket unless an identical one is already stored
+func putWorkflow(tx *bolt.Tx, workflow w.Workflow) error {
+	definition, definitionKey, err := workflowDefinition(workflow)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	definitionBytes, err := encodeDefinition(definition)
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode workflow definition")
+	}
+	definitions, err := tx.CreateBucketIfNotExists([]byte(definitionsBucket))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if definitions.Get([]byte(definitionKey)) == nil {
+		if err := definitions.Put([]byte(definitionKey), definitionBytes); err != nil {
+			return errors.Wrap(err, "Error trying to save workflow definition")
+		}
+	}
+
+	recordBytes, err := encodeWorkflowRecord(newWorkflowRecord(workflow, definitionKey))
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode workflow")
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte(workflowsBucketPrefix + workflow.Name))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := bucket.Put([]byte(workflow.ID), recordBytes); err != nil {
+		return errors.Wrap(err, "Error trying to save workflow")
+	}
+	return nil
+}
+
+// workflowDefinition returns the workflow definition snapshot without the instance variables
+// together with its content address
+func workflowDefinition(workflow w.Workflow) (config.Flowit, string, error) {
+	definition := workflow.State
+	definition.Variables = nil
+	digest, err := utils.Sha256(definition)
+	if err != nil {
+		return definition, "", errors.WithStack(err)
+	}
+	return definition, hex.EncodeToString(digest), nil
+}
+
+// encode gob encodes the values which are not persisted as versioned records, such as leases
+func init() {
+	// Variables defined as lists or maps in the workflow definition are held in interface{} values
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+func encode(source interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(source); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// removeOrphanDefinitions deletes the workflow definitions no workflow record references
+func removeOrphanDefinitions(tx *bolt.Tx) error {
+	definitions := tx.Bucket([]byte(definitionsBucket))
+	if definitions == nil {
+		return nil
+	}
+	referenced := make(map[string]bool)
+	if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if !strings.HasPrefix(string(name), workflowsBucketPrefix) {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			record, err := decodeWorkflowRecord(v)
+			if err != nil {
+				return errors.Wrap(err, "Error trying to decode workflow "+string(k))
+			}
+			referenced[record.DefinitionKey] = true
+			return nil
+		})
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+	var orphans [][]byte
+	if err := definitions.ForEach(func(k, v []byte) error {
+		if !referenced[string(k)] {
+			orphans = append(orphans, append([]byte(nil), k...))
+		}
+		return nil
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+	// Keys can not be deleted while iterating the bucket
+	for _, key := range orphans {
+		if err := definitions.Delete(key); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// copyBucket copies every key, including nested buckets, from one bucket into another
+func copyBucket(from, to *bolt.Bucket) error {
+	return from.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return to.Put(k, v)
+		}
+		nested, err := to.CreateBucket(k)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return copyBucket(from.Bucket(k), nested)
+	})
+}
+
+// migrateDB brings a DB created by a previous flowit version up to the current schema version
+// Before schema version 1, every workflow embedded its whole definition snapshot
+func migrateDB(db *bolt.DB) error {
+	upToDate := false
+	if err := db.View(func(tx *bolt.Tx) error {
+		upToDate = currentSchemaVersion(tx) == schemaVersion
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "Error trying to open view transaction")
+	}
+	if upToDate {
+		return nil
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if currentSchemaVersion(tx) == "" {
+			if err := migrateEmbeddedDefinitions(tx); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		metadata, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return metadata.Put([]byte(schemaVersionKey), []byte(schemaVersion))
+	}); err != nil {
+		return errors.Wrap(err, "Error trying to migrate DB")
+	}
+	return nil
+}
+
+func currentSchemaVersion(tx *bolt.Tx) string {
+	metadata := tx.Bucket([]byte(metadataBucket))
+	if metadata == nil {
+		return ""
+	}
+	return string(metadata.Get([]byte(schemaVersionKey)))
+}
+
+// gobEmbeddingWorkflowV0 is the layout of the workflows stored before schema version 1, which embedded their whole
+// definition snapshot. It is frozen, as w.Workflow and config.Flowit have changed in ways gob can not decode since
+type gobEmbeddingWorkflowV0 struct {
+	ID              string
+	Preffix         string
+	Name            string
+	SchemaVersion   string
+	IsActive        bool
+	Executions      []w.Execution
+	LatestExecution *w.Execution
+	State           gobDefinitionV0
+	DefinitionHash  string
+	Metadata        w.WorkflowMetadata
+}
+
+// workflow upgrades the embedding layout to w.Workflow
+func (legacy gobEmbeddingWorkflowV0) workflow() w.Workflow {
+	return w.Workflow{
+		ID:              legacy.ID,
+		Preffix:         legacy.Preffix,
+		Name:            legacy.Name,
+		SchemaVersion:   legacy.SchemaVersion,
+		IsActive:        legacy.IsActive,
+		Executions:      legacy.Executions,
+		LatestExecution: legacy.LatestExecution,
+		State:           legacy.State.definition(),
+		DefinitionHash:  legacy.DefinitionHash,
+		Metadata:        legacy.Metadata,
+	}
+}
+
+func migrateEmbeddedDefinitions(tx *bolt.Tx) error {
+	var workflows []w.Workflow
+	if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if !strings.HasPrefix(string(name), workflowsBucketPrefix) {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var legacy gobEmbeddingWorkflowV0
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&legacy); err != nil {
+				return errors.Wrap(err, "Error trying to decode workflow "+string(k))
+			}
+			workflows = append(workflows, legacy.workflow())
+			return nil
+		})
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, workflow := range workflows {
+		if err := putWorkflow(tx, workflow); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+package repository
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/config"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// exportFormatVersion is increased every time the export format changes in a way older flowit versions can not read
+const exportFormatVersion = "1"
+
+// exportDocument is the stable, versioned representation of a set of workflows used to move them between repositories
+// Unlike the Store encodings it does not depend on Go field names, so it is safe to keep exports around
+type exportDocument struct {
+	FormatVersion string             `json:"format-version"`
+	Workflows     []exportedWorkflow `json:"workflows"`
+}
+
+type exportedWorkflow struct {
+	ID             string                 `json:"id"`
+	Preffix        string                 `json:"preffix"`
+	Alias          string                 `json:"alias,omitempty"`
+	Name           string                 `json:"name"`
+	SchemaVersion  string                 `json:"schema-version"`
+	IsActive       bool                   `json:"active"`
+	IsCancelled    bool                   `json:"cancelled"`
+	Executions     []exportedExecution    `json:"executions"`
+	Definition     exportedDefinition     `json:"definition"`
+	DefinitionHash string                 `json:"definition-hash"`
+	Variables      map[string]interface{} `json:"variables"`
+	ParentName     string                 `json:"parent-name,omitempty"`
+	ParentID       string                 `json:"parent-id,omitempty"`
+	Children       []exportedChild        `json:"children,omitempty"`
+	Version        uint64                 `json:"version"`
+	Started        uint64                 `json:"started"`
+	Updated        uint64                 `json:"updated"`
+	Finished       uint64                 `json:"finished"`
+}
+
+type exportedChild struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Stage string `json:"stage"`
+}
+
+type exportedExecution struct {
+	ID         string   `json:"id"`
+	FromStage  string   `json:"from-stage"`
+	Stage      string   `json:"stage"`
+	Args       []string `json:"args"`
+	Checkpoint int      `json:"checkpoint"`
+	// CheckpointSucceeded holds the members of the parallel group at the checkpoint which already succeeded
+	CheckpointSucceeded []int                   `json:"checkpoint-succeeded,omitempty"`
+	Failed              bool                    `json:"failed"`
+	FailedStage         string                  `json:"failed-stage,omitempty"`
+	Approval            *exportedApproval       `json:"approval,omitempty"`
+	Results             []exportedCommandResult `json:"results,omitempty"`
+	Version             uint64                  `json:"version"`
+	Started             uint64                  `json:"started"`
+	Finished            uint64                  `json:"finished"`
+}
+
+type exportedApproval struct {
+	RequestedBy string `json:"requested-by"`
+	ApprovedBy  string `json:"approved-by,omitempty"`
+	Approved    uint64 `json:"approved,omitempty"`
+}
+
+type exportedCommandResult struct {
+	Command  string `json:"command"`
+	Output   string `json:"output"`
+	Failed   bool   `json:"failed"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Started  uint64 `json:"started"`
+	Finished uint64 `json:"finished"`
+}
+
+// exportedDefinition is the workflow definition snapshot without its variables, which are exported per workflow
+type exportedDefinition struct {
+	Version       string                 `json:"version"`
+	Checkpoints   bool                   `json:"checkpoints"`
+	Shell         string                 `json:"shell"`
+	VariableMode  string                 `json:"variable-mode,omitempty"`
+	Env           map[string]string      `json:"env,omitempty"`
+	CleanEnv      bool                   `json:"clean-env,omitempty"`
+	AllowEnv      []string               `json:"allow-env,omitempty"`
+	Repository    exportedRepository     `json:"repository"`
+	Retention     exportedRetention      `json:"retention"`
+	Audit         exportedAudit          `json:"audit"`
+	StateMachines []exportedStateMachine `json:"state-machines"`
+	Workflows     []exportedWorkflowDef  `json:"workflows"`
+}
+
+type exportedRepository struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+}
+
+type exportedRetention struct {
+	Days    int    `json:"days,omitempty"`
+	Keep    int    `json:"keep,omitempty"`
+	Archive string `json:"archive,omitempty"`
+}
+
+type exportedAudit struct {
+	File string `json:"file,omitempty"`
+}
+
+type exportedStateMachine struct {
+	ID            string   `json:"id"`
+	Stages        []string `json:"stages"`
+	InitialStages []string `json:"initial-stages"`
+	// InitialStage is only read, from exports and records written when state machines had a single initial stage
+	InitialStage string               `json:"initial-stage,omitempty"`
+	FinalStages  []string             `json:"final-stages"`
+	Transitions  []exportedTransition `json:"transitions"`
+}
+
+type exportedTransition struct {
+	From  []string `json:"from"`
+	To    []string `json:"to"`
+	Guard string   `json:"guard,omitempty"`
+}
+
+type exportedWorkflowDef struct {
+	ID           string            `json:"id"`
+	StateMachine string            `json:"state-machine"`
+	Env          map[string]string `json:"env,omitempty"`
+	Workdir      string            `json:"workdir,omitempty"`
+	Stages       []exportedStage   `json:"stages"`
+}
+
+type exportedStage struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Conditions []exportedCommand `json:"conditions,omitempty"`
+	Actions    []exportedCommand `json:"actions"`
+	Spawn      []exportedSpawn   `json:"spawn,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Workdir    string            `json:"workdir,omitempty"`
+	Confirm    string            `json:"confirm,omitempty"`
+}
+
+// exportedCommand is written as a plain string unless it is a parallel group, a git command or it has options
+type exportedCommand struct {
+	Run            string              `json:"run,omitempty"`
+	Parallel       []string            `json:"parallel,omitempty"`
+	Git            *exportedGitCommand `json:"git,omitempty"`
+	MaxConcurrency int                 `json:"max-concurrency,omitempty"`
+	FailFast       bool                `json:"fail-fast,omitempty"`
+	When           string              `json:"when,omitempty"`
+	Foreach        string              `json:"foreach,omitempty"`
+	As             string              `json:"as,omitempty"`
+	Confirm        string              `json:"confirm,omitempty"`
+}
+
+type exportedGitCommand struct {
+	Action string `json:"action"`
+	Branch string `json:"branch,omitempty"`
+	From   string `json:"from,omitempty"`
+	Onto   string `json:"onto,omitempty"`
+	Into   string `json:"into,omitempty"`
+	Remote string `json:"remote,omitempty"`
+}
+
+// exportedCommandGroup has the default JSON encoding of exportedCommand
+type exportedCommandGroup exportedCommand
+
+func (command exportedCommand) MarshalJSON() ([]byte, error) {
+	if len(command.Parallel) == 0 && command.Git == nil &&
+		command.When == "" && command.Foreach == "" && command.Confirm == "" {
+		return json.Marshal(command.Run)
+	}
+	return json.Marshal(exportedCommandGroup(command))
+}
+
+func (command *exportedCommand) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &command.Run); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*exportedCommandGroup)(command))
+}
+
+func newExportedCommands(commands []config.Command) []exportedCommand {
+	var exported []exportedCommand
+	for _, command := range commands {
+		converted := exportedCommand{
+			Run:            command.Run,
+			Parallel:       command.Parallel,
+			MaxConcurrency: command.MaxConcurrency,
+			FailFast:       command.FailFast,
+			When:           command.When,
+			Foreach:        command.Foreach,
+			As:             command.As,
+			Confirm:        command.Confirm,
+		}
+		if command.Git != nil {
+			git := exportedGitCommand(*command.Git)
+			converted.Git = &git
+		}
+		exported = append(exported, converted)
+	}
+	return exported
+}
+
+func importedCommands(exported []exportedCommand) []config.Command {
+	var commands []config.Command
+	for _, converted := range exported {
+		command := config.Command{
+			Run:            converted.Run,
+			Parallel:       converted.Parallel,
+			MaxConcurrency: converted.MaxConcurrency,
+			FailFast:       converted.FailFast,
+			When:           converted.When,
+			Foreach:        converted.Foreach,
+			As:             converted.As,
+			Confirm:        converted.Confirm,
+		}
+		if converted.Git != nil {
+			git := config.GitCommand(*converted.Git)
+			command.Git = &git
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+type exportedSpawn struct {
+	Workflow  string            `json:"workflow"`
+	Stage     string            `json:"stage,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// WriteExport writes the export document holding the workflows into writer
+func WriteExport(writer io.Writer, workflows []w.Workflow) error {
+	document := exportDocument{
+		FormatVersion: exportFormatVersion,
+		Workflows:     make([]exportedWorkflow, len(workflows)),
+	}
+	for i, workflow := range workflows {
+		document.Workflows[i] = newExportedWorkflow(workflow)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return errors.WithStack(encoder.Encode(document))
+}
+
+// WriteArchive writes the export document holding the workflows into a new gzip compressed file
+// within directory and returns its location
+func WriteArchive(directory string, workflows []w.Workflow, now time.Time) (string, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil { // nolint:gosec
+		return "", errors.WithStack(err)
+	}
+	file := filepath.Join(directory, "flowit-"+now.Format("20060102-150405.000000000")+jsonExtension+".gz")
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644) // nolint:gosec
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	compressor := gzip.NewWriter(f)
+	err = WriteExport(compressor, workflows)
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file) // nolint:errcheck,gosec
+		return "", errors.Wrap(err, "Error trying to write archive")
+	}
+	return file, nil
+}
+
+// ReadExport reads the workflows held by an export document
+// Gzip compressed documents, such as archives, are decompressed transparently
+func ReadExport(reader io.Reader) ([]w.Workflow, error) {
+	buffered := bufio.NewReader(reader)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		decompressor, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error trying to decompress export")
+		}
+		defer decompressor.Close() // nolint:errcheck
+		reader = decompressor
+	} else {
+		reader = buffered
+	}
+
+	var document exportDocument
+	decoder := json.NewDecoder(reader)
+	// Numbers are kept as json.Number so variables get back the integer type workflow definitions produce
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, errors.Wrap(err, "Error trying to decode export")
+	}
+	if document.FormatVersion != exportFormatVersion {
+		return nil, errors.Errorf("Unsupported export format version: %s. Expected version: %s",
+			document.FormatVersion, exportFormatVersion)
+	}
+	workflows := make([]w.Workflow, len(document.Workflows))
+	for i, exported := range document.Workflows {
+		workflows[i] = exported.workflow()
+	}
+	return workflows, nil
+}
+
+func newExportedWorkflow(workflow w.Workflow) exportedWorkflow {
+	executions := make([]exportedExecution, len(workflow.Executions))
+	for i, execution := range workflow.Executions {
+		// The latest execution is the most up to date copy of the first execution in the history
+		if i == 0 && workflow.LatestExecution != nil && workflow.LatestExecution.ID == execution.ID {
+			execution = *workflow.LatestExecution
+		}
+		executions[i] = newExportedExecution(execution)
+	}
+	var children []exportedChild
+	for _, child := range workflow.Children {
+		children = append(children, exportedChild(child))
+	}
+	return exportedWorkflow{
+		ID:             workflow.ID,
+		Preffix:        workflow.Preffix,
+		Alias:          workflow.Alias,
+		Name:           workflow.Name,
+		SchemaVersion:  workflow.SchemaVersion,
+		IsActive:       workflow.IsActive,
+		IsCancelled:    workflow.IsCancelled,
+		Executions:     executions,
+		Definition:     newExportedDefinition(workflow.State),
+		DefinitionHash: workflow.DefinitionHash,
+		Variables:      workflow.State.Variables,
+		ParentName:     workflow.ParentName,
+		ParentID:       workflow.ParentID,
+		Children:       children,
+		Version:        workflow.Metadata.Version,
+		Started:        workflow.Metadata.Started,
+		Updated:        workflow.Metadata.Updated,
+		Finished:       workflow.Metadata.Finished,
+	}
+}
+
+func (exported exportedWorkflow) workflow() w.Workflow {
+	var variables map[string]interface{}
+	if exported.Variables != nil {
+		variables = make(map[string]interface{}, len(exported.Variables))
+		for name, value := range exported.Variables {
+			variables[name] = importedValue(value)
+		}
+	}
+	definition := exported.Definition.definition()
+	definition.Variables = variables
+	workflow := w.Workflow{
+		ID:             exported.ID,
+		Preffix:        exported.Preffix,
+		Alias:          exported.Alias,
+		Name:           exported.Name,
+		SchemaVersion:  exported.SchemaVersion,
+		IsActive:       exported.IsActive,
+		IsCancelled:    exported.IsCancelled,
+		State:          definition,
+		DefinitionHash: exported.DefinitionHash,
+		ParentName:     exported.ParentName,
+		ParentID:       exported.ParentID,
+		Metadata: w.WorkflowMetadata{
+			Version:  exported.Version,
+			Started:  exported.Started,
+			Updated:  exported.Updated,
+			Finished: exported.Finished,
+		},
+	}
+	for _, execution := range exported.Executions {
+		workflow.Executions = append(workflow.Executions, execution.execution())
+	}
+	for _, child := range exported.Children {
+		workflow.Children = append(workflow.Children, w.Child(child))
+	}
+	if len(workflow.Executions) > 0 {
+		latestExecution := workflow.Executions[0]
+		workflow.LatestExecution = &latestExecution
+	}
+	return workflow
+}
+
+func newExportedExecution(execution w.Execution) exportedExecution {
+	exported := exportedExecution{
+		ID:                  execution.ID,
+		FromStage:           execution.FromStage,
+		Stage:               execution.Stage,
+		Args:                execution.Args,
+		Checkpoint:          execution.Checkpoint,
+		CheckpointSucceeded: execution.CheckpointSucceeded,
+		Failed:              execution.Failed,
+		FailedStage:         execution.FailedStage,
+		Version:             execution.Metadata.Version,
+		Started:             execution.Metadata.Started,
+		Finished:            execution.Metadata.Finished,
+	}
+	if execution.Approval != nil {
+		approval := exportedApproval(*execution.Approval)
+		exported.Approval = &approval
+	}
+	for _, result := range execution.Results {
+		exported.Results = append(exported.Results, exportedCommandResult(result))
+	}
+	return exported
+}
+
+func (exported exportedExecution) execution() w.Execution {
+	execution := w.Execution{
+		ID:                  exported.ID,
+		FromStage:           exported.FromStage,
+		Stage:               exported.Stage,
+		Args:                exported.Args,
+		Checkpoint:          exported.Checkpoint,
+		CheckpointSucceeded: exported.CheckpointSucceeded,
+		Failed:              exported.Failed,
+		FailedStage:         exported.FailedStage,
+		Metadata: w.ExecutionMetadata{
+			Version:  exported.Version,
+			Started:  exported.Started,
+			Finished: exported.Finished,
+		},
+	}
+	if exported.Approval != nil {
+		approval := w.Approval(*exported.Approval)
+		execution.Approval = &approval
+	}
+	for _, result := range exported.Results {
+		execution.Results = append(execution.Results, w.CommandResult(result))
+	}
+	return execution
+}
+
+func newExportedDefinition(definition config.Flowit) exportedDefinition {
+	exported := exportedDefinition{
+		Version:      definition.Version,
+		Checkpoints:  definition.Config.CheckpointExecution,
+		Shell:        definition.Config.Shell,
+		VariableMode: definition.Config.VariableMode,
+		Env:          definition.Config.Env,
+		CleanEnv:     definition.Config.CleanEnv,
+		AllowEnv:     definition.Config.AllowEnv,
+		Repository:   exportedRepository(definition.Config.Repository),
+		Retention:    exportedRetention(definition.Config.Retention),
+		Audit:        exportedAudit(definition.Config.Audit),
+	}
+	for _, stateMachine := range definition.StateMachines {
+		exportedStateMachine := exportedStateMachine{
+			ID:            stateMachine.ID,
+			Stages:        stateMachine.Stages,
+			InitialStages: stateMachine.InitialStages,
+			FinalStages:   stateMachine.FinalStages,
+		}
+		for _, transition := range stateMachine.Transitions {
+			exportedStateMachine.Transitions = append(exportedStateMachine.Transitions, exportedTransition(transition))
+		}
+		exported.StateMachines = append(exported.StateMachines, exportedStateMachine)
+	}
+	for _, workflow := range definition.Workflows {
+		exportedWorkflow := exportedWorkflowDef{
+			ID:           workflow.ID,
+			StateMachine: workflow.StateMachine,
+			Env:          workflow.Env,
+			Workdir:      workflow.Workdir,
+		}
+		for _, stage := range workflow.Stages {
+			exportedWorkflowStage := exportedStage{
+				ID:         stage.ID,
+				Type:       stage.Type,
+				Args:       stage.Args,
+				Conditions: newExportedCommands(stage.Conditions),
+				Actions:    newExportedCommands(stage.Actions),
+				Env:        stage.Env,
+				Workdir:    stage.Workdir,
+				Confirm:    stage.Confirm,
+			}
+			for _, spawn := range stage.Spawn {
+				exportedWorkflowStage.Spawn = append(exportedWorkflowStage.Spawn, exportedSpawn(spawn))
+			}
+			exportedWorkflow.Stages = append(exportedWorkflow.Stages, exportedWorkflowStage)
+		}
+		exported.Workflows = append(exported.Workflows, exportedWorkflow)
+	}
+	return exported
+}
+
+func (exported exportedDefinition) definition() config.Flowit {
+	definition := config.Flowit{
+		Version: exported.Version,
+		Config: config.Config{
+			CheckpointExecution: exported.Checkpoints,
+			Shell:               exported.Shell,
+			VariableMode:        exported.VariableMode,
+			Env:                 exported.Env,
+			CleanEnv:            exported.CleanEnv,
+			AllowEnv:            exported.AllowEnv,
+			Repository:          config.Repository(exported.Repository),
+			Retention:           config.Retention(exported.Retention),
+			Audit:               config.Audit(exported.Audit),
+		},
+	}
+	for _, exportedStateMachine := range exported.StateMachines {
+		stateMachine := config.StateMachine{
+			ID:            exportedStateMachine.ID,
+			Stages:        exportedStateMachine.Stages,
+			InitialStages: exportedStateMachine.InitialStages,
+			FinalStages:   exportedStateMachine.FinalStages,
+		}
+		if len(stateMachine.InitialStages) == 0 && exportedStateMachine.InitialStage != "" {
+			stateMachine.InitialStages = []string{exportedStateMachine.InitialStage}
+		}
+		for _, transition := range exportedStateMachine.Transitions {
+			stateMachine.Transitions = append(stateMachine.Transitions, config.StateMachineTransition(transition))
+		}
+		definition.StateMachines = append(definition.StateMachines, stateMachine)
+	}
+	for _, exportedWorkflow := range exported.Workflows {
+		workflow := config.Workflow{
+			ID:           exportedWorkflow.ID,
+			StateMachine: exportedWorkflow.StateMachine,
+			Env:          exportedWorkflow.Env,
+			Workdir:      exportedWorkflow.Workdir,
+		}
+		for _, stage := range exportedWorkflow.Stages {
+			definitionStage := config.Stage{
+				ID:         stage.ID,
+				Type:       stage.Type,
+				Args:       stage.Args,
+				Conditions: importedCommands(stage.Conditions),
+				Actions:    importedCommands(stage.Actions),
+				Env:        stage.Env,
+				Workdir:    stage.Workdir,
+				Confirm:    stage.Confirm,
+			}
+			for _, spawn := range stage.Spawn {
+				definitionStage.Spawn = append(definitionStage.Spawn, config.Spawn(spawn))
+			}
+			workflow.Stages = append(workflow.Stages, definitionStage)
+		}
+		definition.Workflows = append(definition.Workflows, workflow)
+	}
+	return definition
+}
+
+// importedValue converts the numbers decoded from an export into the types workflow definitions produce
+func importedValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := number.Int64(); err == nil {
+		return int(i)
+	}
+	f, _ := number.Float64()
+	return f
+}
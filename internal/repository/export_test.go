@@ -0,0 +1,102 @@
+package repository_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/config"
+	r "github.com/yamil-rivera/flowit/internal/repository"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+var _ = Describe("Export", func() {
+
+	It("should import the same workflows it exported", func() {
+		workflow := testWorkflow()
+		workflow.State = config.Flowit{
+			Version: "0.2",
+			Config: config.Config{
+				CheckpointExecution: true,
+				Shell:               "/usr/bin/env bash",
+				VariableMode:        config.PositionalVariables,
+				Env:                 map[string]string{"GOFLAGS": "-mod=vendor"},
+				CleanEnv:            true,
+				AllowEnv:            []string{"PATH", "HOME"},
+				Repository:          config.Repository{Type: "bolt", Location: ".flowitDS"},
+				Retention:           config.Retention{Days: 30, Keep: 10, Archive: ".flowit-archive"},
+				Audit:               config.Audit{File: ".flowit-audit.jsonl"},
+			},
+			Variables: map[string]interface{}{
+				"my-var":    "my-val",
+				"my-number": 3,
+			},
+			StateMachines: []config.StateMachine{{
+				ID:            "machine",
+				Stages:        []string{"stage", "review", "final"},
+				InitialStages: []string{"stage"},
+				FinalStages:   []string{"final"},
+				Transitions:   []config.StateMachineTransition{{From: []string{"stage"}, To: []string{"final"}, Guard: "true"}},
+			}},
+			Workflows: []config.Workflow{{
+				ID:           "definition",
+				StateMachine: "machine",
+				Env:          map[string]string{"ISSUE": "$<arg>"},
+				Workdir:      "services",
+				Stages: []config.Stage{
+					{ID: "stage", Args: []string{"<arg | Argument>"}, Actions: []config.Command{{Run: "echo $<arg>"}},
+						Env: map[string]string{"STAGE": "stage"}, Workdir: "$<arg>"},
+					{ID: "review", Type: config.ApprovalStage, Confirm: "Review $<arg>?",
+						Actions: []config.Command{{Run: "echo review", Confirm: "Request a review?"},
+							{Git: &config.GitCommand{
+								Action: config.GitMerge, Branch: "feature/$<arg>", Into: "master", Remote: "upstream",
+							}}}},
+					{ID: "final", Actions: []config.Command{{Run: "echo done"}},
+						Conditions: []config.Command{{Parallel: []string{"true", "echo"}, MaxConcurrency: 1, FailFast: true}},
+						Spawn:      []config.Spawn{{Workflow: "definition", Variables: map[string]string{"arg": "$<arg>"}}}},
+				},
+			}},
+		}
+		workflow.DefinitionHash = workflow.State.Hash()
+
+		var export bytes.Buffer
+		Expect(r.WriteExport(&export, []w.Workflow{workflow})).To(Succeed())
+		Expect(export.String()).To(ContainSubstring(`"format-version": "1"`))
+
+		workflows, err := r.ReadExport(&export)
+		Expect(err).To(BeNil())
+		Expect(workflows).To(Equal([]w.Workflow{workflow}))
+	})
+
+	It("should read the single initial stage of older exports", func() {
+		workflows, err := r.ReadExport(strings.NewReader(`{"format-version": "1", "workflows": [{"id": "1", "name": "feature",
+			"definition": {"state-machines": [{"id": "machine", "initial-stage": "stage"}]}}]}`))
+		Expect(err).To(BeNil())
+		Expect(workflows[0].State.StateMachines[0].InitialStages).To(Equal([]string{"stage"}))
+	})
+
+	It("should write stage commands which are not parallel groups as plain strings", func() {
+		workflows, err := r.ReadExport(strings.NewReader(`{"format-version": "1", "workflows": [{"id": "1", "name": "feature",
+			"definition": {"workflows": [{"id": "feature", "stages": [{"id": "stage",
+			"actions": ["echo", {"parallel": ["lint", "test"], "fail-fast": true}]}]}]}}]}`))
+		Expect(err).To(BeNil())
+		Expect(workflows[0].State.Workflows[0].Stages[0].Actions).To(Equal([]config.Command{
+			{Run: "echo"},
+			{Parallel: []string{"lint", "test"}, FailFast: true},
+		}))
+
+		var export bytes.Buffer
+		Expect(r.WriteExport(&export, workflows)).To(Succeed())
+		Expect(export.String()).To(ContainSubstring(`"echo"`))
+		Expect(export.String()).ToNot(ContainSubstring(`"run"`))
+	})
+
+	It("should refuse exports with an unsupported format version", func() {
+		_, err := r.ReadExport(strings.NewReader(`{"format-version": "99", "workflows": []}`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Unsupported export format version: 99"))
+	})
+
+})
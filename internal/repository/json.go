@@ -0,0 +1,420 @@
+package repository
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/config"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+const jsonExtension = ".json"
+
+// orphanGracePeriod is how long unreferenced workflow definition files are kept by Compact
+const orphanGracePeriod = time.Minute
+
+// JSONStore is the Store implementation that keeps every workflow in its own plain JSON file
+// It is meant to be checked into a repository so a team can share workflow instances
+// The directory layout is:
+//
+//	<location>/definitions/<definition-key>.json
+//	<location>/workflows/<workflow-name>/<workflow-id>.json
+//	<location>/audit.jsonl
+//
+// Workflow reads are not locked, as files are replaced atomically
+type JSONStore struct {
+	location string
+}
+
+// NewJSONStore creates and returns a JSONStore instance persisting workflows under the specified directory
+func NewJSONStore(location string) *JSONStore {
+	return &JSONStore{location}
+}
+
+// Drop removes the store directory and everything in it
+func (rs JSONStore) Drop() error {
+	return errors.WithStack(os.RemoveAll(rs.location))
+}
+
+// Compact removes the workflow definition files no longer referenced by any workflow
+// Files are not locked, so recently written definitions are kept in case their workflow is still being written
+func (rs JSONStore) Compact() error {
+	workflowFiles, err := filepath.Glob(filepath.Join(rs.location, "workflows", "*", "*"+jsonExtension))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	referenced := make(map[string]bool)
+	for _, file := range workflowFiles {
+		// Hidden files are temporary files being written
+		if strings.HasPrefix(filepath.Base(file), ".") {
+			continue
+		}
+		var record workflowRecord
+		if err := readJSONFile(file, &record); err != nil {
+			return errors.WithStack(err)
+		}
+		referenced[record.DefinitionKey] = true
+	}
+	definitionFiles, err := filepath.Glob(rs.definitionFile("*"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, file := range definitionFiles {
+		key := strings.TrimSuffix(filepath.Base(file), jsonExtension)
+		info, err := os.Stat(file)
+		if err != nil || referenced[key] || strings.HasPrefix(key, ".") || time.Since(info.ModTime()) < orphanGracePeriod {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Error trying to remove workflow definition "+key)
+		}
+	}
+	return nil
+}
+
+// PutWorkflow takes a workflow.Workflow struct and saves it into its own file
+// The store is locked while the stored version is checked and replaced, so concurrent updates are always detected
+func (rs JSONStore) PutWorkflow(workflow w.Workflow) error {
+	unlock, err := lockFile(rs.updateLockFile())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer unlock()
+
+	var stored workflowRecord
+	err = readJSONFile(rs.workflowFile(workflow.Name, workflow.ID), &stored)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		if err := checkVersion(stored.Metadata.Version, workflow); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	definition, definitionKey, err := workflowDefinition(workflow)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	definitionFile := rs.definitionFile(definitionKey)
+	if _, err := os.Stat(definitionFile); os.IsNotExist(err) {
+		if err := writeJSONFile(definitionFile, definition); err != nil {
+			return errors.Wrap(err, "Error trying to save workflow definition")
+		}
+	}
+
+	if err := writeJSONFile(rs.workflowFile(workflow.Name, workflow.ID),
+		newWorkflowRecord(workflow, definitionKey)); err != nil {
+		return errors.Wrap(err, "Error trying to save workflow")
+	}
+	return nil
+}
+
+// DeleteWorkflow takes a workflowName and workflowID and removes the workflow file
+// If the workflow does not exist, an error is returned
+func (rs JSONStore) DeleteWorkflow(workflowName, workflowID string) error {
+	unlock, err := lockFile(rs.updateLockFile())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer unlock()
+	if err := os.Remove(rs.workflowFile(workflowName, workflowID)); err != nil {
+		return errors.Wrap(err, "Error trying to delete workflow")
+	}
+	return nil
+}
+
+// GetWorkflowFromPreffix takes a workflowName and workflowPreffix and returns a workflow
+// which ID begins with the preffix wrapped in an optional.
+// If no workflow is found, an empty optional is returned. If several workflows are found, an error is returned
+func (rs JSONStore) GetWorkflowFromPreffix(workflowName, workflowPreffix string) (w.OptionalWorkflow, error) {
+	workflows, err := rs.workflowsNamed(workflowName)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	return findWorkflowFromPreffix(workflows, workflowPreffix)
+}
+
+// GetWorkflow takes a workflowName and workflowID and returns the workflow which ID exactly matches the workflowID
+// wrapped in an optional.
+// If no workflow is found, an empty optional is returned
+func (rs JSONStore) GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error) {
+	workflow, err := rs.readWorkflow(rs.workflowFile(workflowName, workflowID), make(map[string]config.Flowit))
+	if os.IsNotExist(errors.Cause(err)) {
+		return w.OptionalWorkflow{}, nil
+	}
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	return w.NewWorkflowOptional(workflow), nil
+}
+
+// GetWorkflows takes a workflowName, an integer 'n' and whether or not inactive workflows are excluded
+// and returns a list of 'n' workflows that match the criteria.
+// If n is 0, all existing workflows that match the criteria are returned
+func (rs JSONStore) GetWorkflows(workflowName string, n int, excludeInactive bool) ([]w.Workflow, error) {
+	workflows, err := rs.workflowsNamed(workflowName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return selectWorkflows(workflows, n, excludeInactive)
+}
+
+// GetAllWorkflows returns a list of all workflows if excludeInactive is false.
+// It returns all active workflows if excludeInactive is true.
+func (rs JSONStore) GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(rs.location, "workflows"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var allWorkflows []w.Workflow
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		workflows, err := rs.workflowsNamed(entry.Name())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		allWorkflows = append(allWorkflows, workflows...)
+	}
+	return selectWorkflows(allWorkflows, 0, excludeInactive)
+}
+
+// QueryWorkflows returns the workflows matching the query, most recently updated first
+func (rs JSONStore) QueryWorkflows(query Query) ([]w.Workflow, error) {
+	return queryStore(rs, query)
+}
+
+// AcquireLease grants the lease unless another process holds a lease on the same workflow that is not stale
+// Lease files are created atomically so only one of several concurrent processes gets the lease
+func (rs JSONStore) AcquireLease(lease Lease) error {
+	file := rs.leaseFile(lease.WorkflowName, lease.WorkflowID)
+	stored, err := rs.GetLease(lease.WorkflowName, lease.WorkflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := checkLease(stored, lease); err != nil {
+		return errors.WithStack(err)
+	}
+	if stored != nil {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	err = createJSONFile(file, lease)
+	if os.IsExist(errors.Cause(err)) {
+		// Another process got the lease between the check and the creation
+		stored, err := rs.GetLease(lease.WorkflowName, lease.WorkflowID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if stored == nil {
+			return errors.New("Workflow with ID: " + lease.WorkflowID + " is being locked by another process")
+		}
+		return errors.WithStack(LeaseHeldError{*stored})
+	}
+	return errors.Wrap(err, "Error trying to save lease")
+}
+
+// ReleaseLease removes the lease unless it was broken and granted to another process meanwhile
+func (rs JSONStore) ReleaseLease(lease Lease) error {
+	stored, err := rs.GetLease(lease.WorkflowName, lease.WorkflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if stored == nil || !stored.isHeldBy(lease) {
+		return nil
+	}
+	return rs.BreakLease(lease.WorkflowName, lease.WorkflowID)
+}
+
+// BreakLease removes any lease on the workflow regardless of its holder
+func (rs JSONStore) BreakLease(workflowName, workflowID string) error {
+	if err := os.Remove(rs.leaseFile(workflowName, workflowID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error trying to remove lease")
+	}
+	return nil
+}
+
+// GetLease returns the lease on the workflow, if any
+func (rs JSONStore) GetLease(workflowName, workflowID string) (*Lease, error) {
+	var lease Lease
+	err := readJSONFile(rs.leaseFile(workflowName, workflowID), &lease)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error trying to read lease")
+	}
+	return &lease, nil
+}
+
+// PutAuditEvent appends the audit event as a new line of the audit file
+// Appends of a single line are atomic, so events of concurrent processes are never interleaved
+func (rs JSONStore) PutAuditEvent(event audit.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode audit event")
+	}
+	if err := os.MkdirAll(rs.location, 0755); err != nil { // nolint:gosec
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(rs.auditFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644) // nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close() // nolint:errcheck,gosec
+		return errors.Wrap(err, "Error trying to save audit event")
+	}
+	return errors.WithStack(f.Close())
+}
+
+// GetAuditEvents returns the audit events matching the query in the order they were recorded
+func (rs JSONStore) GetAuditEvents(query audit.Query) ([]audit.Event, error) {
+	f, err := os.Open(rs.auditFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close() // nolint:errcheck
+	var events []audit.Event
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var event audit.Event
+		if err := decoder.Decode(&event); err != nil {
+			return nil, errors.Wrap(err, "Error trying to decode audit event")
+		}
+		if query.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// workflowsNamed reads all workflows with the specified name sorted by ID
+func (rs JSONStore) workflowsNamed(workflowName string) ([]w.Workflow, error) {
+	dir := filepath.Join(rs.location, "workflows", workflowName)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	definitions := make(map[string]config.Flowit)
+	workflows := make([]w.Workflow, 0, len(entries))
+	for _, entry := range entries {
+		// Hidden files are temporary files being written
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), jsonExtension) {
+			continue
+		}
+		workflow, err := rs.readWorkflow(filepath.Join(dir, entry.Name()), definitions)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		workflows = append(workflows, workflow)
+	}
+	sortWorkflows(workflows)
+	return workflows, nil
+}
+
+// readWorkflow reads a workflow file and its definition, reusing the already read definitions
+func (rs JSONStore) readWorkflow(file string, definitions map[string]config.Flowit) (w.Workflow, error) {
+	var record workflowRecord
+	if err := readJSONFile(file, &record); err != nil {
+		return w.Workflow{}, errors.WithStack(err)
+	}
+	definition, ok := definitions[record.DefinitionKey]
+	if !ok {
+		if err := readJSONFile(rs.definitionFile(record.DefinitionKey), &definition); err != nil {
+			return w.Workflow{}, errors.Wrap(err, "Error trying to read workflow definition")
+		}
+		definitions[record.DefinitionKey] = definition
+	}
+	return record.workflow(definition), nil
+}
+
+func (rs JSONStore) workflowFile(workflowName, workflowID string) string {
+	return filepath.Join(rs.location, "workflows", workflowName, workflowID+jsonExtension)
+}
+
+func (rs JSONStore) leaseFile(workflowName, workflowID string) string {
+	return filepath.Join(rs.location, "leases", workflowName, workflowID+jsonExtension)
+}
+
+// updateLockFile is locked while workflows are updated. It is hidden like the temporary files being written
+func (rs JSONStore) updateLockFile() string {
+	return filepath.Join(rs.location, ".lock")
+}
+
+func (rs JSONStore) auditFile() string {
+	return filepath.Join(rs.location, "audit.jsonl")
+}
+
+func (rs JSONStore) definitionFile(definitionKey string) string {
+	return filepath.Join(rs.location, "definitions", definitionKey+jsonExtension)
+}
+
+func readJSONFile(file string, target interface{}) error {
+	content, err := ioutil.ReadFile(file) // nolint:gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := json.Unmarshal(content, target); err != nil {
+		return errors.Wrap(err, "Error trying to decode "+file)
+	}
+	return nil
+}
+
+// writeJSONFile writes the indented JSON representation of source into file
+// The file is replaced atomically so readers never see a partially written file
+func writeJSONFile(file string, source interface{}) error {
+	return writeJSONFileWith(file, source, os.Rename)
+}
+
+// createJSONFile behaves like writeJSONFile but fails if the file already exists
+func createJSONFile(file string, source interface{}) error {
+	return writeJSONFileWith(file, source, func(tmpFile, file string) error {
+		return os.Link(tmpFile, file)
+	})
+}
+
+// writeJSONFileWith writes source into a temporary file and moves it into place using place
+func writeJSONFileWith(file string, source interface{}, place func(tmpFile, file string) error) error {
+	content, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil { // nolint:gosec
+		return errors.WithStack(err)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), ".tmp-*"+jsonExtension)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmpFile.Name()) // nolint:errcheck
+	if _, err := tmpFile.Write(append(content, '\n')); err != nil {
+		tmpFile.Close() // nolint:errcheck,gosec
+		return errors.WithStack(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil { // nolint:gosec
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(place(tmpFile.Name(), file))
+}
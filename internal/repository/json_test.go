@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package repository_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+
+	r "github.com/yamil-rivera/flowit/internal/repository"
+)
+
+var _ = Describe("JSON store", func() {
+
+	workflow := testWorkflow()
+
+	Context("Sharing the directory", func() {
+
+		It("should report which process is updating workflows instead of overwriting its update", func() {
+
+			location, err := ioutil.TempDir("", "flowit")
+			Expect(err).To(BeNil())
+			rs := r.NewJSONStore(location)
+			defer rs.Drop()
+
+			// Locks are held by open files, so a second open file of this process stands for another process
+			lock, err := os.OpenFile(filepath.Join(location, ".lock"), os.O_RDWR|os.O_CREATE, 0600)
+			Expect(err).To(BeNil())
+			Expect(unix.Flock(int(lock.Fd()), unix.LOCK_EX)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(location, ".lock.holder"), []byte("1234\nfeature a1b2c3 publish"),
+				0600)).To(Succeed())
+
+			err = rs.PutWorkflow(workflow)
+			Expect(lock.Close()).To(Succeed())
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Database busy, held by PID 1234 running feature a1b2c3 publish"))
+
+			Expect(rs.PutWorkflow(workflow)).To(Succeed())
+			_, err = os.Stat(filepath.Join(location, ".lock.holder"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+		})
+
+	})
+
+})
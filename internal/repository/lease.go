@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lease grants a single process the right to run stages of a workflow instance or otherwise change it until it expires
+type Lease struct {
+	WorkflowName string
+	WorkflowID   string
+	PID          int
+	Host         string
+	Stage        string
+	// Command is the command the holder runs when it changes the workflow without running a stage
+	Command  string
+	Acquired uint64
+	Expires  uint64
+}
+
+// LeaseHeldError is returned when acquiring a lease another process holds
+type LeaseHeldError struct {
+	Lease Lease
+}
+
+// NewLease returns a lease held by the current process on the specified workflow stage
+func NewLease(workflowName, workflowID, stage string, duration time.Duration) Lease {
+	host, _ := os.Hostname()
+	now := time.Now()
+	return Lease{
+		WorkflowName: workflowName,
+		WorkflowID:   workflowID,
+		PID:          os.Getpid(),
+		Host:         host,
+		Stage:        stage,
+		Acquired:     uint64(now.UnixNano()),
+		Expires:      uint64(now.Add(duration).UnixNano()),
+	}
+}
+
+// NewCommandLease returns a lease held by the current process on the specified workflow while it runs a command
+// which changes the workflow without running a stage
+func NewCommandLease(workflowName, workflowID, command string, duration time.Duration) Lease {
+	lease := NewLease(workflowName, workflowID, "", duration)
+	lease.Command = command
+	return lease
+}
+
+// Activity describes what the holder of the lease is doing
+func (l Lease) Activity() string {
+	if l.Command != "" {
+		return "running " + l.Command
+	}
+	return "running stage " + l.Stage
+}
+
+// IsStale returns whether or not the lease expired or its holder is known to be gone
+// Holders running on other hosts can not be checked, so their leases only go stale once they expire
+func (l Lease) IsStale(now time.Time) bool {
+	if uint64(now.UnixNano()) > l.Expires {
+		return true
+	}
+	host, err := os.Hostname()
+	return err == nil && host == l.Host && !processExists(l.PID)
+}
+
+// isHeldBy returns whether or not both leases were granted to the same holder
+func (l Lease) isHeldBy(other Lease) bool {
+	return l.PID == other.PID && l.Host == other.Host && l.Acquired == other.Acquired
+}
+
+func (e LeaseHeldError) Error() string {
+	return fmt.Sprintf("Workflow with ID: %s is locked by PID %d on %s %s since %s",
+		e.Lease.WorkflowID, e.Lease.PID, e.Lease.Host, e.Lease.Activity(),
+		time.Unix(0, int64(e.Lease.Acquired)).Format("2006-01-02 15:04:05"))
+}
+
+// checkLease verifies that the lease can be granted given the currently stored lease, if any
+func checkLease(stored *Lease, lease Lease) error {
+	if stored == nil || stored.isHeldBy(lease) || stored.IsStale(time.Now()) {
+		return nil
+	}
+	return errors.WithStack(LeaseHeldError{*stored})
+}
+
+func leaseKey(workflowName, workflowID string) string {
+	return workflowName + "/" + workflowID
+}
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lockTimeout bounds how long a flowit invocation waits for another one to release the DB
+const lockTimeout = 2 * time.Second
+
+// lockRetryInterval is how often a lock held by another flowit invocation is tried again
+const lockRetryInterval = 10 * time.Millisecond
+
+const lockHolderSuffix = ".holder"
+
+// unknownHolderBusyMessage reports a DB lock which holder is not known
+const unknownHolderBusyMessage = "Database busy, held by another flowit invocation"
+
+// writeLockHolder records which process holds the DB lock so that waiting processes can report it
+// Failing to record it only degrades the busy message, so errors are ignored
+func writeLockHolder(location string) {
+	holder := strconv.Itoa(os.Getpid()) + "\n" + strings.Join(os.Args[1:], " ")
+	ioutil.WriteFile(location+lockHolderSuffix, []byte(holder), 0600) // nolint:errcheck,gosec
+}
+
+func removeLockHolder(location string) {
+	os.Remove(location + lockHolderSuffix) // nolint:errcheck,gosec
+}
+
+// busyMessage describes the process holding the DB lock when it is known
+func busyMessage(location string) string {
+	holder, err := ioutil.ReadFile(location + lockHolderSuffix) // nolint:gosec
+	if err != nil {
+		return unknownHolderBusyMessage
+	}
+	lines := strings.SplitN(string(holder), "\n", 2)
+	message := "Database busy, held by PID " + lines[0]
+	if len(lines) == 2 && lines[1] != "" {
+		message += " running " + lines[1]
+	}
+	return message
+}
+
+// lockFile takes an exclusive lock on file, creating it if needed, and returns the function releasing it
+// The lock is released by the OS if its holder is gone. Waiting for it gives up after lockTimeout,
+// reporting which process holds it
+func lockFile(file string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil { // nolint:gosec
+		return nil, errors.WithStack(err)
+	}
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0600) // nolint:gosec
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil || (!locked && time.Now().After(deadline)) {
+			f.Close() // nolint:errcheck,gosec
+			if err != nil {
+				return nil, errors.Wrap(err, "Error trying to lock "+file)
+			}
+			return nil, errors.New(busyMessage(file))
+		}
+		if locked {
+			break
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	writeLockHolder(file)
+	return func() {
+		removeLockHolder(file)
+		// Closing the file releases the lock
+		f.Close() // nolint:errcheck,gosec
+	}, nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package repository
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// tryLock takes an exclusive lock on the open file without waiting for it. It returns false if another process holds it
+func tryLock(f *os.File) (bool, error) {
+	switch err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err {
+	case nil:
+		return true, nil
+	case unix.EWOULDBLOCK:
+		return false, nil
+	default:
+		return false, errors.WithStack(err)
+	}
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// MemoryStore is the Store implementation that keeps workflows in memory for the lifetime of the process
+// Workflows are stored encoded so callers never share state with the store
+type MemoryStore struct {
+	mutex     *sync.Mutex
+	workflows map[string]map[string][]byte
+	leases    map[string]Lease
+	events    *[]audit.Event
+}
+
+// NewMemoryStore creates and returns an empty MemoryStore instance
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex:     &sync.Mutex{},
+		workflows: make(map[string]map[string][]byte),
+		leases:    make(map[string]Lease),
+		events:    &[]audit.Event{},
+	}
+}
+
+// Compact does nothing since workflows are kept together with their definitions
+func (rs MemoryStore) Compact() error {
+	return nil
+}
+
+// Drop removes every stored workflow
+func (rs MemoryStore) Drop() error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	for workflowName := range rs.workflows {
+		delete(rs.workflows, workflowName)
+	}
+	for key := range rs.leases {
+		delete(rs.leases, key)
+	}
+	*rs.events = nil
+	return nil
+}
+
+// PutWorkflow takes a workflow.Workflow struct and stores it
+func (rs MemoryStore) PutWorkflow(workflow w.Workflow) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if storedWorkflow, ok := rs.workflows[workflow.Name][workflow.ID]; ok {
+		stored, err := decodeMemoryWorkflow(storedWorkflow)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := checkVersion(stored.Metadata.Version, workflow); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	encodedWorkflow, err := encode(workflow)
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode workflow")
+	}
+	if _, ok := rs.workflows[workflow.Name]; !ok {
+		rs.workflows[workflow.Name] = make(map[string][]byte)
+	}
+	rs.workflows[workflow.Name][workflow.ID] = encodedWorkflow
+	return nil
+}
+
+// DeleteWorkflow takes a workflowName and workflowID and removes the workflow
+// If the workflow does not exist, an error is returned
+func (rs MemoryStore) DeleteWorkflow(workflowName, workflowID string) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if _, ok := rs.workflows[workflowName][workflowID]; !ok {
+		return errors.New("Workflow " + workflowID + " does not exist")
+	}
+	delete(rs.workflows[workflowName], workflowID)
+	return nil
+}
+
+// GetWorkflowFromPreffix takes a workflowName and workflowPreffix and returns a workflow
+// which ID begins with the preffix wrapped in an optional.
+// If no workflow is found, an empty optional is returned. If several workflows are found, an error is returned
+func (rs MemoryStore) GetWorkflowFromPreffix(workflowName, workflowPreffix string) (w.OptionalWorkflow, error) {
+	workflows, err := rs.workflowsNamed(workflowName)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	return findWorkflowFromPreffix(workflows, workflowPreffix)
+}
+
+// GetWorkflow takes a workflowName and workflowID and returns the workflow which ID exactly matches the workflowID
+// wrapped in an optional.
+// If no workflow is found, an empty optional is returned
+func (rs MemoryStore) GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	encodedWorkflow, ok := rs.workflows[workflowName][workflowID]
+	if !ok {
+		return w.OptionalWorkflow{}, nil
+	}
+	workflow, err := decodeMemoryWorkflow(encodedWorkflow)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	return w.NewWorkflowOptional(workflow), nil
+}
+
+// GetWorkflows takes a workflowName, an integer 'n' and whether or not inactive workflows are excluded
+// and returns a list of 'n' workflows that match the criteria.
+// If n is 0, all existing workflows that match the criteria are returned
+func (rs MemoryStore) GetWorkflows(workflowName string, n int, excludeInactive bool) ([]w.Workflow, error) {
+	workflows, err := rs.workflowsNamed(workflowName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return selectWorkflows(workflows, n, excludeInactive)
+}
+
+// GetAllWorkflows returns a list of all workflows if excludeInactive is false.
+// It returns all active workflows if excludeInactive is true.
+func (rs MemoryStore) GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error) {
+	rs.mutex.Lock()
+	var workflowNames []string
+	for workflowName := range rs.workflows {
+		workflowNames = append(workflowNames, workflowName)
+	}
+	rs.mutex.Unlock()
+
+	var allWorkflows []w.Workflow
+	for _, workflowName := range workflowNames {
+		workflows, err := rs.workflowsNamed(workflowName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		allWorkflows = append(allWorkflows, workflows...)
+	}
+	return selectWorkflows(allWorkflows, 0, excludeInactive)
+}
+
+// QueryWorkflows returns the workflows matching the query, most recently updated first
+func (rs MemoryStore) QueryWorkflows(query Query) ([]w.Workflow, error) {
+	return queryStore(rs, query)
+}
+
+// AcquireLease grants the lease unless another process holds a lease on the same workflow that is not stale
+func (rs MemoryStore) AcquireLease(lease Lease) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	key := leaseKey(lease.WorkflowName, lease.WorkflowID)
+	var stored *Lease
+	if storedLease, ok := rs.leases[key]; ok {
+		stored = &storedLease
+	}
+	if err := checkLease(stored, lease); err != nil {
+		return errors.WithStack(err)
+	}
+	rs.leases[key] = lease
+	return nil
+}
+
+// ReleaseLease removes the lease unless it was broken and granted to another process meanwhile
+func (rs MemoryStore) ReleaseLease(lease Lease) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	key := leaseKey(lease.WorkflowName, lease.WorkflowID)
+	if stored, ok := rs.leases[key]; ok && stored.isHeldBy(lease) {
+		delete(rs.leases, key)
+	}
+	return nil
+}
+
+// BreakLease removes any lease on the workflow regardless of its holder
+func (rs MemoryStore) BreakLease(workflowName, workflowID string) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	delete(rs.leases, leaseKey(workflowName, workflowID))
+	return nil
+}
+
+// GetLease returns the lease on the workflow, if any
+func (rs MemoryStore) GetLease(workflowName, workflowID string) (*Lease, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	lease, ok := rs.leases[leaseKey(workflowName, workflowID)]
+	if !ok {
+		return nil, nil
+	}
+	return &lease, nil
+}
+
+// PutAuditEvent appends the audit event to the recorded events
+func (rs MemoryStore) PutAuditEvent(event audit.Event) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	*rs.events = append(*rs.events, event)
+	return nil
+}
+
+// GetAuditEvents returns the audit events matching the query in the order they were recorded
+func (rs MemoryStore) GetAuditEvents(query audit.Query) ([]audit.Event, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	var events []audit.Event
+	for _, event := range *rs.events {
+		if query.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// workflowsNamed decodes all workflows with the specified name sorted by ID
+func (rs MemoryStore) workflowsNamed(workflowName string) ([]w.Workflow, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	workflows := make([]w.Workflow, 0, len(rs.workflows[workflowName]))
+	for _, encodedWorkflow := range rs.workflows[workflowName] {
+		workflow, err := decodeMemoryWorkflow(encodedWorkflow)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		workflows = append(workflows, workflow)
+	}
+	sortWorkflows(workflows)
+	return workflows, nil
+}
+
+func decodeMemoryWorkflow(buf []byte) (w.Workflow, error) {
+	var workflow w.Workflow
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&workflow); err != nil {
+		return workflow, errors.Wrap(err, "Error trying to decode workflow")
+	}
+	return workflow, nil
+}
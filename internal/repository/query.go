@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// Query describes the criteria the workflows returned by QueryWorkflows must match
+// Zero valued fields match every workflow
+type Query struct {
+	Name   string
+	Status w.Status
+	// Stage matches the stage the latest execution ran or, if it failed, tried to run
+	Stage string
+	// Since and Until bound the last time the workflow was updated, in Unix nanoseconds
+	Since uint64
+	Until uint64
+	// Variables maps variable names to the values the workflow variables must hold
+	Variables map[string]string
+}
+
+// Matches returns whether or not the workflow meets every query criteria
+func (q Query) Matches(workflow w.Workflow) bool {
+	if q.Name != "" && workflow.Name != q.Name {
+		return false
+	}
+	if q.Status != "" && workflow.Status() != q.Status {
+		return false
+	}
+	if q.Stage != "" && workflow.LatestStage() != q.Stage {
+		return false
+	}
+	if q.Since > 0 && workflow.Metadata.Updated < q.Since {
+		return false
+	}
+	if q.Until > 0 && workflow.Metadata.Updated > q.Until {
+		return false
+	}
+	for variable, expected := range q.Variables {
+		value, ok := workflow.State.Variables[variable]
+		if !ok || fmt.Sprint(value) != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// queryStore answers a query on stores that can not filter workflows themselves
+// by decoding every candidate workflow
+func queryStore(store Store, query Query) ([]w.Workflow, error) {
+	var workflows []w.Workflow
+	var err error
+	if query.Name != "" {
+		workflows, err = store.GetWorkflows(query.Name, 0, false)
+	} else {
+		workflows, err = store.GetAllWorkflows(false)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var matching []w.Workflow
+	for _, workflow := range workflows {
+		if query.Matches(workflow) {
+			matching = append(matching, workflow)
+		}
+	}
+	sortByLatestUpdate(matching)
+	return matching, nil
+}
+
+// sortByLatestUpdate sorts workflows from the most to the least recently updated
+func sortByLatestUpdate(workflows []w.Workflow) {
+	sort.SliceStable(workflows, func(i, j int) bool {
+		if workflows[i].Metadata.Updated == workflows[j].Metadata.Updated {
+			return workflows[i].ID < workflows[j].ID
+		}
+		return workflows[i].Metadata.Updated > workflows[j].Metadata.Updated
+	})
+}
@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/config"
+)
+
+// recordMagic prefixes every versioned record, followed by a single byte holding its record version
+// Records written before record versions were introduced have no header and are version 0
+const recordMagic = "FLWT"
+
+// recordVersion is the version records are written with
+// It is increased every time the persisted workflow or definition layout changes in a way the decoders of the
+// previous version can not read. Adding fields does not require a new version, as unknown fields are ignored
+// Version 0 holds gob encoded Go structs, so renaming a Go field or changing its type breaks it
+// Version 1 holds JSON documents with explicit field names which do not depend on Go structs
+const recordVersion byte = 1
+
+// recordDecoder decodes the workflow records and definitions of a single record version,
+// upgrading them to the current in memory representation
+type recordDecoder struct {
+	workflow   func(payload []byte) (workflowRecord, error)
+	definition func(payload []byte) (config.Flowit, error)
+}
+
+// newRecordDecoders returns a decoder for every record version flowit has ever written
+func newRecordDecoders() map[byte]recordDecoder {
+	return map[byte]recordDecoder{
+		0: {decodeGobWorkflowRecord, decodeGobDefinition},
+		1: {decodeJSONWorkflowRecord, decodeJSONDefinition},
+	}
+}
+
+// storedWorkflowRecord is the version 1 layout of a workflow record
+type storedWorkflowRecord struct {
+	ID             string                 `json:"id"`
+	Preffix        string                 `json:"preffix"`
+	Alias          string                 `json:"alias,omitempty"`
+	Name           string                 `json:"name"`
+	SchemaVersion  string                 `json:"schema-version"`
+	IsActive       bool                   `json:"active"`
+	IsCancelled    bool                   `json:"cancelled"`
+	Executions     []exportedExecution    `json:"executions"`
+	DefinitionKey  string                 `json:"definition-key"`
+	DefinitionHash string                 `json:"definition-hash"`
+	Variables      map[string]interface{} `json:"variables"`
+	ParentName     string                 `json:"parent-name,omitempty"`
+	ParentID       string                 `json:"parent-id,omitempty"`
+	Children       []exportedChild        `json:"children,omitempty"`
+	Version        uint64                 `json:"version"`
+	Started        uint64                 `json:"started"`
+	Updated        uint64                 `json:"updated"`
+	Finished       uint64                 `json:"finished"`
+}
+
+func encodeWorkflowRecord(record workflowRecord) ([]byte, error) {
+	// The exported workflow already knows how to fold the latest execution into the execution history
+	exported := newExportedWorkflow(record.workflow(config.Flowit{}))
+	return encodeRecord(storedWorkflowRecord{
+		ID:             exported.ID,
+		Preffix:        exported.Preffix,
+		Alias:          exported.Alias,
+		Name:           exported.Name,
+		SchemaVersion:  exported.SchemaVersion,
+		IsActive:       exported.IsActive,
+		IsCancelled:    exported.IsCancelled,
+		Executions:     exported.Executions,
+		DefinitionKey:  record.DefinitionKey,
+		DefinitionHash: exported.DefinitionHash,
+		Variables:      exported.Variables,
+		ParentName:     exported.ParentName,
+		ParentID:       exported.ParentID,
+		Children:       exported.Children,
+		Version:        exported.Version,
+		Started:        exported.Started,
+		Updated:        exported.Updated,
+		Finished:       exported.Finished,
+	})
+}
+
+func encodeDefinition(definition config.Flowit) ([]byte, error) {
+	return encodeRecord(newExportedDefinition(definition))
+}
+
+func encodeRecord(payload interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte(recordMagic), recordVersion))
+	if err := json.NewEncoder(buf).Encode(payload); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWorkflowRecord decodes a workflow record of any known record version
+func decodeWorkflowRecord(buf []byte) (workflowRecord, error) {
+	decoder, payload, err := recordDecoderFor(buf)
+	if err != nil {
+		return workflowRecord{}, errors.WithStack(err)
+	}
+	record, err := decoder.workflow(payload)
+	if err != nil {
+		return record, errors.Wrap(err, "Error trying to decode workflow")
+	}
+	return record, nil
+}
+
+// decodeDefinition decodes a workflow definition of any known record version
+func decodeDefinition(buf []byte) (config.Flowit, error) {
+	decoder, payload, err := recordDecoderFor(buf)
+	if err != nil {
+		return config.Flowit{}, errors.WithStack(err)
+	}
+	definition, err := decoder.definition(payload)
+	if err != nil {
+		return definition, errors.Wrap(err, "Error trying to decode workflow definition")
+	}
+	return definition, nil
+}
+
+// recordDecoderFor reads the record header and returns the decoder for its version together with the payload
+func recordDecoderFor(buf []byte) (recordDecoder, []byte, error) {
+	version := byte(0)
+	payload := buf
+	if len(buf) > len(recordMagic) && bytes.Equal(buf[:len(recordMagic)], []byte(recordMagic)) {
+		version = buf[len(recordMagic)]
+		payload = buf[len(recordMagic)+1:]
+	}
+	decoder, ok := newRecordDecoders()[version]
+	if !ok {
+		return decoder, nil, errors.Errorf("Unsupported record version: %d. "+
+			"This repository was written by a newer flowit version", version)
+	}
+	return decoder, payload, nil
+}
+
+// workflowRecord is also the version 0 layout, so its fields must not be renamed
+func decodeGobWorkflowRecord(payload []byte) (workflowRecord, error) {
+	var record workflowRecord
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record)
+	return record, errors.WithStack(err)
+}
+
+// gobDefinitionV0 is the version 0 layout of config.Flowit
+// It is also the layout of the definition snapshots workflows embedded before schema version 1
+type gobDefinitionV0 struct {
+	Version       string
+	Config        config.Config
+	Variables     config.Variables
+	StateMachines []gobStateMachineV0
+	Workflows     []gobWorkflowV0
+}
+
+// gobStateMachineV0 had a single initial stage
+type gobStateMachineV0 struct {
+	ID           string
+	Stages       []string
+	InitialStage string
+	FinalStages  []string
+	Transitions  []config.StateMachineTransition
+}
+
+type gobWorkflowV0 struct {
+	ID           string
+	StateMachine string
+	Stages       []gobStageV0
+}
+
+// gobStageV0 had plain string commands
+type gobStageV0 struct {
+	ID         string
+	Args       []string
+	Conditions []string
+	Actions    []string
+}
+
+func decodeGobDefinition(payload []byte) (config.Flowit, error) {
+	var legacy gobDefinitionV0
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&legacy); err != nil {
+		return config.Flowit{}, errors.WithStack(err)
+	}
+	return legacy.definition(), nil
+}
+
+// definition upgrades the version 0 layout to config.Flowit
+func (legacy gobDefinitionV0) definition() config.Flowit {
+	definition := config.Flowit{
+		Version:   legacy.Version,
+		Config:    legacy.Config,
+		Variables: legacy.Variables,
+	}
+	for _, stateMachine := range legacy.StateMachines {
+		definition.StateMachines = append(definition.StateMachines, config.StateMachine{
+			ID:            stateMachine.ID,
+			Stages:        stateMachine.Stages,
+			InitialStages: []string{stateMachine.InitialStage},
+			FinalStages:   stateMachine.FinalStages,
+			Transitions:   stateMachine.Transitions,
+		})
+	}
+	for _, legacyWorkflow := range legacy.Workflows {
+		workflow := config.Workflow{ID: legacyWorkflow.ID, StateMachine: legacyWorkflow.StateMachine}
+		for _, stage := range legacyWorkflow.Stages {
+			workflow.Stages = append(workflow.Stages, config.Stage{
+				ID:         stage.ID,
+				Args:       stage.Args,
+				Conditions: gobCommandsV0(stage.Conditions),
+				Actions:    gobCommandsV0(stage.Actions),
+			})
+		}
+		definition.Workflows = append(definition.Workflows, workflow)
+	}
+	return definition
+}
+
+func gobCommandsV0(commands []string) []config.Command {
+	var upgraded []config.Command
+	for _, command := range commands {
+		upgraded = append(upgraded, config.Command{Run: command})
+	}
+	return upgraded
+}
+
+func decodeJSONWorkflowRecord(payload []byte) (workflowRecord, error) {
+	var stored storedWorkflowRecord
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&stored); err != nil {
+		return workflowRecord{}, errors.WithStack(err)
+	}
+	workflow := exportedWorkflow{
+		ID:             stored.ID,
+		Preffix:        stored.Preffix,
+		Alias:          stored.Alias,
+		Name:           stored.Name,
+		SchemaVersion:  stored.SchemaVersion,
+		IsActive:       stored.IsActive,
+		IsCancelled:    stored.IsCancelled,
+		Executions:     stored.Executions,
+		DefinitionHash: stored.DefinitionHash,
+		Variables:      stored.Variables,
+		ParentName:     stored.ParentName,
+		ParentID:       stored.ParentID,
+		Children:       stored.Children,
+		Version:        stored.Version,
+		Started:        stored.Started,
+		Updated:        stored.Updated,
+		Finished:       stored.Finished,
+	}.workflow()
+	return newWorkflowRecord(workflow, stored.DefinitionKey), nil
+}
+
+func decodeJSONDefinition(payload []byte) (config.Flowit, error) {
+	var exported exportedDefinition
+	if err := json.Unmarshal(payload, &exported); err != nil {
+		return config.Flowit{}, errors.WithStack(err)
+	}
+	return exported.definition(), nil
+}
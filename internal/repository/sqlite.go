@@ -0,0 +1,821 @@
+//go:build cgo
+// +build cgo
+
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/config"
+	"github.com/yamil-rivera/flowit/internal/io"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// SQLiteStore is the Store implementation backed by a SQLite database
+// Workflows, their executions and the executions command results are kept in normalized tables
+// so workflows can be queried without decoding all of them
+type SQLiteStore struct {
+	location string
+}
+
+// sqliteMigrations returns the statements bringing the schema from one version to the next
+// The schema version is the number of migrations applied and it is tracked using PRAGMA user_version
+func sqliteMigrations() [][]string {
+	return [][]string{
+		{
+			`CREATE TABLE definitions (
+				key        TEXT PRIMARY KEY,
+				definition BLOB NOT NULL
+			)`,
+			`CREATE TABLE workflows (
+				id              TEXT PRIMARY KEY,
+				name            TEXT NOT NULL,
+				preffix         TEXT NOT NULL,
+				schema_version  TEXT NOT NULL,
+				is_active       INTEGER NOT NULL,
+				is_cancelled    INTEGER NOT NULL,
+				status          TEXT NOT NULL,
+				stage           TEXT NOT NULL,
+				definition_key  TEXT NOT NULL REFERENCES definitions (key),
+				definition_hash TEXT NOT NULL,
+				version         INTEGER NOT NULL,
+				started         INTEGER NOT NULL,
+				updated         INTEGER NOT NULL,
+				finished        INTEGER NOT NULL
+			)`,
+			`CREATE INDEX workflows_name ON workflows (name, id)`,
+			`CREATE INDEX workflows_updated ON workflows (updated)`,
+			`CREATE TABLE variables (
+				workflow_id TEXT NOT NULL REFERENCES workflows (id) ON DELETE CASCADE,
+				name        TEXT NOT NULL,
+				value       BLOB NOT NULL,
+				text        TEXT NOT NULL,
+				PRIMARY KEY (workflow_id, name)
+			)`,
+			`CREATE TABLE executions (
+				workflow_id  TEXT NOT NULL REFERENCES workflows (id) ON DELETE CASCADE,
+				position     INTEGER NOT NULL,
+				id           TEXT NOT NULL,
+				from_stage   TEXT NOT NULL,
+				stage        TEXT NOT NULL,
+				failed_stage TEXT NOT NULL,
+				args         TEXT NOT NULL,
+				checkpoint   INTEGER NOT NULL,
+				failed       INTEGER NOT NULL,
+				version      INTEGER NOT NULL,
+				started      INTEGER NOT NULL,
+				finished     INTEGER NOT NULL,
+				PRIMARY KEY (workflow_id, position)
+			)`,
+			`CREATE TABLE command_results (
+				workflow_id        TEXT NOT NULL,
+				execution_position INTEGER NOT NULL,
+				position           INTEGER NOT NULL,
+				command            TEXT NOT NULL,
+				output             TEXT NOT NULL,
+				failed             INTEGER NOT NULL,
+				started            INTEGER NOT NULL,
+				finished           INTEGER NOT NULL,
+				PRIMARY KEY (workflow_id, execution_position, position),
+				FOREIGN KEY (workflow_id, execution_position) REFERENCES executions (workflow_id, position) ON DELETE CASCADE
+			)`,
+		},
+		{
+			`CREATE TABLE leases (
+				workflow_name TEXT NOT NULL,
+				workflow_id   TEXT NOT NULL,
+				pid           INTEGER NOT NULL,
+				host          TEXT NOT NULL,
+				stage         TEXT NOT NULL,
+				acquired      INTEGER NOT NULL,
+				expires       INTEGER NOT NULL,
+				PRIMARY KEY (workflow_name, workflow_id)
+			)`,
+		},
+		{
+			`ALTER TABLE workflows ADD COLUMN alias TEXT NOT NULL DEFAULT ''`,
+		},
+		{
+			`CREATE TABLE audit_events (
+				id            INTEGER PRIMARY KEY AUTOINCREMENT,
+				user          TEXT NOT NULL,
+				host          TEXT NOT NULL,
+				directory     TEXT NOT NULL,
+				git_head      TEXT NOT NULL,
+				command_line  TEXT NOT NULL,
+				action        TEXT NOT NULL,
+				workflow_name TEXT NOT NULL,
+				workflow_id   TEXT NOT NULL,
+				from_stage    TEXT NOT NULL,
+				to_stage      TEXT NOT NULL,
+				outcome       TEXT NOT NULL,
+				error         TEXT NOT NULL,
+				started       INTEGER NOT NULL,
+				finished      INTEGER NOT NULL
+			)`,
+			`CREATE INDEX audit_events_started ON audit_events (started)`,
+		},
+		{
+			`ALTER TABLE workflows ADD COLUMN parent_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE workflows ADD COLUMN parent_id TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE children (
+				workflow_id TEXT NOT NULL REFERENCES workflows (id) ON DELETE CASCADE,
+				position    INTEGER NOT NULL,
+				name        TEXT NOT NULL,
+				id          TEXT NOT NULL,
+				stage       TEXT NOT NULL,
+				PRIMARY KEY (workflow_id, position)
+			)`,
+		},
+		{
+			`ALTER TABLE executions ADD COLUMN checkpoint_succeeded TEXT NOT NULL DEFAULT 'null'`,
+		},
+		{
+			`ALTER TABLE command_results ADD COLUMN skipped INTEGER NOT NULL DEFAULT 0`,
+		},
+		{
+			`ALTER TABLE executions ADD COLUMN approval TEXT NOT NULL DEFAULT 'null'`,
+		},
+		{
+			`ALTER TABLE leases ADD COLUMN command TEXT NOT NULL DEFAULT ''`,
+		},
+	}
+}
+
+const workflowColumns = `id, name, preffix, alias, schema_version, is_active, is_cancelled, definition_key,
+	definition_hash, parent_name, parent_id, version, started, updated, finished`
+
+// variableValue wraps variable values so gob keeps their concrete type
+type variableValue struct {
+	Value interface{}
+}
+
+// NewSQLiteStore creates and returns a SQLiteStore instance persisting workflows in the specified file
+func NewSQLiteStore(location string) *SQLiteStore {
+	return &SQLiteStore{location}
+}
+
+// newSQLiteStore is the Store NewStore returns for the sqlite repository type
+func newSQLiteStore(location string) (Store, error) {
+	return NewSQLiteStore(location), nil
+}
+
+// Drop removes the database file
+func (rs SQLiteStore) Drop() error {
+	if err := os.Remove(rs.location); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Compact removes the workflow definitions no longer referenced by any workflow and rebuilds the database file
+// so the space freed by deleted workflows is given back to the file system
+func (rs SQLiteStore) Compact() error {
+	if err := rs.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM definitions WHERE key NOT IN (SELECT definition_key FROM workflows)`)
+		return errors.WithStack(err)
+	}); err != nil {
+		return errors.Wrap(err, "Error trying to remove unused workflow definitions")
+	}
+	db, err := openSQLiteDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeSQLiteDB(db)
+	// VACUUM can not run within a transaction
+	_, err = db.Exec(`VACUUM`)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrBusy {
+		return errors.New(unknownHolderBusyMessage)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error trying to compact database")
+	}
+	return nil
+}
+
+// PutWorkflow takes a workflow.Workflow struct and saves it into the DB replacing any previous version of it
+func (rs SQLiteStore) PutWorkflow(workflow w.Workflow) error {
+	return rs.update(func(tx *sql.Tx) error {
+		definitionKey, err := putDefinition(tx, workflow)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := putWorkflowRow(tx, workflow, definitionKey); err != nil {
+			return errors.WithStack(err)
+		}
+
+		for name, value := range workflow.State.Variables {
+			valueBytes, err := encode(variableValue{value})
+			if err != nil {
+				return errors.Wrap(err, "Error trying to encode variable "+name)
+			}
+			if _, err := tx.Exec(`INSERT INTO variables (workflow_id, name, value, text) VALUES (?, ?, ?, ?)`,
+				workflow.ID, name, valueBytes, fmt.Sprint(value)); err != nil {
+				return errors.Wrap(err, "Error trying to save variable "+name)
+			}
+		}
+
+		for i, child := range workflow.Children {
+			if _, err := tx.Exec(`INSERT INTO children (workflow_id, position, name, id, stage) VALUES (?, ?, ?, ?, ?)`,
+				workflow.ID, i, child.Name, child.ID, child.Stage); err != nil {
+				return errors.Wrap(err, "Error trying to save child workflow "+child.ID)
+			}
+		}
+
+		for i, execution := range workflow.Executions {
+			// The latest execution is the most up to date copy of the first execution in the history
+			if i == 0 && workflow.LatestExecution != nil && workflow.LatestExecution.ID == execution.ID {
+				execution = *workflow.LatestExecution
+			}
+			if err := putExecution(tx, workflow.ID, i, execution); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
+
+// putDefinition saves the workflow definition of the workflow unless it is already stored and returns its key
+func putDefinition(tx *sql.Tx, workflow w.Workflow) (string, error) {
+	definition, definitionKey, err := workflowDefinition(workflow)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	definitionBytes, err := encodeDefinition(definition)
+	if err != nil {
+		return "", errors.Wrap(err, "Error trying to encode workflow definition")
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO definitions (key, definition) VALUES (?, ?)`,
+		definitionKey, definitionBytes); err != nil {
+		return "", errors.Wrap(err, "Error trying to save workflow definition")
+	}
+	return definitionKey, nil
+}
+
+// putWorkflowRow replaces the row of the workflow once its version is checked against the stored one
+func putWorkflowRow(tx *sql.Tx, workflow w.Workflow, definitionKey string) error {
+	var storedVersion uint64
+	err := tx.QueryRow(`SELECT version FROM workflows WHERE id = ?`, workflow.ID).Scan(&storedVersion)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		if err := checkVersion(storedVersion, workflow); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// Deleting the previous version cascades into its variables, executions and command results
+	if _, err := tx.Exec(`DELETE FROM workflows WHERE id = ?`, workflow.ID); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO workflows (`+workflowColumns+`, status, stage)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		workflow.ID, workflow.Name, workflow.Preffix, workflow.Alias, workflow.SchemaVersion, workflow.IsActive,
+		workflow.IsCancelled, definitionKey, workflow.DefinitionHash, workflow.ParentName, workflow.ParentID,
+		workflow.Metadata.Version, workflow.Metadata.Started, workflow.Metadata.Updated, workflow.Metadata.Finished,
+		string(workflow.Status()), workflow.LatestStage()); err != nil {
+		return errors.Wrap(err, "Error trying to save workflow")
+	}
+	return nil
+}
+
+func putExecution(tx *sql.Tx, workflowID string, position int, execution w.Execution) error {
+	args, err := json.Marshal(execution.Args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	succeeded, err := json.Marshal(execution.CheckpointSucceeded)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// The approval is stored as in exports, so its encoding does not depend on Go field names
+	approval, err := json.Marshal(newExportedExecution(execution).Approval)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO executions (workflow_id, position, id, from_stage, stage, failed_stage, args,
+		checkpoint, checkpoint_succeeded, approval, failed, version, started, finished)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		workflowID, position, execution.ID, execution.FromStage, execution.Stage, execution.FailedStage, string(args),
+		execution.Checkpoint, string(succeeded), string(approval), execution.Failed, execution.Metadata.Version,
+		execution.Metadata.Started, execution.Metadata.Finished); err != nil {
+		return errors.Wrap(err, "Error trying to save execution "+execution.ID)
+	}
+	for i, result := range execution.Results {
+		if _, err := tx.Exec(`INSERT INTO command_results (workflow_id, execution_position, position, command, output,
+			failed, skipped, started, finished) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			workflowID, position, i, result.Command, result.Output, result.Failed, result.Skipped, result.Started,
+			result.Finished); err != nil {
+			return errors.Wrap(err, "Error trying to save command result")
+		}
+	}
+	return nil
+}
+
+// DeleteWorkflow takes a workflowName and workflowID and removes the workflow from the DB
+// If the workflow does not exist, an error is returned
+func (rs SQLiteStore) DeleteWorkflow(workflowName, workflowID string) error {
+	return rs.update(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM workflows WHERE name = ? AND id = ?`, workflowName, workflowID)
+		if err != nil {
+			return errors.Wrap(err, "Error trying to delete workflow")
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if deleted == 0 {
+			return errors.New("Workflow " + workflowID + " does not exist")
+		}
+		return nil
+	})
+}
+
+// GetWorkflowFromPreffix takes a workflowName and workflowPreffix and returns a workflow
+// which ID begins with the preffix wrapped in an optional.
+// If no workflow is found, an empty optional is returned. If several workflows are found, an error is returned
+func (rs SQLiteStore) GetWorkflowFromPreffix(workflowName, workflowPreffix string) (w.OptionalWorkflow, error) {
+	workflows, err := rs.selectWorkflows(`WHERE name = ? AND substr(id, 1, length(?)) = ? ORDER BY id`,
+		workflowName, workflowPreffix, workflowPreffix)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	return findWorkflowFromPreffix(workflows, workflowPreffix)
+}
+
+// GetWorkflow takes a workflowName and workflowID and returns the workflow which ID exactly matches the workflowID
+// wrapped in an optional.
+// If no workflow is found, an empty optional is returned
+func (rs SQLiteStore) GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error) {
+	workflows, err := rs.selectWorkflows(`WHERE name = ? AND id = ?`, workflowName, workflowID)
+	if err != nil {
+		return w.OptionalWorkflow{}, errors.WithStack(err)
+	}
+	if len(workflows) == 0 {
+		return w.OptionalWorkflow{}, nil
+	}
+	return w.NewWorkflowOptional(workflows[0]), nil
+}
+
+// GetWorkflows takes a workflowName, an integer 'n' and whether or not inactive workflows are excluded
+// and returns a list of 'n' workflows that match the criteria.
+// If n is 0, all existing workflows that match the criteria are returned
+func (rs SQLiteStore) GetWorkflows(workflowName string, n int, excludeInactive bool) ([]w.Workflow, error) {
+	workflows, err := rs.selectWorkflows(`WHERE name = ? ORDER BY id`, workflowName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return selectWorkflows(workflows, n, excludeInactive)
+}
+
+// GetAllWorkflows returns a list of all workflows if excludeInactive is false.
+// It returns all active workflows if excludeInactive is true.
+func (rs SQLiteStore) GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error) {
+	workflows, err := rs.selectWorkflows(`WHERE is_active OR NOT ? ORDER BY name, id`, excludeInactive)
+	return workflows, errors.WithStack(err)
+}
+
+// QueryWorkflows returns the workflows matching the query, most recently updated first
+func (rs SQLiteStore) QueryWorkflows(query Query) ([]w.Workflow, error) {
+	var conditions []string
+	var args []interface{}
+	if query.Name != "" {
+		conditions = append(conditions, "name = ?")
+		args = append(args, query.Name)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(query.Status))
+	}
+	if query.Stage != "" {
+		conditions = append(conditions, "stage = ?")
+		args = append(args, query.Stage)
+	}
+	if query.Since > 0 {
+		conditions = append(conditions, "updated >= ?")
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		conditions = append(conditions, "updated <= ?")
+		args = append(args, query.Until)
+	}
+	for name, value := range query.Variables {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM variables
+			WHERE variables.workflow_id = workflows.id AND variables.name = ? AND variables.text = ?)`)
+		args = append(args, name, value)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	workflows, err := rs.selectWorkflows(where+" ORDER BY updated DESC, id", args...)
+	return workflows, errors.WithStack(err)
+}
+
+// AcquireLease grants the lease unless another process holds a lease on the same workflow that is not stale
+func (rs SQLiteStore) AcquireLease(lease Lease) error {
+	return rs.update(func(tx *sql.Tx) error {
+		stored, err := selectLease(tx, lease.WorkflowName, lease.WorkflowID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := checkLease(stored, lease); err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO leases (workflow_name, workflow_id, pid, host, stage, command, acquired,
+			expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, lease.WorkflowName, lease.WorkflowID, lease.PID, lease.Host, lease.Stage,
+			lease.Command, lease.Acquired, lease.Expires); err != nil {
+			return errors.Wrap(err, "Error trying to save lease")
+		}
+		return nil
+	})
+}
+
+// ReleaseLease removes the lease unless it was broken and granted to another process meanwhile
+func (rs SQLiteStore) ReleaseLease(lease Lease) error {
+	return rs.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM leases
+			WHERE workflow_name = ? AND workflow_id = ? AND pid = ? AND host = ? AND acquired = ?`,
+			lease.WorkflowName, lease.WorkflowID, lease.PID, lease.Host, lease.Acquired)
+		return errors.Wrap(err, "Error trying to remove lease")
+	})
+}
+
+// BreakLease removes any lease on the workflow regardless of its holder
+func (rs SQLiteStore) BreakLease(workflowName, workflowID string) error {
+	return rs.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM leases WHERE workflow_name = ? AND workflow_id = ?`, workflowName, workflowID)
+		return errors.Wrap(err, "Error trying to remove lease")
+	})
+}
+
+// GetLease returns the lease on the workflow, if any
+func (rs SQLiteStore) GetLease(workflowName, workflowID string) (*Lease, error) {
+	var lease *Lease
+	err := rs.view(func(tx *sql.Tx) error {
+		var err error
+		lease, err = selectLease(tx, workflowName, workflowID)
+		return errors.WithStack(err)
+	})
+	return lease, errors.WithStack(err)
+}
+
+// PutAuditEvent appends the audit event to the audit_events table
+func (rs SQLiteStore) PutAuditEvent(event audit.Event) error {
+	commandLine, err := json.Marshal(event.CommandLine)
+	if err != nil {
+		return errors.Wrap(err, "Error trying to encode audit event")
+	}
+	return rs.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO audit_events (user, host, directory, git_head, command_line, action, workflow_name,
+			workflow_id, from_stage, to_stage, outcome, error, started, finished)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.User, event.Host, event.Directory, event.GitHead, string(commandLine), event.Action, event.WorkflowName,
+			event.WorkflowID, event.FromStage, event.ToStage, string(event.Outcome), event.Error, event.Started, event.Finished)
+		return errors.Wrap(err, "Error trying to save audit event")
+	})
+}
+
+// GetAuditEvents returns the audit events matching the query in the order they were recorded
+func (rs SQLiteStore) GetAuditEvents(query audit.Query) ([]audit.Event, error) {
+	var events []audit.Event
+	err := rs.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT user, host, directory, git_head, command_line, action, workflow_name, workflow_id,
+			from_stage, to_stage, outcome, error, started, finished FROM audit_events
+			WHERE started >= ? AND (? = '' OR workflow_name = ?) ORDER BY id`,
+			query.Since, query.WorkflowName, query.WorkflowName)
+		if err != nil {
+			return errors.Wrap(err, "Error trying to read audit events")
+		}
+		defer rows.Close() // nolint:errcheck
+		for rows.Next() {
+			var event audit.Event
+			var commandLine, outcome string
+			if err := rows.Scan(&event.User, &event.Host, &event.Directory, &event.GitHead, &commandLine, &event.Action,
+				&event.WorkflowName, &event.WorkflowID, &event.FromStage, &event.ToStage, &outcome, &event.Error,
+				&event.Started, &event.Finished); err != nil {
+				return errors.Wrap(err, "Error trying to read audit event")
+			}
+			if err := json.Unmarshal([]byte(commandLine), &event.CommandLine); err != nil {
+				return errors.Wrap(err, "Error trying to decode audit event")
+			}
+			event.Outcome = audit.Outcome(outcome)
+			events = append(events, event)
+		}
+		return errors.WithStack(rows.Err())
+	})
+	return events, errors.WithStack(err)
+}
+
+func selectLease(tx *sql.Tx, workflowName, workflowID string) (*Lease, error) {
+	lease := Lease{WorkflowName: workflowName, WorkflowID: workflowID}
+	err := tx.QueryRow(`SELECT pid, host, stage, command, acquired, expires FROM leases
+		WHERE workflow_name = ? AND workflow_id = ?`, workflowName, workflowID).Scan(
+		&lease.PID, &lease.Host, &lease.Stage, &lease.Command, &lease.Acquired, &lease.Expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error trying to read lease")
+	}
+	return &lease, nil
+}
+
+// selectWorkflows reads the workflows selected by the clauses following the FROM clause
+func (rs SQLiteStore) selectWorkflows(clauses string, args ...interface{}) ([]w.Workflow, error) {
+	var workflows []w.Workflow
+	err := rs.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+workflowColumns+` FROM workflows `+clauses, args...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		definitionKeys := make(map[string]string)
+		for rows.Next() {
+			var workflow w.Workflow
+			var definitionKey string
+			if err := rows.Scan(&workflow.ID, &workflow.Name, &workflow.Preffix, &workflow.Alias, &workflow.SchemaVersion,
+				&workflow.IsActive, &workflow.IsCancelled, &definitionKey, &workflow.DefinitionHash,
+				&workflow.ParentName, &workflow.ParentID, &workflow.Metadata.Version, &workflow.Metadata.Started,
+				&workflow.Metadata.Updated, &workflow.Metadata.Finished); err != nil {
+				rows.Close() // nolint:errcheck,gosec
+				return errors.Wrap(err, "Error trying to read workflow")
+			}
+			definitionKeys[workflow.ID] = definitionKey
+			workflows = append(workflows, workflow)
+		}
+		if err := rows.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		definitions := make(map[string]config.Flowit)
+		for i := range workflows {
+			if err := readWorkflowDetails(tx, &workflows[i], definitionKeys[workflows[i].ID], definitions); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	return workflows, errors.WithStack(err)
+}
+
+// readWorkflowDetails populates the workflow definition, variables, children and executions
+func readWorkflowDetails(tx *sql.Tx, workflow *w.Workflow, definitionKey string,
+	definitions map[string]config.Flowit) error {
+	definition, ok := definitions[definitionKey]
+	if !ok {
+		var definitionBytes []byte
+		if err := tx.QueryRow(`SELECT definition FROM definitions WHERE key = ?`, definitionKey).
+			Scan(&definitionBytes); err != nil {
+			return errors.Wrap(err, "Error trying to read workflow definition "+definitionKey)
+		}
+		decoded, err := decodeDefinition(definitionBytes)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		definition = decoded
+		definitions[definitionKey] = definition
+	}
+	variables, err := readVariables(tx, workflow.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	definition.Variables = variables
+	workflow.State = definition
+
+	children, err := readChildren(tx, workflow.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workflow.Children = children
+
+	executions, err := readExecutions(tx, workflow.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workflow.Executions = executions
+	if len(executions) > 0 {
+		latestExecution := executions[0]
+		workflow.LatestExecution = &latestExecution
+	}
+	return nil
+}
+
+func readChildren(tx *sql.Tx, workflowID string) ([]w.Child, error) {
+	rows, err := tx.Query(`SELECT name, id, stage FROM children WHERE workflow_id = ? ORDER BY position`, workflowID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close() // nolint:errcheck
+	var children []w.Child
+	for rows.Next() {
+		var child w.Child
+		if err := rows.Scan(&child.Name, &child.ID, &child.Stage); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		children = append(children, child)
+	}
+	return children, errors.WithStack(rows.Err())
+}
+
+func readVariables(tx *sql.Tx, workflowID string) (map[string]interface{}, error) {
+	rows, err := tx.Query(`SELECT name, value FROM variables WHERE workflow_id = ?`, workflowID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close() // nolint:errcheck
+	var variables map[string]interface{}
+	for rows.Next() {
+		var name string
+		var valueBytes []byte
+		if err := rows.Scan(&name, &valueBytes); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var value variableValue
+		if err := gob.NewDecoder(bytes.NewReader(valueBytes)).Decode(&value); err != nil {
+			return nil, errors.Wrap(err, "Error trying to decode variable "+name)
+		}
+		if variables == nil {
+			variables = make(map[string]interface{})
+		}
+		variables[name] = value.Value
+	}
+	return variables, errors.WithStack(rows.Err())
+}
+
+func readExecutions(tx *sql.Tx, workflowID string) ([]w.Execution, error) {
+	rows, err := tx.Query(`SELECT id, from_stage, stage, failed_stage, args, checkpoint, checkpoint_succeeded, approval,
+		failed, version, started, finished FROM executions WHERE workflow_id = ? ORDER BY position`, workflowID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var executions []w.Execution
+	for rows.Next() {
+		var execution w.Execution
+		var args, succeeded, approval string
+		if err := rows.Scan(&execution.ID, &execution.FromStage, &execution.Stage, &execution.FailedStage, &args,
+			&execution.Checkpoint, &succeeded, &approval, &execution.Failed, &execution.Metadata.Version,
+			&execution.Metadata.Started, &execution.Metadata.Finished); err != nil {
+			rows.Close() // nolint:errcheck,gosec
+			return nil, errors.WithStack(err)
+		}
+		if err := json.Unmarshal([]byte(args), &execution.Args); err != nil {
+			rows.Close() // nolint:errcheck,gosec
+			return nil, errors.Wrap(err, "Error trying to decode execution arguments")
+		}
+		if err := json.Unmarshal([]byte(succeeded), &execution.CheckpointSucceeded); err != nil {
+			rows.Close() // nolint:errcheck,gosec
+			return nil, errors.Wrap(err, "Error trying to decode execution checkpoint")
+		}
+		var storedApproval *exportedApproval
+		if err := json.Unmarshal([]byte(approval), &storedApproval); err != nil {
+			rows.Close() // nolint:errcheck,gosec
+			return nil, errors.Wrap(err, "Error trying to decode execution approval")
+		}
+		if storedApproval != nil {
+			executionApproval := w.Approval(*storedApproval)
+			execution.Approval = &executionApproval
+		}
+		executions = append(executions, execution)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for i := range executions {
+		results, err := readCommandResults(tx, workflowID, i)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		executions[i].Results = results
+	}
+	return executions, nil
+}
+
+func readCommandResults(tx *sql.Tx, workflowID string, executionPosition int) ([]w.CommandResult, error) {
+	rows, err := tx.Query(`SELECT command, output, failed, skipped, started, finished FROM command_results
+		WHERE workflow_id = ? AND execution_position = ? ORDER BY position`, workflowID, executionPosition)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close() // nolint:errcheck
+	var results []w.CommandResult
+	for rows.Next() {
+		var result w.CommandResult
+		if err := rows.Scan(&result.Command, &result.Output, &result.Failed, &result.Skipped, &result.Started,
+			&result.Finished); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		results = append(results, result)
+	}
+	return results, errors.WithStack(rows.Err())
+}
+
+func (rs SQLiteStore) view(fn func(tx *sql.Tx) error) error {
+	return rs.transaction(fn, true)
+}
+
+func (rs SQLiteStore) update(fn func(tx *sql.Tx) error) error {
+	return rs.transaction(fn, false)
+}
+
+func (rs SQLiteStore) transaction(fn func(tx *sql.Tx) error, readOnly bool) error {
+	db, err := openSQLiteDB(rs.location)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer closeSQLiteDB(db)
+
+	tx, err := db.Begin()
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrBusy {
+		return errors.New(unknownHolderBusyMessage)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error trying to open transaction")
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback() // nolint:errcheck,gosec
+		return errors.WithStack(err)
+	}
+	if readOnly {
+		return errors.WithStack(tx.Rollback())
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+func openSQLiteDB(location string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+location+"?_foreign_keys=1&_txlock=immediate&_busy_timeout="+
+		strconv.Itoa(int(lockTimeout/time.Millisecond)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// A single connection keeps the pragmas set by the migrations in effect for the whole session
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLiteDB(db); err != nil {
+		closeSQLiteDB(db)
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+// migrateSQLiteDB applies the migrations the DB is missing
+func migrateSQLiteDB(db *sql.DB) error {
+	migrations := sqliteMigrations()
+	for {
+		migrated, err := migrateSQLiteDBOnce(db, migrations)
+		if err != nil || !migrated {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// migrateSQLiteDBOnce applies the migration following the DB schema version and reports whether it applied one
+// The version is read within the immediate transaction which applies the migration, so concurrent
+// flowit invocations opening the same DB never apply the same migration twice
+func migrateSQLiteDBOnce(db *sql.DB, migrations [][]string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		tx.Rollback() // nolint:errcheck,gosec
+		return false, errors.Wrap(err, "Error trying to read DB schema version")
+	}
+	if version >= len(migrations) {
+		tx.Rollback() // nolint:errcheck,gosec
+		if version > len(migrations) {
+			return false, errors.Errorf("DB schema version %d is newer than the latest supported version %d",
+				version, len(migrations))
+		}
+		return false, nil
+	}
+	for _, statement := range migrations[version] {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback() // nolint:errcheck,gosec
+			return false, errors.Wrapf(err, "Error trying to migrate DB to schema version %d", version+1)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+		tx.Rollback() // nolint:errcheck,gosec
+		return false, errors.WithStack(err)
+	}
+	return true, errors.WithStack(tx.Commit())
+}
+
+func closeSQLiteDB(db *sql.DB) {
+	if err := db.Close(); err != nil {
+		io.Logger.Errorf("%+v", err)
+	}
+}
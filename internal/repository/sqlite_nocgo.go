@@ -0,0 +1,13 @@
+//go:build !cgo
+// +build !cgo
+
+package repository
+
+import "github.com/pkg/errors"
+
+// newSQLiteStore refuses the sqlite repository type, as the SQLite driver requires cgo
+// and this flowit build was compiled without it
+func newSQLiteStore(location string) (Store, error) {
+	return nil, errors.New("Can not open " + location + ": the sqlite repository type is unsupported in this build, " +
+		"as flowit was compiled without cgo. Rebuild it with CGO_ENABLED=1 or use another repository type")
+}
@@ -0,0 +1,24 @@
+//go:build !cgo
+// +build !cgo
+
+package repository_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yamil-rivera/flowit/internal/config"
+	r "github.com/yamil-rivera/flowit/internal/repository"
+)
+
+var _ = Describe("SQLite store", func() {
+
+	It("should be refused by builds without cgo", func() {
+
+		_, err := r.NewStore(config.Repository{Type: config.SQLiteRepository, Location: ".flowit.db"})
+		Expect(err).To(Not(BeNil()))
+		Expect(err.Error()).To(ContainSubstring("unsupported in this build"))
+
+	})
+
+})
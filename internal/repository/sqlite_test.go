@@ -0,0 +1,115 @@
+//go:build cgo
+// +build cgo
+
+package repository_test
+
+import (
+	"database/sql"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	r "github.com/yamil-rivera/flowit/internal/repository"
+)
+
+var _ = describeStore("SQLite", func() r.Store {
+	return r.NewSQLiteStore(".flowit.db")
+})
+
+var _ = Describe("SQLite store", func() {
+
+	workflow := testWorkflow()
+
+	schemaVersion := func() int {
+		db, err := sql.Open("sqlite3", ".flowit.db")
+		Expect(err).To(BeNil())
+		defer db.Close()
+		var version int
+		Expect(db.QueryRow("PRAGMA user_version").Scan(&version)).To(Succeed())
+		return version
+	}
+
+	Context("Migrating the schema", func() {
+
+		It("should create the schema on first use", func() {
+
+			rs := r.NewSQLiteStore(".flowit.db")
+			defer rs.Drop()
+
+			Expect(rs.PutWorkflow(workflow)).To(Succeed())
+			Expect(schemaVersion()).To(Equal(9))
+
+		})
+
+		It("should migrate a new DB opened by several processes at once", func() {
+
+			rs := r.NewSQLiteStore(".flowit.db")
+			defer rs.Drop()
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := r.NewSQLiteStore(".flowit.db").GetAllWorkflows(false)
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				Expect(err).To(BeNil())
+			}
+			Expect(schemaVersion()).To(Equal(9))
+
+		})
+
+		It("should refuse to use a DB created by a newer flowit version", func() {
+
+			rs := r.NewSQLiteStore(".flowit.db")
+			defer rs.Drop()
+
+			db, err := sql.Open("sqlite3", ".flowit.db")
+			Expect(err).To(BeNil())
+			_, err = db.Exec("PRAGMA user_version = 99")
+			Expect(err).To(BeNil())
+			Expect(db.Close()).To(Succeed())
+
+			_, err = rs.GetAllWorkflows(false)
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("newer than the latest supported version"))
+
+		})
+
+	})
+
+	Context("Storing workflows", func() {
+
+		It("should remove the executions of the previous workflow version", func() {
+
+			rs := r.NewSQLiteStore(".flowit.db")
+			defer rs.Drop()
+
+			Expect(rs.PutWorkflow(workflow)).To(Succeed())
+			overwritten := workflow
+			overwritten.Executions = nil
+			overwritten.LatestExecution = nil
+			overwritten.Metadata.Version++
+			Expect(rs.PutWorkflow(overwritten)).To(Succeed())
+
+			db, err := sql.Open("sqlite3", ".flowit.db")
+			Expect(err).To(BeNil())
+			defer db.Close()
+			var executions, results int
+			Expect(db.QueryRow("SELECT COUNT(*) FROM executions").Scan(&executions)).To(Succeed())
+			Expect(db.QueryRow("SELECT COUNT(*) FROM command_results").Scan(&results)).To(Succeed())
+			Expect(executions).To(Equal(0))
+			Expect(results).To(Equal(0))
+
+		})
+
+	})
+
+})
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/config"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// Store defines the methods that must be implemented in order for a struct to be considered a workflow repository
+type Store interface {
+	GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error)
+	GetWorkflows(workflowName string, count int, excludeInactive bool) ([]w.Workflow, error)
+	GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error)
+	GetWorkflowFromPreffix(workflowName, workflowIDPreffix string) (w.OptionalWorkflow, error)
+	QueryWorkflows(query Query) ([]w.Workflow, error)
+	PutWorkflow(workflow w.Workflow) error
+	DeleteWorkflow(workflowName, workflowID string) error
+	AcquireLease(lease Lease) error
+	ReleaseLease(lease Lease) error
+	BreakLease(workflowName, workflowID string) error
+	GetLease(workflowName, workflowID string) (*Lease, error)
+	PutAuditEvent(event audit.Event) error
+	GetAuditEvents(query audit.Query) ([]audit.Event, error)
+	Compact() error
+	Drop() error
+}
+
+// NewStore returns the Store implementation selected by the repository configuration
+func NewStore(repository config.Repository) (Store, error) {
+	switch repository.Type {
+	case config.BoltRepository:
+		return NewBoltStore(repository.Location), nil
+	case config.MemoryRepository:
+		return NewMemoryStore(), nil
+	case config.JSONRepository:
+		return NewJSONStore(repository.Location), nil
+	case config.SQLiteRepository:
+		return newSQLiteStore(repository.Location)
+	default:
+		return nil, errors.New("Unsupported repository type: " + repository.Type)
+	}
+}
+
+// checkVersion implements optimistic concurrency control as a compare and swap of the workflow version
+// Every update increases the version by one, so a workflow can only replace the stored version it was read from
+// when its version is the next one. Otherwise another update was saved after it was read
+func checkVersion(storedVersion uint64, workflow w.Workflow) error {
+	if workflow.Metadata.Version != storedVersion+1 {
+		return errors.Errorf("Workflow with ID: %s was updated by another flowit invocation while this one was running "+
+			"(stored version %d, updated version %d). Check its status and run the stage again if needed",
+			workflow.ID, storedVersion, workflow.Metadata.Version)
+	}
+	return nil
+}
+
+// sortWorkflows sorts workflows by ID which is the order every Store iterates them in
+func sortWorkflows(workflows []w.Workflow) {
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].ID < workflows[j].ID
+	})
+}
+
+// selectWorkflows applies the GetWorkflows criteria to a list of workflows sorted by ID
+func selectWorkflows(workflows []w.Workflow, n int, excludeInactive bool) ([]w.Workflow, error) {
+	if n < 0 {
+		return nil, nil
+	}
+	var selected []w.Workflow
+	for _, workflow := range workflows {
+		if n > 0 && len(selected) == n {
+			break
+		}
+		if !excludeInactive || workflow.IsActive {
+			selected = append(selected, workflow)
+		}
+	}
+	if n > 0 && len(selected) < n {
+		return selected, errors.New("Number of requested workflows: " + strconv.Itoa(n) +
+			" is greater than the number of items: " + strconv.Itoa(len(selected)))
+	}
+	return selected, nil
+}
+
+// AmbiguousWorkflowError is returned when a workflow reference matches several workflows
+type AmbiguousWorkflowError struct {
+	Reference  string
+	Candidates []w.Workflow
+}
+
+func (e AmbiguousWorkflowError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, candidate := range e.Candidates {
+		candidates[i] = candidate.ID
+		if candidate.Alias != "" {
+			candidates[i] += " (" + candidate.Alias + ")"
+		}
+	}
+	return e.Reference + " matches several workflows: " + strings.Join(candidates, ", ")
+}
+
+// findWorkflowFromPreffix returns the workflow which ID begins with the preffix
+// A workflow which preffix is exactly the provided one is preferred over workflows which ID merely begins with it.
+// If several workflows match, an AmbiguousWorkflowError listing them is returned
+func findWorkflowFromPreffix(workflows []w.Workflow, workflowPreffix string) (w.OptionalWorkflow, error) {
+	var exact, candidates []w.Workflow
+	for _, workflow := range workflows {
+		if workflow.Preffix == workflowPreffix {
+			exact = append(exact, workflow)
+		}
+		if strings.HasPrefix(workflow.ID, workflowPreffix) {
+			candidates = append(candidates, workflow)
+		}
+	}
+	if len(exact) > 0 {
+		candidates = exact
+	}
+	switch len(candidates) {
+	case 0:
+		return w.OptionalWorkflow{}, nil
+	case 1:
+		return w.NewWorkflowOptional(candidates[0]), nil
+	default:
+		return w.OptionalWorkflow{}, errors.WithStack(AmbiguousWorkflowError{"Workflow preffix: " +
+			workflowPreffix, candidates})
+	}
+}
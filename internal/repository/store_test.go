@@ -0,0 +1,725 @@
+package repository_test
+
+import (
+	"io/ioutil"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/config"
+	r "github.com/yamil-rivera/flowit/internal/repository"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// Every Store implementation must pass the same conformance suite
+var _ = describeStore("Bolt", func() r.Store {
+	return r.NewBoltStore(".flowitDS")
+})
+
+var _ = describeStore("Memory", func() r.Store {
+	return r.NewMemoryStore()
+})
+
+var _ = describeStore("JSON", func() r.Store {
+	location, err := ioutil.TempDir("", "flowit")
+	Expect(err).To(BeNil())
+	return r.NewJSONStore(location)
+})
+
+func testWorkflow() w.Workflow {
+	execution := w.Execution{
+		ID:                  "2",
+		Stage:               "stage",
+		Args:                []string{"arg"},
+		CheckpointSucceeded: []int{0, 2},
+		Approval:            &w.Approval{RequestedBy: "requester", ApprovedBy: "approver", Approved: 0xCDCDCDCD},
+		Results: []w.CommandResult{
+			{
+				Command:  "echo arg",
+				Output:   "arg",
+				Started:  0xABABABAB,
+				Finished: 0xBCBCBCBC,
+			},
+		},
+		Metadata: w.ExecutionMetadata{
+			Version:  0xABABABAB,
+			Started:  0xBCBCBCBC,
+			Finished: 0xCDCDCDCD,
+		},
+	}
+	return w.Workflow{
+		ID:       "1",
+		Preffix:  "workflow",
+		Alias:    "alias",
+		Name:     "definition",
+		IsActive: true,
+		Executions: []w.Execution{
+			execution,
+		},
+		LatestExecution: &execution,
+		ParentName:      "parent",
+		ParentID:        "0",
+		Children: []w.Child{
+			{Name: "child", ID: "3", Stage: "stage"},
+		},
+		State: config.Flowit{
+			Variables: map[string]interface{}{
+				"my-var": "my-val",
+			},
+		},
+		Metadata: w.WorkflowMetadata{
+			Version:  0xDEDEDEDE,
+			Started:  0xEFEFEFEF,
+			Updated:  0xABABABAB,
+			Finished: 0xBCBCBCBC,
+		},
+	}
+}
+
+func describeStore(name string, newStore func() r.Store) bool {
+
+	return Describe(name+" store", func() {
+
+		workflow := testWorkflow()
+
+		Context("Storing workflows", func() {
+
+			It("should successfully save and retrieve a populated workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+				optionalWorkflow, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				savedWorkflow, err := optionalWorkflow.Get()
+				Expect(err).To(BeNil())
+				Expect(savedWorkflow).To(Equal(workflow))
+
+			})
+
+			It("should successfully overwrite a workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+				expectedWorkflow := workflow
+				expectedWorkflow.Preffix = "other workflow"
+				expectedWorkflow.Metadata.Version++
+				err = rs.PutWorkflow(expectedWorkflow)
+				Expect(err).To(BeNil())
+				overwrittenWorkflowOption, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				overwrittenWorkflow, err := overwrittenWorkflowOption.Get()
+				Expect(err).To(BeNil())
+				Expect(overwrittenWorkflow).To(Equal(expectedWorkflow))
+
+			})
+
+			It("should reject an update based on an outdated version of the workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				Expect(rs.PutWorkflow(workflow)).To(Succeed())
+
+				firstUpdate := workflow
+				firstUpdate.Metadata.Version++
+				secondUpdate := workflow
+				secondUpdate.Metadata.Version++
+				secondUpdate.Preffix = "other workflow"
+
+				Expect(rs.PutWorkflow(firstUpdate)).To(Succeed())
+				err := rs.PutWorkflow(secondUpdate)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("was updated by another flowit invocation"))
+
+				storedWorkflowOptional, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				storedWorkflow, err := storedWorkflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(storedWorkflow).To(Equal(firstUpdate))
+
+			})
+
+			It("should reject an update which version does not follow the stored one", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				Expect(rs.PutWorkflow(workflow)).To(Succeed())
+
+				update := workflow
+				update.Metadata.Version += 2
+				err := rs.PutWorkflow(update)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("was updated by another flowit invocation"))
+
+				update = workflow
+				err = rs.PutWorkflow(update)
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("was updated by another flowit invocation"))
+
+			})
+
+		})
+
+		Context("Retrieving workflows", func() {
+
+			It("should successfully retrieve a workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+
+				workflow2 := workflow
+				workflow2.ID = "2"
+				workflow2.Preffix = "workflow 2"
+
+				err = rs.PutWorkflow(workflow2)
+				Expect(err).To(BeNil())
+
+				firstWorkflowOptional, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				firstWorkflow, err := firstWorkflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(firstWorkflow).To(Equal(workflow))
+
+			})
+
+			It("should return an empty optional when workflow does not exist", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				firstWorkflowOptional, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				_, err = firstWorkflowOptional.Get()
+				Expect(err).To(Not(BeNil()))
+
+				err = rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+
+				firstWorkflowOptional, err = rs.GetWorkflow("Definition", "1")
+				Expect(err).To(BeNil())
+				_, err = firstWorkflowOptional.Get()
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+			It("should successfully retrieve a workflow from prefix", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "100"
+				workflow1.Preffix = "workflow 1"
+
+				workflow2 := workflow
+				workflow2.ID = "200"
+				workflow2.Preffix = "workflow 2"
+
+				workflow3 := workflow
+				workflow3.ID = "300"
+				workflow3.Preffix = "workflow 3"
+
+				err := rs.PutWorkflow(workflow1)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow2)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow3)
+				Expect(err).To(BeNil())
+
+				workflowOptional, err := rs.GetWorkflowFromPreffix("definition", "1")
+				Expect(err).To(BeNil())
+				workflowWithPrefix, err := workflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(workflowWithPrefix).To(Equal(workflow1))
+
+				workflowOptional, err = rs.GetWorkflowFromPreffix("definition", "2")
+				Expect(err).To(BeNil())
+				workflowWithPrefix, err = workflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(workflowWithPrefix).To(Equal(workflow2))
+
+				workflowOptional, err = rs.GetWorkflowFromPreffix("definition", "3")
+				Expect(err).To(BeNil())
+				workflowWithPrefix, err = workflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(workflowWithPrefix).To(Equal(workflow3))
+
+			})
+
+			It("should fail to retrieve a workflow from an ambiguous prefix", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "100"
+				workflow1.Preffix = "10"
+
+				workflow2 := workflow
+				workflow2.ID = "101"
+				workflow2.Preffix = "101"
+
+				err := rs.PutWorkflow(workflow1)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow2)
+				Expect(err).To(BeNil())
+
+				_, err = rs.GetWorkflowFromPreffix("definition", "1")
+				Expect(err).To(HaveOccurred())
+				ambiguousErr, ok := errors.Cause(err).(r.AmbiguousWorkflowError)
+				Expect(ok).To(BeTrue())
+				Expect(ambiguousErr.Candidates).To(Equal([]w.Workflow{workflow1, workflow2}))
+				Expect(err.Error()).To(ContainSubstring("100 (alias), 101 (alias)"))
+
+				// A workflow which preffix is exactly the requested one is not ambiguous
+				workflowOptional, err := rs.GetWorkflowFromPreffix("definition", "10")
+				Expect(err).To(BeNil())
+				workflowWithPrefix, err := workflowOptional.Get()
+				Expect(err).To(BeNil())
+				Expect(workflowWithPrefix).To(Equal(workflow1))
+
+			})
+
+			It("should return an empty optional when a workflow does not start with prefix", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "01"
+				workflow1.Preffix = "workflow 1"
+
+				err := rs.PutWorkflow(workflow1)
+				Expect(err).To(BeNil())
+
+				workflowOptional, err := rs.GetWorkflowFromPreffix("definition", "1")
+				Expect(err).To(BeNil())
+				_, err = workflowOptional.Get()
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+			It("should successfully retrieve a list of n workflows", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "1"
+				workflow1.Preffix = "workflow 1"
+
+				workflow2 := workflow
+				workflow2.ID = "2"
+				workflow2.Preffix = "workflow 2"
+
+				workflow3 := workflow
+				workflow3.ID = "3"
+				workflow3.Preffix = "workflow 3"
+
+				err := rs.PutWorkflow(workflow1)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow2)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow3)
+				Expect(err).To(BeNil())
+
+				workflows, err := rs.GetWorkflows("definition", 0, false)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(3))
+				Expect(workflows[0]).To(Equal(workflow1))
+				Expect(workflows[1]).To(Equal(workflow2))
+				Expect(workflows[2]).To(Equal(workflow3))
+
+				workflows, err = rs.GetWorkflows("definition", 2, false)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(2))
+				Expect(workflows[0]).To(Equal(workflow1))
+				Expect(workflows[1]).To(Equal(workflow2))
+
+				workflows, err = rs.GetWorkflows("definition", 4, false)
+				Expect(len(workflows)).To(Equal(3))
+				Expect(workflows[0]).To(Equal(workflow1))
+				Expect(workflows[1]).To(Equal(workflow2))
+				Expect(workflows[2]).To(Equal(workflow3))
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+			It("should successfully retrieve a list of active workflows", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "1"
+				workflow1.Preffix = "workflow 1"
+				workflow1.IsActive = true
+
+				workflow2 := workflow
+				workflow2.ID = "2"
+				workflow2.Preffix = "workflow 2"
+				workflow2.IsActive = false
+
+				workflow3 := workflow
+				workflow3.ID = "3"
+				workflow3.Preffix = "workflow 3"
+				workflow3.IsActive = true
+
+				err := rs.PutWorkflow(workflow1)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow2)
+				Expect(err).To(BeNil())
+				err = rs.PutWorkflow(workflow3)
+				Expect(err).To(BeNil())
+
+				workflows, err := rs.GetWorkflows("definition", 0, true)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(2))
+				Expect(workflows[0]).To(Equal(workflow1))
+				Expect(workflows[1]).To(Equal(workflow3))
+
+				workflows, err = rs.GetWorkflows("definition", 1, true)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(1))
+				Expect(workflows[0]).To(Equal(workflow1))
+
+				workflows, err = rs.GetWorkflows("definition", 3, true)
+				Expect(len(workflows)).To(Equal(2))
+				Expect(workflows[0]).To(Equal(workflow1))
+				Expect(workflows[1]).To(Equal(workflow3))
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+		})
+
+		Context("Retrieving workflows of every name", func() {
+
+			It("should successfully retrieve all workflows", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "1"
+				workflow1.IsActive = true
+
+				workflow2 := workflow
+				workflow2.ID = "2"
+				workflow2.Name = "other-definition"
+				workflow2.IsActive = false
+
+				Expect(rs.PutWorkflow(workflow1)).To(Succeed())
+				Expect(rs.PutWorkflow(workflow2)).To(Succeed())
+
+				workflows, err := rs.GetAllWorkflows(false)
+				Expect(err).To(BeNil())
+				Expect(workflows).To(ConsistOf(workflow1, workflow2))
+
+				workflows, err = rs.GetAllWorkflows(true)
+				Expect(err).To(BeNil())
+				Expect(workflows).To(ConsistOf(workflow1))
+
+			})
+
+		})
+
+		Context("Querying workflows", func() {
+
+			It("should return the workflows matching every criteria, most recently updated first", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				failedExecution := w.Execution{
+					ID:          "3",
+					FromStage:   "build",
+					Stage:       "build",
+					FailedStage: "publish",
+					Failed:      true,
+				}
+
+				failed := workflow
+				failed.ID = "1"
+				failed.LatestExecution = &failedExecution
+				failed.Executions = []w.Execution{failedExecution}
+				failed.Metadata.Updated = 300
+
+				active := workflow
+				active.ID = "2"
+				active.Metadata.Updated = 200
+				active.State.Variables = map[string]interface{}{
+					"my-var": "my-other-val",
+				}
+
+				cancelled := workflow
+				cancelled.ID = "3"
+				cancelled.Name = "other-definition"
+				cancelled.IsActive = false
+				cancelled.IsCancelled = true
+				cancelled.Metadata.Updated = 100
+
+				Expect(rs.PutWorkflow(cancelled)).To(Succeed())
+				Expect(rs.PutWorkflow(active)).To(Succeed())
+				Expect(rs.PutWorkflow(failed)).To(Succeed())
+
+				workflows, err := rs.QueryWorkflows(r.Query{})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{failed, active, cancelled}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Name: "definition"})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{failed, active}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Status: w.StatusFailed, Stage: "publish"})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{failed}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Status: w.StatusCancelled})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{cancelled}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Since: 150, Until: 250})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{active}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Variables: map[string]string{"my-var": "my-val"}})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{failed, cancelled}))
+
+				workflows, err = rs.QueryWorkflows(r.Query{Stage: "stage", Variables: map[string]string{"unknown": "my-val"}})
+				Expect(err).To(BeNil())
+				Expect(workflows).To(BeEmpty())
+
+			})
+
+		})
+
+		Context("Deleting workflows", func() {
+
+			It("should successfully delete a workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+				err = rs.DeleteWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				deletedWorkflowOptional, err := rs.GetWorkflow("definition", "1")
+				Expect(err).To(BeNil())
+				_, err = deletedWorkflowOptional.Get()
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+			It("should return an error if workflow does not exist", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.DeleteWorkflow("definition", "1")
+				Expect(err).To(Not(BeNil()))
+
+			})
+
+		})
+
+		Context("Compacting", func() {
+
+			It("should keep the remaining workflows readable", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				workflow1 := workflow
+				workflow1.ID = "1"
+				workflow2 := workflow
+				workflow2.ID = "2"
+				workflow2.State.Version = "0.2"
+
+				Expect(rs.PutWorkflow(workflow1)).To(Succeed())
+				Expect(rs.PutWorkflow(workflow2)).To(Succeed())
+				Expect(rs.DeleteWorkflow("definition", "1")).To(Succeed())
+				Expect(rs.Compact()).To(Succeed())
+
+				workflows, err := rs.GetAllWorkflows(false)
+				Expect(err).To(BeNil())
+				Expect(workflows).To(Equal([]w.Workflow{workflow2}))
+				Expect(rs.PutWorkflow(workflow1)).To(Succeed())
+
+			})
+
+		})
+
+		Context("Auditing", func() {
+
+			It("should return the matching audit events in the order they were recorded", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				events := []audit.Event{
+					{User: "user", Host: "host", Directory: "/tmp", GitHead: "abcdef", Action: audit.Run,
+						WorkflowName: "feature", WorkflowID: "1", ToStage: "start", Outcome: audit.Succeeded,
+						CommandLine: []string{"flowit", "feature", "start"}, Started: 3, Finished: 4},
+					{User: "user", Host: "host", Directory: "/tmp", CommandLine: []string{"flowit", "hotfix", "start"},
+						Action: audit.Run, WorkflowName: "hotfix", WorkflowID: "2", ToStage: "start", Outcome: audit.Failed,
+						Error: "failed", Started: 1, Finished: 2},
+					{User: "user", Host: "host", Directory: "/tmp", CommandLine: []string{"flowit", "feature", "1", "cancel"},
+						Action: audit.Cancel, WorkflowName: "feature", WorkflowID: "1", FromStage: "start", Outcome: audit.Succeeded,
+						Started: 5, Finished: 6},
+				}
+				for _, event := range events {
+					Expect(rs.PutAuditEvent(event)).To(Succeed())
+				}
+
+				recorded, err := rs.GetAuditEvents(audit.Query{})
+				Expect(err).To(BeNil())
+				Expect(recorded).To(Equal(events))
+				recorded, err = rs.GetAuditEvents(audit.Query{WorkflowName: "feature"})
+				Expect(err).To(BeNil())
+				Expect(recorded).To(Equal([]audit.Event{events[0], events[2]}))
+				recorded, err = rs.GetAuditEvents(audit.Query{Since: 2})
+				Expect(err).To(BeNil())
+				Expect(recorded).To(Equal([]audit.Event{events[0], events[2]}))
+				recorded, err = rs.GetAuditEvents(audit.Query{Since: 4, WorkflowName: "hotfix"})
+				Expect(err).To(BeNil())
+				Expect(recorded).To(BeEmpty())
+
+			})
+
+		})
+
+		Context("Leasing workflows", func() {
+
+			otherHostLease := func(expires time.Duration) r.Lease {
+				lease := r.NewLease("definition", "1", "publish", expires)
+				lease.PID = 1
+				lease.Host = "other-host"
+				return lease
+			}
+
+			It("should grant a single lease per workflow", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				lease := otherHostLease(time.Hour)
+				Expect(rs.AcquireLease(lease)).To(Succeed())
+				storedLease, err := rs.GetLease("definition", "1")
+				Expect(err).To(BeNil())
+				Expect(storedLease).To(Equal(&lease))
+
+				err = rs.AcquireLease(r.NewLease("definition", "1", "finish", time.Hour))
+				Expect(err).To(Not(BeNil()))
+				Expect(errors.Cause(err)).To(BeAssignableToTypeOf(r.LeaseHeldError{}))
+				Expect(err.Error()).To(ContainSubstring("is locked by PID 1 on other-host running stage publish"))
+
+				otherWorkflowLease := r.NewLease("definition", "2", "finish", time.Hour)
+				Expect(rs.AcquireLease(otherWorkflowLease)).To(Succeed())
+
+				Expect(rs.ReleaseLease(lease)).To(Succeed())
+				storedLease, err = rs.GetLease("definition", "1")
+				Expect(err).To(BeNil())
+				Expect(storedLease).To(BeNil())
+				Expect(rs.AcquireLease(r.NewLease("definition", "1", "finish", time.Hour))).To(Succeed())
+
+			})
+
+			It("should keep the command a lease is held for", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				lease := r.NewCommandLease("definition", "1", "cancel", time.Hour)
+				lease.PID = 1
+				lease.Host = "other-host"
+				Expect(rs.AcquireLease(lease)).To(Succeed())
+				storedLease, err := rs.GetLease("definition", "1")
+				Expect(err).To(BeNil())
+				Expect(storedLease).To(Equal(&lease))
+
+				err = rs.AcquireLease(r.NewLease("definition", "1", "finish", time.Hour))
+				Expect(err).To(Not(BeNil()))
+				Expect(err.Error()).To(ContainSubstring("is locked by PID 1 on other-host running cancel"))
+
+			})
+
+			It("should grant a lease replacing a stale one", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				Expect(rs.AcquireLease(otherHostLease(-time.Second))).To(Succeed())
+				lease := r.NewLease("definition", "1", "finish", time.Hour)
+				Expect(rs.AcquireLease(lease)).To(Succeed())
+
+				deadHolderLease := r.NewLease("definition", "2", "finish", time.Hour)
+				deadHolderLease.PID = 0x7FFFFFF0
+				Expect(deadHolderLease.IsStale(time.Now())).To(BeTrue())
+				Expect(rs.AcquireLease(deadHolderLease)).To(Succeed())
+				Expect(rs.AcquireLease(r.NewLease("definition", "2", "finish", time.Hour))).To(Succeed())
+
+			})
+
+			It("should only release the lease held by the releasing process", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				brokenLease := r.NewLease("definition", "1", "publish", time.Hour)
+				Expect(rs.AcquireLease(brokenLease)).To(Succeed())
+				Expect(rs.BreakLease("definition", "1")).To(Succeed())
+				lease := otherHostLease(time.Hour)
+				Expect(rs.AcquireLease(lease)).To(Succeed())
+
+				Expect(rs.ReleaseLease(brokenLease)).To(Succeed())
+				storedLease, err := rs.GetLease("definition", "1")
+				Expect(err).To(BeNil())
+				Expect(storedLease).To(Equal(&lease))
+
+			})
+
+		})
+
+		Context("Deleting the DB", func() {
+
+			It("should successfully wipe out the DB", func() {
+
+				rs := newStore()
+				defer rs.Drop()
+
+				err := rs.PutWorkflow(workflow)
+				Expect(err).To(BeNil())
+
+				workflows, err := rs.GetWorkflows("definition", 0, false)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(1))
+
+				Expect(rs.Drop()).To(Succeed())
+
+				workflows, err = rs.GetWorkflows("definition", 0, false)
+				Expect(err).To(BeNil())
+				Expect(len(workflows)).To(Equal(0))
+
+			})
+
+		})
+
+	})
+
+}
@@ -0,0 +1,109 @@
+package runtime
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
+	"github.com/yamil-rivera/flowit/internal/config"
+	"github.com/yamil-rivera/flowit/internal/repository"
+	w "github.com/yamil-rivera/flowit/internal/workflow"
+)
+
+// CollectGarbage removes the finished and cancelled workflows the retention policy does not keep
+// and compacts the repository afterwards. Workflows are archived before being removed if the policy
+// has an archive directory. If dryRun is true the workflows that would be removed are only written
+func (s *Service) CollectGarbage(retention config.Retention, dryRun bool, writer Writer) error {
+	if retention.Days == 0 && retention.Keep == 0 {
+		// nolint: errcheck
+		writer.Write("No retention policy is configured, every workflow is kept")
+		return nil
+	}
+	workflows, err := s.repositoryService.GetAllWorkflows(false)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := time.Now()
+	expired := expiredWorkflows(workflows, retention, now)
+	if len(expired) == 0 {
+		// nolint: errcheck
+		writer.Write("No workflows to remove")
+		return nil
+	}
+	for _, workflow := range expired {
+		// nolint: errcheck
+		writer.Write("Workflow with ID: " + workflow.ID + " (" + workflow.Name + ", " + string(workflow.Status()) + " on " +
+			time.Unix(0, int64(workflow.Metadata.Finished)).Format("2006-01-02") + ") will be removed")
+	}
+	if dryRun {
+		return nil
+	}
+
+	if retention.Archive != "" {
+		archive, err := repository.WriteArchive(retention.Archive, expired, now)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// nolint: errcheck
+		writer.Write("Workflows archived in: " + archive + ". They can be restored with 'flowit import " + archive + "'")
+	}
+	for _, workflow := range expired {
+		event := audit.NewEvent(audit.Remove, workflow.Name, workflow.ID)
+		event.FromStage = workflow.LatestStage()
+		if err := s.audit(event, s.remove(workflow, writer), writer); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := s.repositoryService.Compact(); err != nil {
+		return errors.WithStack(err)
+	}
+	// nolint: errcheck
+	writer.Write(strconv.Itoa(len(expired)) + " workflows were removed")
+	return nil
+}
+
+// remove deletes the workflow unless another flowit invocation holds a lease on it
+func (s *Service) remove(workflow w.Workflow, writer Writer) error {
+	release, err := s.lockForCommand(workflow.Name, workflow.ID, "gc", writer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
+	return errors.WithStack(s.repositoryService.DeleteWorkflow(workflow.Name, workflow.ID))
+}
+
+// expiredWorkflows returns the finished and cancelled workflows which neither finished within the retention days
+// nor are among the retention most recently finished workflows with the same name
+func expiredWorkflows(workflows []w.Workflow, retention config.Retention, now time.Time) []w.Workflow {
+	inactive := make(map[string][]w.Workflow)
+	var names []string
+	for _, workflow := range workflows {
+		if workflow.IsActive {
+			continue
+		}
+		if _, ok := inactive[workflow.Name]; !ok {
+			names = append(names, workflow.Name)
+		}
+		inactive[workflow.Name] = append(inactive[workflow.Name], workflow)
+	}
+	sort.Strings(names)
+
+	threshold := uint64(now.AddDate(0, 0, -retention.Days).UnixNano())
+	var expired []w.Workflow
+	for _, name := range names {
+		finished := inactive[name]
+		sort.SliceStable(finished, func(i, j int) bool {
+			return finished[i].Metadata.Finished > finished[j].Metadata.Finished
+		})
+		for i, workflow := range finished {
+			keptByCount := retention.Keep > 0 && i < retention.Keep
+			keptByAge := retention.Days > 0 && workflow.Metadata.Finished >= threshold
+			if !keptByCount && !keptByAge {
+				expired = append(expired, workflow)
+			}
+		}
+	}
+	return expired
+}
@@ -1,63 +1,184 @@
 package runtime
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/yamil-rivera/flowit/internal/audit"
 	"github.com/yamil-rivera/flowit/internal/config"
+	"github.com/yamil-rivera/flowit/internal/expression"
 	"github.com/yamil-rivera/flowit/internal/fsm"
+	"github.com/yamil-rivera/flowit/internal/git"
+	"github.com/yamil-rivera/flowit/internal/repository"
 	"github.com/yamil-rivera/flowit/internal/utils"
 	w "github.com/yamil-rivera/flowit/internal/workflow"
 )
 
+// leaseDuration bounds how long a workflow stays locked by a process that never released it
+// on a host where the process can not be checked
+const leaseDuration = 24 * time.Hour
+
+// ConflictPolicy decides what happens to imported workflows which ID already exists in the repository
+type ConflictPolicy string
+
+// Supported conflict policies
+const (
+	SkipConflicts      ConflictPolicy = "skip"
+	OverwriteConflicts ConflictPolicy = "overwrite"
+	RenameConflicts    ConflictPolicy = "rename"
+)
+
+// ConflictPolicies returns all the supported conflict policies
+func ConflictPolicies() []ConflictPolicy {
+	return []ConflictPolicy{SkipConflicts, OverwriteConflicts, RenameConflicts}
+}
+
 // Service exposes the methods to interact with the Runtime Service
 type Service struct {
-	repositoryService RepositoryService
+	repositoryService repository.Store
 	fsmServiceFactory fsm.FsmServiceFactory
 	workflowService   WorkflowService
+	auditLog          AuditLog
 }
 
-// RepositoryService defines the methods that must be implemented in order for a struct to be considered a Repository Service by the RuntimeService
-type RepositoryService interface {
-	GetWorkflow(workflowName, workflowID string) (w.OptionalWorkflow, error)
-	GetWorkflows(workflowName string, count int, excludeInactive bool) ([]w.Workflow, error)
-	GetAllWorkflows(excludeInactive bool) ([]w.Workflow, error)
-	GetWorkflowFromPreffix(workflowName, workflowIDPreffix string) (w.OptionalWorkflow, error)
-	PutWorkflow(workflow w.Workflow) error
-}
-
-// WorkflowService defines the methods that must be implemented in order for a struct to be considered a Workflow Service by the RuntimeService
+// WorkflowService defines the methods that must be implemented in order for
+// a struct to be considered a Workflow Service by the RuntimeService
 type WorkflowService interface {
 	CreateWorkflow(workflowName string, definition config.Flowit) *w.Workflow
+	AssignPreffix(workflow *w.Workflow, workflows []w.Workflow)
+	SetAlias(workflow *w.Workflow, alias string)
+	RenameWorkflow(workflow *w.Workflow, workflows []w.Workflow)
 	CancelWorkflow(workflow *w.Workflow)
 	StartExecution(workflow *w.Workflow, fromStage, currentState string, args []string) *w.Execution
-	SetCheckpoint(execution *w.Execution, checkpoint int)
+	SetCheckpoint(execution *w.Execution, checkpoint int, succeeded []int)
+	AddCommandResult(execution *w.Execution, command, output string, failed bool, started uint64)
+	SkipCommand(execution *w.Execution, command string)
+	RequestApproval(execution *w.Execution, user string)
+	Approve(workflow *w.Workflow, user string) error
 	FinishExecution(workflow *w.Workflow, execution *w.Execution, workflowState w.WorkflowState) error
 	AddVariables(workflow *w.Workflow, variables map[string]interface{})
+	UpgradeWorkflow(workflow *w.Workflow, definition config.Flowit)
+	SetParent(workflow *w.Workflow, parent w.Workflow)
+	AddChild(workflow *w.Workflow, child w.Workflow, stage string)
 }
 
-// Writer defines the methods that must be implemented in order for a struct to be considered a Writer by the RuntimeService
+// AuditLog defines the methods that must be implemented in order for
+// a struct to be considered an AuditLog by the RuntimeService
+// Every change to a workflow instance, successful or not, is recorded in it
+type AuditLog interface {
+	Record(event audit.Event) error
+}
+
+// Writer defines the methods that must be implemented in order for
+// a struct to be considered a Writer by the RuntimeService
 // A Writer is an object which encapsulates a write side-effect
 // It is used by the RuntimeService to avoid depending on a concrete logging implementation
 type Writer interface {
 	Write(s string) error
 }
 
-// Executor defines the methods that must be implemented in order for a struct to be considered an Executor by the RuntimeService
+// Prompter defines the methods that must be implemented in order for
+// a struct to be considered a Prompter by the RuntimeService
+// It asks the user to confirm the stages and actions which require it before they are run
+type Prompter interface {
+	Confirm(question string) (bool, error)
+}
+
+// Executor defines the methods that must be implemented in order for
+// a struct to be considered an Executor by the RuntimeService
+// Execute is called concurrently to run the commands of parallel groups
 type Executor interface {
-	Config(shell string)
-	Execute(command string) (string, error)
+	Config(config ExecutorConfig)
+	Execute(command Command) (string, error)
+}
+
+// ExecutorConfig holds how an Executor runs the commands of a workflow
+type ExecutorConfig struct {
+	Shell string
+	// CleanEnv runs commands without the environment flowit runs with, except for the AllowEnv variables
+	CleanEnv bool
+	AllowEnv []string
+}
+
+// Command is a shell command ready to be run by an Executor
+type Command struct {
+	Script string
+	// Args are the positional parameters of the script, $1 onwards
+	Args []string
+	// Env holds the environment variables the script is run with on top of the inherited ones
+	Env map[string]string
+	// Dir is the directory the script is run in. The current directory is used if it is empty
+	Dir string
+}
+
+// CommandGuardEvaluator evaluates transition guards as commands or expressions with the workflow variables
+// A guard passes if its command succeeds or its expression is true
+type CommandGuardEvaluator struct {
+	executor    Executor
+	environment expression.Environment
+	settings    commandSettings
+}
+
+// parentWorkflow holds the workflow which stage is spawning a new workflow and the variables it maps into it
+type parentWorkflow struct {
+	workflow  *w.Workflow
+	variables map[string]interface{}
 }
 
 // UnixShellExecutor is the default implementation of the Executor interface
 type UnixShellExecutor struct {
-	shell string
+	config ExecutorConfig
 }
 
 // NewService returns a new instance of the RuntimeService
-func NewService(rs RepositoryService, fsf fsm.FsmServiceFactory, ws WorkflowService) *Service {
-	return &Service{rs, fsf, ws}
+func NewService(rs repository.Store, fsf fsm.FsmServiceFactory, ws WorkflowService, al AuditLog) *Service {
+	return &Service{rs, fsf, ws, al}
+}
+
+// NewCommandGuardEvaluator returns a CommandGuardEvaluator running guards with an executor already configured
+// for the workflow. previous is the execution preceding the one the guards are evaluated for, if any
+// Guard commands are run with the workflow context, their from stage being the stage the previous execution ran
+func NewCommandGuardEvaluator(executor Executor, workflow w.Workflow, previous *w.Execution) *CommandGuardEvaluator {
+	fromStage := ""
+	if previous != nil {
+		fromStage = previous.Stage
+	}
+	settings := newCommandSettings(workflow, fromStage, "", "")
+	return &CommandGuardEvaluator{executor, expressionEnvironment(workflow, previous, settings, executor), settings}
+}
+
+// Evaluate runs the guard command, or evaluates the guard expression,
+// and returns why the guard does not pass, if it does not
+// The reason is the command output or, if there is none, the command itself
+func (e *CommandGuardEvaluator) Evaluate(guard string) error {
+	if expression.IsExpression(guard) {
+		passed, err := evaluateExpression(guard, e.environment)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !passed {
+			return errors.New(guard + " is false")
+		}
+		return nil
+	}
+	command, shown, err := renderCommand(guard, e.environment.Variables, e.settings)
+	if err != nil {
+		return errors.Wrap(err, "Error evaluating variables in guard: "+guard)
+	}
+	out, err := e.executor.Execute(command)
+	if err == nil {
+		return nil
+	}
+	if out != "" {
+		return errors.New(out)
+	}
+	return errors.New(shown + " failed")
 }
 
 // NewUnixShellExecutor returns an Executor instance based on the UnixShellExecutor
@@ -65,115 +186,534 @@ func NewUnixShellExecutor() Executor {
 	return &UnixShellExecutor{}
 }
 
-// Config configures the UnixShellExecutor using a shell binary location
-func (e *UnixShellExecutor) Config(shell string) {
-	e.shell = shell
+// NewExecutorConfig returns the configuration executors run the commands of a workflow definition with
+func NewExecutorConfig(config config.Config) ExecutorConfig {
+	return ExecutorConfig{config.Shell, config.CleanEnv, config.AllowEnv}
+}
+
+// Config configures the UnixShellExecutor using a shell binary location and the environment commands inherit
+func (e *UnixShellExecutor) Config(config ExecutorConfig) {
+	e.config = config
 }
 
 // TODO: Handle && exit 1
 // Execute receives a command, runs it using the configured shell and returns the produced output
-func (e *UnixShellExecutor) Execute(command string) (string, error) {
-	shellArgs := strings.Split(e.shell, " ")
+// The shell names the script flowit, so its positional parameters start at $1
+// The command environment, which holds the workflow context, is added to the environment flowit runs with
+// or, with a clean environment, to its allowed variables
+func (e *UnixShellExecutor) Execute(command Command) (string, error) {
+	shellArgs := strings.Split(e.config.Shell, " ")
 	mainCommand := shellArgs[0]
-	restOfArgs := append(shellArgs[1:], "-c", command)
+	restOfArgs := append(shellArgs[1:], "-c", command.Script, "flowit")
+	restOfArgs = append(restOfArgs, command.Args...)
 	cmd := exec.Command(mainCommand, restOfArgs...)
+	cmd.Dir = command.Dir
+	cmd.Env = e.inheritedEnv()
+	for name, value := range command.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
 	out, err := cmd.Output()
 	trimmedOut := strings.TrimSuffix(string(out), "\n")
 	if err != nil {
-		return trimmedOut, errors.Wrap(err, "Error executing command: "+command+" with shell: "+e.shell)
+		return trimmedOut, errors.Wrap(err, "Error executing command: "+command.Script+" with shell: "+e.config.Shell)
 	}
 	return trimmedOut, nil
 }
 
+// inheritedEnv returns the variables of the environment flowit runs with which commands inherit
+func (e *UnixShellExecutor) inheritedEnv() []string {
+	if !e.config.CleanEnv {
+		return os.Environ()
+	}
+	env := []string{}
+	for _, name := range e.config.AllowEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
 // Run executes a workflow stage based on the provided configuration or based on a persisted workflow
 // If optionalWorkflowPreffix is not empty, the workflow state will be retrieved from the repository
-// If optionalWorkflowPreffix is empty, the provided workflow definition will be used to create a new workflow in the repository
-func (s *Service) Run(optionalWorkflowPreffix utils.OptionalString, args []string, workflowName, stageID string, workflowDefinition config.Flowit, executor Executor, writer Writer) error {
-	var workflow *w.Workflow
-	if !optionalWorkflowPreffix.IsSet() {
-		workflow = s.workflowService.CreateWorkflow(workflowName, workflowDefinition)
-		// nolint: errcheck
-		writer.Write("Workflow with ID: " + workflow.ID + " was created")
-	} else {
-		workflowPreffix, _ := optionalWorkflowPreffix.Get()
-		optionalWorkflow, _ := s.repositoryService.GetWorkflowFromPreffix(workflowName, workflowPreffix)
-		wf, _ := optionalWorkflow.Get()
-		workflow = &wf
+// If optionalWorkflowPreffix is empty, the provided workflow definition will be used to create a new workflow
+// in the repository which can also be addressed with the alias, if provided
+// The stages and actions which require a confirmation are only run if prompter confirms them
+func (s *Service) Run(optionalWorkflowPreffix utils.OptionalString, alias string, args []string,
+	workflowName, stageID string, workflowDefinition config.Flowit, executor Executor, writer Writer,
+	prompter Prompter) error {
+	event := audit.NewEvent(audit.Run, workflowName, "")
+	event.ToStage = stageID
+	err := s.run(stageRun{
+		workflowPreffix: optionalWorkflowPreffix,
+		alias:           alias,
+		args:            args,
+		workflowName:    workflowName,
+		stageID:         stageID,
+		definition:      workflowDefinition,
+		executor:        executor,
+		writer:          writer,
+		prompter:        prompter,
+		event:           &event,
+	})
+	return s.audit(event, err, writer)
+}
+
+// stageRun holds what a stage of a workflow is run with
+type stageRun struct {
+	// workflowPreffix is empty when a new workflow is created
+	workflowPreffix utils.OptionalString
+	alias           string
+	args            []string
+	workflowName    string
+	stageID         string
+	definition      config.Flowit
+	executor        Executor
+	writer          Writer
+	prompter        Prompter
+	event           *audit.Event
+	// parent is set when the workflow is spawned by a stage of another workflow
+	parent *parentWorkflow
+}
+
+// stageExecution holds the execution of a stage being run and what its commands are run with
+type stageExecution struct {
+	workflow    *w.Workflow
+	execution   *w.Execution
+	stage       config.Stage
+	fromStageID string
+	// previous is the execution preceding the one being run, if any
+	previous *w.Execution
+	// checkpoint and succeeded are where a failed execution of the stage is resumed from
+	checkpoint  int
+	succeeded   []int
+	settings    commandSettings
+	environment expression.Environment
+}
+
+func (s *Service) run(r stageRun) error {
+	workflow, release, err := s.stageWorkflow(r)
+	if err != nil {
+		return errors.WithStack(err)
 	}
+	defer release()
+
 	fsmService, err := s.fsmServiceFactory.NewFsmService(workflow.State)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	e, err := s.startExecution(r, workflow, fsmService)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.runStage(r, e); err != nil {
+		return errors.WithStack(err)
+	}
+
+	workflowState := w.STARTED
+	if fsmService.IsFinalState(workflow.StateMachineID(), r.stageID) {
+		workflowState = w.FINISHED
+	}
+	if err := s.workflowService.FinishExecution(workflow, e.execution, workflowState); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.repositoryService.PutWorkflow(*workflow); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// stageWorkflow returns the workflow the stage is run on, together with the function releasing its lease
+func (s *Service) stageWorkflow(r stageRun) (*w.Workflow, func(), error) {
+	if !r.workflowPreffix.IsSet() {
+		workflow, err := s.createWorkflow(r)
+		return workflow, func() {}, err
+	}
+	workflowPreffix, _ := r.workflowPreffix.Get()
+	return s.lockWorkflow(r, workflowPreffix)
+}
+
+// createWorkflow creates the workflow a stage is run on for the first time
+func (s *Service) createWorkflow(r stageRun) (*w.Workflow, error) {
+	workflow := s.workflowService.CreateWorkflow(r.workflowName, r.definition)
+	workflows, err := s.repositoryService.GetWorkflows(r.workflowName, 0, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.workflowService.AssignPreffix(workflow, workflows)
+	if r.alias != "" {
+		if err := validateAlias(r.alias, *workflow, r.definition, workflows); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.workflowService.SetAlias(workflow, r.alias)
+	}
+	if r.parent != nil {
+		s.workflowService.SetParent(workflow, *r.parent.workflow)
+		s.workflowService.AddVariables(workflow, r.parent.variables)
+	}
+	r.event.WorkflowID = workflow.ID
+	// nolint: errcheck
+	r.writer.Write("Workflow with ID: " + workflow.ID + " was created")
+	return workflow, nil
+}
+
+// lockWorkflow takes the lease on the workflow with the provided preffix and returns it as it is stored once locked
+// The lease keeps other flowit invocations from running stages of this workflow until this one finishes
+func (s *Service) lockWorkflow(r stageRun, workflowPreffix string) (*w.Workflow, func(), error) {
+	optionalWorkflow, err := s.repositoryService.GetWorkflowFromPreffix(r.workflowName, workflowPreffix)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	workflow, err := optionalWorkflow.Get()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Workflow with ID preffix: "+workflowPreffix+" does not exist")
+	}
+	r.event.WorkflowID = workflow.ID
+
+	lease := repository.NewLease(r.workflowName, workflow.ID, r.stageID, leaseDuration)
+	release, err := s.lock(lease, workflow.Preffix, r.writer)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	// The workflow is read again since another flowit invocation may have saved it
+	// between the first read and taking the lease
+	if optionalWorkflow, err = s.repositoryService.GetWorkflow(r.workflowName, workflow.ID); err == nil {
+		workflow, err = optionalWorkflow.Get()
+	}
+	if err != nil {
+		release()
+		return nil, nil, errors.WithStack(err)
+	}
+	if workflow.IsDrifted(r.definition.Hash()) {
+		// nolint: errcheck
+		r.writer.Write("Warning: the workflow definition changed since workflow with ID: " + workflow.ID + " was created. " +
+			"Run 'flowit " + r.workflowName + " " + workflow.Preffix + " upgrade' to start using it")
+	}
+	return &workflow, release, nil
+}
+
+// startExecution checks the stage can be run on the workflow and starts its execution
+func (s *Service) startExecution(r stageRun, workflow *w.Workflow, fsmService fsm.Service) (stageExecution, error) {
+	fromStageID, err := s.checkTransition(r, *workflow, fsmService)
+	if err != nil {
+		return stageExecution{}, errors.WithStack(err)
+	}
+	e := stageExecution{workflow: workflow, stage: workflow.Stage(r.stageID), fromStageID: fromStageID}
+	if e.checkpoint, e.succeeded, err = resumeCheckpoint(*workflow, r.args); err != nil {
+		return stageExecution{}, errors.WithStack(err)
+	}
+
+	e.previous = workflow.LatestExecution
+	e.execution = s.workflowService.StartExecution(workflow, fromStageID, r.stageID, r.args)
+	if err := s.addArgs(workflow, e.stage, r.args); err != nil {
+		return stageExecution{}, errors.WithStack(err)
+	}
+
+	// Set executor for this run based on workflow state
+	r.executor.Config(NewExecutorConfig(workflow.State.Config))
+
+	guardEvaluator := NewCommandGuardEvaluator(r.executor, *workflow, e.previous)
+	reason := fsmService.BlockedReason(workflow.StateMachineID(), fromStageID, e.stage.ID, guardEvaluator)
+	if reason != "" {
+		return stageExecution{}, errors.Errorf("Transition from %s to %s is blocked: %s", fromStageID, r.stageID, reason)
+	}
+	if err := s.waitForChildren(*workflow, fromStageID); err != nil {
+		return stageExecution{}, errors.Wrapf(err, "Transition from %s to %s is blocked", fromStageID, r.stageID)
+	}
 
+	e.settings = newCommandSettings(*workflow, fromStageID, r.stageID, e.execution.ID)
+	e.environment = expressionEnvironment(*workflow, e.previous, e.settings, r.executor)
+	return e, nil
+}
+
+// checkTransition returns the stage the workflow is leaving if it can transition to the stage being run
+func (s *Service) checkTransition(r stageRun, workflow w.Workflow, fsmService fsm.Service) (string, error) {
 	fromStageID := fsmService.OriginState()
 	if workflow.LatestExecution != nil {
 		fromStageID = workflow.LatestExecution.Stage
+		r.event.FromStage = fromStageID
+	}
+	if !fsmService.IsTransitionValid(workflow.StateMachineID(), fromStageID, workflow.Stage(r.stageID).ID) {
+		return "", errors.Errorf("Invalid transition from %s to %s", fromStageID, r.stageID)
+	}
+	if workflow.IsPendingApproval() {
+		return "", errors.Errorf("Transition from %s to %s is blocked: waiting for approval. "+
+			"A user other than %s must run 'flowit %s %s approve'",
+			fromStageID, r.stageID, workflow.LatestExecution.Approval.RequestedBy, r.workflowName, workflow.Preffix)
 	}
+	return fromStageID, nil
+}
 
-	stage := workflow.Stage(stageID)
-	if !fsmService.IsTransitionValid(workflow.StateMachineID(), fromStageID, stage.ID) {
-		return errors.Errorf("Invalid transition from %s to %s", fromStageID, stageID)
+// resumeCheckpoint returns where the latest execution of the workflow failed when checkpoints are enabled
+// A failed execution can only be resumed with its arguments
+func resumeCheckpoint(workflow w.Workflow, args []string) (int, []int, error) {
+	lastExecution := workflow.LatestExecution
+	if lastExecution == nil || !workflow.State.Config.CheckpointExecution {
+		return 0, nil, nil
+	}
+	if lastExecution.Failed && !utils.CompareSlices(lastExecution.Args, args) {
+		return 0, nil, errors.Errorf("Arguments: %+v do not match with last failed execution arguments: %+v",
+			args, lastExecution.Args)
 	}
+	if lastExecution.Checkpoint < 0 {
+		return 0, nil, nil
+	}
+	return lastExecution.Checkpoint, lastExecution.CheckpointSucceeded, nil
+}
 
-	checkpoint := 0
-	if workflow.LatestExecution != nil && workflow.State.Config.CheckpointExecution {
-		lastExecution := workflow.LatestExecution
-		if lastExecution.Failed && !utils.CompareSlices(lastExecution.Args, args) {
-			return errors.Errorf("Arguments: %+v do not match with last failed execution arguments: %+v", args, lastExecution.Args)
+// addArgs adds the arguments the stage is run with to the workflow variables
+func (s *Service) addArgs(workflow *w.Workflow, stage config.Stage, args []string) error {
+	if len(stage.Args) == 0 {
+		return nil
+	}
+	if len(args) != len(stage.Args) {
+		return errors.Errorf("Wrong number of arguments provided. Expected %d but got %d.", len(stage.Args), len(args))
+	}
+	variables := make(map[string]interface{})
+	for i, arg := range stage.Args {
+		variable, err := utils.ExtractVariableNameFromVariableDeclaration(arg)
+		if err != nil {
+			return errors.WithStack(err)
 		}
-		if lastExecution.Checkpoint >= 0 {
-			checkpoint = lastExecution.Checkpoint
+		variables[variable] = args[i]
+	}
+	s.workflowService.AddVariables(workflow, variables)
+	return nil
+}
+
+// runStage runs the conditions, actions and spawns of the stage once it is confirmed
+func (s *Service) runStage(r stageRun, e stageExecution) error {
+	err := s.runConditions(e.execution, e.stage.Conditions, e.environment, e.settings, r.executor, r.writer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if e.stage.Confirm != "" {
+		if err := confirm(e.stage.Confirm, e.workflow.State.Variables, r.prompter); err != nil {
+			return errors.Wrap(err, "Stage "+r.stageID+" was not run")
 		}
 	}
 
-	execution := s.workflowService.StartExecution(workflow, fromStageID, stageID, args)
+	actionCount, err := s.runActions(r, e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// A checkpoint past the actions is set on the first workflow which was not spawned yet
+	firstSpawn := 0
+	if e.checkpoint > actionCount {
+		firstSpawn = e.checkpoint - actionCount
+	}
+	if err := s.spawnChildren(r, e, actionCount, firstSpawn); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if e.stage.Type == config.ApprovalStage {
+		s.workflowService.RequestApproval(e.execution, r.event.User)
+		// nolint: errcheck
+		r.writer.Write("Stage " + r.stageID + " requires approval. A user other than " + r.event.User +
+			" must run 'flowit " + r.workflowName + " " + e.workflow.Preffix + " approve' before leaving it")
+	}
+	return nil
+}
 
-	if len(stage.Args) > 0 {
-		if len(args) != len(stage.Args) {
-			return errors.Errorf("Wrong number of arguments provided. Expected %d but got %d.", len(stage.Args), len(args))
+// waitForChildren returns an error listing the workflows spawned by the stage the workflow is leaving
+// which have not finished yet
+func (s *Service) waitForChildren(workflow w.Workflow, stageID string) error {
+	var pending []string
+	for _, child := range workflow.StageChildren(stageID) {
+		optionalChild, err := s.repositoryService.GetWorkflow(child.Name, child.ID)
+		if err != nil {
+			return errors.WithStack(err)
 		}
-		variables := make(map[string]interface{})
-		for i, arg := range stage.Args {
-			variable, err := utils.ExtractVariableNameFromVariableDeclaration(arg)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			variables[variable] = args[i]
+		childWorkflow, err := optionalChild.Get()
+		if err != nil {
+			// Deleted children can never finish so they do not block their parent
+			continue
 		}
-		s.workflowService.AddVariables(workflow, variables)
+		if status := childWorkflow.Status(); status != w.StatusFinished {
+			pending = append(pending, child.Name+" "+childWorkflow.Preffix+" ("+string(status)+")")
+		}
+	}
+	if len(pending) > 0 {
+		return errors.New("waiting for child workflows: " + strings.Join(pending, ", "))
 	}
+	return nil
+}
 
-	// Set executor for this run based on workflow state
-	executor.Config(workflow.State.Config.Shell)
+// spawnedWorkflow is a workflow a stage spawns together with the stage it starts at and what it starts with
+type spawnedWorkflow struct {
+	workflow  string
+	stage     string
+	args      []string
+	variables map[string]interface{}
+}
 
-	err = s.runConditions(stage.Conditions, workflow.State.Variables, executor, writer)
+// spawnChildren creates the workflows the stage spawns from the first one, running their initial stage with the
+// mapped variables. Every spawned workflow is recorded as a child of the workflow. The workflow is saved before the
+// first workflow is spawned and after each one, as if its execution failed at the next one, so that children are never
+// lost and resuming the stage only spawns the ones which were not spawned yet. Spawns are numbered after the
+// actionCount actions
+func (s *Service) spawnChildren(r stageRun, e stageExecution, actionCount, first int) error {
+	if first >= len(e.stage.Spawn) {
+		return nil
+	}
+	// Every spawn is checked before any workflow is spawned
+	spawns, err := spawnedWorkflows(*e.workflow, e.stage, config.WorkflowDefinition{Flowit: r.definition})
 	if err != nil {
 		return errors.WithStack(err)
 	}
-
-	err = s.runActions(workflow, execution, stage.Actions, workflow.State.Variables, workflow.State.Config.CheckpointExecution, checkpoint, executor, writer)
-	if err != nil {
+	if err := s.saveSpawnCheckpoint(e.workflow, e.execution, actionCount+first); err != nil {
 		return errors.WithStack(err)
 	}
+	for i := first; i < len(spawns); i++ {
+		spawn := spawns[i]
+		// nolint: errcheck
+		r.writer.Write("Spawning " + spawn.workflow + " workflow...")
+		event := audit.NewEvent(audit.Run, spawn.workflow, "")
+		event.ToStage = spawn.stage
+		child := r
+		child.workflowPreffix = utils.OptionalString{}
+		child.alias = ""
+		child.args = spawn.args
+		child.workflowName = spawn.workflow
+		child.stageID = spawn.stage
+		child.event = &event
+		child.parent = &parentWorkflow{e.workflow, spawn.variables}
+		if err := s.audit(event, s.run(child), r.writer); err != nil {
+			if e.workflow.State.Config.CheckpointExecution {
+				// nolint: errcheck
+				r.writer.Write("Checkpoint set on spawned workflow: " + spawn.workflow)
+			}
+			return errors.Wrap(err, "Error spawning "+spawn.workflow+" workflow")
+		}
+		s.workflowService.AddChild(e.workflow, w.Workflow{Name: spawn.workflow, ID: event.WorkflowID}, e.stage.ID)
+		if err := s.saveSpawnCheckpoint(e.workflow, e.execution, actionCount+i+1); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	// The spawned workflows run with their own executor configuration
+	r.executor.Config(NewExecutorConfig(e.workflow.State.Config))
+	return nil
+}
 
-	stateMachineID := workflow.StateMachineID()
-	isFinal := fsmService.IsFinalState(stateMachineID, stageID)
-	workflowState := w.STARTED
-	if isFinal {
-		workflowState = w.FINISHED
+// spawnedWorkflows returns the workflows the stage spawns with the variables of the workflow mapped into them
+func spawnedWorkflows(workflow w.Workflow, stage config.Stage,
+	definition config.WorkflowDefinition) ([]spawnedWorkflow, error) {
+	spawns := make([]spawnedWorkflow, len(stage.Spawn))
+	for i, spawn := range stage.Spawn {
+		childStageID, err := spawnStage(spawn, definition)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		childStage, err := definition.Stage(spawn.Workflow, childStageID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		variables := make(map[string]interface{}, len(spawn.Variables))
+		for name, expression := range spawn.Variables {
+			value, err := utils.EvaluateVariablesInExpression(expression, workflow.State.Variables)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error evaluating variables in spawned workflow variable: "+name)
+			}
+			variables[name] = value
+		}
+		args := make([]string, len(childStage.Args))
+		for j, arg := range childStage.Args {
+			name, err := utils.ExtractVariableNameFromVariableDeclaration(arg)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			value, ok := variables[name]
+			if !ok {
+				return nil, errors.Errorf("Spawned workflow %s needs variable %s to run its %s stage",
+					spawn.Workflow, name, childStageID)
+			}
+			if args[j], ok = value.(string); !ok {
+				return nil, errors.Errorf("Spawned workflow %s variable %s must be a string to be an argument of its %s stage",
+					spawn.Workflow, name, childStageID)
+			}
+		}
+		spawns[i] = spawnedWorkflow{spawn.Workflow, childStageID, args, variables}
 	}
-	err = s.workflowService.FinishExecution(workflow, execution, workflowState)
-	if err != nil {
+	return spawns, nil
+}
+
+// saveSpawnCheckpoint saves the workflow as if its execution failed
+// at the checkpoint, keeping the workflow being run untouched
+// but for its version, which must keep increasing
+func (s *Service) saveSpawnCheckpoint(workflow *w.Workflow, execution *w.Execution, checkpoint int) error {
+	snapshot := *workflow
+	snapshot.Executions = append([]w.Execution(nil), workflow.Executions...)
+	failed := *execution
+	snapshot.LatestExecution = &failed
+	if snapshot.State.Config.CheckpointExecution {
+		s.workflowService.SetCheckpoint(&failed, checkpoint, nil)
+	}
+	if err := s.workflowService.FinishExecution(&snapshot, &failed, w.FAILED); err != nil {
 		return errors.WithStack(err)
 	}
-	if err := s.repositoryService.PutWorkflow(*workflow); err != nil {
+	if err := s.repositoryService.PutWorkflow(snapshot); err != nil {
 		return errors.WithStack(err)
 	}
+	workflow.Metadata.Version = snapshot.Metadata.Version
 	return nil
 }
 
+// spawnStage returns the stage a spawned workflow starts at
+func spawnStage(spawn config.Spawn, definition config.WorkflowDefinition) (string, error) {
+	if spawn.Stage != "" {
+		return spawn.Stage, nil
+	}
+	childWorkflow, err := definition.Workflow(spawn.Workflow)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	stateMachine, err := definition.StateMachine(childWorkflow.StateMachine)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(stateMachine.InitialStages) != 1 {
+		return "", errors.New("The stage spawned workflow " + spawn.Workflow + " starts at must be specified")
+	}
+	return stateMachine.InitialStages[0], nil
+}
+
+// lock takes the lease so that no other flowit invocation changes the workflow until the returned function releases it
+// It fails if another invocation holds a lease on the workflow which is not stale
+func (s *Service) lock(lease repository.Lease, workflowPreffix string, writer Writer) (func(), error) {
+	if err := s.repositoryService.AcquireLease(lease); err != nil {
+		if _, held := errors.Cause(err).(repository.LeaseHeldError); held {
+			return nil, errors.Wrap(err, "Wait for it to finish or run 'flowit "+lease.WorkflowName+" "+workflowPreffix+
+				" unlock --force' if it is no longer running")
+		}
+		return nil, errors.WithStack(err)
+	}
+	return func() {
+		if err := s.repositoryService.ReleaseLease(lease); err != nil {
+			// nolint: errcheck
+			writer.Write("Warning: could not release the lock on workflow with ID: " + lease.WorkflowID + ": " + err.Error())
+		}
+	}, nil
+}
+
+// lockForCommand takes the lease on the provided workflowID for a command which changes it without running a stage
+func (s *Service) lockForCommand(workflowName, workflowID, command string, writer Writer) (func(), error) {
+	return s.lock(repository.NewCommandLease(workflowName, workflowID, command, leaseDuration), workflowID, writer)
+}
+
 // Cancel marks the provided workflowID as cancelled
 func (s *Service) Cancel(workflowID string, workflowName string, writer Writer) error {
+	event := audit.NewEvent(audit.Cancel, workflowName, workflowID)
+	return s.audit(event, s.cancel(workflowID, workflowName, writer, &event), writer)
+}
+
+func (s *Service) cancel(workflowID string, workflowName string, writer Writer, event *audit.Event) error {
+	release, err := s.lockForCommand(workflowName, workflowID, "cancel", writer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
 	workflowOptional, err := s.repositoryService.GetWorkflow(workflowName, workflowID)
 	if err != nil {
 		return errors.WithStack(err)
@@ -182,6 +722,7 @@ func (s *Service) Cancel(workflowID string, workflowName string, writer Writer)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	event.FromStage = workflow.LatestStage()
 	s.workflowService.CancelWorkflow(&workflow)
 	if err := s.repositoryService.PutWorkflow(workflow); err != nil {
 		return errors.WithStack(err)
@@ -191,69 +732,956 @@ func (s *Service) Cancel(workflowID string, workflowName string, writer Writer)
 	return nil
 }
 
-func (s Service) execute(commands []string, variables map[string]interface{}, checkpoint int, executor Executor, writer Writer) (int, error) {
+// Approve grants the approval the approval stage the provided workflowID is at requires,
+// so that the workflow can leave it. It must be granted by a user other than the one who ran the stage
+func (s *Service) Approve(workflowID, workflowName string, writer Writer) error {
+	event := audit.NewEvent(audit.Approve, workflowName, workflowID)
+	return s.audit(event, s.approve(workflowID, workflowName, writer, &event), writer)
+}
 
-	i, err := s.runCommands(commands[checkpoint:], variables, executor, writer)
+func (s *Service) approve(workflowID, workflowName string, writer Writer, event *audit.Event) error {
+	release, err := s.lockForCommand(workflowName, workflowID, "approve", writer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
+	workflowOptional, err := s.repositoryService.GetWorkflow(workflowName, workflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workflow, err := workflowOptional.Get()
 	if err != nil {
-		return i + checkpoint, errors.WithStack(err)
+		return errors.WithStack(err)
+	}
+	event.FromStage = workflow.LatestStage()
+	if !workflow.IsPendingApproval() {
+		return errors.New("Workflow with ID: " + workflow.ID + " is not waiting for approval")
+	}
+	if workflow.LatestExecution.Approval.RequestedBy == event.User {
+		return errors.New("Stage " + workflow.LatestStage() + " must be approved by a user other than " + event.User +
+			", who ran it")
+	}
+	if err := s.workflowService.Approve(&workflow, event.User); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.repositoryService.PutWorkflow(workflow); err != nil {
+		return errors.WithStack(err)
 	}
-	return 0, nil
+	// nolint: errcheck
+	writer.Write("Stage " + workflow.LatestStage() + " of workflow with ID: " + workflow.ID +
+		" was approved by " + event.User)
+	return nil
 }
 
-func (s Service) runCommands(commands []string, variables map[string]interface{}, executor Executor, writer Writer) (int, error) {
+// Alias sets the alias the provided workflowID can also be addressed with. An empty alias removes it
+func (s *Service) Alias(workflowID, workflowName, alias string, workflowDefinition config.Flowit, writer Writer) error {
+	event := audit.NewEvent(audit.Alias, workflowName, workflowID)
+	return s.audit(event, s.alias(workflowID, workflowName, alias, workflowDefinition, writer), writer)
+}
 
-	if len(commands) == 0 {
-		return 0, nil
+func (s *Service) alias(workflowID, workflowName, alias string, workflowDefinition config.Flowit, writer Writer) error {
+	release, err := s.lockForCommand(workflowName, workflowID, "alias", writer)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-
-	for i, command := range commands {
-		parsedCommand, err := utils.EvaluateVariablesInExpression(command, variables)
+	defer release()
+	workflowOptional, err := s.repositoryService.GetWorkflow(workflowName, workflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workflow, err := workflowOptional.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if alias != "" {
+		workflows, err := s.repositoryService.GetWorkflows(workflowName, 0, true)
 		if err != nil {
-			return i, errors.Wrap(err, "Error evaluating variables in command: "+command)
+			return errors.WithStack(err)
 		}
-		out, err := executor.Execute(parsedCommand)
-		// nolint: errcheck
-		writer.Write(out)
-		if err != nil {
-			return i, errors.Wrap(err, "Error executing command: "+parsedCommand)
+		if err := validateAlias(alias, workflow, workflowDefinition, workflows); err != nil {
+			return errors.WithStack(err)
 		}
 	}
-	return 0, nil
+	s.workflowService.SetAlias(&workflow, alias)
+	if err := s.repositoryService.PutWorkflow(workflow); err != nil {
+		return errors.WithStack(err)
+	}
+	if alias == "" {
+		// nolint: errcheck
+		writer.Write("Workflow with ID: " + workflow.ID + " no longer has an alias")
+		return nil
+	}
+	// nolint: errcheck
+	writer.Write("Workflow with ID: " + workflow.ID + " can now be addressed as: " + alias)
+	return nil
 }
 
-func (s Service) runConditions(conditions []string, variables map[string]interface{}, executor Executor, writer Writer) error {
-	if len(conditions) > 0 {
+// Unlock removes the lock another flowit invocation holds on the provided workflowID
+// Locks held by running processes are only removed if force is true
+func (s *Service) Unlock(workflowID, workflowName string, force bool, writer Writer) error {
+	event := audit.NewEvent(audit.Unlock, workflowName, workflowID)
+	return s.audit(event, s.unlock(workflowID, workflowName, force, writer), writer)
+}
+
+func (s *Service) unlock(workflowID, workflowName string, force bool, writer Writer) error {
+	lease, err := s.repositoryService.GetLease(workflowName, workflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if lease == nil {
 		// nolint: errcheck
-		writer.Write("Running conditions...")
-		_, err := s.execute(conditions, variables, 0, executor, writer)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+		writer.Write("Workflow with ID: " + workflowID + " is not locked")
+		return nil
 	}
+	if !force && !lease.IsStale(time.Now()) {
+		return errors.Wrap(repository.LeaseHeldError{Lease: *lease},
+			"The process holding the lock might still be running. Use --force to remove it anyway")
+	}
+	if err := s.repositoryService.BreakLease(workflowName, workflowID); err != nil {
+		return errors.WithStack(err)
+	}
+	// nolint: errcheck
+	writer.Write("Workflow with ID: " + workflowID + " was unlocked")
 	return nil
 }
 
-func (s Service) runActions(workflow *w.Workflow, execution *w.Execution, actions []string, variables map[string]interface{}, checkpointEnabled bool, checkpoint int, executor Executor, writer Writer) error {
+// Upgrade replaces the workflow definition snapshot of the provided workflowID with the provided workflow definition
+// The changes the upgrade introduces are written and confirmed with the prompter before applying them.
+// If dryRun is true the workflow is left untouched
+func (s *Service) Upgrade(workflowID, workflowName string, workflowDefinition config.Flowit, dryRun bool,
+	writer Writer, prompter Prompter) error {
+	if dryRun {
+		return s.upgrade(workflowID, workflowName, workflowDefinition, dryRun, writer, prompter)
+	}
+	event := audit.NewEvent(audit.Upgrade, workflowName, workflowID)
+	return s.audit(event, s.upgrade(workflowID, workflowName, workflowDefinition, dryRun, writer, prompter), writer)
+}
+
+func (s *Service) upgrade(workflowID, workflowName string, workflowDefinition config.Flowit, dryRun bool,
+	writer Writer, prompter Prompter) error {
+	if !dryRun {
+		release, err := s.lockForCommand(workflowName, workflowID, "upgrade", writer)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer release()
+	}
+	workflowOptional, err := s.repositoryService.GetWorkflow(workflowName, workflowID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workflow, err := workflowOptional.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if workflow.DefinitionHash == workflowDefinition.Hash() {
+		// nolint: errcheck
+		writer.Write("Workflow with ID: " + workflow.ID + " is already using the current workflow definition")
+		return nil
+	}
+	if err := validateUpgrade(workflow, workflowDefinition); err != nil {
+		return errors.WithStack(err)
+	}
+
+	upgradedWorkflow := workflow
+	s.workflowService.UpgradeWorkflow(&upgradedWorkflow, workflowDefinition)
+	diff, err := config.Diff(workflow.State, upgradedWorkflow.State, workflowName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, line := range diff {
+		// nolint: errcheck
+		writer.Write(line)
+	}
+	if dryRun {
+		return nil
+	}
+	if err := confirm("Upgrade workflow with ID: "+workflow.ID+"?", nil, prompter); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := s.repositoryService.PutWorkflow(upgradedWorkflow); err != nil {
+		return errors.WithStack(err)
+	}
+	// nolint: errcheck
+	writer.Write("Workflow with ID: " + workflow.ID + " was upgraded")
+	return nil
+}
+
+// Import saves workflows exported from another repository
+// Nothing is imported unless every workflow belongs to a workflow of the provided workflow definition.
+// Workflows which ID already exists are skipped, overwritten or imported with a new ID depending on policy
+func (s *Service) Import(workflows []w.Workflow, workflowDefinition config.Flowit, policy ConflictPolicy,
+	writer Writer) error {
+	for _, workflow := range workflows {
+		if err := validateImport(workflow, workflowDefinition); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	for _, workflow := range workflows {
+		if policy == OverwriteConflicts {
+			// The lease is held until every workflow is imported, so that the workflow overwritten is not changed meanwhile
+			release, err := s.lockForCommand(workflow.Name, workflow.ID, "import", writer)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			releases = append(releases, release)
+		}
+		if err := s.importWorkflow(workflow, workflowDefinition, policy, writer); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// importWorkflow saves the workflow, resolving a conflict with the workflow stored with its ID as policy decides
+func (s *Service) importWorkflow(workflow w.Workflow, workflowDefinition config.Flowit, policy ConflictPolicy,
+	writer Writer) error {
+	existingWorkflows, err := s.repositoryService.GetWorkflows(workflow.Name, 0, false)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	importedID := workflow.ID
+	optionalWorkflow, err := s.repositoryService.GetWorkflow(workflow.Name, workflow.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if existingWorkflow, err := optionalWorkflow.Get(); err == nil {
+		switch policy {
+		case SkipConflicts:
+			// nolint: errcheck
+			writer.Write("Workflow with ID: " + workflow.ID + " already exists and was skipped")
+			return nil
+		case OverwriteConflicts:
+			// The imported workflow replaces whatever version is stored
+			workflow.Metadata.Version = existingWorkflow.Metadata.Version + 1
+		case RenameConflicts:
+			s.workflowService.RenameWorkflow(&workflow, existingWorkflows)
+		default:
+			return errors.New("Unsupported conflict policy: " + string(policy))
+		}
+	}
+	// The exported preffix might be ambiguous among the workflows of this repository
+	s.workflowService.AssignPreffix(&workflow, existingWorkflows)
+	if workflow.Alias != "" {
+		if err := validateAlias(workflow.Alias, workflow, workflowDefinition, existingWorkflows); err != nil {
+			// nolint: errcheck
+			writer.Write("Warning: the alias of workflow with ID: " + workflow.ID + " was dropped: " + err.Error())
+			workflow.Alias = ""
+		}
+	}
+	event := audit.NewEvent(audit.Import, workflow.Name, workflow.ID)
+	event.FromStage = workflow.LatestStage()
+	if err := s.audit(event, s.repositoryService.PutWorkflow(workflow), writer); err != nil {
+		return errors.WithStack(err)
+	}
+	message := "Workflow with ID: " + importedID + " was imported"
+	if workflow.ID != importedID {
+		message += " with ID: " + workflow.ID
+	}
+	// nolint: errcheck
+	writer.Write(message + " and can be addressed as: " + workflow.Preffix)
+	if workflow.IsActive && workflow.IsDrifted(workflowDefinition.Hash()) {
+		// nolint: errcheck
+		writer.Write("Warning: workflow with ID: " + workflow.ID + " was created with a different workflow definition. " +
+			"Run 'flowit " + workflow.Name + " " + workflow.Preffix + " upgrade' to start using the current one")
+	}
+	return nil
+}
+
+// validateImport verifies that the workflow belongs to a workflow of the workflow definition
+// and that its definition snapshot is consistent with its executions
+func validateImport(workflow w.Workflow, workflowDefinition config.Flowit) error {
+	definition := config.WorkflowDefinition{Flowit: workflowDefinition}
+	if _, err := definition.Workflow(workflow.Name); err != nil {
+		return errors.Wrap(err, "Workflow with ID: "+workflow.ID+" can not be imported. "+
+			"Workflow "+workflow.Name+" is not defined")
+	}
+	snapshot := config.WorkflowDefinition{Flowit: workflow.State}
+	workflowConfig, err := snapshot.Workflow(workflow.Name)
+	if err != nil {
+		return errors.Wrap(err, "Workflow with ID: "+workflow.ID+" can not be imported. "+
+			"Its workflow definition is incomplete")
+	}
+	stateMachine, err := snapshot.StateMachine(workflowConfig.StateMachine)
+	if err != nil {
+		return errors.Wrap(err, "Workflow with ID: "+workflow.ID+" can not be imported. "+
+			"Its workflow definition is incomplete")
+	}
+	if workflow.LatestExecution != nil && !utils.FindStringInArray(workflow.LatestStage(), stateMachine.Stages) {
+		return errors.New("Workflow with ID: " + workflow.ID + " can not be imported. " +
+			"Stage: " + workflow.LatestStage() + " is not part of its workflow definition")
+	}
+	return nil
+}
+
+// validateAlias verifies that the alias can not be confused with
+// a stage of the workflow nor with another active workflow
+func validateAlias(alias string, workflow w.Workflow, workflowDefinition config.Flowit, workflows []w.Workflow) error {
+	if strings.ContainsAny(alias, " \t\n") || strings.HasPrefix(alias, "-") {
+		return errors.New("Invalid alias: " + alias + ". Aliases can not contain whitespace nor start with '-'")
+	}
+	definition := config.WorkflowDefinition{Flowit: workflowDefinition}
+	if stages, err := definition.Stages(workflow.Name); err == nil {
+		for _, stage := range stages {
+			if stage.ID == alias {
+				return errors.New("Invalid alias: " + alias + ". It is a stage of workflow " + workflow.Name)
+			}
+		}
+	}
+	for _, other := range workflows {
+		if other.ID != workflow.ID && other.IsActive && (other.Preffix == alias || other.Alias == alias) {
+			return errors.New("Invalid alias: " + alias + ". It is already used by workflow with ID: " + other.ID)
+		}
+	}
+	return nil
+}
+
+// validateUpgrade verifies that the workflow can continue from its current stage using the new definition
+func validateUpgrade(workflow w.Workflow, workflowDefinition config.Flowit) error {
+	if !workflow.IsActive || workflow.LatestExecution == nil {
+		return errors.New("Only active workflows can be upgraded")
+	}
+	if workflow.LatestExecution.Failed && workflow.LatestExecution.Checkpoint >= 0 {
+		return errors.New("Workflow with ID: " + workflow.ID + " has a pending checkpoint. " +
+			"Resume or cancel the failed stage before upgrading")
+	}
+	definition := config.WorkflowDefinition{Flowit: workflowDefinition}
+	workflowConfig, err := definition.Workflow(workflow.Name)
+	if err != nil {
+		return errors.Wrap(err, "Workflow "+workflow.Name+" is no longer defined")
+	}
+	stateMachine, err := definition.StateMachine(workflowConfig.StateMachine)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	currentStage := workflow.LatestExecution.Stage
+	if !utils.FindStringInArray(currentStage, stateMachine.Stages) {
+		return errors.New("Current stage: " + currentStage + " no longer exists in the workflow definition")
+	}
+	return nil
+}
+
+// audit records the event with the outcome of the audited action and returns the action error
+// Failing to record the event does not undo the action, so it is only reported as a warning
+func (s *Service) audit(event audit.Event, err error, writer Writer) error {
+	event.Finish(err)
+	if auditErr := s.auditLog.Record(event); auditErr != nil {
+		// nolint: errcheck
+		writer.Write("Warning: could not record the audit event: " + auditErr.Error())
+	}
+	return err
+}
+
+func (s Service) execute(execution *w.Execution, commands []stageCommand, checkpoint int, succeeded []int,
+	environment expression.Environment, executor Executor, writer Writer, prompter Prompter) (int, []int, error) {
+
+	for i := checkpoint; i < len(commands); i++ {
+		commandEnvironment := commands[i].environment(environment, *execution, executor)
+		run, err := commands[i].shouldRun(commandEnvironment, executor)
+		if err != nil {
+			return i, nil, errors.WithStack(err)
+		}
+		if !run {
+			s.workflowService.SkipCommand(execution, describeCommand(commands[i]))
+			// nolint: errcheck
+			writer.Write("Skipped: " + describeCommand(commands[i]))
+			continue
+		}
+		if commands[i].command.Confirm != "" {
+			if err := confirm(commands[i].command.Confirm, commands[i].variables, prompter); err != nil {
+				return i, nil, errors.Wrap(err, "Command: "+describeCommand(commands[i])+" was not run")
+			}
+		}
+		// Only the parallel group at the checkpoint may have members which already succeeded
+		var skipped []int
+		if i == checkpoint {
+			skipped = succeeded
+		}
+		groupSucceeded, err := s.runCommand(execution, commands[i], skipped, commandEnvironment, executor, writer)
+		if err != nil {
+			return i, groupSucceeded, errors.WithStack(err)
+		}
+	}
+	return 0, nil, nil
+}
+
+// stageCommand is a command of a stage together with the variables it is run with and how it is run
+type stageCommand struct {
+	command   config.Command
+	variables map[string]interface{}
+	settings  commandSettings
+}
+
+// commandSettings holds how the values of variable references reach commands and the environment commands are run with
+type commandSettings struct {
+	variableMode string
+	// context holds the environment variables describing the workflow instance and the stage being run
+	context map[string]string
+	// env holds the environment variables defined by the workflow definition, which values may reference variables
+	env map[string]string
+	// workdir is the directory commands are run in, which may reference variables
+	workdir string
+}
+
+// expandCommands replaces every command with foreach by a command for every item of its list variable
+// The expansion only depends on the workflow variables, so a checkpoint
+// indexes the same command when the stage is resumed
+func expandCommands(commands []config.Command, variables map[string]interface{},
+	settings commandSettings) ([]stageCommand, error) {
+	var expanded []stageCommand
+	for _, command := range commands {
+		if command.Foreach == "" {
+			expanded = append(expanded, stageCommand{command, variables, settings})
+			continue
+		}
+		items, err := listVariable(command.Foreach, variables)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error expanding command: "+command.Run)
+		}
+		itemVariable := command.As
+		if itemVariable == "" {
+			itemVariable = config.DefaultForeachVariable
+		}
+		for _, item := range items {
+			itemVariables := make(map[string]interface{}, len(variables)+1)
+			for name, value := range variables {
+				itemVariables[name] = value
+			}
+			itemVariables[itemVariable] = item
+			expanded = append(expanded, stageCommand{command, itemVariables, settings})
+		}
+	}
+	return expanded, nil
+}
+
+// listVariable returns the items of a list variable
+// Variables set by stage arguments are strings, which hold comma separated items
+func listVariable(name string, variables map[string]interface{}) ([]string, error) {
+	value, ok := variables[name]
+	if !ok {
+		return nil, errors.New("Variable: " + name + " could not be evaluated")
+	}
+	var items []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+	case string:
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	default:
+		return nil, errors.New("Variable: " + name + " is not a list")
+	}
+	return items, nil
+}
+
+// environment returns what the expressions of the command can refer to
+// Expressions see the variables of the command and the outputs of the commands run before it
+func (command stageCommand) environment(environment expression.Environment, execution w.Execution,
+	executor Executor) expression.Environment {
+	environment.Variables = command.variables
+	environment.Outputs = make([]string, len(execution.Results))
+	for i, result := range execution.Results {
+		environment.Outputs[i] = result.Output
+	}
+	environment.Run = commandRunner(executor, command.variables, command.settings)
+	return environment
+}
+
+// shouldRun runs the when command of the command, or evaluates
+// its when expression, if any, and returns whether it passed
+func (command stageCommand) shouldRun(environment expression.Environment, executor Executor) (bool, error) {
+	if command.command.When == "" {
+		return true, nil
+	}
+	if expression.IsExpression(command.command.When) {
+		return evaluateExpression(command.command.When, environment)
+	}
+	when, _, err := renderCommand(command.command.When, command.variables, command.settings)
+	if err != nil {
+		return false, errors.Wrap(err, "Error evaluating variables in when: "+command.command.When)
+	}
+	_, err = executor.Execute(when)
+	return err == nil, nil
+}
+
+// runCommand runs a single command or a parallel group, skipping the members of the group which already succeeded
+// It returns every member of the group which succeeded
+func (s Service) runCommand(execution *w.Execution, command stageCommand, succeeded []int,
+	environment expression.Environment, executor Executor, writer Writer) ([]int, error) {
+	if len(command.command.Parallel) > 0 {
+		return s.runParallelCommands(execution, command, succeeded, executor, writer)
+	}
+	if command.command.Git != nil {
+		return nil, s.runGitCommand(execution, command, writer)
+	}
+	if expression.IsExpression(command.command.Run) {
+		return nil, s.runExpression(execution, command.command.Run, environment)
+	}
+	shellCommand, parsedCommand, err := renderCommand(command.command.Run, command.variables, command.settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error evaluating variables in command: "+command.command.Run)
+	}
+	started := uint64(time.Now().UnixNano())
+	out, err := executor.Execute(shellCommand)
+	s.workflowService.AddCommandResult(execution, parsedCommand, out, err != nil, started)
 	// nolint: errcheck
-	writer.Write("Running actions...")
-	failedActionIdx, err := s.execute(actions, variables, checkpoint, executor, writer)
+	writer.Write(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error executing command: "+parsedCommand)
+	}
+	return nil, nil
+}
+
+// runExpression evaluates a command written as an expression, which fails unless the expression is true
+// Its result is recorded like the result of any other command
+func (s Service) runExpression(execution *w.Execution, value string, environment expression.Environment) error {
+	started := uint64(time.Now().UnixNano())
+	passed, err := evaluateExpression(value, environment)
+	switch {
+	case err != nil:
+		s.workflowService.AddCommandResult(execution, value, err.Error(), true, started)
+		return errors.WithStack(err)
+	case !passed:
+		s.workflowService.AddCommandResult(execution, value, "false", true, started)
+		return errors.New("Expression: " + value + " is false")
+	}
+	s.workflowService.AddCommandResult(execution, value, "true", false, started)
+	return nil
+}
+
+// runGitCommand runs a built-in git action or check in the working directory of the command
+// Its result is recorded like the result of any other command, a failed check having the reason it failed as output
+func (s Service) runGitCommand(execution *w.Execution, command stageCommand, writer Writer) error {
+	gitCommand, err := renderGitCommand(*command.command.Git, command.variables)
+	if err != nil {
+		return errors.Wrap(err, "Error evaluating variables in command: "+describeGitCommand(*command.command.Git))
+	}
+	dir, err := utils.EvaluateVariablesInExpression(command.settings.workdir, command.variables)
+	if err != nil {
+		return errors.Wrap(err, "Error evaluating variables in workdir: "+command.settings.workdir)
+	}
+	parsedCommand := describeGitCommand(gitCommand)
+	started := uint64(time.Now().UnixNano())
+	out, err := runGit(git.NewRepository(dir), gitCommand)
+	if err != nil && out == "" {
+		out = errors.Cause(err).Error()
+	}
+	s.workflowService.AddCommandResult(execution, parsedCommand, out, err != nil, started)
+	if out != "" {
+		// nolint: errcheck
+		writer.Write(out)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error executing command: "+parsedCommand)
+	}
+	return nil
+}
+
+// runGit runs a git action or check and returns its output. Checks fail unless the repository is in the expected state
+func runGit(repository git.Repository, command config.GitCommand) (string, error) {
+	remote := command.Remote
+	if remote == "" {
+		remote = git.DefaultRemote
+	}
+	switch command.Action {
+	case config.GitCreateBranch:
+		return repository.CreateBranch(command.Branch, command.From)
+	case config.GitRebaseOnto:
+		return repository.RebaseOnto(command.Branch, command.Onto, remote)
+	case config.GitMerge:
+		return repository.Merge(command.Branch, command.Into, remote)
+	case config.GitDeleteBranch:
+		return repository.DeleteBranch(command.Branch)
+	case config.GitPush:
+		return repository.Push(command.Branch, remote)
+	case config.GitBranchExists:
+		exists, err := repository.BranchExists(command.Branch)
+		if err == nil && !exists {
+			err = errors.New("Branch: " + command.Branch + " does not exist")
+		}
+		return "", err
+	case config.GitClean:
+		clean, err := repository.IsClean()
+		if err == nil && !clean {
+			err = errors.New("The working tree has uncommitted changes")
+		}
+		return "", err
+	case config.GitUpToDate:
+		upToDate, err := repository.IsUpToDate(command.Branch, remote)
+		if err == nil && !upToDate {
+			err = errors.New("The branch is not up to date with remote: " + remote)
+		}
+		return "", err
+	default:
+		return "", errors.New("Unsupported git command: " + command.Action)
+	}
+}
+
+// renderGitCommand evaluates the variables of every field of a git command
+func renderGitCommand(command config.GitCommand, variables map[string]interface{}) (config.GitCommand, error) {
+	for _, field := range []*string{&command.Branch, &command.From, &command.Onto, &command.Into, &command.Remote} {
+		value, err := utils.EvaluateVariablesInExpression(*field, variables)
+		if err != nil {
+			return command, errors.WithStack(err)
+		}
+		*field = value
+	}
+	return command, nil
+}
+
+// describeGitCommand returns the git command as it is shown, e.g. git merge feature/abc-12 into master
+func describeGitCommand(command config.GitCommand) string {
+	parts := []string{"git", command.Action}
+	for _, field := range []struct{ preposition, value string }{
+		{"", command.Branch},
+		{"from ", command.From},
+		{"onto ", command.Onto},
+		{"into ", command.Into},
+		{"remote ", command.Remote},
+	} {
+		if field.value != "" {
+			parts = append(parts, field.preposition+field.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// commandOutcome is the outcome of a member of a parallel group
+type commandOutcome struct {
+	index   int
+	output  string
+	err     error
+	started uint64
+}
+
+// runParallelCommands runs the members of the group which have not succeeded yet, at most MaxConcurrency at a time
+// The output of every member is written once it finishes, each line prefixed with the member command
+// Once a member fails no more members are started if the group fails fast. Otherwise every member is run
+func (s Service) runParallelCommands(execution *w.Execution, command stageCommand, succeeded []int, executor Executor,
+	writer Writer) ([]int, error) {
+	group := command.command
+	succeeded = append([]int(nil), succeeded...)
+	var pending []int
+	for i := range group.Parallel {
+		if !containsInt(succeeded, i) {
+			pending = append(pending, i)
+		}
+	}
+	shellCommands, commands, err := renderParallelCommands(command, pending)
+	if err != nil {
+		return succeeded, errors.WithStack(err)
+	}
+
+	outcomes := make(chan commandOutcome)
+	start := func(i int) {
+		go func() {
+			started := uint64(time.Now().UnixNano())
+			out, err := executor.Execute(shellCommands[i])
+			outcomes <- commandOutcome{i, out, err, started}
+		}()
+	}
+	concurrency := group.MaxConcurrency
+	if concurrency <= 0 || concurrency > len(pending) {
+		concurrency = len(pending)
+	}
+	for _, i := range pending[:concurrency] {
+		start(i)
+	}
+	next, running := concurrency, concurrency
+	var failed []string
+	for running > 0 {
+		outcome := <-outcomes
+		running--
+		command := commands[outcome.index]
+		s.workflowService.AddCommandResult(execution, command, outcome.output, outcome.err != nil, outcome.started)
+		if outcome.output != "" {
+			// nolint: errcheck
+			writer.Write("[" + command + "] " + strings.ReplaceAll(outcome.output, "\n", "\n["+command+"] "))
+		}
+		if outcome.err != nil {
+			failed = append(failed, command)
+		} else {
+			succeeded = append(succeeded, outcome.index)
+		}
+		if next < len(pending) && (len(failed) == 0 || !group.FailFast) {
+			start(pending[next])
+			next++
+			running++
+		}
+	}
+	sort.Ints(succeeded)
+	if len(failed) > 0 {
+		return succeeded, errors.New("Error executing commands in parallel: " + strings.Join(failed, ", "))
+	}
+	return succeeded, nil
+}
+
+// renderParallelCommands returns the commands to run and the commands as they are shown for the pending members
+// of the parallel group
+func renderParallelCommands(command stageCommand, pending []int) ([]Command, []string, error) {
+	group := command.command
+	shellCommands := make([]Command, len(group.Parallel))
+	commands := make([]string, len(group.Parallel))
+	for _, i := range pending {
+		shellCommand, parsedCommand, err := renderCommand(group.Parallel[i], command.variables, command.settings)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Error evaluating variables in command: "+group.Parallel[i])
+		}
+		shellCommands[i], commands[i] = shellCommand, parsedCommand
+	}
+	return shellCommands, commands, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// describeCommand returns the command as it is run or, if its variables can not be evaluated, as it is written
+func describeCommand(command stageCommand) string {
+	if len(command.command.Parallel) > 0 {
+		return "parallel: [" + strings.Join(command.command.Parallel, ", ") + "]"
+	}
+	if command.command.Git != nil {
+		if gitCommand, err := renderGitCommand(*command.command.Git, command.variables); err == nil {
+			return describeGitCommand(gitCommand)
+		}
+		return describeGitCommand(*command.command.Git)
+	}
+	if parsedCommand, err := utils.EvaluateVariablesInCommand(command.command.Run, command.variables); err == nil {
+		return parsedCommand
+	}
+	return command.command.Run
+}
+
+func (s Service) runConditions(execution *w.Execution, conditions []config.Command, environment expression.Environment,
+	settings commandSettings, executor Executor, writer Writer) error {
+	if len(conditions) > 0 {
+		// nolint: errcheck
+		writer.Write("Running conditions...")
+		commands, err := expandCommands(conditions, environment.Variables, settings)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// Conditions never require a confirmation
+		if _, _, err := s.execute(execution, commands, 0, nil, environment, executor, writer, nil); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// runActions runs the actions of the stage from the checkpoint and returns how many commands they expand to
+func (s Service) runActions(r stageRun, e stageExecution) (int, error) {
+	// nolint: errcheck
+	r.writer.Write("Running actions...")
+	commands, err := expandCommands(e.stage.Actions, e.environment.Variables, e.settings)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	failedActionIdx, groupSucceeded, err := s.execute(e.execution, commands, e.checkpoint, e.succeeded, e.environment,
+		r.executor, r.writer, r.prompter)
 	if err != nil {
 		// TOFIX:
 		// stdout = append(stdout, utils.MergeSlices(actions[checkpoint:failedActionIdx], out)...)
-		if checkpointEnabled {
-			s.workflowService.SetCheckpoint(execution, failedActionIdx)
+		if e.workflow.State.Config.CheckpointExecution {
+			s.workflowService.SetCheckpoint(e.execution, failedActionIdx, groupSucceeded)
 			// nolint: errcheck
-			writer.Write("Checkpoint set on command: " + actions[failedActionIdx])
-			if err := s.workflowService.FinishExecution(workflow, execution, w.FAILED); err != nil {
-				return errors.WithStack(err)
+			r.writer.Write("Checkpoint set on command: " + describeCommand(commands[failedActionIdx]))
+			if err := s.workflowService.FinishExecution(e.workflow, e.execution, w.FAILED); err != nil {
+				return 0, errors.WithStack(err)
 			}
-			if err := s.repositoryService.PutWorkflow(*workflow); err != nil {
-				return errors.WithStack(err)
+			if err := s.repositoryService.PutWorkflow(*e.workflow); err != nil {
+				return 0, errors.WithStack(err)
 			}
 		}
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
 	// TOFIX:
 	// stdout = append(stdout, utils.MergeSlices(actions[checkpoint:], out)...)
+	return len(commands), nil
+}
+
+// expressionEnvironment returns what the expressions of the workflow can refer to
+// previous is the execution preceding the one being run, if any
+func expressionEnvironment(workflow w.Workflow, previous *w.Execution, settings commandSettings,
+	executor Executor) expression.Environment {
+	environment := expression.Environment{
+		Variables: workflow.State.Variables,
+		Workflow: map[string]interface{}{
+			"name":  workflow.Name,
+			"id":    workflow.ID,
+			"alias": workflow.Alias,
+		},
+		Run: commandRunner(executor, workflow.State.Variables, settings),
+	}
+	if previous != nil {
+		outputs := make([]string, len(previous.Results))
+		for i, result := range previous.Results {
+			outputs[i] = result.Output
+		}
+		environment.Previous = map[string]interface{}{
+			"stage":    previous.Stage,
+			"from":     previous.FromStage,
+			"args":     previous.Args,
+			"failed":   previous.Failed,
+			"started":  previous.Metadata.Started / uint64(time.Second),
+			"finished": previous.Metadata.Finished / uint64(time.Second),
+			"outputs":  outputs,
+		}
+	}
+	return environment
+}
+
+// commandRunner returns the function expressions run commands with, once their variables are evaluated
+func commandRunner(executor Executor, variables map[string]interface{},
+	settings commandSettings) func(string) (string, error) {
+	return func(command string) (string, error) {
+		shellCommand, _, err := renderCommand(command, variables, settings)
+		if err != nil {
+			return "", errors.Wrap(err, "Error evaluating variables in command: "+command)
+		}
+		return executor.Execute(shellCommand)
+	}
+}
+
+// renderCommand evaluates the variables of a command as the settings define
+// It returns the command to run and the command as it is shown, which always has the quoted values substituted
+func renderCommand(command string, variables map[string]interface{},
+	settings commandSettings) (Command, string, error) {
+	shown, err := utils.EvaluateVariablesInCommand(command, variables)
+	if err != nil {
+		return Command{}, "", errors.WithStack(err)
+	}
+	env, err := settings.environment(variables)
+	if err != nil {
+		return Command{}, "", errors.WithStack(err)
+	}
+	dir, err := utils.EvaluateVariablesInExpression(settings.workdir, variables)
+	if err != nil {
+		return Command{}, "", errors.Wrap(err, "Error evaluating variables in workdir: "+settings.workdir)
+	}
+	switch settings.variableMode {
+	case config.EnvironmentVariables:
+		script, values, err := utils.BindVariablesInCommand(command, variables, environmentParameter)
+		if err != nil {
+			return Command{}, "", errors.WithStack(err)
+		}
+		for i, value := range values {
+			env[environmentParameter(i)] = value
+		}
+		return Command{Script: script, Env: env, Dir: dir}, shown, nil
+	case config.PositionalVariables:
+		script, values, err := utils.BindVariablesInCommand(command, variables, positionalParameter)
+		if err != nil {
+			return Command{}, "", errors.WithStack(err)
+		}
+		return Command{Script: script, Args: values, Env: env, Dir: dir}, shown, nil
+	default:
+		// Workflows created before variable modes existed substitute their values
+		return Command{Script: shown, Env: env, Dir: dir}, shown, nil
+	}
+}
+
+// newCommandSettings returns the settings the commands of a workflow stage are run with
+// fromStage, stageID and executionID are left empty when the commands are not run by an execution
+func newCommandSettings(workflow w.Workflow, fromStage, stageID, executionID string) commandSettings {
+	context := map[string]string{
+		config.ContextEnvPrefix + "WORKFLOW":    workflow.Name,
+		config.ContextEnvPrefix + "INSTANCE_ID": workflow.ID,
+	}
+	for name, value := range map[string]string{"FROM_STAGE": fromStage, "STAGE": stageID, "EXECUTION_ID": executionID} {
+		if value != "" {
+			context[config.ContextEnvPrefix+name] = value
+		}
+	}
+	return commandSettings{workflow.State.Config.VariableMode, context, workflow.Env(stageID), workflow.Workdir(stageID)}
+}
+
+// environment returns the environment variables a command is run with: the workflow context,
+// a FLOWIT_VAR_ variable for every variable but maps and the variables of the env sections
+func (settings commandSettings) environment(variables map[string]interface{}) (map[string]string, error) {
+	env := make(map[string]string, len(settings.context)+len(variables)+len(settings.env))
+	for name, value := range settings.context {
+		env[name] = value
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	// Names which only differ in their dashes are exported as the same variable, so the last one in order wins
+	sort.Strings(names)
+	for _, name := range names {
+		if value, err := utils.FormatVariableValue(variables[name]); err == nil {
+			env[variableEnvName(name)] = value
+		}
+	}
+	for name, value := range settings.env {
+		rendered, err := utils.EvaluateVariablesInExpression(value, variables)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error evaluating environment variable: "+name)
+		}
+		env[name] = rendered
+	}
+	return env, nil
+}
+
+// variableEnvName returns the name of the environment variable holding the value of a workflow variable
+func variableEnvName(variable string) string {
+	name := []rune(strings.ToUpper(variable))
+	for i, r := range name {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	return config.ContextEnvPrefix + "VAR_" + string(name)
+}
+
+func environmentParameter(i int) string {
+	return "FLOWIT_VALUE_" + strconv.Itoa(i+1)
+}
+
+func positionalParameter(i int) string {
+	return strconv.Itoa(i + 1)
+}
+
+// confirm asks prompter the question, once its variables are evaluated, and returns an error unless it is confirmed
+func confirm(question string, variables map[string]interface{}, prompter Prompter) error {
+	question, err := utils.EvaluateVariablesInExpression(question, variables)
+	if err != nil {
+		return errors.Wrap(err, "Error evaluating variables in confirmation")
+	}
+	confirmed, err := prompter.Confirm(question)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !confirmed {
+		return errors.New("Not confirmed: " + question)
+	}
 	return nil
 }
+
+// evaluateExpression evaluates a value written as an expression which must be true or false
+func evaluateExpression(value string, environment expression.Environment) (bool, error) {
+	parsed, err := expression.Parse(value)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return parsed.EvaluateBool(environment)
+}
@@ -1,8 +1,17 @@
 package runtime_test
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/yamil-rivera/flowit/internal/audit"
 	"github.com/yamil-rivera/flowit/internal/config"
 	"github.com/yamil-rivera/flowit/internal/utils"
 
@@ -10,8 +19,8 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/yamil-rivera/flowit/internal/fsm"
+	"github.com/yamil-rivera/flowit/internal/repository"
 	r "github.com/yamil-rivera/flowit/internal/runtime"
-	"github.com/yamil-rivera/flowit/internal/testmocks"
 	"github.com/yamil-rivera/flowit/internal/workflow"
 )
 
@@ -20,15 +29,55 @@ type mockWriter struct {
 	captures []string
 }
 
-func (e mockExecutor) Config(shell string) {
+func (e mockExecutor) Config(config r.ExecutorConfig) {
 	// We don't do anything
 }
 
-func (e mockExecutor) Execute(command string) (string, error) {
-	if command == "FAIL" {
-		return command, errors.New("Command failed")
+func (e mockExecutor) Execute(command r.Command) (string, error) {
+	if command.Script == "FAIL" {
+		return command.Script, errors.New("Command failed")
 	}
-	return command, nil
+	return command.Script, nil
+}
+
+// cancellingExecutor cancels the workflow it is running for in the middle of the execution
+// as another flowit invocation would do
+type cancellingExecutor struct {
+	mockExecutor
+	cancel func()
+}
+
+func (e cancellingExecutor) Execute(command r.Command) (string, error) {
+	e.cancel()
+	return e.mockExecutor.Execute(command)
+}
+
+// parallelExecutor records the commands it runs and how many of them ran at the same time
+type parallelExecutor struct {
+	mockExecutor
+	mutex    *sync.Mutex
+	executed *[]string
+	running  *int
+	peak     *int
+}
+
+func newParallelExecutor() parallelExecutor {
+	return parallelExecutor{mutex: &sync.Mutex{}, executed: &[]string{}, running: new(int), peak: new(int)}
+}
+
+func (e parallelExecutor) Execute(command r.Command) (string, error) {
+	e.mutex.Lock()
+	*e.executed = append(*e.executed, command.Script)
+	*e.running++
+	if *e.running > *e.peak {
+		*e.peak = *e.running
+	}
+	e.mutex.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	e.mutex.Lock()
+	*e.running--
+	e.mutex.Unlock()
+	return e.mockExecutor.Execute(command)
 }
 
 func (w *mockWriter) Write(s string) error {
@@ -36,6 +85,17 @@ func (w *mockWriter) Write(s string) error {
 	return nil
 }
 
+// mockPrompter answers every question with answer and records the questions it was asked
+type mockPrompter struct {
+	answer    bool
+	questions []string
+}
+
+func (p *mockPrompter) Confirm(question string) (bool, error) {
+	p.questions = append(p.questions, question)
+	return p.answer, nil
+}
+
 var _ = Describe("Runtime", func() {
 
 	createWorkflowDefinition := func() config.Flowit {
@@ -50,8 +110,9 @@ var _ = Describe("Runtime", func() {
 					ID: "simple-machine",
 					Stages: []string{
 						"start",
+						"finish",
 					},
-					InitialStage: "start",
+					InitialStages: []string{"start"},
 					FinalStages: []string{
 						"finish",
 					},
@@ -78,13 +139,13 @@ var _ = Describe("Runtime", func() {
 								"< arg-1 | test >",
 								"< arg-2 | test >",
 							},
-							Conditions: []string{
-								"COND1",
-								"COND2: $<arg-1>",
+							Conditions: []config.Command{
+								{Run: "COND1"},
+								{Run: "COND2: $<arg-1>"},
 							},
-							Actions: []string{
-								"ACTION1",
-								"ACTION2: $<arg-2>",
+							Actions: []config.Command{
+								{Run: "ACTION1"},
+								{Run: "ACTION2: $<arg-2>"},
 							},
 						},
 					},
@@ -99,8 +160,8 @@ var _ = Describe("Runtime", func() {
 	Context("Executing stages", func() {
 
 		It("should execute successfully for a new workflow", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{
 				"1",
@@ -111,7 +172,8 @@ var _ = Describe("Runtime", func() {
 
 			wd := createWorkflowDefinition()
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.captures).To(ContainElements([]string{
 				"COND1",
@@ -119,10 +181,20 @@ var _ = Describe("Runtime", func() {
 				"ACTION1",
 				"ACTION2: 2",
 			}))
+
+			workflows, err := rs.GetWorkflows(workflowName, 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows).To(HaveLen(1))
+			results := workflows[0].LatestExecution.Results
+			Expect(results).To(HaveLen(4))
+			Expect(results[3].Command).To(Equal("ACTION2: 2"))
+			Expect(results[3].Output).To(Equal("ACTION2: 2"))
+			Expect(results[3].Failed).To(BeFalse())
+			Expect(workflows[0].Executions[0]).To(Equal(*workflows[0].LatestExecution))
 		})
 
 		It("should execute successfully for an existing workflow", func() {
-			rs := testmocks.NewRepositoryMock()
+			rs := repository.NewMemoryStore()
 			w := workflow.Workflow{
 				ID:      "12345",
 				Preffix: "12345",
@@ -131,7 +203,7 @@ var _ = Describe("Runtime", func() {
 			}
 			err := rs.PutWorkflow(w)
 			Expect(err).ToNot(HaveOccurred())
-			service := r.NewService(rs, fsf, ws)
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			wd := config.Flowit{}
 			args := []string{
@@ -143,7 +215,8 @@ var _ = Describe("Runtime", func() {
 
 			writer := &mockWriter{}
 			// TODO: Consider changing service.Run() to accept either a workflowID or a workflowDefinition
-			err = service.Run(utils.NewStringOptional(w.ID), args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err = service.Run(utils.NewStringOptional(w.ID), "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(writer.captures).To(ContainElements([]string{
 				"COND1",
@@ -154,8 +227,8 @@ var _ = Describe("Runtime", func() {
 		})
 
 		It("should fail to run stage with wrong arguments", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{
 				"1",
@@ -165,13 +238,14 @@ var _ = Describe("Runtime", func() {
 
 			wd := createWorkflowDefinition()
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).To(HaveOccurred())
 		})
 
 		It("should not execute actions if one condition fails", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{
 				"1",
@@ -181,13 +255,14 @@ var _ = Describe("Runtime", func() {
 			stageID := "start"
 
 			wd := createWorkflowDefinition()
-			wd.Workflows[0].Stages[0].Conditions = []string{
-				"COND1",
-				"COND2: $<arg-1>",
-				"FAIL",
+			wd.Workflows[0].Stages[0].Conditions = []config.Command{
+				{Run: "COND1"},
+				{Run: "COND2: $<arg-1>"},
+				{Run: "FAIL"},
 			}
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).To(HaveOccurred())
 			Expect(writer.captures).To(ContainElements([]string{
 				"COND1",
@@ -200,8 +275,8 @@ var _ = Describe("Runtime", func() {
 		})
 
 		It("should save checkpoint with failed action", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{
 				"1",
@@ -212,13 +287,14 @@ var _ = Describe("Runtime", func() {
 
 			wd := createWorkflowDefinition()
 			wd.Config.CheckpointExecution = true
-			wd.Workflows[0].Stages[0].Actions = []string{
-				"ACTION1",
-				"ACTION2: $<arg-2>",
-				"FAIL",
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "ACTION1"},
+				{Run: "ACTION2: $<arg-2>"},
+				{Run: "FAIL"},
 			}
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).To(HaveOccurred())
 			Expect(writer.captures).To(ContainElements([]string{
 				"COND1",
@@ -233,7 +309,8 @@ var _ = Describe("Runtime", func() {
 			Expect(len(workflows)).To(Equal(1))
 
 			writer = &mockWriter{}
-			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", args, workflowName, stageID, wd, mockExecutor{},
+				writer, &mockPrompter{})
 			Expect(err).To(HaveOccurred())
 			Expect(writer.captures).To(ContainElements([]string{
 				"COND1",
@@ -246,9 +323,121 @@ var _ = Describe("Runtime", func() {
 			}))
 		})
 
+		It("should run parallel groups concurrently up to their max concurrency", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Parallel: []string{"P1: $<arg-1>", "P2", "P3", "P4"}, MaxConcurrency: 2},
+				{Run: "ACTION2: $<arg-2>"},
+			}
+
+			executor := newParallelExecutor()
+			writer := &mockWriter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, executor, writer,
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*executor.executed).To(ConsistOf("COND1", "COND2: 1", "P1: 1", "P2", "P3", "P4", "ACTION2: 2"))
+			Expect(*executor.peak).To(Equal(2))
+			Expect(writer.captures).To(ContainElements("[P1: 1] P1: 1", "[P4] P4", "ACTION2: 2"))
+		})
+
+		It("should stop starting the commands of a fail fast parallel group once one fails", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Parallel: []string{"FAIL", "P2", "P3"}, MaxConcurrency: 1, FailFast: true},
+			}
+
+			executor := newParallelExecutor()
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, executor, &mockWriter{},
+				&mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Error executing commands in parallel: FAIL"))
+			Expect(*executor.executed).ToNot(ContainElement("P2"))
+			Expect(*executor.executed).ToNot(ContainElement("P3"))
+		})
+
+		It("should only run the parallel group commands which did not succeed when resuming a checkpoint", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "ACTION1"},
+				{Parallel: []string{"P1", "FAIL", "P3"}},
+			}
+
+			executor := newParallelExecutor()
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, executor, &mockWriter{},
+				&mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(*executor.executed).To(ContainElements("P1", "FAIL", "P3"))
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows[0].LatestExecution.Checkpoint).To(Equal(1))
+			Expect(workflows[0].LatestExecution.CheckpointSucceeded).To(Equal([]int{0, 2}))
+
+			executor = newParallelExecutor()
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{"1", "2"}, "feature", "start", wd,
+				executor, &mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(*executor.executed).To(Equal([]string{"COND1", "COND2: 1", "FAIL"}))
+		})
+
+		It("should skip the commands which when command fails and record them", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "ACTION1", When: "FAIL"},
+				{Run: "ACTION2: $<arg-2>", When: "WHEN: $<arg-1>"},
+			}
+
+			executor := newParallelExecutor()
+			writer := &mockWriter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, executor, writer,
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*executor.executed).To(Equal([]string{"COND1", "COND2: 1", "FAIL", "WHEN: 1", "ACTION2: 2"}))
+			Expect(writer.captures).To(ContainElements("Skipped: ACTION1", "ACTION2: 2"))
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			results := workflows[0].LatestExecution.Results
+			Expect(results[2].Command).To(Equal("ACTION1"))
+			Expect(results[2].Skipped).To(BeTrue())
+			Expect(results[3].Skipped).To(BeFalse())
+		})
+
+		It("should run foreach commands for every item and resume them from the failed item", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Variables["services"] = []interface{}{"api", "FAIL", "web"}
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "DEPLOY $<item>", Foreach: "arg-1"},
+				{Run: "$<service>", Foreach: "services", As: "service"},
+			}
+
+			executor := newParallelExecutor()
+			err := service.Run(utils.OptionalString{}, "", []string{"a, b", "2"}, "feature", "start", wd, executor,
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(*executor.executed).To(Equal([]string{"COND1", "COND2: 'a, b'", "DEPLOY a", "DEPLOY b", "api", "FAIL"}))
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows[0].LatestExecution.Checkpoint).To(Equal(3))
+
+			executor = newParallelExecutor()
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{"a, b", "2"}, "feature", "start", wd,
+				executor, &mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(*executor.executed).To(Equal([]string{"COND1", "COND2: 'a, b'", "FAIL"}))
+		})
+
 		It("should fail to resume a failed checkpoint stage if given different arguments", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{
 				"1",
@@ -259,13 +448,14 @@ var _ = Describe("Runtime", func() {
 
 			wd := createWorkflowDefinition()
 			wd.Config.CheckpointExecution = true
-			wd.Workflows[0].Stages[0].Actions = []string{
-				"ACTION1",
-				"ACTION2: $<arg-2>",
-				"FAIL",
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "ACTION1"},
+				{Run: "ACTION2: $<arg-2>"},
+				{Run: "FAIL"},
 			}
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).To(HaveOccurred())
 
 			workflows, err := rs.GetWorkflows("feature", 1, true)
@@ -276,14 +466,605 @@ var _ = Describe("Runtime", func() {
 				"1",
 			}
 			writer = &mockWriter{}
-			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", args, workflowName, stageID, wd, mockExecutor{},
+				writer, &mockPrompter{})
 			Expect(err).To(HaveOccurred())
 			Expect(writer.captures).ToNot(ContainElement("COND1"))
 		})
 
+		It("should fail when the workflow is updated while a stage is running", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			executor := cancellingExecutor{
+				cancel: func() {
+					cancelled := workflows[0]
+					ws.CancelWorkflow(&cancelled)
+					// Once cancelled, later attempts to cancel the same version are rejected
+					_ = rs.PutWorkflow(cancelled)
+				},
+			}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd, executor,
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("was updated by another flowit invocation"))
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", workflows[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			storedWorkflow, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storedWorkflow.Status()).To(Equal(workflow.StatusCancelled))
+		})
+
+		It("should create workflows with unique aliases", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+
+			err := service.Run(utils.OptionalString{}, "start", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("It is a stage of workflow feature"))
+
+			err = service.Run(utils.OptionalString{}, "my-branch", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows[0].Alias).To(Equal("my-branch"))
+
+			err = service.Run(utils.OptionalString{}, "my-branch", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("It is already used by workflow with ID: " + workflows[0].ID))
+
+			Expect(service.Alias(workflows[0].ID, "feature", "other-branch", wd, &mockWriter{})).To(Succeed())
+			optionalWorkflow, err := rs.GetWorkflow("feature", workflows[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			workflow, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflow.Alias).To(Equal("other-branch"))
+		})
+
+		It("should not run a stage of a workflow locked by another process", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			lease := repository.NewLease("feature", workflows[0].ID, "finish", time.Hour)
+			lease.Host = "other-host"
+			Expect(rs.AcquireLease(lease)).To(Succeed())
+
+			writer := &mockWriter{}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unlock --force"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION3"))
+
+			err = service.Unlock(workflows[0].ID, "feature", false, &mockWriter{})
+			Expect(err).To(HaveOccurred())
+			Expect(service.Unlock(workflows[0].ID, "feature", true, &mockWriter{})).To(Succeed())
+
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, &mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			remainingLease, err := rs.GetLease("feature", workflows[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(remainingLease).To(BeNil())
+		})
+
+		It("should not change a workflow locked by another process", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			workflowID := workflows[0].ID
+
+			lease := repository.NewLease("feature", workflowID, "finish", time.Hour)
+			lease.Host = "other-host"
+			Expect(rs.AcquireLease(lease)).To(Succeed())
+
+			upgradedDefinition := createWorkflowDefinition()
+			upgradedDefinition.Variables = map[string]interface{}{"new-var": "value"}
+			for _, err := range []error{
+				service.Cancel(workflowID, "feature", &mockWriter{}),
+				service.Approve(workflowID, "feature", &mockWriter{}),
+				service.Alias(workflowID, "feature", "branch", wd, &mockWriter{}),
+				service.Upgrade(workflowID, "feature", upgradedDefinition, false, &mockWriter{}, &mockPrompter{answer: true}),
+			} {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("is locked by PID"))
+			}
+			optionalWorkflow, err := rs.GetWorkflow("feature", workflowID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(optionalWorkflow.Get()).To(Equal(workflows[0]))
+
+			Expect(service.Unlock(workflowID, "feature", true, &mockWriter{})).To(Succeed())
+			Expect(service.Cancel(workflowID, "feature", &mockWriter{})).To(Succeed())
+			remainingLease, err := rs.GetLease("feature", workflowID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(remainingLease).To(BeNil())
+		})
+
+		It("should not transition along an edge which guard fails", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.StateMachines[0].Transitions[0].Guard = "FAIL"
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			writer := &mockWriter{}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Transition from start to finish is blocked: FAIL"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION3"))
+		})
+
+		It("should evaluate guards written as expressions against the previous execution", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.StateMachines[0].Transitions[0].Guard = "${{ previous.stage == 'start' && arg-1 > 5 }}"
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+
+			err := service.Run(utils.OptionalString{}, "", []string{"10", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			writer := &mockWriter{}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("ACTION3"))
+
+			err = service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err = rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, &mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(
+				"Transition from start to finish is blocked: ${{ previous.stage == 'start' && arg-1 > 5 }} is false"))
+		})
+
+		It("should run conditions and when clauses written as expressions", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Conditions = []config.Command{
+				{Run: "COND1"},
+				{Run: "${{ outputs[0] == 'COND1' && arg-2 =~ '^[0-9]+$' }}"},
+			}
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: "ACTION1", When: "${{ contains(output('STATUS: $<arg-1>'), 'open') }}"},
+				{Run: "ACTION2: $<arg-2>", When: "${{ arg-1 != 'open' }}"},
+			}
+
+			writer := &mockWriter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"open", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("ACTION1"))
+			Expect(writer.captures).To(ContainElement("Skipped: ACTION2: 2"))
+			workflows, err := rs.GetWorkflows("feature", 1, false)
+			Expect(err).ToNot(HaveOccurred())
+			results := workflows[0].LatestExecution.Results
+			Expect(results[1].Command).To(Equal("${{ outputs[0] == 'COND1' && arg-2 =~ '^[0-9]+$' }}"))
+			Expect(results[1].Output).To(Equal("true"))
+			Expect(results[1].Failed).To(BeFalse())
+
+			err = service.Run(utils.OptionalString{}, "", []string{"open", "two"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("is false"))
+			Expect(err.Error()).ToNot(ContainSubstring("ACTION1"))
+		})
+
+		It("should pass variable values to the shell without running them in every variable mode", func() {
+			for _, mode := range []string{config.SubstituteVariables, config.EnvironmentVariables, config.PositionalVariables} {
+				rs := repository.NewMemoryStore()
+				service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+				wd := createWorkflowDefinition()
+				wd.Config.Shell = "/bin/sh"
+				wd.Config.VariableMode = mode
+				wd.Workflows[0].Stages[0].Conditions = nil
+				wd.Workflows[0].Stages[0].Actions = []config.Command{
+					{Run: `printf '%s|%s' $<arg-1> "$<arg-2>"`},
+				}
+
+				executor := r.NewUnixShellExecutor()
+				err := service.Run(utils.OptionalString{}, "", []string{"x; echo INJECTED", "$(echo INJECTED)"}, "feature", "start",
+					wd, executor, &mockWriter{}, &mockPrompter{})
+				Expect(err).ToNot(HaveOccurred())
+				workflows, err := rs.GetWorkflows("feature", 1, false)
+				Expect(err).ToNot(HaveOccurred())
+				result := workflows[0].LatestExecution.Results[0]
+				Expect(result.Output).To(Equal("x; echo INJECTED|$(echo INJECTED)"), mode)
+				Expect(result.Command).To(Equal(`printf '%s|%s' 'x; echo INJECTED' "\$(echo INJECTED)"`), mode)
+			}
+		})
+
+		It("should run commands with the workflow context and the env sections as environment variables", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Config.Shell = "/bin/sh"
+			wd.Config.Env = map[string]string{"TARGET": "config", "REGION": "eu"}
+			wd.Workflows[0].Env = map[string]string{"TARGET": "workflow", "ISSUE": "issue-$<arg-1>"}
+			wd.Workflows[0].Stages[0].Env = map[string]string{"REGION": "us"}
+			wd.Workflows[0].Stages[0].Conditions = nil
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: `echo "$FLOWIT_WORKFLOW $FLOWIT_STAGE $FLOWIT_FROM_STAGE $FLOWIT_VAR_ARG_1 $TARGET $REGION $ISSUE"`},
+				{Run: `echo "$FLOWIT_INSTANCE_ID $FLOWIT_EXECUTION_ID"`},
+			}
+
+			executor := r.NewUnixShellExecutor()
+			err := service.Run(utils.OptionalString{}, "", []string{"12", "b"}, "feature", "start", wd, executor, &mockWriter{},
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, false)
+			Expect(err).ToNot(HaveOccurred())
+			results := workflows[0].LatestExecution.Results
+			Expect(results[0].Output).To(Equal("feature start origin 12 workflow us issue-12"))
+			Expect(results[1].Output).To(Equal(workflows[0].ID + " " + workflows[0].LatestExecution.ID))
+		})
+
+		It("should run commands in their working directory with a clean environment", func() {
+			directory, err := ioutil.TempDir("", "flowit-workdir")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(directory) // nolint:errcheck
+			for _, service := range []string{"api", "web"} {
+				Expect(os.MkdirAll(filepath.Join(directory, "services", service), 0755)).To(Succeed())
+			}
+			os.Setenv("FLOWIT_TEST_ALLOWED", "allowed") // nolint:errcheck
+			os.Setenv("FLOWIT_TEST_SECRET", "secret")   // nolint:errcheck
+			defer os.Unsetenv("FLOWIT_TEST_ALLOWED")    // nolint:errcheck
+			defer os.Unsetenv("FLOWIT_TEST_SECRET")     // nolint:errcheck
+
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Config.Shell = "/bin/sh"
+			wd.Config.CleanEnv = true
+			wd.Config.AllowEnv = []string{"FLOWIT_TEST_ALLOWED", "PATH"}
+			wd.Variables = map[string]interface{}{"services": []interface{}{"api", "web"}}
+			wd.Workflows[0].Workdir = directory
+			wd.Workflows[0].Stages[0].Workdir = directory + "/services/$<service>"
+			wd.Workflows[0].Stages[0].Conditions = []config.Command{{Run: "basename $(pwd)"}}
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Run: `echo "$(basename $(pwd)) ${FLOWIT_TEST_ALLOWED:-none} ${FLOWIT_TEST_SECRET:-none}"`,
+					Foreach: "services", As: "service"},
+			}
+
+			err = service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, r.NewUnixShellExecutor(),
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Variable: $<service> could not be evaluated"))
+
+			wd.Workflows[0].Stages[0].Conditions = nil
+			err = service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, r.NewUnixShellExecutor(),
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, false)
+			Expect(err).ToNot(HaveOccurred())
+			results := workflows[0].LatestExecution.Results
+			Expect(results[0].Output).To(Equal("api allowed none"))
+			Expect(results[1].Output).To(Equal("web allowed none"))
+		})
+
+		It("should run git actions and checks in the working directory", func() {
+			directory, err := ioutil.TempDir("", "flowit-git")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(directory) // nolint:errcheck
+			clone := filepath.Join(directory, "clone")
+			runGit := func(dir string, args ...string) {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = dir
+				out, err := cmd.CombinedOutput()
+				Expect(err).ToNot(HaveOccurred(), string(out))
+			}
+			runGit(directory, "init", "--quiet", "--bare", "remote.git")
+			runGit(directory, "clone", "--quiet", "remote.git", "clone")
+			runGit(clone, "config", "user.name", "flowit")
+			runGit(clone, "config", "user.email", "flowit@example.com")
+			runGit(clone, "checkout", "--quiet", "-b", "master")
+			Expect(ioutil.WriteFile(filepath.Join(clone, "README"), []byte("readme"), 0644)).To(Succeed())
+			runGit(clone, "add", "README")
+			runGit(clone, "commit", "--quiet", "-m", "Initial commit")
+			runGit(clone, "push", "--quiet", "origin", "master")
+
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Workdir = clone
+			wd.Workflows[0].Stages[0].Conditions = []config.Command{{Git: &config.GitCommand{Action: config.GitClean}}}
+			wd.Workflows[0].Stages[0].Actions = []config.Command{
+				{Git: &config.GitCommand{Action: config.GitCreateBranch, Branch: "feature/$<arg-1>", From: "master"}},
+				{Git: &config.GitCommand{Action: config.GitPush}},
+			}
+
+			Expect(ioutil.WriteFile(filepath.Join(clone, "README"), []byte("changed"), 0644)).To(Succeed())
+			err = service.Run(utils.OptionalString{}, "", []string{"abc-12", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("The working tree has uncommitted changes"))
+
+			runGit(clone, "checkout", "--quiet", "README")
+			err = service.Run(utils.OptionalString{}, "", []string{"abc-12", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, false)
+			Expect(err).ToNot(HaveOccurred())
+			results := workflows[0].LatestExecution.Results
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Command).To(Equal("git clean"))
+			Expect(results[1].Command).To(Equal("git create-branch feature/abc-12 from master"))
+			Expect(results[2].Command).To(Equal("git push"))
+			Expect(workflows[0].Branches()).To(Equal([]string{"feature/abc-12"}))
+			runGit(directory+"/remote.git", "show-ref", "--verify", "--quiet", "refs/heads/feature/abc-12")
+		})
+
+		It("should spawn child workflows and wait for them to finish", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Spawn = []config.Spawn{
+				{Workflow: "changelog", Variables: map[string]string{"version": "v$<arg-1>"}},
+			}
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+			wd.Workflows = append(wd.Workflows, config.Workflow{
+				ID:           "changelog",
+				StateMachine: "simple-machine",
+				Stages: []config.Stage{
+					{ID: "start", Args: []string{"< version | Version >"}, Actions: []config.Command{{Run: "CHANGELOG: $<version>"}}},
+					{ID: "finish", Actions: []config.Command{{Run: "PUBLISH: $<version>"}}},
+				},
+			})
+
+			writer := &mockWriter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("CHANGELOG: v1"))
+			features, err := rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			changelogs, err := rs.GetWorkflows("changelog", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelogs).To(HaveLen(1))
+			Expect(changelogs[0].ParentName).To(Equal("feature"))
+			Expect(changelogs[0].ParentID).To(Equal(features[0].ID))
+			Expect(features[0].Children).To(Equal([]workflow.Child{{Name: "changelog", ID: changelogs[0].ID, Stage: "start"}}))
+
+			writer = &mockWriter{}
+			err = service.Run(utils.NewStringOptional(features[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(
+				"Transition from start to finish is blocked: waiting for child workflows: changelog"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION3"))
+
+			err = service.Run(utils.NewStringOptional(changelogs[0].Preffix), "", []string{}, "changelog", "finish", wd,
+				mockExecutor{}, &mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			writer = &mockWriter{}
+			err = service.Run(utils.NewStringOptional(features[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("ACTION3"))
+		})
+
+		It("should not spawn a child workflow twice when a later spawn fails", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Spawn = []config.Spawn{
+				{Workflow: "changelog", Variables: map[string]string{"version": "v$<arg-1>"}},
+				{Workflow: "release", Variables: map[string]string{"tag": "v$<arg-2>"}},
+			}
+			for _, child := range []string{"changelog", "release"} {
+				wd.Workflows = append(wd.Workflows, config.Workflow{
+					ID:           child,
+					StateMachine: "simple-machine",
+					Stages: []config.Stage{
+						{ID: "start", Args: []string{"< version | Version >"}, Actions: []config.Command{{Run: child + ": $<version>"}}},
+					},
+				})
+			}
+
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Spawned workflow release needs variable version to run its start stage"))
+			changelogs, err := rs.GetWorkflows("changelog", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelogs).To(BeEmpty())
+
+			wd.Workflows[0].Stages[0].Spawn[1].Variables = map[string]string{"version": "v$<arg-2>"}
+			wd.Workflows[2].Stages[0].Conditions = []config.Command{{Run: "FAIL"}}
+			writer := &mockWriter{}
+			err = service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				&mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("Checkpoint set on spawned workflow: release"))
+			changelogs, err = rs.GetWorkflows("changelog", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelogs).To(HaveLen(1))
+			features, err := rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(features).To(HaveLen(1))
+			Expect(features[0].Status()).To(Equal(workflow.StatusFailed))
+			Expect(features[0].Children).To(Equal([]workflow.Child{{Name: "changelog", ID: changelogs[0].ID, Stage: "start"}}))
+
+			wd.Workflows[2].Stages[0].Conditions = nil
+			writer = &mockWriter{}
+			err = service.Run(utils.NewStringOptional(features[0].Preffix), "", []string{"1", "2"}, "feature", "start", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).ToNot(ContainElement("ACTION1"))
+			Expect(writer.captures).To(ContainElement("release: v2"))
+			changelogs, err = rs.GetWorkflows("changelog", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelogs).To(HaveLen(1))
+			releases, err := rs.GetWorkflows("release", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			features, err = rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(features[0].Status()).To(Equal(workflow.StatusActive))
+			Expect(features[0].Children).To(Equal([]workflow.Child{
+				{Name: "changelog", ID: changelogs[0].ID, Stage: "start"},
+				{Name: "release", ID: releases[0].ID, Stage: "start"},
+			}))
+		})
+
+		It("should only run the stages and actions which are confirmed", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Confirm = "Start $<arg-1>?"
+
+			writer := &mockWriter{}
+			prompter := &mockPrompter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				prompter)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Not confirmed: Start 1?"))
+			Expect(writer.captures).To(ContainElement("COND1"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION1"))
+			workflows, err := rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows).To(BeEmpty())
+
+			wd.Workflows[0].Stages[0].Confirm = ""
+			wd.Workflows[0].Stages[0].Actions[1].Confirm = "Run $<arg-2>?"
+			writer = &mockWriter{}
+			err = service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				&mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("ACTION1"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION2: 2"))
+			workflows, err = rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows[0].LatestExecution.Checkpoint).To(Equal(1))
+
+			writer = &mockWriter{}
+			prompter = &mockPrompter{answer: true}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{"1", "2"}, "feature", "start", wd,
+				mockExecutor{}, writer, prompter)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(prompter.questions).To(Equal([]string{"Run 2?"}))
+			Expect(writer.captures).ToNot(ContainElement("ACTION1"))
+			Expect(writer.captures).To(ContainElement("ACTION2: 2"))
+		})
+
+		It("should not leave an approval stage until another user approves it", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Type = config.ApprovalStage
+			wd.Workflows[0].Stages = append(wd.Workflows[0].Stages, config.Stage{
+				ID:      "finish",
+				Actions: []config.Command{{Run: "ACTION3"}},
+			})
+			user := audit.NewEvent(audit.Approve, "feature", "").User
+
+			writer := &mockWriter{}
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{}, writer,
+				&mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows[0].LatestExecution.Approval).To(Equal(&workflow.Approval{RequestedBy: user}))
+			Expect(writer.captures).To(ContainElement(ContainSubstring("'flowit feature " + workflows[0].Preffix + " approve'")))
+
+			writer = &mockWriter{}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Transition from start to finish is blocked: waiting for approval"))
+			Expect(writer.captures).ToNot(ContainElement("ACTION3"))
+
+			err = service.Approve(workflows[0].ID, "feature", &mockWriter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must be approved by a user other than " + user))
+
+			// The stage was run by someone else
+			requested := workflows[0]
+			requested.LatestExecution.Approval.RequestedBy = "someone-else"
+			requested.Executions[0].Approval = requested.LatestExecution.Approval
+			requested.Metadata.Version++
+			Expect(rs.PutWorkflow(requested)).To(Succeed())
+			Expect(service.Approve(workflows[0].ID, "feature", &mockWriter{})).To(Succeed())
+			err = service.Approve(workflows[0].ID, "feature", &mockWriter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("is not waiting for approval"))
+
+			writer = &mockWriter{}
+			err = service.Run(utils.NewStringOptional(workflows[0].Preffix), "", []string{}, "feature", "finish", wd,
+				mockExecutor{}, writer, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("ACTION3"))
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", workflows[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			storedWorkflow, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			approval := storedWorkflow.Executions[1].Approval
+			Expect(approval.RequestedBy).To(Equal("someone-else"))
+			Expect(approval.ApprovedBy).To(Equal(user))
+			Expect(approval.Approved).ToNot(BeZero())
+		})
+
 		It("should fail to execute an incorrect stage", func() {
-			rs := testmocks.NewRepositoryMock()
-			service := r.NewService(rs, fsf, ws)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
 
 			args := []string{}
 			workflowName := "feature"
@@ -291,11 +1072,296 @@ var _ = Describe("Runtime", func() {
 
 			wd := createWorkflowDefinition()
 			writer := &mockWriter{}
-			err := service.Run(utils.OptionalString{}, args, workflowName, stageID, wd, mockExecutor{}, writer)
+			err := service.Run(utils.OptionalString{}, "", args, workflowName, stageID, wd, mockExecutor{}, writer,
+				&mockPrompter{})
 			Expect(err).To(HaveOccurred())
 			Expect(writer.captures).ToNot(ContainElement("COND1"))
 		})
 
 	})
 
+	Context("Upgrading workflows", func() {
+
+		startWorkflow := func(rs repository.Store, wd config.Flowit) workflow.Workflow {
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			return workflows[0]
+		}
+
+		It("should warn when the workflow definition changed", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			w := startWorkflow(rs, createWorkflowDefinition())
+
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{{Run: "ACTION3"}}
+			writer := &mockWriter{}
+			_ = service.Run(utils.NewStringOptional(w.Preffix), "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				writer, &mockPrompter{})
+			Expect(writer.captures[0]).To(ContainSubstring("workflow definition changed"))
+		})
+
+		It("should only show the changes on a dry run", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			w := startWorkflow(rs, createWorkflowDefinition())
+
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{{Run: "ACTION3"}}
+			writer := &mockWriter{}
+			err := service.Upgrade(w.ID, "feature", wd, true, writer, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.captures).To(ContainElements([]string{
+				"~ Workflow.Stages.start.Actions",
+				"    - ACTION1",
+				"    + ACTION3",
+			}))
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", w.ID)
+			Expect(err).ToNot(HaveOccurred())
+			stored, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored.DefinitionHash).To(Equal(w.DefinitionHash))
+		})
+
+		It("should upgrade the workflow definition preserving argument variables", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			w := startWorkflow(rs, createWorkflowDefinition())
+
+			wd := createWorkflowDefinition()
+			wd.Variables = map[string]interface{}{"new-var": "value"}
+			err := service.Upgrade(w.ID, "feature", wd, false, &mockWriter{}, &mockPrompter{answer: true})
+			Expect(err).ToNot(HaveOccurred())
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", w.ID)
+			Expect(err).ToNot(HaveOccurred())
+			stored, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored.DefinitionHash).To(Equal(wd.Hash()))
+			Expect(stored.State.Variables).To(BeEquivalentTo(map[string]interface{}{
+				"new-var": "value",
+				"arg-1":   "1",
+				"arg-2":   "2",
+			}))
+		})
+
+		It("should leave the workflow untouched when the upgrade is not confirmed", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			w := startWorkflow(rs, createWorkflowDefinition())
+
+			wd := createWorkflowDefinition()
+			wd.Workflows[0].Stages[0].Actions = []config.Command{{Run: "ACTION3"}}
+			writer := &mockWriter{}
+			prompter := &mockPrompter{}
+			err := service.Upgrade(w.ID, "feature", wd, false, writer, prompter)
+			Expect(err).To(HaveOccurred())
+			Expect(writer.captures).To(ContainElement("    + ACTION3"))
+			Expect(prompter.questions).To(Equal([]string{"Upgrade workflow with ID: " + w.ID + "?"}))
+
+			optionalWorkflow, err := rs.GetWorkflow("feature", w.ID)
+			Expect(err).ToNot(HaveOccurred())
+			stored, err := optionalWorkflow.Get()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored.DefinitionHash).To(Equal(w.DefinitionHash))
+		})
+
+		It("should fail to upgrade when the current stage no longer exists", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			w := startWorkflow(rs, createWorkflowDefinition())
+
+			wd := createWorkflowDefinition()
+			wd.StateMachines[0].Stages = []string{"begin"}
+			err := service.Upgrade(w.ID, "feature", wd, false, &mockWriter{}, &mockPrompter{answer: true})
+			Expect(err).To(HaveOccurred())
+		})
+
+	})
+
+	Context("Importing workflows", func() {
+
+		exportWorkflow := func(wd config.Flowit) workflow.Workflow {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			err := service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})
+			Expect(err).ToNot(HaveOccurred())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			return workflows[0]
+		}
+
+		It("should skip, overwrite or rename workflows which ID already exists", func() {
+			wd := createWorkflowDefinition()
+			exported := exportWorkflow(wd)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+
+			Expect(service.Import([]workflow.Workflow{exported}, wd, r.SkipConflicts, &mockWriter{})).To(Succeed())
+			writer := &mockWriter{}
+			Expect(service.Import([]workflow.Workflow{exported}, wd, r.SkipConflicts, writer)).To(Succeed())
+			Expect(writer.captures).To(ContainElement("Workflow with ID: " + exported.ID + " already exists and was skipped"))
+
+			Expect(service.Import([]workflow.Workflow{exported}, wd, r.OverwriteConflicts, &mockWriter{})).To(Succeed())
+			workflows, err := rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(workflows)).To(Equal(1))
+			Expect(workflows[0].Metadata.Version).To(Equal(exported.Metadata.Version + 1))
+
+			Expect(service.Import([]workflow.Workflow{exported}, wd, r.RenameConflicts, &mockWriter{})).To(Succeed())
+			workflows, err = rs.GetWorkflows("feature", 0, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(workflows)).To(Equal(2))
+			for _, imported := range workflows {
+				Expect(imported.State).To(Equal(exported.State))
+			}
+		})
+
+		It("should not import workflows of an unknown workflow", func() {
+			wd := createWorkflowDefinition()
+			exported := exportWorkflow(wd)
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+
+			wd.Workflows[0].ID = "other"
+			err := service.Import([]workflow.Workflow{exported}, wd, r.SkipConflicts, &mockWriter{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Workflow feature is not defined"))
+			workflows, err := rs.GetAllWorkflows(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(workflows).To(BeEmpty())
+		})
+
+	})
+
+	Context("Auditing", func() {
+
+		It("should record every change, successful or not, in the repository and the audit file", func() {
+			auditDirectory, err := ioutil.TempDir("", "flowit-audit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(auditDirectory) // nolint:errcheck
+			auditFile := filepath.Join(auditDirectory, "audit.jsonl")
+
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, auditFile))
+			wd := createWorkflowDefinition()
+			Expect(service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})).
+				To(Succeed())
+			workflows, err := rs.GetWorkflows("feature", 1, true)
+			Expect(err).ToNot(HaveOccurred())
+			workflowID := workflows[0].ID
+			Expect(service.Run(utils.NewStringOptional(workflowID), "", []string{"1", "2"}, "feature", "start", wd,
+				mockExecutor{}, &mockWriter{}, &mockPrompter{})).
+				ToNot(Succeed())
+			Expect(service.Cancel(workflowID, "feature", &mockWriter{})).To(Succeed())
+
+			events, err := rs.GetAuditEvents(audit.Query{WorkflowName: "feature"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(events).To(HaveLen(3))
+			for _, event := range events {
+				Expect(event.WorkflowID).To(Equal(workflowID))
+				Expect(event.Host).ToNot(BeEmpty())
+				Expect(event.Finished).To(BeNumerically(">=", event.Started))
+			}
+			Expect([]string{events[0].Action, events[0].FromStage, events[0].ToStage, string(events[0].Outcome)}).
+				To(Equal([]string{audit.Run, "", "start", string(audit.Succeeded)}))
+			Expect([]string{events[1].Action, events[1].FromStage, events[1].ToStage, string(events[1].Outcome)}).
+				To(Equal([]string{audit.Run, "start", "start", string(audit.Failed)}))
+			Expect(events[1].Error).To(ContainSubstring("Invalid transition from start to start"))
+			Expect([]string{events[2].Action, events[2].FromStage, string(events[2].Outcome)}).
+				To(Equal([]string{audit.Cancel, "start", string(audit.Succeeded)}))
+
+			content, err := ioutil.ReadFile(auditFile)
+			Expect(err).ToNot(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			Expect(lines).To(HaveLen(3))
+			var event audit.Event
+			Expect(json.Unmarshal([]byte(lines[2]), &event)).To(Succeed())
+			Expect(event).To(Equal(events[2]))
+		})
+
+	})
+
+	Context("Collecting garbage", func() {
+
+		putFinished := func(rs repository.Store, id string, finished time.Time) workflow.Workflow {
+			finishedWorkflow := workflow.Workflow{
+				ID:       id,
+				Preffix:  id,
+				Name:     "feature",
+				IsActive: false,
+				Metadata: workflow.WorkflowMetadata{
+					Started:  uint64(finished.Add(-time.Hour).UnixNano()),
+					Updated:  uint64(finished.UnixNano()),
+					Finished: uint64(finished.UnixNano()),
+				},
+				State: createWorkflowDefinition(),
+			}
+			Expect(rs.PutWorkflow(finishedWorkflow)).To(Succeed())
+			return finishedWorkflow
+		}
+
+		It("should only remove the finished workflows the retention policy does not keep", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			wd := createWorkflowDefinition()
+			Expect(service.Run(utils.OptionalString{}, "", []string{"1", "2"}, "feature", "start", wd, mockExecutor{},
+				&mockWriter{}, &mockPrompter{})).
+				To(Succeed())
+			putFinished(rs, "recent", time.Now().AddDate(0, 0, -1))
+			old := putFinished(rs, "old", time.Now().AddDate(0, 0, -60))
+
+			archiveDirectory, err := ioutil.TempDir("", "flowit-archive")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(archiveDirectory) // nolint:errcheck
+
+			retention := config.Retention{Days: 30, Archive: archiveDirectory}
+			writer := &mockWriter{}
+			Expect(service.CollectGarbage(retention, true, writer)).To(Succeed())
+			workflows, err := rs.GetAllWorkflows(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(workflows)).To(Equal(3))
+
+			Expect(service.CollectGarbage(retention, false, writer)).To(Succeed())
+			Expect(writer.captures).To(ContainElement("1 workflows were removed"))
+			workflows, err = rs.GetAllWorkflows(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(workflows)).To(Equal(2))
+			for _, kept := range workflows {
+				Expect(kept.ID).ToNot(Equal("old"))
+			}
+
+			archives, err := filepath.Glob(filepath.Join(retention.Archive, "*.gz"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(archives)).To(Equal(1))
+			archive, err := os.Open(archives[0])
+			Expect(err).ToNot(HaveOccurred())
+			defer archive.Close() // nolint:errcheck
+			archived, err := repository.ReadExport(archive)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(archived).To(Equal([]workflow.Workflow{old}))
+		})
+
+		It("should keep the most recently finished workflows", func() {
+			rs := repository.NewMemoryStore()
+			service := r.NewService(rs, fsf, ws, audit.NewLog(rs, ""))
+			putFinished(rs, "first", time.Now().AddDate(0, 0, -90))
+			putFinished(rs, "second", time.Now().AddDate(0, 0, -60))
+
+			Expect(service.CollectGarbage(config.Retention{Keep: 1}, false, &mockWriter{})).To(Succeed())
+			workflows, err := rs.GetAllWorkflows(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(workflows)).To(Equal(1))
+			Expect(workflows[0].ID).To(Equal("second"))
+		})
+
+	})
+
 })
@@ -1,14 +1,15 @@
 package utils
 
 import (
-	"errors"
 	"regexp"
-	"strings"
+
+	"github.com/pkg/errors"
 )
 
 const variableNamingRegexPattern = `([a-zA-Z0-9\-\_]+)`
 const descriptionNamingRegexPattern = `([a-zA-Z]+[a-zA-Z0-9\-\_ ]*)`
-const variableDeclarationRegexPattern = `^< *` + variableNamingRegexPattern + ` *\| *` + descriptionNamingRegexPattern + ` *>$`
+const variableDeclarationRegexPattern = `^< *` + variableNamingRegexPattern + ` *\| *` +
+	descriptionNamingRegexPattern + ` *>$`
 const variableReferenceRegexPattern = `\$<` + variableNamingRegexPattern + `>`
 
 // IsValidVariableDeclaration receives a string and returns a boolean value indicating
@@ -36,19 +37,39 @@ func ExtractVariableNameFromVariableDeclaration(expression string) (string, erro
 }
 
 // EvaluateVariablesInExpression receives an expression and a replacementMap and returns the expression with all
-// its variables replaced. It returns an error if the expression does not contain a variable reference or if a variable
-// reference is not in the replacement map
+// its variable references replaced. It returns an error if the expression can not be parsed or if a variable
+// reference without a default is not in the replacement map
 func EvaluateVariablesInExpression(expression string, replacementMap map[string]interface{}) (string, error) {
-	if !DoesExpressionContainsVariableReference(expression) {
-		return expression, nil
+	template, err := ParseTemplate(expression)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return template.Render(replacementMap, false)
+}
+
+// EvaluateVariablesInCommand works as EvaluateVariablesInExpression for shell commands
+// Every value is quoted so that the shell reads it as it is, unless its reference is filtered with raw
+func EvaluateVariablesInCommand(command string, replacementMap map[string]interface{}) (string, error) {
+	template, err := ParseTemplate(command)
+	if err != nil {
+		return "", errors.WithStack(err)
 	}
-	rx := regexp.MustCompile(variableReferenceRegexPattern)
-	matches := rx.FindAllStringSubmatch(expression, -1)
-	for _, match := range matches {
-		if _, ok := replacementMap[match[1]]; !ok {
-			return "", errors.New("Variable: " + match[0] + " could not be evaluated")
-		}
-		expression = strings.ReplaceAll(expression, match[0], replacementMap[match[1]].(string))
+	return template.Render(replacementMap, true)
+}
+
+// BindVariablesInCommand works as EvaluateVariablesInCommand but every value is read from a shell parameter
+// named by parameter instead of being written into the command. It returns the command and the parameter values
+func BindVariablesInCommand(command string, replacementMap map[string]interface{},
+	parameter func(int) string) (string, []string, error) {
+	template, err := ParseTemplate(command)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
 	}
-	return expression, nil
+	return template.Bind(replacementMap, parameter)
+}
+
+// FormatVariableValue returns the text a variable value is written as in commands. Lists are written as comma separated
+// items. It returns an error for maps, which can only be written through their keys
+func FormatVariableValue(value interface{}) (string, error) {
+	return templateValue(value)
 }
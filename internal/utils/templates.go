@@ -0,0 +1,445 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Template is a parsed text with variable references, such as $<name>,
+// $<branches[feature].name> or $<name | default:main>
+// A reference is followed by the filters its value goes through. $$< stands for a literal $<
+type Template struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or a variable reference together with the shell quotes it is written within
+type templatePart struct {
+	text      string
+	reference *variableReference
+	quoting   quoteContext
+}
+
+// quoteContext defines the shell quotes a variable reference is written within
+type quoteContext int
+
+const (
+	unquoted quoteContext = iota
+	singleQuoted
+	doubleQuoted
+)
+
+type variableReference struct {
+	source  string
+	path    []string
+	filters []filter
+}
+
+type filter struct {
+	name      string
+	arguments []string
+}
+
+// shellSafeCharacters are the characters of the values which are not quoted when they are substituted in commands
+const shellSafeCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:@%+=,"
+
+// ParseTemplate parses a text with variable references
+// $< followed by anything but a variable name is kept as literal text
+func ParseTemplate(text string) (Template, error) {
+	var template Template
+	var literal strings.Builder
+	quoting := unquoted
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '$' && i+2 < len(runes) && runes[i+1] == '$' && runes[i+2] == '<' {
+			literal.WriteString("$<")
+			i += 2
+			continue
+		}
+		if runes[i] == '$' && i+2 < len(runes) && runes[i+1] == '<' && isNameRune(runes[i+2]) {
+			end, err := referenceEnd(runes, i+2)
+			if err != nil {
+				return Template{}, errors.Wrap(err, "Invalid variable reference in: "+text)
+			}
+			reference, err := parseReference(string(runes[i : end+1]))
+			if err != nil {
+				return Template{}, errors.Wrap(err, "Invalid variable reference in: "+text)
+			}
+			template.parts = append(template.parts, templatePart{text: literal.String()},
+				templatePart{reference: &reference, quoting: quoting})
+			literal.Reset()
+			i = end
+			continue
+		}
+		quoting = nextQuoteContext(quoting, runes, i)
+		literal.WriteRune(runes[i])
+		// Escaped quotes do not change the shell quotes the text is written within
+		if runes[i] == '\\' && quoting != singleQuoted && i+1 < len(runes) && strings.ContainsRune(`\\"'`, runes[i+1]) {
+			i++
+			literal.WriteRune(runes[i])
+		}
+	}
+	template.parts = append(template.parts, templatePart{text: literal.String()})
+	return template, nil
+}
+
+// nextQuoteContext returns the shell quotes the text following the rune at position i is written within
+func nextQuoteContext(quoting quoteContext, runes []rune, i int) quoteContext {
+	switch {
+	case quoting == unquoted && runes[i] == '\'':
+		return singleQuoted
+	case quoting == unquoted && runes[i] == '"':
+		return doubleQuoted
+	case quoting == singleQuoted && runes[i] == '\'':
+		return unquoted
+	case quoting == doubleQuoted && runes[i] == '"':
+		return unquoted
+	}
+	return quoting
+}
+
+// referenceEnd returns the position of the > closing the reference which name starts at position start
+// Filter arguments may contain > if they are quoted
+func referenceEnd(runes []rune, start int) (int, error) {
+	var quote rune
+	for i := start; i < len(runes); i++ {
+		switch {
+		case quote != 0:
+			if runes[i] == quote {
+				quote = 0
+			}
+		case runes[i] == '\'' || runes[i] == '"':
+			quote = runes[i]
+		case runes[i] == '>':
+			return i, nil
+		}
+	}
+	return 0, errors.New("Unterminated variable reference: " + string(runes[start-2:]))
+}
+
+// parseReference parses a $<path | filter:argument:argument> reference
+func parseReference(source string) (variableReference, error) {
+	reference := variableReference{source: source}
+	sections := splitUnquoted(source[2:len(source)-1], '|')
+	path, err := parsePath(strings.TrimSpace(sections[0]))
+	if err != nil {
+		return variableReference{}, errors.Wrap(err, "Invalid variable reference: "+source)
+	}
+	reference.path = path
+	for _, section := range sections[1:] {
+		arguments := splitUnquoted(strings.TrimSpace(section), ':')
+		name := strings.TrimSpace(arguments[0])
+		arity, ok := filterArity(name)
+		if !ok {
+			return variableReference{}, errors.New("Unknown filter: " + name + " in variable reference: " + source)
+		}
+		if len(arguments)-1 != arity {
+			return variableReference{}, errors.Errorf("Filter: %s in variable reference: %s expects %d arguments but got %d",
+				name, source, arity, len(arguments)-1)
+		}
+		for i := range arguments[1:] {
+			arguments[i+1] = unquote(strings.TrimSpace(arguments[i+1]))
+		}
+		reference.filters = append(reference.filters, filter{name, arguments[1:]})
+	}
+	return reference, nil
+}
+
+// filterArity returns the number of arguments the filter takes, if it exists
+func filterArity(name string) (int, bool) {
+	switch name {
+	case "upper", "lower", "slug", "trim", "raw":
+		return 0, true
+	case "default":
+		return 1, true
+	case "replace":
+		return 2, true
+	}
+	return 0, false
+}
+
+// parsePath parses a variable name followed by keys, written as .key, [key], ['key'] or [index]
+func parsePath(source string) ([]string, error) {
+	runes := []rune(source)
+	var path []string
+	i := 0
+	readName := func() string {
+		start := i
+		for i < len(runes) && isNameRune(runes[i]) {
+			i++
+		}
+		return string(runes[start:i])
+	}
+	name := readName()
+	if name == "" {
+		return nil, errors.New("Missing variable name")
+	}
+	path = append(path, name)
+	for i < len(runes) {
+		switch runes[i] {
+		case '.':
+			i++
+			key := readName()
+			if key == "" {
+				return nil, errors.Errorf("Missing key at position %d", i)
+			}
+			path = append(path, key)
+		case '[':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.Errorf("Unterminated key at position %d", i)
+			}
+			key := unquote(strings.TrimSpace(string(runes[i+1 : end])))
+			if key == "" {
+				return nil, errors.Errorf("Missing key at position %d", i)
+			}
+			path = append(path, key)
+			i = end + 1
+		default:
+			return nil, errors.Errorf("Unexpected %q at position %d", runes[i], i)
+		}
+	}
+	return path, nil
+}
+
+// splitUnquoted splits the text by the separator when it is not within quotes
+func splitUnquoted(text string, separator rune) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == separator:
+			parts = append(parts, text[start:i])
+			start = i + len(string(r))
+		}
+	}
+	return append(parts, text[start:])
+}
+
+func unquote(text string) string {
+	if len(text) >= 2 && (text[0] == '\'' || text[0] == '"') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
+
+func isNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
+
+// Render replaces every variable reference of the template with its value
+// If quote is true, values are quoted for the shell quotes they are written within unless filtered with raw
+func (t Template) Render(variables map[string]interface{}, quote bool) (string, error) {
+	var rendered strings.Builder
+	for _, part := range t.parts {
+		if part.reference == nil {
+			rendered.WriteString(part.text)
+			continue
+		}
+		value, raw, err := part.reference.evaluate(variables)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if quote && !raw {
+			value = shellQuote(value, part.quoting)
+		}
+		rendered.WriteString(value)
+	}
+	return rendered.String(), nil
+}
+
+// Bind renders the template as a command which reads the value of every variable reference from a shell parameter,
+// named by parameter after the position of the value, instead of containing it
+// It returns the command and the values of its parameters. References filtered with raw are still substituted
+func (t Template) Bind(variables map[string]interface{}, parameter func(int) string) (string, []string, error) {
+	var rendered strings.Builder
+	var values []string
+	for _, part := range t.parts {
+		if part.reference == nil {
+			rendered.WriteString(part.text)
+			continue
+		}
+		value, raw, err := part.reference.evaluate(variables)
+		if err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		if raw {
+			rendered.WriteString(value)
+			continue
+		}
+		expansion := "${" + parameter(len(values)) + "}"
+		values = append(values, value)
+		switch part.quoting {
+		case singleQuoted:
+			rendered.WriteString(`'"` + expansion + `"'`)
+		case doubleQuoted:
+			rendered.WriteString(expansion)
+		default:
+			rendered.WriteString(`"` + expansion + `"`)
+		}
+	}
+	return rendered.String(), values, nil
+}
+
+// RawReferences returns the references of the template filtered with raw, which values are never quoted
+func (t Template) RawReferences() []string {
+	var references []string
+	for _, part := range t.parts {
+		if part.reference != nil && part.reference.isRaw() {
+			references = append(references, part.reference.source)
+		}
+	}
+	return references
+}
+
+func (r variableReference) isRaw() bool {
+	for _, f := range r.filters {
+		if f.name == "raw" {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate returns the value of the reference once filtered and whether or not it was filtered with raw
+func (r variableReference) evaluate(variables map[string]interface{}) (string, bool, error) {
+	value, found := lookup(variables, r.path)
+	var text string
+	if found {
+		var err error
+		if text, err = templateValue(value); err != nil {
+			return "", false, errors.Wrap(err, "Variable: "+r.source+" could not be evaluated")
+		}
+	}
+	raw := false
+	for _, f := range r.filters {
+		if !found && f.name != "default" {
+			continue
+		}
+		switch f.name {
+		case "default":
+			if !found || text == "" {
+				text, found = f.arguments[0], true
+			}
+		case "upper":
+			text = strings.ToUpper(text)
+		case "lower":
+			text = strings.ToLower(text)
+		case "trim":
+			text = strings.TrimSpace(text)
+		case "slug":
+			text = slug(text)
+		case "replace":
+			text = strings.ReplaceAll(text, f.arguments[0], f.arguments[1])
+		case "raw":
+			raw = true
+		}
+	}
+	if !found {
+		return "", false, errors.New("Variable: " + r.source + " could not be evaluated")
+	}
+	return text, raw, nil
+}
+
+// lookup follows the path through nested maps and lists
+func lookup(variables map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = variables
+	for _, key := range path {
+		switch collection := current.(type) {
+		case map[string]interface{}:
+			value, ok := collection[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case map[interface{}]interface{}:
+			value, ok := collection[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(collection) {
+				return nil, false
+			}
+			current = collection[index]
+		case []string:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(collection) {
+				return nil, false
+			}
+			current = collection[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
+
+// templateValue returns the text a value is rendered as. Lists are rendered as comma separated items
+func templateValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			text, err := templateValue(item)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	case []string:
+		return strings.Join(value, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "", errors.New("It is a map with keys: " + strings.Join(keys, ", ") + ". Reference one of its keys instead")
+	case map[interface{}]interface{}:
+		return "", errors.New("It is a map. Reference one of its keys instead")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// slug lowercases the text and replaces every run of characters other than letters and digits with a dash
+func slug(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	return strings.Join(words, "-")
+}
+
+// shellQuote quotes a value so that the shell reads it as a single word, whatever it contains
+func shellQuote(value string, quoting quoteContext) string {
+	switch quoting {
+	case singleQuoted:
+		return strings.ReplaceAll(value, "'", `'\''`)
+	case doubleQuoted:
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value)
+	default:
+		if value != "" && strings.Trim(value, shellSafeCharacters) == "" {
+			return value
+		}
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Templates", func() {
+
+	variables := map[string]interface{}{
+		"suffix":   "  My New Feature ",
+		"issue":    "abc-12",
+		"empty":    "",
+		"services": []interface{}{"api", "web"},
+		"branches": map[string]interface{}{
+			"feature": map[string]interface{}{"name": "feature/abc-12"},
+		},
+		"message": "it's $HOME; rm -rf `pwd`",
+	}
+
+	render := func(text string) string {
+		rendered, err := EvaluateVariablesInExpression(text, variables)
+		Expect(err).To(BeNil())
+		return rendered
+	}
+
+	renderCommand := func(text string) string {
+		rendered, err := EvaluateVariablesInCommand(text, variables)
+		Expect(err).To(BeNil())
+		return rendered
+	}
+
+	Describe("Evaluating variable references", func() {
+
+		It("should apply defaults and filters in order", func() {
+			Expect(render("$<base | default:main>")).To(Equal("main"))
+			Expect(render("$<empty|default:'main branch'>")).To(Equal("main branch"))
+			Expect(render("$<issue | default:main>")).To(Equal("abc-12"))
+			Expect(render("$<issue|upper>")).To(Equal("ABC-12"))
+			Expect(render("$<suffix | trim | lower>")).To(Equal("my new feature"))
+			Expect(render("feature/$<suffix | slug>")).To(Equal("feature/my-new-feature"))
+			Expect(render("$<issue | replace:-:_ | upper>")).To(Equal("ABC_12"))
+			Expect(render("$<missing | upper | default:x>")).To(Equal("x"))
+		})
+
+		It("should index maps and lists", func() {
+			Expect(render("$<branches[feature].name>")).To(Equal("feature/abc-12"))
+			Expect(render("$<branches['feature'].name>")).To(Equal("feature/abc-12"))
+			Expect(render("$<branches.feature.name>")).To(Equal("feature/abc-12"))
+			Expect(render("$<services[1]> $<services>")).To(Equal("web api,web"))
+			Expect(render("$<services[2] | default:none>")).To(Equal("none"))
+		})
+
+		It("should keep escaped references and text which is not a reference", func() {
+			Expect(render("echo $$<issue> $<issue>")).To(Equal("echo $<issue> abc-12"))
+			Expect(render("a $< issue > b")).To(Equal("a $< issue > b"))
+		})
+
+		It("should return a descriptive error for references which can not be evaluated", func() {
+			_, err := EvaluateVariablesInExpression("$<branches[hotfix].name>", variables)
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Variable: $<branches[hotfix].name> could not be evaluated"))
+
+			_, err = EvaluateVariablesInExpression("$<branches>", variables)
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("It is a map with keys: feature"))
+		})
+
+	})
+
+	Describe("Parsing variable references", func() {
+
+		It("should return a descriptive error for invalid references", func() {
+			_, err := ParseTemplate("git checkout $<issue | capitalize>")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unknown filter: capitalize"))
+
+			_, err = ParseTemplate("git checkout $<issue | replace:a>")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring(
+				"Filter: replace in variable reference: $<issue | replace:a> expects 2 arguments but got 1"))
+
+			_, err = ParseTemplate("git checkout $<issue")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unterminated variable reference: $<issue"))
+
+			_, err = ParseTemplate("git checkout $<branches[feature>")
+			Expect(err).To(Not(BeNil()))
+			Expect(err.Error()).To(ContainSubstring("Unterminated key"))
+		})
+
+	})
+
+	Describe("Evaluating variable references in commands", func() {
+
+		It("should quote values for the shell quotes they are written within", func() {
+			Expect(renderCommand("git checkout -b feature/$<issue>")).To(Equal("git checkout -b feature/abc-12"))
+			Expect(renderCommand("git commit -m $<message>")).To(Equal(`git commit -m 'it'\''s $HOME; rm -rf ` + "`pwd`'"))
+			Expect(renderCommand(`git commit -m "Fix: $<message>"`)).To(Equal(`git commit -m "Fix: it's \$HOME; rm -rf ` +
+				"\\`pwd\\`\""))
+			Expect(renderCommand(`echo 'Fix: $<message>'`)).To(Equal(`echo 'Fix: it'\''s $HOME; rm -rf ` + "`pwd`'"))
+			Expect(renderCommand(`echo \"$<suffix>`)).To(Equal(`echo \"'  My New Feature '`))
+			Expect(renderCommand("echo $<empty>")).To(Equal("echo ''"))
+		})
+
+		It("should not quote values filtered with raw", func() {
+			Expect(renderCommand("ls $<suffix | trim | raw>")).To(Equal("ls My New Feature"))
+		})
+
+	})
+
+	Describe("Binding variable references to shell parameters", func() {
+
+		It("should read every value from a parameter for the shell quotes it is written within", func() {
+			parameter := func(i int) string { return "V" + string(rune('1'+i)) }
+			script, values, err := BindVariablesInCommand(
+				`git commit -m $<message> && echo "Fix: $<issue>" 'on $<issue | upper>' $<suffix | trim | raw>`,
+				variables, parameter)
+			Expect(err).To(BeNil())
+			Expect(script).To(Equal(`git commit -m "${V1}" && echo "Fix: ${V2}" 'on '"${V3}"'' My New Feature`))
+			Expect(values).To(Equal([]string{"it's $HOME; rm -rf `pwd`", "abc-12", "ABC-12"}))
+		})
+
+	})
+
+})
@@ -1,29 +1,51 @@
 package workflow
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/yamil-rivera/flowit/internal/config"
+	"github.com/yamil-rivera/flowit/internal/utils"
 )
 
 // Workflow is the data structure representing a single workflow instance
 type Workflow struct {
-	ID              string
-	Preffix         string
+	ID      string
+	Preffix string
+	// Alias is an optional user provided name the workflow can also be addressed with
+	Alias           string
 	Name            string
+	SchemaVersion   string
 	IsActive        bool
+	IsCancelled     bool
 	Executions      []Execution
 	LatestExecution *Execution
-	// TODO: Switch to pointer and refer to a separate struct in the repository
-	// This to avoid several workflows based on the same config to have duplicated data in the repo
-	State    config.Flowit
+	// State is the workflow definition snapshot plus the workflow instance variables
+	// Repositories persist the definition separately so workflows based on the same one share it
+	State config.Flowit
+	// DefinitionHash identifies the workflow definition State was taken from
+	DefinitionHash string
+	// ParentName and ParentID identify the workflow which stage spawned this one, if any
+	ParentName string
+	ParentID   string
+	// Children are the workflows spawned by the stages of this one, in the order they were spawned
+	Children []Child
 	Metadata WorkflowMetadata
 }
 
+// Child is a workflow instance spawned by a stage of another workflow instance
+type Child struct {
+	Name string
+	ID   string
+	// Stage is the parent stage which spawned the child
+	Stage string
+}
+
 // WorkflowMetadata is the data structure that provides workflow instance metadata
 type WorkflowMetadata struct {
+	// Version is increased on every update and lets repositories detect concurrent updates
 	Version  uint64
 	Started  uint64
 	Updated  uint64
@@ -37,8 +59,23 @@ type Execution struct {
 	Stage      string
 	Args       []string
 	Checkpoint int
-	Failed     bool
-	Metadata   ExecutionMetadata
+	// CheckpointSucceeded holds the commands which already succeeded when the checkpoint is a parallel group
+	CheckpointSucceeded []int
+	Failed              bool
+	// FailedStage is the stage that was being run when the execution failed
+	FailedStage string
+	// Approval records who requested and who granted the approval the stage requires, if it is an approval stage
+	Approval *Approval
+	Results  []CommandResult
+	Metadata ExecutionMetadata
+}
+
+// Approval is the data structure representing the approval an approval stage requires before leaving it
+// It is pending until ApprovedBy is set
+type Approval struct {
+	RequestedBy string
+	ApprovedBy  string
+	Approved    uint64
 }
 
 // ExecutionMetadata is the data structure that provides execution instance metadata
@@ -48,6 +85,17 @@ type ExecutionMetadata struct {
 	Finished uint64
 }
 
+// CommandResult is the data structure representing the outcome of a single stage command
+type CommandResult struct {
+	Command string
+	Output  string
+	Failed  bool
+	// Skipped commands were not run since their when command did not succeed
+	Skipped  bool
+	Started  uint64
+	Finished uint64
+}
+
 // OptionalWorkflow is the data type that wraps an Workflow in an optional
 type OptionalWorkflow struct {
 	workflow Workflow
@@ -63,6 +111,24 @@ const (
 	FINISHED WorkflowState = iota
 )
 
+// Status summarizes the state of a workflow instance
+type Status string
+
+const (
+	StatusActive    Status = "active"
+	StatusFailed    Status = "failed"
+	StatusFinished  Status = "finished"
+	StatusCancelled Status = "cancelled"
+)
+
+// Statuses returns all the possible workflow statuses
+func Statuses() []Status {
+	return []Status{StatusActive, StatusFailed, StatusFinished, StatusCancelled}
+}
+
+// minPreffixLength is the length of the workflow preffix unless it is ambiguous
+const minPreffixLength = 6
+
 // Service implements the Workflow Service methods
 type Service struct{}
 
@@ -74,23 +140,70 @@ func NewService() *Service {
 // CreateWorkflow creates a new Workflow with a name and a variable map as inputs
 func (s *Service) CreateWorkflow(workflowName string, definition config.Flowit) *Workflow {
 	workflowID := uuid.New().String()
+	hash := definition.Hash()
+	// The workflow variables are copied since they are extended with the workflow instance variables,
+	// which must not leak into other workflows created from the same definition
+	variables := make(map[string]interface{}, len(definition.Variables))
+	for k, v := range definition.Variables {
+		variables[k] = v
+	}
+	definition.Variables = variables
 	return &Workflow{
-		ID:       workflowID,
-		Preffix:  workflowID[:6],
-		Name:     workflowName,
-		IsActive: false,
-		State:    definition,
+		ID:             workflowID,
+		Preffix:        workflowID[:minPreffixLength],
+		Name:           workflowName,
+		SchemaVersion:  definition.Version,
+		IsActive:       false,
+		State:          definition,
+		DefinitionHash: hash,
 		Metadata: WorkflowMetadata{
 			Version: 0,
 		},
 	}
 }
 
+// AssignPreffix sets the shortest workflow preffix which does not begin the ID nor equals the alias
+// of any of the provided workflows
+func (s *Service) AssignPreffix(workflow *Workflow, workflows []Workflow) {
+	length := minPreffixLength
+	for ; length < len(workflow.ID); length++ {
+		if !clashes(workflow.ID[:length], workflow.ID, workflows) {
+			break
+		}
+	}
+	workflow.Preffix = workflow.ID[:length]
+}
+
+// RenameWorkflow gives the workflow a new ID and a preffix which is not ambiguous among the provided workflows
+func (s *Service) RenameWorkflow(workflow *Workflow, workflows []Workflow) {
+	workflow.ID = uuid.New().String()
+	s.AssignPreffix(workflow, workflows)
+}
+
+// SetAlias sets the workflow alias. An empty alias removes it
+func (s *Service) SetAlias(w *Workflow, alias string) {
+	w.Alias = alias
+	w.Metadata.Version++
+	w.Metadata.Updated = uint64(time.Now().UnixNano())
+}
+
+// SetParent records the workflow which stage spawned the workflow
+func (s *Service) SetParent(workflow *Workflow, parent Workflow) {
+	workflow.ParentName = parent.Name
+	workflow.ParentID = parent.ID
+}
+
+// AddChild records a workflow spawned by a stage of the workflow
+func (s *Service) AddChild(workflow *Workflow, child Workflow, stage string) {
+	workflow.Children = append(workflow.Children, Child{Name: child.Name, ID: child.ID, Stage: stage})
+}
+
 // CancelWorkflow marks workflow as cancelled
-// TODO: Unit test
 func (s *Service) CancelWorkflow(w *Workflow) {
 	now := uint64(time.Now().UnixNano())
 	w.IsActive = false
+	w.IsCancelled = true
+	w.Metadata.Version++
 	w.Metadata.Updated = now
 	w.Metadata.Finished = now
 }
@@ -119,9 +232,57 @@ func (s *Service) StartExecution(workflow *Workflow, fromStage, currentStage str
 	return &execution
 }
 
-// SetCheckpoint sets the checkpoint for a given execution
-func (s *Service) SetCheckpoint(execution *Execution, checkpoint int) {
+// SetCheckpoint sets the checkpoint for a given execution together with the commands which already succeeded
+// when the checkpoint is a parallel group
+func (s *Service) SetCheckpoint(execution *Execution, checkpoint int, succeeded []int) {
 	execution.Checkpoint = checkpoint
+	execution.CheckpointSucceeded = succeeded
+}
+
+// AddCommandResult records the outcome of a command run by the given execution
+func (s *Service) AddCommandResult(execution *Execution, command, output string, failed bool, started uint64) {
+	execution.Results = append(execution.Results, CommandResult{
+		Command:  command,
+		Output:   output,
+		Failed:   failed,
+		Started:  started,
+		Finished: uint64(time.Now().UnixNano()),
+	})
+}
+
+// SkipCommand records a command the given execution did not run
+func (s *Service) SkipCommand(execution *Execution, command string) {
+	now := uint64(time.Now().UnixNano())
+	execution.Results = append(execution.Results, CommandResult{
+		Command:  command,
+		Skipped:  true,
+		Started:  now,
+		Finished: now,
+	})
+}
+
+// RequestApproval records that the given execution requires the approval of a user other than the one requesting it
+func (s *Service) RequestApproval(execution *Execution, user string) {
+	execution.Approval = &Approval{RequestedBy: user}
+}
+
+// Approve records that the user granted the approval the latest execution of the workflow requires
+func (s *Service) Approve(workflow *Workflow, user string) error {
+	if !workflow.IsPendingApproval() {
+		return errors.New("Workflow with ID: " + workflow.ID + " is not waiting for approval")
+	}
+	now := uint64(time.Now().UnixNano())
+	approval := *workflow.LatestExecution.Approval
+	approval.ApprovedBy = user
+	approval.Approved = now
+	workflow.LatestExecution.Approval = &approval
+	// The executions history holds its own copy of the latest execution
+	if len(workflow.Executions) > 0 && workflow.Executions[0].ID == workflow.LatestExecution.ID {
+		workflow.Executions[0].Approval = &approval
+	}
+	workflow.Metadata.Version++
+	workflow.Metadata.Updated = now
+	return nil
 }
 
 // FinishExecution marks a given execution as finished
@@ -133,9 +294,15 @@ func (s *Service) FinishExecution(workflow *Workflow, execution *Execution, work
 	execution.Metadata.Finished = now
 	if workflowState == FAILED {
 		execution.Failed = true
+		execution.FailedStage = execution.Stage
 		execution.Stage = execution.FromStage
 	}
+	// The executions history holds a copy of the latest execution taken when it was started
+	if len(workflow.Executions) > 0 && workflow.Executions[0].ID == execution.ID {
+		workflow.Executions[0] = *execution
+	}
 	workflow.IsActive = workflowState != FINISHED
+	workflow.Metadata.Version++
 	workflow.Metadata.Updated = now
 	if workflowState == FINISHED {
 		workflow.Metadata.Finished = now
@@ -150,6 +317,26 @@ func (s *Service) AddVariables(workflow *Workflow, variables map[string]interfac
 	}
 }
 
+// UpgradeWorkflow replaces the workflow definition snapshot with the provided definition
+// Variables populated from stage arguments or mapped in by the parent workflow
+// are preserved while the rest are taken from the new definition
+func (s *Service) UpgradeWorkflow(workflow *Workflow, definition config.Flowit) {
+	variables := make(map[string]interface{}, len(definition.Variables))
+	for k, v := range definition.Variables {
+		variables[k] = v
+	}
+	for _, variable := range append(workflow.argVariables(), workflow.spawnVariables()...) {
+		if v, ok := workflow.State.Variables[variable]; ok {
+			variables[variable] = v
+		}
+	}
+	workflow.State = definition
+	workflow.State.Variables = variables
+	workflow.DefinitionHash = definition.Hash()
+	workflow.Metadata.Version++
+	workflow.Metadata.Updated = uint64(time.Now().UnixNano())
+}
+
 // NewWorkflowOptional receives a Workflow and returns a filled optional ready to be unwrapped
 func NewWorkflowOptional(workflow Workflow) OptionalWorkflow {
 	return OptionalWorkflow{
@@ -182,6 +369,118 @@ func (w Workflow) Stage(stageID string) config.Stage {
 	return config.Stage{}
 }
 
+// Env returns the environment variables defined for the commands of a stage
+// Stage variables override workflow variables, which override config
+// variables. An empty stage ID leaves stage variables out
+func (w Workflow) Env(stageID string) map[string]string {
+	env := make(map[string]string)
+	for name, value := range w.State.Config.Env {
+		env[name] = value
+	}
+	for _, wf := range w.State.Workflows {
+		if wf.ID == w.Name {
+			for name, value := range wf.Env {
+				env[name] = value
+			}
+		}
+	}
+	if stageID != "" {
+		for name, value := range w.Stage(stageID).Env {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// Workdir returns the directory the commands of a stage are run
+// in, which is the workflow one unless the stage sets its own
+// An empty stage ID returns the workflow one
+func (w Workflow) Workdir(stageID string) string {
+	if stageID != "" {
+		if workdir := w.Stage(stageID).Workdir; workdir != "" {
+			return workdir
+		}
+	}
+	for _, wf := range w.State.Workflows {
+		if wf.ID == w.Name {
+			return wf.Workdir
+		}
+	}
+	return ""
+}
+
+// IsDrifted returns whether or not the workflow definition snapshot was taken from
+// a definition different than the one provided
+func (w Workflow) IsDrifted(definitionHash string) bool {
+	// Workflows created before definitions were hashed can not be compared
+	return w.DefinitionHash != "" && w.DefinitionHash != definitionHash
+}
+
+// Status returns the workflow status derived from its latest execution
+func (w Workflow) Status() Status {
+	switch {
+	case w.IsCancelled:
+		return StatusCancelled
+	case !w.IsActive:
+		return StatusFinished
+	case w.LatestExecution != nil && w.LatestExecution.Failed:
+		return StatusFailed
+	default:
+		return StatusActive
+	}
+}
+
+// LatestStage returns the stage the latest execution ran or, if it failed, the stage it tried to run
+func (w Workflow) LatestStage() string {
+	if w.LatestExecution == nil {
+		return ""
+	}
+	if w.LatestExecution.Failed {
+		return w.LatestExecution.FailedStage
+	}
+	return w.LatestExecution.Stage
+}
+
+// IsPendingApproval returns whether or not the workflow is at an approval stage nobody approved yet
+func (w Workflow) IsPendingApproval() bool {
+	return w.IsActive && w.LatestExecution != nil && !w.LatestExecution.Failed &&
+		w.LatestExecution.Approval != nil && w.LatestExecution.Approval.ApprovedBy == ""
+}
+
+// Branches returns the branches the stages of the workflow create with the built-in git create-branch action
+// Branches referencing variables the workflow does not hold yet, or foreach items, are left out
+func (w Workflow) Branches() []string {
+	var branches []string
+	for _, wf := range w.State.Workflows {
+		if wf.ID != w.Name {
+			continue
+		}
+		for _, stage := range wf.Stages {
+			for _, action := range stage.Actions {
+				if action.Git == nil || action.Git.Action != config.GitCreateBranch || action.Foreach != "" {
+					continue
+				}
+				branch, err := utils.EvaluateVariablesInExpression(action.Git.Branch, w.State.Variables)
+				if err == nil && branch != "" && !utils.FindStringInArray(branch, branches) {
+					branches = append(branches, branch)
+				}
+			}
+		}
+	}
+	return branches
+}
+
+// StageChildren returns the workflows spawned by a stage of the workflow
+func (w Workflow) StageChildren(stage string) []Child {
+	var children []Child
+	for _, child := range w.Children {
+		if child.Stage == stage {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
 // StateMachineID returns the worklow state machine ID
 func (w Workflow) StateMachineID() string {
 	for _, wf := range w.State.Workflows {
@@ -191,3 +490,57 @@ func (w Workflow) StateMachineID() string {
 	}
 	return ""
 }
+
+// argVariables returns the names of the variables populated from stage arguments
+func (w Workflow) argVariables() []string {
+	var variables []string
+	for _, wf := range w.State.Workflows {
+		if wf.ID != w.Name {
+			continue
+		}
+		for _, s := range wf.Stages {
+			for _, arg := range s.Args {
+				if variable, err := utils.ExtractVariableNameFromVariableDeclaration(arg); err == nil {
+					variables = append(variables, variable)
+				}
+			}
+		}
+	}
+	return variables
+}
+
+// spawnVariables returns the names of the variables the parent of a spawned workflow maps into it
+func (w Workflow) spawnVariables() []string {
+	var variables []string
+	if w.ParentID == "" {
+		return variables
+	}
+	for _, wf := range w.State.Workflows {
+		if wf.ID != w.ParentName {
+			continue
+		}
+		for _, s := range wf.Stages {
+			for _, spawn := range s.Spawn {
+				if spawn.Workflow != w.Name {
+					continue
+				}
+				for variable := range spawn.Variables {
+					variables = append(variables, variable)
+				}
+			}
+		}
+	}
+	return variables
+}
+
+func clashes(preffix, workflowID string, workflows []Workflow) bool {
+	for _, workflow := range workflows {
+		if workflow.ID == workflowID {
+			continue
+		}
+		if strings.HasPrefix(workflow.ID, preffix) || workflow.Alias == preffix {
+			return true
+		}
+	}
+	return false
+}
@@ -31,7 +31,7 @@ var _ = Describe("Workflow", func() {
 					"stage-1",
 					"stage-2",
 				},
-				InitialStage: "stage-1",
+				InitialStages: []string{"stage-1"},
 				FinalStages: []string{
 					"stage-2",
 				},
@@ -58,10 +58,12 @@ var _ = Describe("Workflow", func() {
 			Expect(err).To(BeNil())
 			Expect(workflow.Preffix).To(Equal(id.String()[:6]))
 			Expect(workflow.Name).To(Equal("my-workflow"))
+			Expect(workflow.SchemaVersion).To(Equal(wd.Version))
 			Expect(workflow.IsActive).To(BeFalse())
 			Expect(len(workflow.Executions)).To(Equal(0))
 			Expect(workflow.LatestExecution).To(BeNil())
 			Expect(workflow.State).To(Equal(wd))
+			Expect(workflow.DefinitionHash).To(Equal(wd.Hash()))
 
 			Expect(workflow.Metadata.Started).To(Equal(uint64(0)))
 			Expect(workflow.Metadata.Updated).To(Equal(uint64(0)))
@@ -70,6 +72,55 @@ var _ = Describe("Workflow", func() {
 
 		})
 
+		It("should lengthen the preffix until it is not ambiguous", func() {
+			workflow := service.CreateWorkflow("my-workflow", wd)
+			other := *service.CreateWorkflow("my-workflow", wd)
+			other.ID = workflow.ID[:7] + "x"
+			aliased := *service.CreateWorkflow("my-workflow", wd)
+			aliased.Alias = workflow.ID[:8]
+
+			service.AssignPreffix(workflow, []w.Workflow{other, aliased, *workflow})
+			Expect(workflow.Preffix).To(Equal(workflow.ID[:9]))
+
+			service.AssignPreffix(workflow, nil)
+			Expect(workflow.Preffix).To(Equal(workflow.ID[:6]))
+		})
+
+	})
+
+	Context("Recording spawned workflows", func() {
+
+		It("should record the parent and the children of spawned workflows", func() {
+			parent := service.CreateWorkflow("release", wd)
+			child := service.CreateWorkflow("changelog", wd)
+			service.AddVariables(child, map[string]interface{}{"version": "1.0"})
+			Expect(parent.State.Variables).ToNot(HaveKey("version"))
+			Expect(wd.Variables).ToNot(HaveKey("version"))
+
+			service.SetParent(child, *parent)
+			service.AddChild(parent, *child, "stage-1")
+			Expect(child.ParentName).To(Equal("release"))
+			Expect(child.ParentID).To(Equal(parent.ID))
+			Expect(parent.StageChildren("stage-1")).To(Equal([]w.Child{{Name: "changelog", ID: child.ID, Stage: "stage-1"}}))
+			Expect(parent.StageChildren("stage-2")).To(BeEmpty())
+		})
+
+	})
+
+	Context("Setting an alias", func() {
+
+		It("should set and remove the workflow alias", func() {
+			workflow := service.CreateWorkflow("my-workflow", wd)
+
+			service.SetAlias(workflow, "my-alias")
+			Expect(workflow.Alias).To(Equal("my-alias"))
+			Expect(workflow.Metadata.Version).To(Equal(uint64(1)))
+
+			service.SetAlias(workflow, "")
+			Expect(workflow.Alias).To(BeEmpty())
+			Expect(workflow.Metadata.Version).To(Equal(uint64(2)))
+		})
+
 	})
 
 	Context("Starting Workflow Execution", func() {
@@ -164,6 +215,7 @@ var _ = Describe("Workflow", func() {
 			// assert
 			Expect(err).To(BeNil())
 			Expect(workflow.IsActive).To(BeTrue())
+			Expect(workflow.Metadata.Version).To(Equal(uint64(1)))
 			Expect(workflow.Metadata.Finished).To(Equal(uint64(0)))
 			Expect(execution.Metadata.Finished).To(BeNumerically(">=", uint64(before.UnixNano())))
 			Expect(execution.Metadata.Finished).To(BeNumerically("<=", uint64(after.UnixNano())))
@@ -202,6 +254,109 @@ var _ = Describe("Workflow", func() {
 
 		})
 
+		It("should keep the failed stage and the command results of a failed execution", func() {
+
+			workflow := service.CreateWorkflow("my-workflow", wd)
+
+			execution := service.StartExecution(workflow, "stage-1", "stage-2", nil)
+			service.AddCommandResult(execution, "false", "", true, uint64(time.Now().UnixNano()))
+			err := service.FinishExecution(workflow, execution, w.FAILED)
+
+			Expect(err).To(BeNil())
+			Expect(workflow.Status()).To(Equal(w.StatusFailed))
+			Expect(workflow.LatestStage()).To(Equal("stage-2"))
+			Expect(execution.Stage).To(Equal("stage-1"))
+			Expect(execution.Results).To(HaveLen(1))
+			Expect(execution.Results[0].Command).To(Equal("false"))
+			Expect(execution.Results[0].Failed).To(BeTrue())
+			Expect(workflow.Executions[0]).To(Equal(*execution))
+
+		})
+
+	})
+
+	Context("Listing the branches of a workflow", func() {
+
+		It("should return the branches created by git actions which variables are known", func() {
+			definition := wd
+			definition.Variables = map[string]interface{}{"ticket": "abc-12"}
+			definition.Workflows = []config.Workflow{
+				{
+					ID: "feature",
+					Stages: []config.Stage{
+						{
+							ID: "start",
+							Actions: []config.Command{
+								{Git: &config.GitCommand{Action: config.GitCreateBranch, Branch: "feature/$<ticket>", From: "master"}},
+								{Git: &config.GitCommand{Action: config.GitCreateBranch, Branch: "hotfix/$<missing>"}},
+								{Git: &config.GitCommand{Action: config.GitCreateBranch, Branch: "$<item>"}, Foreach: "ticket"},
+								{Git: &config.GitCommand{Action: config.GitPush}},
+								{Run: "echo feature/$<ticket>"},
+							},
+						},
+						{
+							ID: "publish",
+							Actions: []config.Command{
+								{Git: &config.GitCommand{Action: config.GitCreateBranch, Branch: "feature/$<ticket>"}},
+							},
+						},
+					},
+				},
+			}
+
+			workflow := service.CreateWorkflow("feature", definition)
+			Expect(workflow.Branches()).To(Equal([]string{"feature/abc-12"}))
+
+			workflow.Name = "other"
+			Expect(workflow.Branches()).To(BeEmpty())
+		})
+
+	})
+
+	Context("Upgrading a Workflow", func() {
+
+		It("should preserve the variables mapped in by the parent workflow", func() {
+			definition := wd
+			definition.Variables = map[string]interface{}{"variable": "value"}
+			definition.Workflows = []config.Workflow{
+				{
+					ID: "release",
+					Stages: []config.Stage{
+						{ID: "stage-1", Spawn: []config.Spawn{
+							{Workflow: "changelog", Variables: map[string]string{"version": "$<tag>"}},
+						}},
+					},
+				},
+				{ID: "changelog", Stages: []config.Stage{{ID: "stage-1"}}},
+			}
+			parent := service.CreateWorkflow("release", definition)
+			child := service.CreateWorkflow("changelog", definition)
+			service.SetParent(child, *parent)
+			service.AddVariables(child, map[string]interface{}{"version": "v1", "other": "dropped"})
+
+			service.UpgradeWorkflow(child, definition)
+			Expect(child.State.Variables).To(Equal(config.Variables{"variable": "value", "version": "v1"}))
+		})
+
+	})
+
+	Context("Cancelling a Workflow", func() {
+
+		It("should mark the workflow as cancelled", func() {
+
+			workflow := service.CreateWorkflow("my-workflow", wd)
+			execution := service.StartExecution(workflow, "origin", "stage-1", nil)
+			Expect(service.FinishExecution(workflow, execution, w.STARTED)).To(Succeed())
+			Expect(workflow.Status()).To(Equal(w.StatusActive))
+
+			service.CancelWorkflow(workflow)
+
+			Expect(workflow.IsActive).To(BeFalse())
+			Expect(workflow.Status()).To(Equal(w.StatusCancelled))
+			Expect(workflow.Metadata.Version).To(Equal(uint64(2)))
+
+		})
+
 	})
 
 })